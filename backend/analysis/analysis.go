@@ -1,51 +1,110 @@
 package analysis
 
 import (
-	"sync"
-	"time"
+	"math"
 
-	"jobmon/job"
+	conf "jobmon/config"
 
 	// Changepoint is a Go library for changepoint detection with support for nonparametric distributions
 	"pgregory.net/changepoint"
 )
 
-// TODO:Add docs
-// TODO: Add unit tests
-func ChangePointDetection(j *job.JobData) map[string][]time.Time {
-	var wg sync.WaitGroup
-	var lock sync.Mutex
-	data := make(map[string][]time.Time)
-	for _, m := range j.MetricData {
-		wg.Add(1)
-		go func(m job.MetricData) {
-			defer wg.Done()
-			for _, v := range m.Data {
-
-				// Store measurements in float64 slice
-				values := make([]float64, 0)
-				for _, vi := range v {
-					val := vi["_value"]
-					if val == nil {
-						return
-					}
-					values = append(values, val.(float64))
-				}
-
-				// Compute indexes of elements that split measurements into
-				// "statistically homogeneous" segments.
-				cpts := changepoint.NonParametric(values, 1)
-
-				times := make([]time.Time, 0)
-				for _, i := range cpts {
-					times = append(times, v[i]["_time"].(time.Time))
-				}
-				lock.Lock()
-				data[m.Config.Measurement] = times
-				lock.Unlock()
+// ChangePoints returns the indexes of the elements that split values into
+// "statistically homogeneous" segments, using the method, penalty and minimum segment length of c.
+func ChangePoints(values []float64, c conf.PhaseDetectionConfig) []int {
+	c = c.WithDefaults()
+	if c.MinSegment < 1 {
+		c.MinSegment = 1
+	}
+	if c.Method == "mean" {
+		return meanChangePoints(values, c.MinSegment, c.Penalty)
+	}
+	return changepoint.NonParametric(values, c.MinSegment)
+}
+
+// meanChangePoints detects changes of the mean of normally distributed values with the PELT algorithm.
+// The cost of a segment is its sum of squared deviations from the segment mean in units of the noise
+// variance; each change point costs penalty * log(n).
+func meanChangePoints(values []float64, minSegment int, penalty float64) []int {
+	n := len(values)
+	if n < 2*minSegment {
+		return nil
+	}
+	variance := noiseVariance(values)
+	if variance == 0 {
+		return nil
+	}
+
+	// Prefix sums allow to compute the cost of any segment in constant time
+	sum := make([]float64, n+1)
+	sumSq := make([]float64, n+1)
+	for i, v := range values {
+		sum[i+1] = sum[i] + v
+		sumSq[i+1] = sumSq[i] + v*v
+	}
+	// cost of the segment values[from:to]
+	cost := func(from, to int) float64 {
+		s := sum[to] - sum[from]
+		return (sumSq[to] - sumSq[from] - s*s/float64(to-from)) / variance
+	}
+
+	beta := penalty * math.Log(float64(n))
+	// bestCost[i] is the minimal cost of values[:i]; previous[i] the start of its last segment
+	bestCost := make([]float64, n+1)
+	previous := make([]int, n+1)
+	bestCost[0] = -beta
+	candidates := []int{0}
+	for end := minSegment; end <= n; end++ {
+		bestCost[end] = math.Inf(1)
+		for _, start := range candidates {
+			if end-start < minSegment {
+				continue
+			}
+			if c := bestCost[start] + cost(start, end) + beta; c < bestCost[end] {
+				bestCost[end] = c
+				previous[end] = start
 			}
-		}(m)
+		}
+
+		// Prune starts that can not be part of an optimal segmentation anymore
+		kept := candidates[:0]
+		for _, start := range candidates {
+			if end-start < minSegment || bestCost[start]+cost(start, end) <= bestCost[end] {
+				kept = append(kept, start)
+			}
+		}
+		candidates = append(kept, end)
+	}
+
+	var result []int
+	for i := previous[n]; i > 0; i = previous[i] {
+		result = append(result, i)
+	}
+	for l, r := 0, len(result)-1; l < r; l, r = l+1, r-1 {
+		result[l], result[r] = result[r], result[l]
+	}
+	return result
+}
+
+// noiseVariance estimates the variance of the noise in values from the median absolute deviation of
+// the differences of consecutive values, which is not affected by changes of the mean.
+// Falls back to the variance of values if most differences are equal.
+func noiseVariance(values []float64) float64 {
+	diffs := make([]float64, len(values)-1)
+	for i := range diffs {
+		diffs[i] = values[i+1] - values[i]
+	}
+	med := median(diffs)
+	deviations := make([]float64, len(diffs))
+	for i, d := range diffs {
+		deviations[i] = math.Abs(d - med)
+	}
+	// Differences of independent values have twice the variance of the values
+	sigma := madScale * median(deviations) / math.Sqrt2
+	_, stdDev, _ := statistics(values)
+	// Ignore rounding errors of values without noise
+	if sigma > 1e-6*stdDev {
+		return sigma * sigma
 	}
-	wg.Wait()
-	return data
+	return stdDev * stdDev
 }
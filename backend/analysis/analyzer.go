@@ -0,0 +1,213 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+
+	"golang.org/x/exp/slices"
+)
+
+// Severities of findings
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+)
+
+// Maximum run time of an analyzer if not configured
+const defaultAnalyzerTimeout = 30 * time.Second
+
+// Analyzer analyzes the data of a finished job.
+type Analyzer interface {
+	// Name identifies the analyzer in the configuration and in the results, e.g. "imbalance"
+	Name() string
+	// Analyze returns the findings for the job of data; data is separated by hostname and data.Metadata
+	// contains the mean and max values of the metrics. Analyzers run concurrently and must not modify data.
+	// ctx is canceled when the analyzer times out.
+	Analyze(ctx context.Context, data *job.JobData, c *conf.Configuration) (Report, error)
+}
+
+// Report is the result of an analyzer.
+type Report struct {
+	Findings []job.Finding
+	// Annotate stores results in the job metadata, e.g. in the data of the metrics; optional.
+	// It is called after all analyzers finished.
+	Annotate func(j *job.JobMetadata)
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Analyzer)
+)
+
+// Register makes analyzer a available under its name. Register panics if the name is already used.
+func Register(a Analyzer) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[a.Name()]; ok {
+		panic("analysis: Register(): Analyzer " + a.Name() + " is already registered")
+	}
+	registry[a.Name()] = a
+}
+
+// Registered returns the sorted names of all registered analyzers.
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled returns the registered analyzers enabled for jobs of partition, sorted by name.
+// Analyzers are enabled unless disabled in c.Analysis; partition settings take precedence.
+func Enabled(c *conf.Configuration, partition conf.BasePartitionConfig) []Analyzer {
+	var disabled []string
+	if c.Analysis != nil {
+		disabled = c.Analysis.Disabled
+	}
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	analyzers := []Analyzer{}
+	for name, a := range registry {
+		enabled, ok := partition.Analyzers[name]
+		if !ok {
+			enabled = !slices.Contains(disabled, name)
+		}
+		if enabled {
+			analyzers = append(analyzers, a)
+		}
+	}
+	sort.Slice(analyzers, func(i, j int) bool {
+		return analyzers[i].Name() < analyzers[j].Name()
+	})
+	return analyzers
+}
+
+// Run runs analyzers concurrently on data and stores their results in data.Metadata, which must be set.
+// Analyzers that fail or exceed the configured timeout are reported with an error
+// and do not annotate the metadata.
+func Run(ctx context.Context, analyzers []Analyzer, data *job.JobData, c *conf.Configuration) {
+	timeout := defaultAnalyzerTimeout
+	if c.Analysis != nil && c.Analysis.Timeout != "" {
+		// The timeout was checked by Validate
+		timeout, _ = time.ParseDuration(c.Analysis.Timeout)
+	}
+
+	// Annotations modify the metadata, analyzers that timed out may still read their copy
+	metadata := *data.Metadata
+	metadata.Data = slices.Clone(metadata.Data)
+	input := *data
+	input.Metadata = &metadata
+
+	results := make([]job.AnalyzerResult, len(analyzers))
+	reports := make([]Report, len(analyzers))
+	var wg sync.WaitGroup
+	for i, a := range analyzers {
+		wg.Add(1)
+		go func(i int, a Analyzer) {
+			defer wg.Done()
+			results[i] = job.AnalyzerResult{Analyzer: a.Name(), Findings: []job.Finding{}}
+			report, err := runWithTimeout(ctx, a, &input, c, timeout)
+			if err != nil {
+				logging.Error("analysis: Run(): Analyzer ", a.Name(), " failed for job ", data.Metadata.Id, ": ", err)
+				results[i].Error = err.Error()
+				return
+			}
+			for _, f := range report.Findings {
+				f.Analyzer = a.Name()
+				results[i].Findings = append(results[i].Findings, f)
+			}
+			reports[i] = report
+		}(i, a)
+	}
+	wg.Wait()
+
+	for _, report := range reports {
+		if report.Annotate != nil {
+			report.Annotate(data.Metadata)
+		}
+	}
+	data.Metadata.Analysis = results
+}
+
+// runWithTimeout runs analyzer a and returns an error if it does not finish within timeout.
+// Analyzers that do not check ctx keep running in the background, their report is discarded.
+func runWithTimeout(
+	ctx context.Context,
+	a Analyzer,
+	data *job.JobData,
+	c *conf.Configuration,
+	timeout time.Duration,
+) (
+	report Report,
+	err error,
+) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		report Report
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		report, err := a.Analyze(ctx, data, c)
+		done <- result{report, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.report, r.err
+	case <-ctx.Done():
+		return report, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// ValidateConfig checks that the analyzers named in c are registered.
+func ValidateConfig(c *conf.Configuration) conf.ValidationErrors {
+	errs := conf.ValidationErrors{}
+	registered := Registered()
+	if c.Analysis != nil {
+		for _, name := range c.Analysis.Disabled {
+			if !slices.Contains(registered, name) {
+				errs = append(errs, conf.ValidationError{Field: "Analysis.Disabled", Message: "unknown analyzer " + name})
+			}
+		}
+	}
+	for partName, partConfig := range c.Partitions {
+		for name := range partConfig.Analyzers {
+			if !slices.Contains(registered, name) {
+				errs = append(errs, conf.ValidationError{Field: "Partitions." + partName + ".Analyzers", Message: "unknown analyzer " + name})
+			}
+		}
+		for virtName, virtConfig := range partConfig.VirtualPartitions {
+			for name := range virtConfig.Analyzers {
+				if !slices.Contains(registered, name) {
+					errs = append(errs, conf.ValidationError{
+						Field:   "Partitions." + partName + ".VirtualPartitions." + virtName + ".Analyzers",
+						Message: "unknown analyzer " + name,
+					})
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(errs, func(a, b conf.ValidationError) bool {
+		return a.Field < b.Field
+	})
+	return errs
+}
@@ -0,0 +1,93 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"jobmon/config"
+	"jobmon/job"
+
+	"golang.org/x/exp/slices"
+)
+
+// testAnalyzer returns a single finding after delay and annotates the exit code
+type testAnalyzer struct {
+	name  string
+	delay time.Duration
+	err   error
+}
+
+func (a testAnalyzer) Name() string {
+	return a.name
+}
+
+func (a testAnalyzer) Analyze(ctx context.Context, data *job.JobData, c *config.Configuration) (Report, error) {
+	select {
+	case <-time.After(a.delay):
+	case <-ctx.Done():
+	}
+	if a.err != nil {
+		return Report{}, a.err
+	}
+	return Report{
+		Findings: []job.Finding{{Kind: "test", Severity: SeverityInfo}},
+		Annotate: func(j *job.JobMetadata) {
+			j.ExitCode++
+		},
+	}, nil
+}
+
+// Tests if analyzers run concurrently and failed or timed out analyzers are reported without annotations
+func TestRun(t *testing.T) {
+	c := config.Configuration{Analysis: &config.AnalysisConfig{Timeout: "100ms"}}
+	data := job.JobData{Metadata: &job.JobMetadata{Id: 1}}
+	analyzers := []Analyzer{
+		testAnalyzer{name: "a", delay: 50 * time.Millisecond},
+		testAnalyzer{name: "b", delay: 50 * time.Millisecond},
+		testAnalyzer{name: "failing", err: fmt.Errorf("failed")},
+		testAnalyzer{name: "slow", delay: time.Second},
+	}
+
+	start := time.Now()
+	Run(context.Background(), analyzers, &data, &c)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Analyzers did not run concurrently or timeout was ignored: %v", d)
+	}
+
+	results := data.Metadata.Analysis
+	if len(results) != 4 || len(results[0].Findings) != 1 || results[0].Findings[0].Analyzer != "a" {
+		t.Fatalf("Wrong results: %+v", results)
+	}
+	if results[2].Error != "failed" || results[3].Error == "" {
+		t.Fatalf("Errors were not reported: %+v", results)
+	}
+	if data.Metadata.ExitCode != 2 {
+		t.Fatalf("Wrong number of annotations: %d", data.Metadata.ExitCode)
+	}
+}
+
+// Tests if partition settings take precedence over globally disabled analyzers
+func TestEnabled(t *testing.T) {
+	c := config.Configuration{Analysis: &config.AnalysisConfig{Disabled: []string{"phases"}}}
+	names := func(analyzers []Analyzer) []string {
+		result := []string{}
+		for _, a := range analyzers {
+			result = append(result, a.Name())
+		}
+		return result
+	}
+
+	if n := names(Enabled(&c, config.BasePartitionConfig{})); !slices.Equal(n, []string{"imbalance"}) {
+		t.Fatalf("Wrong analyzers %v", n)
+	}
+	partition := config.BasePartitionConfig{Analyzers: map[string]bool{"phases": true, "imbalance": false}}
+	if n := names(Enabled(&c, partition)); !slices.Equal(n, []string{"phases"}) {
+		t.Fatalf("Wrong analyzers with partition settings %v", n)
+	}
+	c.Partitions = map[string]config.PartitionConfig{"p": {BasePartitionConfig: config.BasePartitionConfig{Analyzers: map[string]bool{"unknown": true}}}}
+	if errs := ValidateConfig(&c); len(errs) != 1 {
+		t.Fatalf("Unknown analyzer was not reported: %v", errs)
+	}
+}
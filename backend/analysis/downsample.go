@@ -0,0 +1,227 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"jobmon/job"
+)
+
+// Downsampling methods
+const (
+	// Largest-Triangle-Three-Buckets keeps the points that preserve the visual shape of a series
+	DownsampleLTTB = "lttb"
+	// Min/max envelope keeps the minimum and maximum of each bucket, so no peak is hidden
+	DownsampleMinMax = "minmax"
+)
+
+// DownsampleMethods are the supported downsampling methods
+var DownsampleMethods = []string{DownsampleLTTB, DownsampleMinMax}
+
+// Factor by which the data queried for downsampling has more points than requested
+const downsampleOversampling = 4
+
+// DownsampleInterval returns the sample interval to query the data of job j with, so that
+// each series has about downsampleOversampling times maxPoints points before downsampling.
+// The interval is a multiple of the metric sample interval.
+func DownsampleInterval(j *job.JobMetadata, metricSampleInterval time.Duration, maxPoints int) time.Duration {
+	stopTime := j.StopTime
+	if j.IsRunning {
+		stopTime = int(time.Now().Unix())
+	}
+	duration := float64(stopTime - j.StartTime)
+	datapoints := duration / metricSampleInterval.Seconds()
+	factor := math.Max(1, math.Ceil(datapoints/float64(downsampleOversampling*maxPoints)))
+	return time.Duration(factor) * metricSampleInterval
+}
+
+// ValidateDownsampling checks that maxPoints points can be selected with method.
+func ValidateDownsampling(method string, maxPoints int) error {
+	switch method {
+	case DownsampleLTTB:
+		if maxPoints < 3 {
+			return fmt.Errorf("at least 3 points are required for %s", method)
+		}
+	case DownsampleMinMax:
+		if maxPoints < 2 {
+			return fmt.Errorf("at least 2 points are required for %s", method)
+		}
+	default:
+		return fmt.Errorf("unknown downsampling method '%s', must be one of %v", method, DownsampleMethods)
+	}
+	return nil
+}
+
+// Downsample returns a copy of data in which every series of the metric and quantile data has
+// at most maxPoints points, selected with method. The series of data are not modified, so
+// cached data can be downsampled. Raw data is returned unchanged.
+func Downsample(data job.JobData, method string, maxPoints int) job.JobData {
+	downsample := LTTB
+	if method == DownsampleMinMax {
+		downsample = MinMax
+	}
+	series := func(data map[string][]job.QueryResult) map[string][]job.QueryResult {
+		if data == nil {
+			return nil
+		}
+		result := make(map[string][]job.QueryResult, len(data))
+		for key, rows := range data {
+			result[key] = downsample(rows, maxPoints)
+		}
+		return result
+	}
+
+	metricData := make([]job.MetricData, len(data.MetricData))
+	for i, m := range data.MetricData {
+		m.Data = series(m.Data)
+		metricData[i] = m
+	}
+	quantileData := make([]job.QuantileData, len(data.QuantileData))
+	for i, q := range data.QuantileData {
+		q.Data = series(q.Data)
+		quantileData[i] = q
+	}
+	data.MetricData = metricData
+	data.QuantileData = quantileData
+	return data
+}
+
+// point is a row of a series with its time and value as coordinates.
+type point struct {
+	index int
+	x, y  float64
+	// False for rows without value, e.g. gaps of the series
+	valid bool
+}
+
+// seriesPoints returns the coordinates of rows, or false if a row has no time.
+func seriesPoints(rows []job.QueryResult) ([]point, bool) {
+	points := make([]point, len(rows))
+	for i, row := range rows {
+		t, ok := row["_time"].(time.Time)
+		if !ok {
+			return nil, false
+		}
+		points[i] = point{index: i, x: float64(t.UnixNano())}
+		points[i].y, points[i].valid = row["_value"].(float64)
+	}
+	return points, true
+}
+
+// LTTB selects at most maxPoints rows of the time series rows with the Largest-Triangle-Three-Buckets
+// algorithm. The first and last row are always kept. Rows without value are only kept for buckets
+// that contain no values, so longer gaps remain visible.
+func LTTB(rows []job.QueryResult, maxPoints int) []job.QueryResult {
+	if maxPoints < 3 || len(rows) <= maxPoints {
+		return rows
+	}
+	points, ok := seriesPoints(rows)
+	if !ok {
+		return rows
+	}
+
+	// Bucket i contains the points [bucket(i), bucket(i+1)), without the first and last point
+	n := len(points)
+	bucket := func(i int) int {
+		return i*(n-2)/(maxPoints-2) + 1
+	}
+
+	result := make([]job.QueryResult, 0, maxPoints)
+	result = append(result, rows[0])
+	a := points[0]
+	if !a.valid {
+		for _, p := range points {
+			if p.valid {
+				a = p
+				break
+			}
+		}
+	}
+	for i := 0; i < maxPoints-2; i++ {
+		// Average of the next bucket, the last point for the last bucket
+		avgX, avgY, count := 0.0, 0.0, 0
+		end := bucket(i + 2)
+		if end > n-1 {
+			end = n
+		}
+		for _, p := range points[bucket(i+1):end] {
+			if p.valid {
+				avgX += p.x
+				avgY += p.y
+				count++
+			}
+		}
+		if count > 0 {
+			avgX /= float64(count)
+			avgY /= float64(count)
+		} else {
+			avgX, avgY = a.x, a.y
+		}
+
+		// Point of this bucket forming the largest triangle with the previous point and the average
+		selected := -1
+		maxArea := -1.0
+		for _, p := range points[bucket(i):bucket(i+1)] {
+			if !p.valid {
+				continue
+			}
+			area := math.Abs((a.x-avgX)*(p.y-a.y) - (a.x-p.x)*(avgY-a.y))
+			if area > maxArea {
+				maxArea = area
+				selected = p.index
+			}
+		}
+		if selected < 0 {
+			// Bucket without values
+			result = append(result, rows[bucket(i)])
+			continue
+		}
+		result = append(result, rows[selected])
+		a = points[selected]
+	}
+	return append(result, rows[n-1])
+}
+
+// MinMax selects at most maxPoints rows of the time series rows by keeping the rows with
+// the minimum and maximum value of maxPoints/2 buckets in their original order.
+// Buckets without values are represented by their first row, so gaps remain visible.
+func MinMax(rows []job.QueryResult, maxPoints int) []job.QueryResult {
+	buckets := maxPoints / 2
+	if buckets < 1 || len(rows) <= maxPoints {
+		return rows
+	}
+	points, ok := seriesPoints(rows)
+	if !ok {
+		return rows
+	}
+
+	n := len(points)
+	result := make([]job.QueryResult, 0, maxPoints)
+	for i := 0; i < buckets; i++ {
+		start, end := i*n/buckets, (i+1)*n/buckets
+		min, max := -1, -1
+		for _, p := range points[start:end] {
+			if !p.valid {
+				continue
+			}
+			if min < 0 || p.y < points[min].y {
+				min = p.index
+			}
+			if max < 0 || p.y > points[max].y {
+				max = p.index
+			}
+		}
+		switch {
+		case min < 0:
+			result = append(result, rows[start])
+		case min == max:
+			result = append(result, rows[min])
+		case min < max:
+			result = append(result, rows[min], rows[max])
+		default:
+			result = append(result, rows[max], rows[min])
+		}
+	}
+	return result
+}
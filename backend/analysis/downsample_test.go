@@ -0,0 +1,97 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"jobmon/job"
+)
+
+// series returns rows with one value per minute; nil values are kept as missing values
+func series(values ...any) []job.QueryResult {
+	rows := make([]job.QueryResult, len(values))
+	for i, v := range values {
+		rows[i] = job.QueryResult{"_time": time.Unix(int64(60*i), 0), "_value": v}
+	}
+	return rows
+}
+
+// spikySeries returns n constant values with a single spike at index spike
+func spikySeries(n int, spike int) []job.QueryResult {
+	values := make([]any, n)
+	for i := range values {
+		values[i] = 1.0
+	}
+	values[spike] = 100.0
+	return series(values...)
+}
+
+// Tests if LTTB keeps the first, last and peak points
+func TestLTTB(t *testing.T) {
+	rows := spikySeries(1000, 537)
+	result := LTTB(rows, 50)
+	if len(result) != 50 {
+		t.Fatalf("Wrong number of points %d", len(result))
+	}
+	if result[0]["_time"] != rows[0]["_time"] || result[49]["_time"] != rows[999]["_time"] {
+		t.Fatalf("First or last point was not kept")
+	}
+	found := false
+	for i, row := range result {
+		if row["_value"] == 100.0 {
+			found = true
+		}
+		if i > 0 && !row["_time"].(time.Time).After(result[i-1]["_time"].(time.Time)) {
+			t.Fatalf("Points are not in order")
+		}
+	}
+	if !found {
+		t.Fatalf("Spike was not kept")
+	}
+
+	if len(LTTB(rows[:40], 50)) != 40 {
+		t.Fatalf("Short series was modified")
+	}
+}
+
+// Tests if MinMax keeps minimum and maximum of each bucket and gaps
+func TestMinMax(t *testing.T) {
+	result := MinMax(series(1.0, 5.0, 3.0, -2.0, nil, nil, nil, nil, 4.0, 2.0, 2.0, 2.0), 6)
+	values := []any{}
+	for _, row := range result {
+		values = append(values, row["_value"])
+	}
+	expected := []any{5.0, -2.0, nil, 4.0, 2.0}
+	if len(values) != len(expected) {
+		t.Fatalf("Wrong values %v", values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Fatalf("Wrong values %v", values)
+		}
+	}
+}
+
+// Tests if downsampling job data does not modify the original series
+func TestDownsample(t *testing.T) {
+	rows := spikySeries(100, 10)
+	data := job.JobData{
+		MetricData:   []job.MetricData{{Data: map[string][]job.QueryResult{"n1": rows}}},
+		QuantileData: []job.QuantileData{{Data: map[string][]job.QueryResult{"0.5": rows}}},
+	}
+	result := Downsample(data, DownsampleMinMax, 10)
+	if len(result.MetricData[0].Data["n1"]) > 10 || len(result.QuantileData[0].Data["0.5"]) > 10 {
+		t.Fatalf("Series were not downsampled")
+	}
+	if len(data.MetricData[0].Data["n1"]) != 100 || len(data.QuantileData[0].Data["0.5"]) != 100 {
+		t.Fatalf("Original data was modified")
+	}
+
+	if ValidateDownsampling(DownsampleLTTB, 2) == nil || ValidateDownsampling("mean", 100) == nil {
+		t.Fatalf("Invalid downsampling parameters were accepted")
+	}
+	j := job.JobMetadata{StartTime: 0, StopTime: 24 * 3600}
+	if i := DownsampleInterval(&j, 30*time.Second, 200); i != 2*time.Minute {
+		t.Fatalf("Wrong sample interval %v", i)
+	}
+}
@@ -0,0 +1,208 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	conf "jobmon/config"
+	"jobmon/job"
+)
+
+// Robust scores of nodes above this threshold mark outliers
+const outlierThreshold = 3.0
+
+// Relative deviation from the median that marks outliers if most nodes have the same mean
+const outlierRelativeDeviation = 0.5
+
+// Scale factor of the median absolute deviation to estimate the standard deviation of normal distributions
+const madScale = 1.4826
+
+// Imbalance computes for each metric of j how evenly its values are distributed over the nodes.
+// The metric data must be separated by hostname; metrics with less than two nodes are skipped.
+func Imbalance(j *job.JobData) []job.MetricImbalance {
+	result := []job.MetricImbalance{}
+	for _, m := range j.MetricData {
+		if len(m.Data) < 2 {
+			continue
+		}
+		result = append(result, MetricImbalance(m))
+	}
+	return result
+}
+
+// MetricImbalance computes the coefficient of variation and max/mean ratio across nodes over time
+// and the nodes whose mean deviates strongly from the other nodes. m must be separated by hostname.
+func MetricImbalance(m job.MetricData) job.MetricImbalance {
+	imbalance := job.MetricImbalance{
+		GUID:     m.Config.GUID,
+		Points:   []job.ImbalancePoint{},
+		Outliers: []job.OutlierNode{},
+	}
+
+	// Collect the values of all nodes per point in time and the mean per node
+	valuesAt := make(map[time.Time][]float64)
+	hosts := make([]string, 0, len(m.Data))
+	hostMeans := make([]float64, 0, len(m.Data))
+	for host, rows := range m.Data {
+		values := valuesByTime(rows)
+		if len(values) == 0 {
+			continue
+		}
+		sum := 0.0
+		for t, v := range values {
+			valuesAt[t] = append(valuesAt[t], v)
+			sum += v
+		}
+		hosts = append(hosts, host)
+		hostMeans = append(hostMeans, sum/float64(len(values)))
+	}
+
+	scoreSum := 0.0
+	for t, values := range valuesAt {
+		if len(values) < 2 {
+			continue
+		}
+		mean, stdDev, max := statistics(values)
+		point := job.ImbalancePoint{Time: t}
+		if mean > 0 {
+			point.CoefficientOfVariation = stdDev / mean
+			point.MaxMeanRatio = max / mean
+		}
+		imbalance.Points = append(imbalance.Points, point)
+		scoreSum += point.CoefficientOfVariation
+	}
+	sort.Slice(imbalance.Points, func(i, j int) bool {
+		return imbalance.Points[i].Time.Before(imbalance.Points[j].Time)
+	})
+	if len(imbalance.Points) > 0 {
+		imbalance.Score = scoreSum / float64(len(imbalance.Points))
+	}
+
+	imbalance.Outliers = outliers(hosts, hostMeans)
+	return imbalance
+}
+
+// outliers returns the hosts whose mean deviates from the median of means by more than
+// outlierThreshold robust standard deviations. At least three hosts are required.
+func outliers(hosts []string, means []float64) []job.OutlierNode {
+	result := []job.OutlierNode{}
+	if len(hosts) < 3 {
+		return result
+	}
+	med := median(means)
+	deviations := make([]float64, len(means))
+	for i, v := range means {
+		deviations[i] = math.Abs(v - med)
+	}
+	scale := madScale * median(deviations)
+	if scale == 0 {
+		// Most nodes have the same mean, nodes deviating by outlierRelativeDeviation get score outlierThreshold
+		scale = outlierRelativeDeviation * math.Abs(med) / outlierThreshold
+	}
+	if scale == 0 {
+		return result
+	}
+
+	for i, v := range means {
+		if score := (v - med) / scale; math.Abs(score) > outlierThreshold {
+			result = append(result, job.OutlierNode{Hostname: hosts[i], Mean: v, Score: score})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Hostname < result[j].Hostname
+	})
+	return result
+}
+
+// statistics returns the mean, the population standard deviation and the maximum of values.
+func statistics(values []float64) (mean float64, stdDev float64, max float64) {
+	max = math.Inf(-1)
+	for _, v := range values {
+		mean += v
+		max = math.Max(max, v)
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdDev += (v - mean) * (v - mean)
+	}
+	stdDev = math.Sqrt(stdDev / float64(len(values)))
+	return
+}
+
+// median returns the median of values without modifying values.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Metrics with a higher mean coefficient of variation across nodes are reported as imbalanced
+const imbalanceWarning = 0.25
+
+// imbalanceAnalyzer reports metrics that are unevenly distributed over the nodes and outlier nodes.
+type imbalanceAnalyzer struct{}
+
+func init() {
+	Register(imbalanceAnalyzer{})
+}
+
+func (imbalanceAnalyzer) Name() string {
+	return "imbalance"
+}
+
+func (imbalanceAnalyzer) Analyze(ctx context.Context, data *job.JobData, c *conf.Configuration) (Report, error) {
+	imbalances := Imbalance(data)
+
+	findings := []job.Finding{}
+	for _, imbalance := range imbalances {
+		if imbalance.Score > imbalanceWarning {
+			findings = append(findings, job.Finding{
+				Kind:     "load-imbalance",
+				Severity: SeverityWarning,
+				Metric:   imbalance.GUID,
+				Value:    imbalance.Score,
+				Message:  fmt.Sprintf("Mean coefficient of variation across nodes is %.2f", imbalance.Score),
+			})
+		}
+		if len(imbalance.Outliers) > 0 {
+			hosts := make([]string, len(imbalance.Outliers))
+			for i, outlier := range imbalance.Outliers {
+				hosts[i] = outlier.Hostname
+			}
+			findings = append(findings, job.Finding{
+				Kind:     "outlier-nodes",
+				Severity: SeverityWarning,
+				Metric:   imbalance.GUID,
+				Nodes:    hosts,
+				Value:    float64(len(hosts)),
+				Message:  "Nodes deviating strongly from the other nodes: " + strings.Join(hosts, ", "),
+			})
+		}
+	}
+
+	return Report{
+		Findings: findings,
+		Annotate: func(j *job.JobMetadata) {
+			for _, imbalance := range imbalances {
+				for i := range j.Data {
+					if j.Data[i].Config.GUID != imbalance.GUID {
+						continue
+					}
+					j.Data[i].Imbalance = imbalance.Score
+					j.Data[i].OutlierNodes = nil
+					for _, outlier := range imbalance.Outliers {
+						j.Data[i].OutlierNodes = append(j.Data[i].OutlierNodes, outlier.Hostname)
+					}
+				}
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,56 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"jobmon/config"
+	"jobmon/job"
+)
+
+// Tests if the imbalance over time and an idle node are detected
+func TestMetricImbalance(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := time.Unix(1030, 0)
+	data := map[string][]job.QueryResult{
+		"n4": {{"_time": t0, "_value": 0.0}, {"_time": t1, "_value": 0.0}},
+	}
+	for _, host := range []string{"n1", "n2", "n3"} {
+		data[host] = []job.QueryResult{{"_time": t0, "_value": 100.0}, {"_time": t1, "_value": 100.0}}
+	}
+
+	imbalance := MetricImbalance(job.MetricData{Config: config.MetricConfig{GUID: "cpu"}, Data: data})
+
+	if imbalance.GUID != "cpu" || len(imbalance.Points) != 2 || imbalance.Points[0].Time != t0 {
+		t.Fatalf("Wrong imbalance points: %+v", imbalance)
+	}
+	// Mean 75, standard deviation 43.3
+	if p := imbalance.Points[0]; math.Abs(p.CoefficientOfVariation-0.57735) > 1e-4 || math.Abs(p.MaxMeanRatio-4.0/3) > 1e-9 {
+		t.Fatalf("Wrong imbalance point: %+v", p)
+	}
+	if math.Abs(imbalance.Score-0.57735) > 1e-4 {
+		t.Fatalf("Wrong imbalance score %v", imbalance.Score)
+	}
+	if len(imbalance.Outliers) != 1 || imbalance.Outliers[0].Hostname != "n4" || imbalance.Outliers[0].Score >= 0 {
+		t.Fatalf("Idle node was not detected: %+v", imbalance.Outliers)
+	}
+}
+
+// Tests if balanced nodes have no outliers and single node data is skipped
+func TestImbalanceBalanced(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	data := map[string][]job.QueryResult{}
+	for i, host := range []string{"n1", "n2", "n3", "n4"} {
+		data[host] = []job.QueryResult{{"_time": t0, "_value": 100.0 + float64(i)}}
+	}
+	jobData := job.JobData{MetricData: []job.MetricData{
+		{Config: config.MetricConfig{GUID: "cpu"}, Data: data},
+		{Config: config.MetricConfig{GUID: "mem"}, Data: map[string][]job.QueryResult{"n1": data["n1"]}},
+	}}
+
+	result := Imbalance(&jobData)
+	if len(result) != 1 || result[0].GUID != "cpu" || len(result[0].Outliers) != 0 || result[0].Score > 0.02 {
+		t.Fatalf("Wrong imbalance of balanced nodes: %+v", result)
+	}
+}
@@ -0,0 +1,301 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	conf "jobmon/config"
+	"jobmon/job"
+
+	"golang.org/x/exp/slices"
+)
+
+// Labels of phases dominated by metrics of a category; Key is the metric category
+var phaseLabels = map[string]string{
+	"Performance":  "compute",
+	"Filesystem":   "I/O load",
+	"Memory":       "memory load",
+	"Interconnect": "communication",
+}
+
+// Phases where all metrics stay below this fraction of their maximum are idle
+const idleLevel = 0.2
+
+// timeSeries is the mean over all nodes of a metric, sorted by time.
+type timeSeries struct {
+	config conf.MetricConfig
+	times  []time.Time
+	values []float64
+}
+
+// Phases splits each metric of j at its change points into segments and merges the change
+// points of all metrics into phases of the job. Change points of different metrics closer than
+// c.MinSegment sample intervals are considered the same. Rows without value are skipped.
+func Phases(j *job.JobData, c conf.PhaseDetectionConfig) job.JobPhases {
+	c = c.WithDefaults()
+	result := job.JobPhases{
+		Method:     c.Method,
+		Penalty:    c.Penalty,
+		MinSegment: c.MinSegment,
+		Phases:     []job.JobPhase{},
+		Metrics:    []job.MetricSegments{},
+	}
+
+	series := make([]timeSeries, 0, len(j.MetricData))
+	for _, m := range j.MetricData {
+		if s := meanSeries(m); len(s.values) > 0 {
+			series = append(series, s)
+		}
+	}
+	if len(series) == 0 {
+		return result
+	}
+	sort.Slice(series, func(a, b int) bool {
+		return series[a].config.GUID < series[b].config.GUID
+	})
+
+	start, stop := series[0].times[0], series[0].times[len(series[0].times)-1]
+	var step time.Duration
+	var changePoints []time.Time
+	for _, s := range series {
+		segments := job.MetricSegments{GUID: s.config.GUID, ChangePoints: []time.Time{}, Segments: []job.PhaseSegment{}}
+		bounds := append([]int{0}, ChangePoints(s.values, c)...)
+		for i, from := range bounds {
+			to := len(s.values)
+			if i+1 < len(bounds) {
+				to = bounds[i+1]
+			}
+			segment := job.PhaseSegment{Start: s.times[from], Stop: s.times[len(s.times)-1]}
+			if to < len(s.times) {
+				segment.Stop = s.times[to]
+			}
+			segment.PhaseStatistics = phaseStatistics(s.values[from:to])
+			segments.Segments = append(segments.Segments, segment)
+			if i > 0 {
+				segments.ChangePoints = append(segments.ChangePoints, s.times[from])
+			}
+		}
+		result.Metrics = append(result.Metrics, segments)
+		changePoints = append(changePoints, segments.ChangePoints...)
+
+		if s.times[0].Before(start) {
+			start = s.times[0]
+		}
+		if last := s.times[len(s.times)-1]; last.After(stop) {
+			stop = last
+		}
+		if d := medianStep(s.times); d > step {
+			step = d
+		}
+	}
+
+	bounds := append([]time.Time{start}, mergeChangePoints(changePoints, time.Duration(c.MinSegment)*step)...)
+	bounds = append(bounds, stop)
+	for i := 0; i+1 < len(bounds); i++ {
+		phase := job.JobPhase{Start: bounds[i], Stop: bounds[i+1], Metrics: make(map[string]job.PhaseStatistics)}
+		levels := make(map[string]float64)
+		for _, s := range series {
+			values := s.valuesBetween(phase.Start, phase.Stop, i+2 == len(bounds))
+			if len(values) == 0 {
+				continue
+			}
+			stats := phaseStatistics(values)
+			phase.Metrics[s.config.GUID] = stats
+			if _, _, max := statistics(s.values); max > 0 {
+				levels[s.config.GUID] = stats.Mean / max
+			}
+		}
+		phase.Label = phaseLabel(series, levels, i == 0, i+2 == len(bounds))
+		result.Phases = append(result.Phases, phase)
+	}
+	return result
+}
+
+// meanSeries returns the mean over all nodes of m per point in time. Rows without value are skipped.
+func meanSeries(m job.MetricData) timeSeries {
+	type sum struct {
+		value float64
+		count int
+	}
+	sums := make(map[time.Time]*sum)
+	for _, rows := range m.Data {
+		for t, v := range valuesByTime(rows) {
+			s, ok := sums[t]
+			if !ok {
+				s = &sum{}
+				sums[t] = s
+			}
+			s.value += v
+			s.count++
+		}
+	}
+
+	series := timeSeries{config: m.Config, times: make([]time.Time, 0, len(sums))}
+	for t := range sums {
+		series.times = append(series.times, t)
+	}
+	sort.Slice(series.times, func(a, b int) bool {
+		return series.times[a].Before(series.times[b])
+	})
+	series.values = make([]float64, len(series.times))
+	for i, t := range series.times {
+		series.values[i] = sums[t].value / float64(sums[t].count)
+	}
+	return series
+}
+
+// valuesBetween returns the values from start to stop; stop is only included if inclusive is true.
+func (s timeSeries) valuesBetween(start time.Time, stop time.Time, inclusive bool) []float64 {
+	from := sort.Search(len(s.times), func(i int) bool {
+		return !s.times[i].Before(start)
+	})
+	to := sort.Search(len(s.times), func(i int) bool {
+		return s.times[i].After(stop) || (!inclusive && s.times[i].Equal(stop))
+	})
+	if from >= to {
+		return nil
+	}
+	return s.values[from:to]
+}
+
+// mergeChangePoints sorts changePoints and replaces change points that are at most tolerance
+// apart from the first change point of their group by the median of the group.
+func mergeChangePoints(changePoints []time.Time, tolerance time.Duration) []time.Time {
+	sorted := slices.Clone(changePoints)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Before(sorted[b])
+	})
+	merged := []time.Time{}
+	for first := 0; first < len(sorted); {
+		last := first
+		for last+1 < len(sorted) && sorted[last+1].Sub(sorted[first]) <= tolerance {
+			last++
+		}
+		merged = append(merged, sorted[(first+last)/2])
+		first = last + 1
+	}
+	return merged
+}
+
+// medianStep returns the median duration between two consecutive times.
+func medianStep(times []time.Time) time.Duration {
+	if len(times) < 2 {
+		return 0
+	}
+	steps := make([]float64, len(times)-1)
+	for i := range steps {
+		steps[i] = float64(times[i+1].Sub(times[i]))
+	}
+	return time.Duration(median(steps))
+}
+
+// phaseLabel describes the dominating load of a phase by the category of the metric with the highest
+// level, i.e. the mean during the phase relative to the maximum of the metric. Only metrics of categories
+// with a label in phaseLabels are considered; if there are none, the phase is labeled "active".
+func phaseLabel(series []timeSeries, levels map[string]float64, first bool, last bool) string {
+	label, labelLevel := "", 0.0
+	considered := false
+	for _, s := range series {
+		for _, category := range s.config.Categories {
+			if l, ok := phaseLabels[category]; ok {
+				considered = true
+				if levels[s.config.GUID] > labelLevel {
+					label, labelLevel = l, levels[s.config.GUID]
+				}
+			}
+		}
+	}
+	if !considered {
+		label = "active"
+		for _, level := range levels {
+			labelLevel = math.Max(labelLevel, level)
+		}
+	}
+	if labelLevel >= idleLevel {
+		return label
+	}
+	switch {
+	case last && !first:
+		return "idle tail"
+	case first && !last:
+		return "idle start"
+	}
+	return "idle"
+}
+
+// phaseStatistics returns the mean and maximum of values.
+func phaseStatistics(values []float64) job.PhaseStatistics {
+	mean, _, max := statistics(values)
+	return job.PhaseStatistics{Mean: mean, Max: max}
+}
+
+// Idle phases longer than this fraction of the job are reported as warnings
+const idlePhaseWarning = 0.1
+
+// phaseAnalyzer splits jobs into phases and reports long idle phases.
+type phaseAnalyzer struct{}
+
+func init() {
+	Register(phaseAnalyzer{})
+}
+
+func (phaseAnalyzer) Name() string {
+	return "phases"
+}
+
+func (phaseAnalyzer) Analyze(ctx context.Context, data *job.JobData, c *conf.Configuration) (Report, error) {
+	var detection conf.PhaseDetectionConfig
+	if c.PhaseDetection != nil {
+		detection = *c.PhaseDetection
+	}
+	phases := Phases(data, detection)
+
+	findings := []job.Finding{}
+	if len(phases.Phases) > 0 {
+		labels := make([]string, len(phases.Phases))
+		for i, phase := range phases.Phases {
+			labels[i] = phase.Label
+		}
+		findings = append(findings, job.Finding{
+			Kind:     "phases",
+			Severity: SeverityInfo,
+			Value:    float64(len(phases.Phases)),
+			Message:  fmt.Sprintf("%d phases: %s", len(phases.Phases), strings.Join(labels, ", ")),
+		})
+
+		duration := phases.Phases[len(phases.Phases)-1].Stop.Sub(phases.Phases[0].Start)
+		for _, phase := range phases.Phases {
+			phase := phase
+			d := phase.Stop.Sub(phase.Start)
+			if !strings.HasPrefix(phase.Label, "idle") || float64(d) <= idlePhaseWarning*float64(duration) {
+				continue
+			}
+			findings = append(findings, job.Finding{
+				Kind:     "idle-phase",
+				Severity: SeverityWarning,
+				Start:    &phase.Start,
+				Stop:     &phase.Stop,
+				Value:    d.Seconds(),
+				Message:  fmt.Sprintf("The job was idle for %v (%s)", d, phase.Label),
+			})
+		}
+	}
+
+	return Report{
+		Findings: findings,
+		Annotate: func(j *job.JobMetadata) {
+			j.Phases = &phases
+			for _, segments := range phases.Metrics {
+				for i := range j.Data {
+					if j.Data[i].Config.GUID == segments.GUID {
+						j.Data[i].ChangePoints = segments.ChangePoints
+					}
+				}
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,100 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"jobmon/config"
+	"jobmon/job"
+
+	"pgregory.net/changepoint"
+)
+
+// stepRows returns one row per 30 seconds with value low for the first n points and high for the next n points
+func stepRows(low, high float64, n int) []job.QueryResult {
+	rows := make([]job.QueryResult, 0, 2*n)
+	for i := 0; i < 2*n; i++ {
+		v := low
+		if i >= n {
+			v = high
+		}
+		// Small noise so that the noise variance can be estimated
+		v += float64(i%3) * 0.01
+		rows = append(rows, job.QueryResult{"_time": time.Unix(int64(30*i), 0), "_value": v})
+	}
+	return rows
+}
+
+// Tests if both methods find a single change of the mean
+func TestChangePoints(t *testing.T) {
+	values := make([]float64, 0, 40)
+	for _, row := range stepRows(1, 10, 20) {
+		values = append(values, row["_value"].(float64))
+	}
+	for _, method := range config.ChangePointMethods {
+		cps := ChangePoints(values, config.PhaseDetectionConfig{Method: method})
+		if len(cps) != 1 || cps[0] != 20 {
+			t.Errorf("Wrong change points of method %s: %v", method, cps)
+		}
+	}
+	if cps := ChangePoints(make([]float64, 40), config.PhaseDetectionConfig{Method: "mean"}); len(cps) != 0 {
+		t.Errorf("Change points in constant values: %v", cps)
+	}
+
+	// Without configuration the change points are the same as before phase detection was configurable
+	short := []float64{0, 3, 3, 3, 3, 0, 1, 0, 2, 2, 0, 0, 2, 0}
+	if cps, previous := ChangePoints(short, config.PhaseDetectionConfig{}), changepoint.NonParametric(short, 1); !reflect.DeepEqual(cps, previous) {
+		t.Errorf("Default change points %v differ from previous change points %v", cps, previous)
+	}
+}
+
+// Tests if rows without value are skipped and change points of metrics are merged into labeled phases
+func TestPhases(t *testing.T) {
+	flops := stepRows(100, 1, 20)
+	// Missing values must not stop the detection
+	flops[5]["_value"] = nil
+	io := stepRows(0, 50, 20)
+	// Change of the I/O metric one sample later is the same phase change
+	io[20]["_value"] = 0.0
+
+	j := job.JobData{MetricData: []job.MetricData{
+		{
+			Config: config.MetricConfig{GUID: "flops", Categories: []string{"Performance"}},
+			Data:   map[string][]job.QueryResult{"n1": flops, "n2": stepRows(100, 1, 20)},
+		},
+		{
+			Config: config.MetricConfig{GUID: "io", Categories: []string{"Filesystem"}},
+			Data:   map[string][]job.QueryResult{"n1": io},
+		},
+	}}
+
+	phases := Phases(&j, config.PhaseDetectionConfig{Method: "mean"})
+
+	if phases.Method != "mean" || phases.Penalty != 3 {
+		t.Fatalf("Wrong method or penalty: %s %v", phases.Method, phases.Penalty)
+	}
+	if len(phases.Metrics) != 2 || len(phases.Metrics[0].ChangePoints) != 1 || len(phases.Metrics[1].ChangePoints) != 1 {
+		t.Fatalf("Wrong metric segments: %+v", phases.Metrics)
+	}
+	if len(phases.Phases) != 2 {
+		t.Fatalf("Change points were not merged: %+v", phases.Phases)
+	}
+	if phases.Phases[0].Label != "compute" || phases.Phases[1].Label != "I/O load" {
+		t.Fatalf("Wrong labels: %s, %s", phases.Phases[0].Label, phases.Phases[1].Label)
+	}
+	if s := phases.Phases[0].Metrics["flops"]; s.Mean < 100 || s.Max > 100.1 {
+		t.Fatalf("Wrong statistics of first phase: %+v", s)
+	}
+}
+
+// Tests if phases without load at the end of a job are idle tails
+func TestPhaseLabelIdle(t *testing.T) {
+	series := []timeSeries{{config: config.MetricConfig{GUID: "flops", Categories: []string{"Performance"}}}}
+	if l := phaseLabel(series, map[string]float64{"flops": 0.05}, false, true); l != "idle tail" {
+		t.Fatalf("Wrong label %s", l)
+	}
+	if l := phaseLabel(nil, map[string]float64{"temp": 0.9}, true, true); l != "active" {
+		t.Fatalf("Wrong label %s", l)
+	}
+}
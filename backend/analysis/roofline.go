@@ -0,0 +1,129 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"jobmon/job"
+)
+
+// Factors of the unit prefixes supported by metric units
+var unitPrefixFactors = map[byte]float64{
+	'k': 1e3,
+	'M': 1e6,
+	'G': 1e9,
+	'T': 1e12,
+}
+
+// RooflinePoint is the operational intensity and achieved performance at one point in time.
+type RooflinePoint struct {
+	Time time.Time
+	// Operational intensity in FLOP/B
+	Intensity float64
+	// Achieved performance per node in FLOP/s
+	Performance float64
+}
+
+// RooflineData contains the points of a job in the roofline model.
+type RooflineData struct {
+	// Peak floating point performance of a node in FLOP/s; 0 if not configured
+	PeakFlops float64
+	// Peak memory bandwidth of a node in B/s; 0 if not configured
+	PeakMemBandwidth float64
+	// Operational intensity in FLOP/B above which performance is bound by the peak floating point performance
+	RidgePoint float64
+	// Points of each node over time; Key is the hostname
+	Nodes map[string][]RooflinePoint
+	// Points of the whole job over time; Performance is the mean per node
+	Job []RooflinePoint
+}
+
+// Roofline computes the operational intensity and achieved performance per node and for the
+// whole job over time. flops and memBandwidth must contain per node data separated by hostname.
+// Values are converted to FLOP/s and B/s according to the unit prefix of the metrics.
+// Points where the memory bandwidth is 0 have no defined intensity and are skipped.
+func Roofline(flops job.MetricData, memBandwidth job.MetricData, peakFlops float64, peakMemBandwidth float64) RooflineData {
+	data := RooflineData{
+		PeakFlops:        peakFlops,
+		PeakMemBandwidth: peakMemBandwidth,
+		Nodes:            make(map[string][]RooflinePoint),
+		Job:              []RooflinePoint{},
+	}
+	if peakMemBandwidth > 0 {
+		data.RidgePoint = peakFlops / peakMemBandwidth
+	}
+	flopsFactor := unitFactor(flops.Config.Unit)
+	bandwidthFactor := unitFactor(memBandwidth.Config.Unit)
+
+	// Sums over all nodes per point in time
+	type sums struct {
+		flops, bandwidth float64
+		nodes            int
+	}
+	jobSums := make(map[time.Time]*sums)
+
+	for host, flopsRows := range flops.Data {
+		bandwidth := valuesByTime(memBandwidth.Data[host])
+		points := []RooflinePoint{}
+		for t, f := range valuesByTime(flopsRows) {
+			b, ok := bandwidth[t]
+			if !ok || b <= 0 {
+				continue
+			}
+			f *= flopsFactor
+			b *= bandwidthFactor
+			points = append(points, RooflinePoint{Time: t, Intensity: f / b, Performance: f})
+
+			s, ok := jobSums[t]
+			if !ok {
+				s = &sums{}
+				jobSums[t] = s
+			}
+			s.flops += f
+			s.bandwidth += b
+			s.nodes++
+		}
+		sortPoints(points)
+		data.Nodes[host] = points
+	}
+
+	for t, s := range jobSums {
+		data.Job = append(data.Job, RooflinePoint{Time: t, Intensity: s.flops / s.bandwidth, Performance: s.flops / float64(s.nodes)})
+	}
+	sortPoints(data.Job)
+	return data
+}
+
+// unitFactor returns the factor to convert values in unit to the unit without prefix, e.g. 1e9 for "GFLOP/s".
+func unitFactor(unit string) float64 {
+	if len(unit) < 2 {
+		return 1
+	}
+	factor, ok := unitPrefixFactors[unit[0]]
+	if !ok || !(strings.HasPrefix(unit[1:], "FLOP") || strings.HasPrefix(unit[1:], "B")) {
+		return 1
+	}
+	return factor
+}
+
+// valuesByTime returns the float values of rows by their time. Rows without value are skipped.
+func valuesByTime(rows []job.QueryResult) map[time.Time]float64 {
+	values := make(map[time.Time]float64, len(rows))
+	for _, row := range rows {
+		t, ok := row["_time"].(time.Time)
+		if !ok {
+			continue
+		}
+		if v, ok := row["_value"].(float64); ok {
+			values[t] = v
+		}
+	}
+	return values
+}
+
+func sortPoints(points []RooflinePoint) {
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+}
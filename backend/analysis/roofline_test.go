@@ -0,0 +1,53 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"jobmon/config"
+	"jobmon/job"
+)
+
+// Tests if points are computed per node and job with unit conversion and missing values
+func TestRoofline(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := time.Unix(1030, 0)
+	flops := job.MetricData{
+		Config: config.MetricConfig{Unit: "GFLOP/s"},
+		Data: map[string][]job.QueryResult{
+			"n1": {{"_time": t0, "_value": 10.0}, {"_time": t1, "_value": 20.0}},
+			"n2": {{"_time": t0, "_value": 30.0}, {"_time": t1, "_value": nil}},
+		},
+	}
+	memBandwidth := job.MetricData{
+		Config: config.MetricConfig{Unit: "MB/s"},
+		Data: map[string][]job.QueryResult{
+			"n1": {{"_time": t0, "_value": 1000.0}, {"_time": t1, "_value": 0.0}},
+			"n2": {{"_time": t0, "_value": 3000.0}, {"_time": t1, "_value": 1000.0}},
+		},
+	}
+
+	data := Roofline(flops, memBandwidth, 100e9, 10e9)
+
+	if data.RidgePoint != 10 {
+		t.Fatalf("Wrong ridge point %v", data.RidgePoint)
+	}
+	if n1 := data.Nodes["n1"]; len(n1) != 1 || n1[0].Intensity != 10 || n1[0].Performance != 10e9 {
+		t.Fatalf("Wrong points of n1: %+v", n1)
+	}
+	if n2 := data.Nodes["n2"]; len(n2) != 1 || n2[0].Time != t0 {
+		t.Fatalf("Points without values were not skipped: %+v", n2)
+	}
+	if len(data.Job) != 1 || data.Job[0].Intensity != 10 || data.Job[0].Performance != 20e9 {
+		t.Fatalf("Wrong job points: %+v", data.Job)
+	}
+}
+
+// Tests if only prefixes of FLOP and byte units are converted
+func TestUnitFactor(t *testing.T) {
+	for unit, want := range map[string]float64{"GFLOP/s": 1e9, "MB/s": 1e6, "FLOP/s": 1, "B/s": 1, "Packet/s": 1, "": 1} {
+		if got := unitFactor(unit); got != want {
+			t.Errorf("Wrong factor for %s: %v", unit, got)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"encoding/json"
+	"jobmon/logging"
+	"jobmon/store"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audited actions
+const (
+	ConfigUpdate        = "config.update"
+	ConfigRollback      = "config.rollback"
+	UserConfigUpdate    = "user.config"
+	APIKeyGenerate      = "apikey.generate"
+	MetadataRefresh     = "job.refresh_metadata"
+	TagAdd              = "tag.add"
+	TagRemove           = "tag.remove"
+	RoleSet             = "role.set"
+	RoleRemove          = "role.remove"
+	RoleRequestApprove  = "role_request.approve"
+	RoleRequestDeny     = "role_request.deny"
+	LocalUserCreate     = "local_user.create"
+	LocalUserUpdate     = "local_user.update"
+	LocalUserRemove     = "local_user.remove"
+	PasswordChange      = "user.password"
+	ImpersonationStart  = "impersonation.start"
+	ImpersonationStop   = "impersonation.stop"
+	AggregationBackfill = "aggregation.backfill"
+)
+
+// Replacement for values of sensitive fields
+const redactedValue = "<redacted>"
+
+// Arrays with more elements are recorded as a whole instead of per element
+const maxFlattenedElements = 1000
+
+// Field names containing one of these words are never written to the audit log in clear text
+var sensitiveFields = []string{"secret", "password", "token", "hash"}
+
+// Logger writes audit log entries to the store.
+type Logger struct {
+	store *store.Store
+}
+
+// Init initializes the audit logger with store.
+func (l *Logger) Init(store *store.Store) {
+	l.store = store
+}
+
+// Log stores entry with the current time. Failures are logged but do not abort the audited action.
+func (l *Logger) Log(entry store.AuditEntry) {
+	entry.Timestamp = time.Now()
+	if err := (*l.store).AddAuditEntry(&entry); err != nil {
+		logging.Error("audit: Log(): Could not store audit entry for action '", entry.Action, "' by '", entry.Username, "': ", err)
+		return
+	}
+	logging.Info("audit: Log(): ", entry.Username, " ", entry.Action, " ", entry.Target)
+}
+
+// RemoteAddr returns the address of the client that sent req. The X-Forwarded-For and X-Real-IP
+// headers are only used if req was sent by one of the trustedProxies, given as IP addresses or
+// CIDR ranges; otherwise clients could forge them. In X-Forwarded-For, the last address that is
+// not a trusted proxy is the client.
+func RemoteAddr(req *http.Request, trustedProxies []string) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !isTrustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addrs := strings.Split(forwarded, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if i == 0 || !isTrustedProxy(addr, trustedProxies) {
+				return addr
+			}
+		}
+	}
+	if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+		return realIP
+	}
+	return host
+}
+
+// isTrustedProxy reports whether addr is one of the IP addresses or in one of the CIDR ranges trustedProxies.
+func isTrustedProxy(addr string, trustedProxies []string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the fields that differ between the JSON representations of before and after.
+// Nested objects and arrays are flattened to dotted field names, e.g. "Metrics.2.Measurement".
+// Values of sensitive fields like secrets or password hashes are redacted.
+func Diff(before any, after any) []store.AuditChange {
+	beforeFields := flatten(before)
+	afterFields := flatten(after)
+
+	fields := make([]string, 0, len(beforeFields)+len(afterFields))
+	for f := range beforeFields {
+		fields = append(fields, f)
+	}
+	for f := range afterFields {
+		if _, ok := beforeFields[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]store.AuditChange, 0)
+	for _, f := range fields {
+		b, a := beforeFields[f], afterFields[f]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		if isSensitive(f) {
+			if b != nil {
+				b = redactedValue
+			}
+			if a != nil {
+				a = redactedValue
+			}
+		}
+		changes = append(changes, store.AuditChange{Field: f, Before: b, After: a})
+	}
+	return changes
+}
+
+// Snapshot returns a copy of the JSON representation of v, to be passed to Diff
+// as before value when v is modified in place.
+func Snapshot(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logging.Error("audit: Snapshot(): Could not marshal value: ", err)
+		return nil
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		logging.Error("audit: Snapshot(): Could not unmarshal value: ", err)
+		return nil
+	}
+	return generic
+}
+
+// flatten returns the leaf values of the JSON representation of v by their dotted path.
+func flatten(v any) map[string]any {
+	fields := make(map[string]any)
+	if v == nil {
+		return fields
+	}
+	flattenInto(fields, "", Snapshot(v))
+	return fields
+}
+
+func flattenInto(fields map[string]any, prefix string, v any) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		if len(val) == 0 {
+			fields[prefix] = val
+		}
+		for k, child := range val {
+			flattenInto(fields, join(k), child)
+		}
+	case []any:
+		if len(val) == 0 || len(val) > maxFlattenedElements {
+			fields[prefix] = val
+			return
+		}
+		for i, child := range val {
+			flattenInto(fields, join(strconv.Itoa(i)), child)
+		}
+	default:
+		fields[prefix] = val
+	}
+}
+
+// isSensitive reports whether field may contain credentials.
+func isSensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, s := range sensitiveFields {
+		if strings.Contains(field, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"jobmon/store"
+	"jobmon/test"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tests if only changed fields are recorded and nested values are flattened
+func TestDiff(t *testing.T) {
+	type partition struct {
+		Metrics []string
+	}
+	type config struct {
+		Name       string
+		JWTSecret  string
+		Partitions map[string]partition
+	}
+	before := config{
+		Name:       "a",
+		JWTSecret:  "old",
+		Partitions: map[string]partition{"p1": {Metrics: []string{"cpu", "mem"}}},
+	}
+	after := config{
+		Name:       "a",
+		JWTSecret:  "new",
+		Partitions: map[string]partition{"p1": {Metrics: []string{"cpu", "gpu"}}},
+	}
+
+	changes := Diff(before, after)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	if changes[0].Field != "JWTSecret" || changes[0].Before != redactedValue || changes[0].After != redactedValue {
+		t.Fatalf("Secret was not redacted: %+v", changes[0])
+	}
+	if changes[1].Field != "Partitions.p1.Metrics.1" || changes[1].Before != "mem" || changes[1].After != "gpu" {
+		t.Fatalf("Wrong nested change: %+v", changes[1])
+	}
+
+	if changes := Diff(before, before); len(changes) != 0 {
+		t.Fatalf("Unchanged value produced changes: %+v", changes)
+	}
+	if changes := Diff(nil, store.Role{Name: "pi", Permissions: []string{"x"}}); len(changes) != 2 {
+		t.Fatalf("Created value should record all fields, got %+v", changes)
+	}
+}
+
+// Tests if Snapshot is not affected by later changes of the original value
+func TestSnapshot(t *testing.T) {
+	metrics := []string{"cpu", "mem"}
+	before := Snapshot(metrics)
+	metrics[1] = "gpu"
+
+	changes := Diff(before, metrics)
+	if len(changes) != 1 || changes[0].Before != "mem" {
+		t.Fatalf("Snapshot changed with original: %+v", changes)
+	}
+}
+
+// Tests if entries are stored with a timestamp
+func TestLog(t *testing.T) {
+	mockStore := &test.MockStore{}
+	var s store.Store = mockStore
+	logger := Logger{}
+	logger.Init(&s)
+
+	logger.Log(store.AuditEntry{Username: "adminTest", Action: TagAdd, Target: "1"})
+	if len(mockStore.AuditEntries) != 1 || mockStore.AuditEntries[0].Timestamp.IsZero() {
+		t.Fatalf("Audit entry was not stored: %+v", mockStore.AuditEntries)
+	}
+}
+
+// Tests if the address set by a trusted reverse proxy is preferred
+func TestRemoteAddr(t *testing.T) {
+	trusted := []string{"10.0.0.1", "172.16.0.0/12"}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if addr := RemoteAddr(req, trusted); addr != "10.0.0.1" {
+		t.Fatalf("Wrong remote address %s", addr)
+	}
+	req.Header.Set("X-Forwarded-For", "192.168.1.5, 172.16.3.4")
+	if addr := RemoteAddr(req, trusted); addr != "192.168.1.5" {
+		t.Fatalf("Forwarded address not used, got %s", addr)
+	}
+
+	// Addresses prepended by the client are skipped
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.5")
+	if addr := RemoteAddr(req, trusted); addr != "192.168.1.5" {
+		t.Fatalf("Forged forwarded address used, got %s", addr)
+	}
+}
+
+// Tests if the headers of clients that are not trusted proxies are ignored
+func TestRemoteAddrSpoofed(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.168.1.5:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Real-IP", "1.2.3.4")
+	if addr := RemoteAddr(req, nil); addr != "192.168.1.5" {
+		t.Fatalf("Spoofed address used without trusted proxies, got %s", addr)
+	}
+	if addr := RemoteAddr(req, []string{"10.0.0.0/8"}); addr != "192.168.1.5" {
+		t.Fatalf("Spoofed address used from untrusted client, got %s", addr)
+	}
+}
@@ -3,8 +3,10 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"jobmon/config"
@@ -26,11 +28,13 @@ import (
 	"golang.org/x/oauth2"
 )
 
-// The user can have one of the following roles.
+// Built-in roles. The permissions granted by a role are configured in the store,
+// see DefaultRoles for the initial configuration.
 const (
-	JOBCONTROL = "job-control"
-	USER       = "user"
-	ADMIN      = "admin"
+	JOBCONTROL     = "job-control"
+	USER           = "user"
+	ACCOUNTMANAGER = "account-manager"
+	ADMIN          = "admin"
 )
 
 // AuthPayLoad stores credentials of local users.
@@ -44,6 +48,14 @@ type AuthPayload struct {
 type UserInfo struct {
 	Roles    []string `json:"Roles"`
 	Username string   `json:"Username"`
+	// Accounts managed by the user, used by the view-account-jobs permission
+	Accounts []string `json:"Accounts,omitempty"`
+	// Email address as provided by the OAuth provider
+	Email string `json:"Email,omitempty"`
+	// Admin impersonating this user; impersonation sessions have restricted permissions
+	ImpersonatedBy string `json:"ImpersonatedBy,omitempty"`
+	// Permissions granted by Roles; resolved on every request and never part of the JWT
+	Permissions []string `json:"-"`
 }
 
 // UserClaims stores UserInfo and
@@ -73,9 +85,12 @@ type UserSession struct {
 // AuthManager is the main object that stores all the necessary information for
 // localUsers, OAuthUsers, sessions etc.
 type AuthManager struct {
+	// Guards the settings that are replaced by Reconfigure
+	configLock           sync.RWMutex
 	hmacSampleSecret     []byte // JWT secret
 	JSONWebTokenLifeTime time.Duration
 	APITokenLifeTime     time.Duration
+	ImpersonationTime    time.Duration
 	store                *store.Store
 	localUsers           map[string]config.LocalUser
 	oauthAvailable       bool
@@ -84,6 +99,9 @@ type AuthManager struct {
 	sessions             map[string]UserSession
 	sessionsLock         sync.Mutex
 	notifier             *notify.Notifier
+	roles                map[string][]string // role name -> permissions
+	rolesLock            sync.RWMutex
+	roleRequestsPerDay   int
 }
 
 // default JWT issuer
@@ -93,8 +111,8 @@ const ISSUER = "monitoring-backend"
 type APIHandle func(http.ResponseWriter, *http.Request, httprouter.Params, UserInfo)
 
 // Protected is a high level function that creates a protected route which
-// requires authLevel authentication level.
-func (authManager *AuthManager) Protected(h APIHandle, authLevel string) httprouter.Handle {
+// requires permission perm. An empty perm only requires a valid session.
+func (authManager *AuthManager) Protected(h APIHandle, perm string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Check if r contains an authorization Cookie, get the full JWT token.
 		token, err := r.Cookie("Authorization")
@@ -147,12 +165,12 @@ func (authManager *AuthManager) Protected(h APIHandle, authLevel string) httprou
 		}
 
 		/*
-		* user.Roles contains the roles send in the authorization header.
-		* Users are allowed if this list contains the needed auth-level or the role "admin"
+		* user.Permissions contains the permissions granted by the roles send in the authorization header.
+		* Users are allowed if this list contains the needed permission
 		 */
-		if utils.Contains(user.Roles, ADMIN) ||
-			utils.Contains(user.Roles, authLevel) {
-			h(w, r, ps, user)
+		if user.HasPermission(perm) {
+			// Queries of the request are scheduled fairly between users
+			h(w, r.WithContext(utils.WithUser(r.Context(), user.Username)), ps, user)
 		} else {
 			http.SetCookie(
 				w,
@@ -173,57 +191,69 @@ func (authManager *AuthManager) Protected(h APIHandle, authLevel string) httprou
 // Init initializes auth with c and store.
 func (auth *AuthManager) Init(c config.Configuration, store *store.Store, notifier *notify.Notifier) {
 
-	auth.JSONWebTokenLifeTime = c.JSONWebTokenLifeTime
-	auth.APITokenLifeTime = c.APITokenLifeTime
-
 	if c.JWTSecret == "" {
 		logging.Fatal("auth: Init(): No jwt secret set")
 	}
-	auth.hmacSampleSecret = []byte(c.JWTSecret)
-
 	if store == nil {
 		logging.Fatal("auth: Init(): No store given")
 	}
 	auth.store = store
-	auth.localUsers = c.LocalUsers
 	auth.notifier = notifier
 
-	err := auth.createOAuthConfig(c)
-	if err != nil {
-		logging.Error("auth: Protected(): OAuth is not available: ", err)
-	}
+	auth.Reconfigure(c)
 	auth.sessions = make(map[string]UserSession)
+	auth.initRoles()
 }
 
-// createOAuthConfig sets the oauthConfig field for auth based on the configuration c.
-func (auth *AuthManager) createOAuthConfig(c config.Configuration) error {
-	auth.oauthAvailable = false
+// Reconfigure applies the token life times, the JWT secret, the bootstrap users,
+// the role request limit and the OAuth settings of c. Existing sessions are kept,
+// but tokens signed with a previous JWT secret are no longer valid.
+func (auth *AuthManager) Reconfigure(c config.Configuration) {
+	oauthConfig, err := createOAuthConfig(c)
+	if err != nil {
+		logging.Error("auth: Reconfigure(): OAuth is not available: ", err)
+	}
+
+	auth.configLock.Lock()
+	defer auth.configLock.Unlock()
+	auth.JSONWebTokenLifeTime = c.JSONWebTokenLifeTime
+	auth.APITokenLifeTime = c.APITokenLifeTime
+	auth.ImpersonationTime = c.ImpersonationLifeTime
+	auth.hmacSampleSecret = []byte(c.JWTSecret)
+	auth.localUsers = c.LocalUsers
+	auth.roleRequestsPerDay = c.RoleRequestsPerDay
+	auth.oauthConfig = oauthConfig
+	auth.oauthUserInfoURL = c.OAuth.UserInfoURL
+	auth.oauthAvailable = err == nil
+}
 
+// createOAuthConfig returns the OAuth configuration based on the configuration c.
+func createOAuthConfig(c config.Configuration) (oauthConfig oauth2.Config, err error) {
 	if c.OAuth.ClientID == "" {
-		return fmt.Errorf("no OAuth ClientID set")
+		return oauthConfig, fmt.Errorf("no OAuth ClientID set")
 	}
 
 	if c.OAuth.Secret == "" {
-		return fmt.Errorf("no OAuth Secret set")
+		return oauthConfig, fmt.Errorf("no OAuth Secret set")
 	}
 
 	if c.OAuth.RedirectURL == "" {
-		return fmt.Errorf("no OAuth RedirectURL set")
+		return oauthConfig, fmt.Errorf("no OAuth RedirectURL set")
 	}
 
 	if c.OAuth.AuthURL == "" {
-		return fmt.Errorf("no OAuth AuthURL set")
+		return oauthConfig, fmt.Errorf("no OAuth AuthURL set")
 	}
 
 	if c.OAuth.TokenURL == "" {
-		return fmt.Errorf("no OAuth TokenURL set")
+		return oauthConfig, fmt.Errorf("no OAuth TokenURL set")
 	}
 
 	if c.OAuth.UserInfoURL == "" {
-		return fmt.Errorf("no OAuth UserInfoURL set")
+		return oauthConfig, fmt.Errorf("no OAuth UserInfoURL set")
 	}
 
-	auth.oauthConfig =
+	oauthConfig =
 		oauth2.Config{
 			ClientID:     c.OAuth.ClientID,
 			ClientSecret: c.OAuth.Secret,
@@ -238,16 +268,17 @@ func (auth *AuthManager) createOAuthConfig(c config.Configuration) error {
 				TokenURL: c.OAuth.TokenURL,
 			},
 		}
-	auth.oauthUserInfoURL = c.OAuth.UserInfoURL
-	auth.oauthAvailable = true
 
 	logging.Info("auth: createOAuthConfig(): Created OAuth config")
-	return nil
+	return oauthConfig, nil
 }
 
 // validate checks if tokenStr is validated from auth, if that's the case
 // it returns the user information.
 func (auth *AuthManager) validate(tokenStr string) (UserInfo, error) {
+	auth.configLock.RLock()
+	secret := auth.hmacSampleSecret
+	auth.configLock.RUnlock()
 
 	// Parse, validate and verify token
 	// This per default also checks if time based claims ExpiresAt, IssuedAt, NotBefore are valid
@@ -261,7 +292,7 @@ func (auth *AuthManager) validate(tokenStr string) (UserInfo, error) {
 			&UserClaims{},
 			// Return key / secret for validating
 			func(token *jwt.Token) (interface{}, error) {
-				return auth.hmacSampleSecret, nil
+				return secret, nil
 			},
 		)
 	if err != nil {
@@ -275,28 +306,42 @@ func (auth *AuthManager) validate(tokenStr string) (UserInfo, error) {
 	}
 
 	// Get token from store
-	tokenFromStore, ok := (*auth.store).GetUserSessionToken(claims.Username)
+	tokenFromStore, ok := (*auth.store).GetUserSessionToken(claims.UserInfo.sessionKey())
 	if !ok || tokenFromStore != tokenStr {
 		return UserInfo{}, fmt.Errorf("session was revoked")
 	}
 
-	logging.Info("auth: validate(): Validated token for ", claims.UserInfo.Username)
-	return claims.UserInfo, nil
+	user := claims.UserInfo
+	user.Permissions = auth.resolvePermissions(user.Roles)
+	if user.ImpersonatedBy != "" {
+		user.Permissions = restrictImpersonation(user.Permissions)
+	}
+
+	logging.Info("auth: validate(): Validated token for ", user.Username)
+	return user, nil
 }
 
 // GenerateJWT, generates a JSON Web Token for the given user.
 func (auth *AuthManager) GenerateJWT(user UserInfo) (string, error) {
 
 	// Set JSON web token life time
+	auth.configLock.RLock()
 	lifeTime := auth.JSONWebTokenLifeTime
+	apiLifeTime := auth.APITokenLifeTime
+	auth.configLock.RUnlock()
 
 	if utils.Contains(user.Roles, JOBCONTROL) {
 		// Notify admins that a new JWT for an api-user was created
 		(*auth.notifier).Notify("JWT for job-control user changed", "The JWT for user '"+user.Username+"' got newly generated.")
 		// Expand lifetime of JWT for api-users
-		lifeTime = auth.APITokenLifeTime
+		lifeTime = apiLifeTime
 	}
 
+	return auth.signJWT(user, lifeTime)
+}
+
+// signJWT creates a JSON Web Token with lifetime lifeTime for user and stores it as the users session.
+func (auth *AuthManager) signJWT(user UserInfo, lifeTime time.Duration) (string, error) {
 	// Set JSON web token claims
 	now := time.Now()
 	claims :=
@@ -311,11 +356,13 @@ func (auth *AuthManager) GenerateJWT(user UserInfo) (string, error) {
 
 	// Create JSON web token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	auth.configLock.RLock()
 	ret, err := token.SignedString(auth.hmacSampleSecret)
+	auth.configLock.RUnlock()
 
 	// Store JSON web token in database
 	if err == nil {
-		(*auth.store).SetUserSessionToken(user.Username, ret)
+		(*auth.store).SetUserSessionToken(user.sessionKey(), ret)
 	}
 
 	return ret, err
@@ -328,7 +375,9 @@ func (auth *AuthManager) AppendJWT(user UserInfo, w http.ResponseWriter) (err er
 		return
 	}
 
+	auth.configLock.RLock()
 	lifeTime := auth.JSONWebTokenLifeTime
+	auth.configLock.RUnlock()
 
 	http.SetCookie(
 		w,
@@ -343,15 +392,42 @@ func (auth *AuthManager) AppendJWT(user UserInfo, w http.ResponseWriter) (err er
 }
 
 // AuthLocalUser returns a user if username and password are valid credentials.
+// Users stored in the store take precedence over the bootstrap users from the configuration.
+// The accounts of bootstrap users are set in the user configuration like those of OAuth users.
 func (auth *AuthManager) AuthLocalUser(
+	ctx context.Context,
 	username string,
 	password string,
 ) (
 	user UserInfo,
 	err error,
 ) {
+	// Check users managed in the store first
+	storeUser, storeErr := (*auth.store).GetLocalUser(ctx, username)
+	if storeErr == nil {
+		if storeUser.Disabled {
+			err = fmt.Errorf("auth: AuthLocalUser(): user '%s' is disabled", username)
+			return
+		}
+		if bcrypt_err := bcrypt.CompareHashAndPassword([]byte(storeUser.BCryptHash), []byte(password)); bcrypt_err != nil {
+			err = fmt.Errorf("auth: AuthLocalUser(): %w", bcrypt_err)
+			return
+		}
+		user =
+			UserInfo{
+				Roles:    storeUser.Roles,
+				Accounts: storeUser.Accounts,
+				Username: username,
+			}
+		logging.Info("auth: AuthLocalUser(): Authenticated local user '", username, "' with roles ", storeUser.Roles)
+		return
+	}
+	if !errors.Is(storeErr, sql.ErrNoRows) {
+		logging.Debug("auth: AuthLocalUser(): Could not get local user '", username, "' from store: ", storeErr)
+	}
+
 	// Check if username is valid
-	val, ok := auth.localUsers[username]
+	val, ok := auth.configLocalUser(username)
 	if !ok {
 		err = fmt.Errorf("auth: AuthLocalUser(): invalid user '%s'", username)
 		return
@@ -364,15 +440,25 @@ func (auth *AuthManager) AuthLocalUser(
 	}
 
 	// Return user information
+	userRoles, _ := (*auth.store).GetUserRoles(username)
 	user =
 		UserInfo{
 			Roles:    []string{val.Role},
+			Accounts: userRoles.Accounts,
 			Username: username,
 		}
 	logging.Info("auth: AuthLocalUser(): Authenticated local user '", username, "' with role '", val.Role, "'")
 	return
 }
 
+// configLocalUser returns the bootstrap user 'username' from the configuration.
+func (auth *AuthManager) configLocalUser(username string) (config.LocalUser, bool) {
+	auth.configLock.RLock()
+	defer auth.configLock.RUnlock()
+	user, ok := auth.localUsers[username]
+	return user, ok
+}
+
 // Logout logs out user <username> from active sessions
 func (auth *AuthManager) Logout(username string) {
 	(*auth.store).RemoveUserSession(username)
@@ -380,11 +466,15 @@ func (auth *AuthManager) Logout(username string) {
 
 // OAuthAvailable checks if OAuth is available.
 func (auth *AuthManager) OAuthAvailable() bool {
+	auth.configLock.RLock()
+	defer auth.configLock.RUnlock()
 	return auth.oauthAvailable
 }
 
 // GetOAuthCodeURL returns the URL that redirects the user to the FeLS login page.
 func (auth *AuthManager) GetOAuthCodeURL(sessionID string) string {
+	auth.configLock.RLock()
+	defer auth.configLock.RUnlock()
 	return auth.oauthConfig.AuthCodeURL(sessionID, oauth2.AccessTypeOnline)
 }
 
@@ -433,13 +523,20 @@ func (auth *AuthManager) SetSessionUserInfo(sessionID string, info OAuthUserInfo
 
 // ExchangeOAuthToken returns authentication token in the case of OAuth authentication.
 func (auth *AuthManager) ExchangeOAuthToken(code string) (*oauth2.Token, error) {
-	return auth.oauthConfig.Exchange(context.Background(), code)
+	auth.configLock.RLock()
+	oauthConfig := auth.oauthConfig
+	auth.configLock.RUnlock()
+	return oauthConfig.Exchange(context.Background(), code)
 }
 
 // GetOAuthUserInfo returns OAuth user info.
 func (auth *AuthManager) GetOAuthUserInfo(token *oauth2.Token) (*OAuthUserInfo, error) {
-	client := auth.oauthConfig.Client(context.Background(), token)
-	resp, err := client.Get(auth.oauthUserInfoURL)
+	auth.configLock.RLock()
+	oauthConfig := auth.oauthConfig
+	userInfoURL := auth.oauthUserInfoURL
+	auth.configLock.RUnlock()
+	client := oauthConfig.Client(context.Background(), token)
+	resp, err := client.Get(userInfoURL)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"jobmon/logging"
+	"jobmon/utils"
+	"time"
+)
+
+// Permissions that are never granted to impersonation sessions, so an admin
+// viewing the system as another user cannot change anything on their behalf.
+var impersonationDeniedPermissions = []string{
+	PermJobControl,
+	PermManageTags,
+	PermEditConfig,
+	PermManageUsers,
+	PermLiveLog,
+}
+
+// restrictImpersonation returns perms without the permissions denied to impersonation sessions.
+func restrictImpersonation(perms []string) []string {
+	restricted := make([]string, 0, len(perms))
+	for _, p := range perms {
+		if !utils.Contains(impersonationDeniedPermissions, p) {
+			restricted = append(restricted, p)
+		}
+	}
+	return restricted
+}
+
+// sessionKey returns the key the session token of user is stored under.
+// Impersonation sessions are stored per admin, so they neither replace
+// the session of the impersonated user nor the session of the admin.
+func (user UserInfo) sessionKey() string {
+	if user.ImpersonatedBy != "" {
+		return "impersonation:" + user.ImpersonatedBy
+	}
+	return user.Username
+}
+
+// lookupUserInfo returns the UserInfo the user 'username' would get on login.
+func (auth *AuthManager) lookupUserInfo(ctx context.Context, username string) (user UserInfo, err error) {
+	user.Username = username
+
+	userRoles, known := (*auth.store).GetUserRoles(username)
+	if known {
+		user.Roles = userRoles.Roles
+		user.Accounts = userRoles.Accounts
+	}
+
+	if localUser, err := (*auth.store).GetLocalUser(ctx, username); err == nil {
+		user.Roles = localUser.Roles
+		user.Accounts = localUser.Accounts
+	} else if localUser, ok := auth.configLocalUser(username); ok {
+		user.Roles = []string{localUser.Role}
+	} else if !known {
+		return user, fmt.Errorf("unknown user '%s'", username)
+	}
+	return user, nil
+}
+
+// GenerateImpersonationJWT generates a time limited JSON Web Token that lets admin see the
+// system as the user 'target'. The token carries the UserInfo of target marked with the
+// impersonating admin; see restrictImpersonation for the permissions of such sessions.
+func (auth *AuthManager) GenerateImpersonationJWT(
+	ctx context.Context,
+	admin UserInfo,
+	target string,
+) (
+	token string,
+	user UserInfo,
+	expires time.Time,
+	err error,
+) {
+	if admin.ImpersonatedBy != "" {
+		return "", user, expires, fmt.Errorf("impersonation sessions cannot start another impersonation")
+	}
+	if target == admin.Username {
+		return "", user, expires, fmt.Errorf("admin '%s' cannot impersonate themselves", admin.Username)
+	}
+
+	user, err = auth.lookupUserInfo(ctx, target)
+	if err != nil {
+		return
+	}
+	user.ImpersonatedBy = admin.Username
+
+	auth.configLock.RLock()
+	lifeTime := auth.ImpersonationTime
+	auth.configLock.RUnlock()
+	expires = time.Now().Add(lifeTime)
+	token, err = auth.signJWT(user, lifeTime)
+	if err != nil {
+		return
+	}
+
+	logging.Warning("auth: GenerateImpersonationJWT(): Admin '", admin.Username, "' impersonates user '", target, "' until ", expires.Format(time.RFC3339))
+	return
+}
+
+// StopImpersonation revokes the impersonation session of user.
+func (auth *AuthManager) StopImpersonation(user UserInfo) error {
+	if user.ImpersonatedBy == "" {
+		return fmt.Errorf("user '%s' is not impersonated", user.Username)
+	}
+	(*auth.store).RemoveUserSession(user.sessionKey())
+	logging.Warning("auth: StopImpersonation(): Admin '", user.ImpersonatedBy, "' stopped impersonating user '", user.Username, "'")
+	return nil
+}
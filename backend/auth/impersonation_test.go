@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"jobmon/store"
+	"jobmon/test"
+	"testing"
+	"time"
+)
+
+// Tests if impersonation tokens carry the target user with restricted permissions
+// and leave the sessions of admin and target untouched
+func TestImpersonation(t *testing.T) {
+	mockStore := &test.MockStore{
+		UserRoles: map[string]store.UserRoles{
+			"alice": {Username: "alice", Roles: []string{USER, JOBCONTROL}, Accounts: []string{"proj1"}},
+		},
+	}
+	authManager := newTestAuthManager(mockStore)
+	authManager.ImpersonationTime = time.Hour
+
+	admin := UserInfo{Username: "adminTest", Roles: []string{ADMIN}}
+	adminToken, _ := authManager.GenerateJWT(admin)
+	aliceToken, _ := authManager.GenerateJWT(UserInfo{Username: "alice", Roles: []string{USER}})
+
+	token, user, _, err := authManager.GenerateImpersonationJWT(context.Background(), admin, "alice")
+	if err != nil {
+		t.Fatalf("Could not impersonate user: %v", err)
+	}
+	if user.Username != "alice" || user.ImpersonatedBy != admin.Username || len(user.Accounts) != 1 {
+		t.Fatalf("Wrong impersonated user: %+v", user)
+	}
+
+	validated, err := authManager.validate(token)
+	if err != nil {
+		t.Fatalf("Impersonation token not valid: %v", err)
+	}
+	if !validated.HasPermission(PermViewOwnJobs) {
+		t.Fatalf("Impersonation session cannot view jobs")
+	}
+	for _, p := range impersonationDeniedPermissions {
+		if validated.HasPermission(p) {
+			t.Fatalf("Impersonation session has permission %s", p)
+		}
+	}
+	if validated.HasPermission(PermOwnSession) || !admin.HasPermission(PermOwnSession) {
+		t.Fatalf("Impersonation session can act on the account of the user")
+	}
+	if _, _, _, err := authManager.GenerateImpersonationJWT(context.Background(), validated, "bob"); err == nil {
+		t.Fatalf("Impersonation session could start another impersonation")
+	}
+
+	if _, err := authManager.validate(adminToken); err != nil {
+		t.Fatalf("Admin session was revoked: %v", err)
+	}
+	if _, err := authManager.validate(aliceToken); err != nil {
+		t.Fatalf("User session was revoked: %v", err)
+	}
+
+	if err := authManager.StopImpersonation(validated); err != nil {
+		t.Fatalf("Could not stop impersonation: %v", err)
+	}
+	if _, err := authManager.validate(token); err == nil {
+		t.Fatalf("Impersonation token still valid after stop")
+	}
+	if _, _, _, err := authManager.GenerateImpersonationJWT(context.Background(), admin, "unknown"); err == nil {
+		t.Fatalf("Unknown user was impersonated")
+	}
+}
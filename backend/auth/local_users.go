@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"jobmon/logging"
+	"jobmon/store"
+	"time"
+
+	// Package bcrypt implements Provos and Mazières's bcrypt adaptive hashing algorithm. See http://www.usenix.org/event/usenix99/provos/provos.pdf
+	"golang.org/x/crypto/bcrypt"
+	// Package slices defines various functions useful with slices of any type
+	"golang.org/x/exp/slices"
+)
+
+// Minimum length of passwords of local users
+const MinPasswordLength = 8
+
+// LocalUserPayload stores the fields of a local user that can be set by an admin.
+// Fields that are nil are left unchanged on updates.
+type LocalUserPayload struct {
+	Username string
+	Password *string
+	Roles    *[]string
+	Accounts *[]string
+	Disabled *bool
+}
+
+// PasswordChangePayload stores the old and new password of a user changing their own password.
+type PasswordChangePayload struct {
+	OldPassword string
+	NewPassword string
+}
+
+// hashPassword checks the password policy and returns the bcrypt hash of password.
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// GetLocalUsers returns all local users managed in the store.
+func (auth *AuthManager) GetLocalUsers(ctx context.Context) ([]store.LocalUser, error) {
+	return (*auth.store).GetLocalUsers(ctx)
+}
+
+// CreateLocalUser creates a new local user in the store.
+func (auth *AuthManager) CreateLocalUser(ctx context.Context, payload LocalUserPayload) (user store.LocalUser, err error) {
+	if payload.Username == "" {
+		return user, fmt.Errorf("username must not be empty")
+	}
+	if _, err := (*auth.store).GetLocalUser(ctx, payload.Username); err == nil {
+		return user, fmt.Errorf("local user '%s' already exists", payload.Username)
+	}
+	if payload.Password == nil {
+		return user, fmt.Errorf("no password given")
+	}
+
+	user.Username = payload.Username
+	user.Roles = []string{}
+	if payload.Roles != nil {
+		user.Roles = *payload.Roles
+	}
+	user.Accounts = []string{}
+	if payload.Accounts != nil {
+		user.Accounts = *payload.Accounts
+	}
+	if payload.Disabled != nil {
+		user.Disabled = *payload.Disabled
+	}
+	user.BCryptHash, err = hashPassword(*payload.Password)
+	if err != nil {
+		return
+	}
+	user.PasswordChangedAt = time.Now()
+
+	if err = (*auth.store).PutLocalUser(user); err != nil {
+		return
+	}
+	logging.Info("auth: CreateLocalUser(): Created local user '", user.Username, "'")
+	return
+}
+
+// UpdateLocalUser updates the local user 'username' with the non nil fields of payload.
+// Disabling a user, changing their password or changing their roles or accounts revokes their
+// session, as the roles and accounts of a session are those of the login.
+func (auth *AuthManager) UpdateLocalUser(ctx context.Context, username string, payload LocalUserPayload) (user store.LocalUser, err error) {
+	user, err = (*auth.store).GetLocalUser(ctx, username)
+	if err != nil {
+		return user, fmt.Errorf("unknown local user '%s'", username)
+	}
+
+	revokeSession := false
+	if payload.Roles != nil {
+		revokeSession = revokeSession || !slices.Equal(user.Roles, *payload.Roles)
+		user.Roles = *payload.Roles
+	}
+	if payload.Accounts != nil {
+		revokeSession = revokeSession || !slices.Equal(user.Accounts, *payload.Accounts)
+		user.Accounts = *payload.Accounts
+	}
+	if payload.Disabled != nil {
+		user.Disabled = *payload.Disabled
+		revokeSession = revokeSession || user.Disabled
+	}
+	if payload.Password != nil {
+		user.BCryptHash, err = hashPassword(*payload.Password)
+		if err != nil {
+			return
+		}
+		user.PasswordChangedAt = time.Now()
+		revokeSession = true
+	}
+
+	if err = (*auth.store).PutLocalUser(user); err != nil {
+		return
+	}
+	if revokeSession {
+		auth.Logout(username)
+	}
+	logging.Info("auth: UpdateLocalUser(): Updated local user '", username, "'")
+	return
+}
+
+// RemoveLocalUser removes the local user 'username' from the store and revokes their session.
+// Bootstrap users from the configuration cannot be removed.
+func (auth *AuthManager) RemoveLocalUser(ctx context.Context, username string) error {
+	if _, err := (*auth.store).GetLocalUser(ctx, username); err != nil {
+		return fmt.Errorf("unknown local user '%s'", username)
+	}
+	if err := (*auth.store).RemoveLocalUser(username); err != nil {
+		return err
+	}
+	auth.Logout(username)
+	logging.Info("auth: RemoveLocalUser(): Removed local user '", username, "'")
+	return nil
+}
+
+// ChangePassword changes the password of the local user 'username' after checking the old password.
+// Bootstrap users from the configuration are copied to the store on their first password change.
+func (auth *AuthManager) ChangePassword(ctx context.Context, username string, payload PasswordChangePayload) error {
+	user, err := auth.AuthLocalUser(ctx, username, payload.OldPassword)
+	if err != nil {
+		return fmt.Errorf("old password is not valid")
+	}
+
+	hash, err := hashPassword(payload.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	storeUser, err := (*auth.store).GetLocalUser(ctx, username)
+	if err != nil {
+		// Bootstrap users keep the roles and accounts they logged in with
+		storeUser = store.LocalUser{
+			Username: username,
+			Roles:    user.Roles,
+			Accounts: user.Accounts,
+		}
+		if storeUser.Accounts == nil {
+			storeUser.Accounts = []string{}
+		}
+	}
+	storeUser.BCryptHash = hash
+	storeUser.PasswordChangedAt = time.Now()
+
+	if err := (*auth.store).PutLocalUser(storeUser); err != nil {
+		return err
+	}
+	logging.Info("auth: ChangePassword(): Changed password of local user '", username, "'")
+	return nil
+}
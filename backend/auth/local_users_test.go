@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"jobmon/config"
+	"jobmon/notify"
+	"jobmon/store"
+	"jobmon/test"
+	"reflect"
+	"testing"
+)
+
+// newLocalUserTestManager returns an AuthManager with the bootstrap user "bootstrap" / "bootstrap-pw".
+func newLocalUserTestManager(t *testing.T) (*AuthManager, *test.MockStore) {
+	hash, err := hashPassword("bootstrap-pw")
+	if err != nil {
+		t.Fatalf("Could not hash password: %v", err)
+	}
+	authManager := AuthManager{}
+	config := config.Configuration{
+		JSONWebTokenLifeTime: standartLifetime,
+		APITokenLifeTime:     standartLifetime,
+		JWTSecret:            "<jwt_secret (secret to use when generating java web tokens)>",
+		OAuth:                OauthTestConfig,
+		LocalUsers: map[string]config.LocalUser{
+			"bootstrap": {BCryptHash: hash, Role: ADMIN},
+		},
+	}
+	mockStore := &test.MockStore{}
+	var st store.Store = mockStore
+	var n notify.Notifier = &test.MockEmailNotifier{}
+	authManager.Init(config, &st, &n)
+	return &authManager, mockStore
+}
+
+// Tests creation and login of local users managed in the store
+func TestCreateLocalUser(t *testing.T) {
+	authManager, _ := newLocalUserTestManager(t)
+
+	short := "short"
+	if _, err := authManager.CreateLocalUser(context.Background(), LocalUserPayload{Username: "alice", Password: &short}); err == nil {
+		t.Fatalf("Too short password was accepted")
+	}
+
+	password := "alice-password"
+	roles := []string{USER, ACCOUNTMANAGER}
+	accounts := []string{"proj1"}
+	if _, err := authManager.CreateLocalUser(context.Background(), LocalUserPayload{Username: "alice", Password: &password, Roles: &roles, Accounts: &accounts}); err != nil {
+		t.Fatalf("Could not create local user: %v", err)
+	}
+	if _, err := authManager.CreateLocalUser(context.Background(), LocalUserPayload{Username: "alice", Password: &password}); err == nil {
+		t.Fatalf("Local user was created twice")
+	}
+
+	user, err := authManager.AuthLocalUser(context.Background(), "alice", password)
+	if err != nil {
+		t.Fatalf("Could not log in as local user: %v", err)
+	}
+	if !reflect.DeepEqual(user.Roles, roles) {
+		t.Fatalf("Wrong roles returned: %v", user.Roles)
+	}
+	if !reflect.DeepEqual(user.Accounts, accounts) {
+		t.Fatalf("Wrong accounts returned: %v", user.Accounts)
+	}
+	if _, err := authManager.AuthLocalUser(context.Background(), "alice", "wrong-password"); err == nil {
+		t.Fatalf("Wrong password was accepted")
+	}
+}
+
+// Tests if disabled users cannot log in and lose their session
+func TestDisableLocalUser(t *testing.T) {
+	authManager, mockStore := newLocalUserTestManager(t)
+
+	password := "alice-password"
+	authManager.CreateLocalUser(context.Background(), LocalUserPayload{Username: "alice", Password: &password})
+	mockStore.SetUserSessionToken("alice", "token")
+
+	disabled := true
+	if _, err := authManager.UpdateLocalUser(context.Background(), "alice", LocalUserPayload{Disabled: &disabled}); err != nil {
+		t.Fatalf("Could not disable local user: %v", err)
+	}
+	if _, err := authManager.AuthLocalUser(context.Background(), "alice", password); err == nil {
+		t.Fatalf("Disabled user could log in")
+	}
+	if _, ok := mockStore.JWT["alice"]; ok {
+		t.Fatalf("Session of disabled user was not revoked")
+	}
+}
+
+// Tests if changing the accounts of a local user revokes their session, as
+// sessions carry the accounts of the login, and if unchanged accounts keep it
+func TestUpdateLocalUserAccounts(t *testing.T) {
+	authManager, mockStore := newLocalUserTestManager(t)
+
+	password := "alice-password"
+	accounts := []string{"proj1"}
+	authManager.CreateLocalUser(context.Background(), LocalUserPayload{Username: "alice", Password: &password, Accounts: &accounts})
+	mockStore.SetUserSessionToken("alice", "token")
+
+	if _, err := authManager.UpdateLocalUser(context.Background(), "alice", LocalUserPayload{Accounts: &accounts}); err != nil {
+		t.Fatalf("Could not update local user: %v", err)
+	}
+	if _, ok := mockStore.JWT["alice"]; !ok {
+		t.Fatalf("Session was revoked without changes")
+	}
+
+	accounts = []string{"proj1", "proj2"}
+	if _, err := authManager.UpdateLocalUser(context.Background(), "alice", LocalUserPayload{Accounts: &accounts}); err != nil {
+		t.Fatalf("Could not update local user: %v", err)
+	}
+	if _, ok := mockStore.JWT["alice"]; ok {
+		t.Fatalf("Session was not revoked on account change")
+	}
+}
+
+// Tests if store users take precedence over bootstrap users and
+// bootstrap users are copied to the store on password change
+func TestChangePassword(t *testing.T) {
+	authManager, mockStore := newLocalUserTestManager(t)
+
+	if _, err := authManager.AuthLocalUser(context.Background(), "bootstrap", "bootstrap-pw"); err != nil {
+		t.Fatalf("Bootstrap user could not log in: %v", err)
+	}
+
+	if err := authManager.ChangePassword(context.Background(), "bootstrap", PasswordChangePayload{OldPassword: "wrong", NewPassword: "new-password"}); err == nil {
+		t.Fatalf("Password changed with wrong old password")
+	}
+	if err := authManager.ChangePassword(context.Background(), "bootstrap", PasswordChangePayload{OldPassword: "bootstrap-pw", NewPassword: "new-password"}); err != nil {
+		t.Fatalf("Could not change password: %v", err)
+	}
+
+	storeUser, ok := mockStore.LocalUsers["bootstrap"]
+	if !ok || !reflect.DeepEqual(storeUser.Roles, []string{ADMIN}) {
+		t.Fatalf("Bootstrap user was not copied to the store: %+v", storeUser)
+	}
+	if _, err := authManager.AuthLocalUser(context.Background(), "bootstrap", "bootstrap-pw"); err == nil {
+		t.Fatalf("Old password still accepted")
+	}
+	if _, err := authManager.AuthLocalUser(context.Background(), "bootstrap", "new-password"); err != nil {
+		t.Fatalf("New password not accepted: %v", err)
+	}
+}
+
+// Tests if bootstrap users get the accounts of their user configuration
+// and keep them when they are copied to the store on password change
+func TestBootstrapUserAccounts(t *testing.T) {
+	authManager, mockStore := newLocalUserTestManager(t)
+	mockStore.SetUserAccounts("bootstrap", []string{"proj1"})
+
+	user, err := authManager.AuthLocalUser(context.Background(), "bootstrap", "bootstrap-pw")
+	if err != nil {
+		t.Fatalf("Could not log in as bootstrap user: %v", err)
+	}
+	if !reflect.DeepEqual(user.Accounts, []string{"proj1"}) {
+		t.Fatalf("Wrong accounts returned: %v", user.Accounts)
+	}
+
+	if err := authManager.ChangePassword(context.Background(), "bootstrap", PasswordChangePayload{OldPassword: "bootstrap-pw", NewPassword: "new-password"}); err != nil {
+		t.Fatalf("Could not change password: %v", err)
+	}
+	if storeUser := mockStore.LocalUsers["bootstrap"]; !reflect.DeepEqual(storeUser.Accounts, []string{"proj1"}) {
+		t.Fatalf("Accounts were not copied to the store: %+v", storeUser)
+	}
+	user, err = authManager.AuthLocalUser(context.Background(), "bootstrap", "new-password")
+	if err != nil || !reflect.DeepEqual(user.Accounts, []string{"proj1"}) {
+		t.Fatalf("Wrong accounts after password change: %v, %v", user.Accounts, err)
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"jobmon/job"
+	"jobmon/logging"
+	"jobmon/store"
+	"jobmon/utils"
+	"sort"
+)
+
+// Permissions that can be granted to a role.
+const (
+	// Start and stop jobs, i.e. the slurm prolog / epilog API
+	PermJobControl = "job-control"
+	// View jobs submitted by the user
+	PermViewOwnJobs = "view-own-jobs"
+	// View jobs charged to an account managed by the user
+	PermViewAccountJobs = "view-account-jobs"
+	// View jobs of all users
+	PermViewAllJobs = "view-all-jobs"
+	// Add and remove job tags
+	PermManageTags = "manage-tags"
+	// Edit the backend configuration
+	PermEditConfig = "edit-config"
+	// Manage users, roles and API keys
+	PermManageUsers = "manage-users"
+	// Access the live backend log
+	PermLiveLog = "live-log"
+)
+
+// PermOwnSession is not granted by roles: it only requires a valid session that is not an
+// impersonation, for actions on the own account like changing the password or requesting roles.
+const PermOwnSession = "own-session"
+
+// AllPermissions lists every known permission.
+var AllPermissions = []string{
+	PermJobControl,
+	PermViewOwnJobs,
+	PermViewAccountJobs,
+	PermViewAllJobs,
+	PermManageTags,
+	PermEditConfig,
+	PermManageUsers,
+	PermLiveLog,
+}
+
+// DefaultRoles maps the built-in roles to the permissions they grant.
+// They are written to the store when missing, so admins can adapt them afterwards.
+var DefaultRoles = map[string][]string{
+	JOBCONTROL:     {PermJobControl},
+	USER:           {PermViewOwnJobs, PermManageTags},
+	ACCOUNTMANAGER: {PermViewOwnJobs, PermViewAccountJobs, PermManageTags},
+	ADMIN:          AllPermissions,
+}
+
+// HasPermission checks if the user was granted permission perm.
+// An empty permission only requires the user to be authenticated.
+func (u UserInfo) HasPermission(perm string) bool {
+	if perm == PermOwnSession {
+		return u.ImpersonatedBy == ""
+	}
+	return perm == "" || utils.Contains(u.Permissions, perm)
+}
+
+// JobVisibility returns the filter restricting jobs to the ones the user is
+// allowed to view, or nil if the user may view all jobs.
+func (u UserInfo) JobVisibility() *job.JobVisibility {
+	if u.HasPermission(PermViewAllJobs) {
+		return nil
+	}
+	v := &job.JobVisibility{}
+	if u.HasPermission(PermViewOwnJobs) {
+		username := u.Username
+		v.UserName = &username
+	}
+	if u.HasPermission(PermViewAccountJobs) {
+		v.Accounts = u.Accounts
+	}
+	return v
+}
+
+// CanViewJob checks if the user is allowed to view job j.
+func (u UserInfo) CanViewJob(j *job.JobMetadata) bool {
+	return u.JobVisibility().Matches(j)
+}
+
+// initRoles loads the role definitions from the store and adds missing default roles.
+func (auth *AuthManager) initRoles() {
+	auth.rolesLock.Lock()
+	defer auth.rolesLock.Unlock()
+
+	auth.roles = make(map[string][]string)
+	roles, err := (*auth.store).GetRoles(context.Background())
+	if err != nil {
+		logging.Error("auth: initRoles(): Could not read roles from store: ", err)
+	}
+	for _, r := range roles {
+		auth.roles[r.Name] = r.Permissions
+	}
+
+	for name, perms := range DefaultRoles {
+		if _, ok := auth.roles[name]; ok {
+			continue
+		}
+		auth.roles[name] = perms
+		if err := (*auth.store).SetRole(store.Role{Name: name, Permissions: perms}); err != nil {
+			logging.Error("auth: initRoles(): Could not store default role '", name, "': ", err)
+		}
+	}
+}
+
+// resolvePermissions returns the union of the permissions granted by roles.
+// The admin role always grants all permissions, so admins cannot lock themselves out.
+func (auth *AuthManager) resolvePermissions(roles []string) []string {
+	auth.rolesLock.RLock()
+	defer auth.rolesLock.RUnlock()
+
+	if utils.Contains(roles, ADMIN) {
+		return append([]string{}, AllPermissions...)
+	}
+	perms := make([]string, 0)
+	for _, r := range roles {
+		for _, p := range auth.roles[r] {
+			if !utils.Contains(perms, p) {
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
+// GetRoles returns all configured roles sorted by name.
+func (auth *AuthManager) GetRoles() []store.Role {
+	auth.rolesLock.RLock()
+	defer auth.rolesLock.RUnlock()
+
+	roles := make([]store.Role, 0, len(auth.roles))
+	for name, perms := range auth.roles {
+		roles = append(roles, store.Role{Name: name, Permissions: perms})
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// SetRole creates or updates the role definition role.
+func (auth *AuthManager) SetRole(role store.Role) error {
+	if role.Name == "" {
+		return fmt.Errorf("role name must not be empty")
+	}
+	if role.Name == ADMIN {
+		return fmt.Errorf("role '%s' always grants all permissions and cannot be changed", ADMIN)
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	for _, p := range role.Permissions {
+		if !utils.Contains(AllPermissions, p) {
+			return fmt.Errorf("unknown permission '%s'", p)
+		}
+	}
+
+	auth.rolesLock.Lock()
+	defer auth.rolesLock.Unlock()
+	if err := (*auth.store).SetRole(role); err != nil {
+		return err
+	}
+	auth.roles[role.Name] = role.Permissions
+	return nil
+}
+
+// RemoveRole deletes the role definition with the given name.
+func (auth *AuthManager) RemoveRole(name string) error {
+	if _, ok := DefaultRoles[name]; ok {
+		return fmt.Errorf("built-in role '%s' cannot be removed", name)
+	}
+
+	auth.rolesLock.Lock()
+	defer auth.rolesLock.Unlock()
+	if _, ok := auth.roles[name]; !ok {
+		return fmt.Errorf("unknown role '%s'", name)
+	}
+	if err := (*auth.store).RemoveRole(name); err != nil {
+		return err
+	}
+	delete(auth.roles, name)
+	return nil
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"jobmon/config"
+	"jobmon/job"
+	"jobmon/notify"
+	"jobmon/store"
+	"jobmon/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// newTestAuthManager returns an initialized AuthManager backed by a MockStore.
+func newTestAuthManager(s *test.MockStore) *AuthManager {
+	authManager := AuthManager{}
+	config := config.Configuration{
+		JSONWebTokenLifeTime: standartLifetime,
+		APITokenLifeTime:     standartLifetime,
+		JWTSecret:            "<jwt_secret (secret to use when generating java web tokens)>",
+		OAuth:                OauthTestConfig,
+		LocalUsers:           LocalUsersTestConfig,
+	}
+	var st store.Store = s
+	var notify notify.Notifier = &test.MockEmailNotifier{}
+	authManager.Init(config, &st, &notify)
+	return &authManager
+}
+
+// Tests if the default roles are written to an empty store
+func TestDefaultRolesSeeded(t *testing.T) {
+	s := &test.MockStore{}
+	newTestAuthManager(s)
+
+	for name := range DefaultRoles {
+		if _, ok := s.Roles[name]; !ok {
+			t.Fatalf("Default role %s was not stored", name)
+		}
+	}
+}
+
+// Tests if roles changed by an admin are not overwritten by the defaults
+func TestStoredRolesKept(t *testing.T) {
+	s := &test.MockStore{
+		Roles: map[string]store.Role{
+			USER: {Name: USER, Permissions: []string{PermViewOwnJobs}},
+		},
+	}
+	authManager := newTestAuthManager(s)
+
+	perms := authManager.resolvePermissions([]string{USER})
+	if len(perms) != 1 || perms[0] != PermViewOwnJobs {
+		t.Fatalf("Stored role was overwritten, got permissions %v", perms)
+	}
+}
+
+// Tests if the permissions of multiple roles are merged and admins get all permissions
+func TestResolvePermissions(t *testing.T) {
+	authManager := newTestAuthManager(&test.MockStore{})
+
+	perms := authManager.resolvePermissions([]string{USER, JOBCONTROL})
+	user := UserInfo{Permissions: perms}
+	if !user.HasPermission(PermViewOwnJobs) || !user.HasPermission(PermJobControl) {
+		t.Fatalf("Permissions of roles were not merged: %v", perms)
+	}
+	if user.HasPermission(PermEditConfig) {
+		t.Fatalf("User was granted permission %s", PermEditConfig)
+	}
+
+	admin := UserInfo{Permissions: authManager.resolvePermissions([]string{ADMIN})}
+	for _, p := range AllPermissions {
+		if !admin.HasPermission(p) {
+			t.Fatalf("Admin is missing permission %s", p)
+		}
+	}
+
+	if len(authManager.resolvePermissions([]string{"unknown"})) != 0 {
+		t.Fatalf("Unknown role granted permissions")
+	}
+}
+
+// Tests validation of role updates
+func TestSetRole(t *testing.T) {
+	s := &test.MockStore{}
+	authManager := newTestAuthManager(s)
+
+	if err := authManager.SetRole(store.Role{Name: "pi", Permissions: []string{"fly"}}); err == nil {
+		t.Fatalf("Role with unknown permission was accepted")
+	}
+	if err := authManager.SetRole(store.Role{Name: ADMIN, Permissions: []string{}}); err == nil {
+		t.Fatalf("Admin role was changed")
+	}
+	if err := authManager.SetRole(store.Role{Name: "pi", Permissions: []string{PermViewAccountJobs}}); err != nil {
+		t.Fatalf("Valid role was rejected: %v", err)
+	}
+	if perms := authManager.resolvePermissions([]string{"pi"}); len(perms) != 1 || perms[0] != PermViewAccountJobs {
+		t.Fatalf("New role not applied, got %v", perms)
+	}
+	if err := authManager.RemoveRole(USER); err == nil {
+		t.Fatalf("Built-in role was removed")
+	}
+	if err := authManager.RemoveRole("pi"); err != nil {
+		t.Fatalf("Could not remove role: %v", err)
+	}
+	if _, ok := s.Roles["pi"]; ok {
+		t.Fatalf("Role was not removed from store")
+	}
+}
+
+// Tests if account managers can view jobs of their accounts only
+func TestCanViewJob(t *testing.T) {
+	authManager := newTestAuthManager(&test.MockStore{})
+
+	own := job.JobMetadata{UserName: "alice", Account: "other"}
+	managed := job.JobMetadata{UserName: "bob", Account: "proj1"}
+	foreign := job.JobMetadata{UserName: "bob", Account: "proj2"}
+
+	user := UserInfo{Username: "alice", Accounts: []string{"proj1"}}
+	user.Permissions = authManager.resolvePermissions([]string{USER})
+	if !user.CanViewJob(&own) || user.CanViewJob(&managed) || user.CanViewJob(&foreign) {
+		t.Fatalf("User job visibility is wrong")
+	}
+
+	user.Permissions = authManager.resolvePermissions([]string{ACCOUNTMANAGER})
+	if !user.CanViewJob(&own) || !user.CanViewJob(&managed) || user.CanViewJob(&foreign) {
+		t.Fatalf("Account manager job visibility is wrong")
+	}
+
+	user.Permissions = authManager.resolvePermissions([]string{ADMIN})
+	if user.JobVisibility() != nil || !user.CanViewJob(&foreign) {
+		t.Fatalf("Admin job visibility is wrong")
+	}
+}
+
+// Tests if Protected enforces permissions
+func TestProtected(t *testing.T) {
+	authManager := newTestAuthManager(&test.MockStore{})
+	token, _ := authManager.GenerateJWT(UserInfo{Roles: []string{USER}, Username: "userTest"})
+
+	called := false
+	handle := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, user UserInfo) {
+		called = true
+	}
+	request := func(perm string) int {
+		called = false
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "Authorization", Value: "Bearer " + token})
+		rec := httptest.NewRecorder()
+		authManager.Protected(handle, perm)(rec, req, nil)
+		return rec.Code
+	}
+
+	if code := request(PermViewOwnJobs); code != http.StatusOK || !called {
+		t.Fatalf("Permitted request was refused with %d", code)
+	}
+	if code := request(PermEditConfig); code != http.StatusForbidden || called {
+		t.Fatalf("Forbidden request returned %d", code)
+	}
+	if code := request(""); code != http.StatusOK || !called {
+		t.Fatalf("Authenticated request was refused with %d", code)
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jobmon/logging"
+	"jobmon/store"
+	"jobmon/utils"
+	"time"
+)
+
+// ErrRoleRequestLimit is returned when a user exceeded the allowed number of role requests.
+var ErrRoleRequestLimit = errors.New("too many role requests")
+
+// RequestRole stores a request of user for role and notifies the administrators.
+// If role is empty the user role is requested. The requester is always taken
+// from the validated session, email is only used when the session carries no address.
+func (auth *AuthManager) RequestRole(
+	ctx context.Context,
+	user UserInfo,
+	role string,
+	reason string,
+	email string,
+) (
+	request store.RoleRequest,
+	err error,
+) {
+	if role == "" {
+		role = USER
+	}
+
+	auth.rolesLock.RLock()
+	_, roleExists := auth.roles[role]
+	auth.rolesLock.RUnlock()
+	if !roleExists {
+		return request, fmt.Errorf("unknown role '%s'", role)
+	}
+	if utils.Contains(user.Roles, role) {
+		return request, fmt.Errorf("user '%s' already has role '%s'", user.Username, role)
+	}
+
+	// Rate limit role requests per user
+	pending, err := (*auth.store).GetRoleRequests(ctx, store.RoleRequestPending)
+	if err != nil {
+		return request, err
+	}
+	for _, p := range pending {
+		if p.Username == user.Username && p.Role == role {
+			return request, fmt.Errorf("%w: request for role '%s' is already pending", ErrRoleRequestLimit, role)
+		}
+	}
+	count, err := (*auth.store).CountRoleRequests(ctx, user.Username, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return request, err
+	}
+	auth.configLock.RLock()
+	limit := auth.roleRequestsPerDay
+	auth.configLock.RUnlock()
+	if count >= limit {
+		return request, fmt.Errorf("%w: at most %d requests per day are allowed", ErrRoleRequestLimit, limit)
+	}
+
+	if user.Email != "" {
+		email = user.Email
+	}
+	request =
+		store.RoleRequest{
+			Username:  user.Username,
+			Role:      role,
+			Reason:    reason,
+			Email:     email,
+			Status:    store.RoleRequestPending,
+			CreatedAt: time.Now(),
+		}
+	if err = (*auth.store).AddRoleRequest(&request); err != nil {
+		return
+	}
+
+	subject := "Role request by " + user.Username
+	message := "The user " + user.Username + " requests the role '" + role + "' to access the jobmon-system." +
+		"\n\nReason: " + reason
+	if err := (*auth.notifier).Notify(subject, message); err != nil {
+		logging.Error("auth: RequestRole(): Could not notify admins about role request ", request.Id, ": ", err)
+	}
+
+	logging.Info("auth: RequestRole(): User '", user.Username, "' requested role '", role, "'")
+	return request, nil
+}
+
+// DecideRoleRequest approves or denies the pending role request with the given id.
+// On approval the role is added to the requesters roles and the requesters session is
+// revoked, so the next login picks up the new role. The requester is notified in both cases.
+// Requests of bootstrap users from the configuration cannot be approved, their role is set there.
+func (auth *AuthManager) DecideRoleRequest(
+	ctx context.Context,
+	id int64,
+	admin string,
+	approve bool,
+	comment string,
+) (
+	request store.RoleRequest,
+	err error,
+) {
+	request, err = (*auth.store).GetRoleRequest(ctx, id)
+	if err != nil {
+		return
+	}
+	if request.Status != store.RoleRequestPending {
+		return request, fmt.Errorf("role request %d was already %s", id, request.Status)
+	}
+
+	request.DecidedBy = admin
+	request.DecidedAt = time.Now()
+	request.Comment = comment
+	request.Status = store.RoleRequestDenied
+	if approve {
+		if err = auth.addRole(ctx, request.Username, request.Role); err != nil {
+			return
+		}
+		request.Status = store.RoleRequestApproved
+		auth.Logout(request.Username)
+	}
+	if err = (*auth.store).UpdateRoleRequest(request); err != nil {
+		return
+	}
+
+	if request.Email != "" {
+		subject := "Your role request was " + request.Status
+		message := "Your request for the role '" + request.Role + "' was " + request.Status + " by " + admin + "."
+		if comment != "" {
+			message += "\n\nComment: " + comment
+		}
+		if approve {
+			message += "\n\nPlease log in again to use the new role."
+		}
+		if err := (*auth.notifier).NotifyUser(request.Email, subject, message); err != nil {
+			logging.Error("auth: DecideRoleRequest(): Could not notify user '", request.Username, "': ", err)
+		}
+	} else {
+		logging.Info("auth: DecideRoleRequest(): No email address known for user '", request.Username, "'")
+	}
+
+	logging.Info("auth: DecideRoleRequest(): Role request ", id, " of user '", request.Username, "' was ", request.Status, " by ", admin)
+	return request, nil
+}
+
+// UserRoles returns the roles and accounts user 'username' gets on login, for
+// local users managed in the store or in the configuration as well as for OAuth users.
+func (auth *AuthManager) UserRoles(ctx context.Context, username string) store.UserRoles {
+	user, _ := auth.lookupUserInfo(ctx, username)
+	return store.UserRoles{Roles: user.Roles, Accounts: user.Accounts}
+}
+
+// addRole adds role to the roles of user 'username' that are read on login: local users
+// managed in the store have their own roles, OAuth users get the roles stored per user name.
+func (auth *AuthManager) addRole(ctx context.Context, username string, role string) error {
+	if localUser, err := (*auth.store).GetLocalUser(ctx, username); err == nil {
+		if utils.Contains(localUser.Roles, role) {
+			return nil
+		}
+		localUser.Roles = append(localUser.Roles, role)
+		return (*auth.store).PutLocalUser(localUser)
+	}
+	if _, ok := auth.configLocalUser(username); ok {
+		return fmt.Errorf("the role of user '%s' is set in the configuration", username)
+	}
+
+	userRoles, _ := (*auth.store).GetUserRoles(username)
+	if !utils.Contains(userRoles.Roles, role) {
+		(*auth.store).SetUserRoles(username, append(userRoles.Roles, role))
+	}
+	return nil
+}
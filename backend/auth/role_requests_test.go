@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"jobmon/config"
+	"jobmon/notify"
+	"jobmon/store"
+	"jobmon/test"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// newRoleRequestTestManager returns an AuthManager with a mock store and a mock notifier.
+func newRoleRequestTestManager() (*AuthManager, *test.MockStore, *test.MockEmailNotifier) {
+	authManager := AuthManager{}
+	config := config.Configuration{
+		JSONWebTokenLifeTime: standartLifetime,
+		APITokenLifeTime:     standartLifetime,
+		JWTSecret:            "<jwt_secret (secret to use when generating java web tokens)>",
+		OAuth:                OauthTestConfig,
+		LocalUsers:           LocalUsersTestConfig,
+		RoleRequestsPerDay:   2,
+	}
+	mockStore := &test.MockStore{}
+	mockNotifier := &test.MockEmailNotifier{}
+	var st store.Store = mockStore
+	var n notify.Notifier = mockNotifier
+	authManager.Init(config, &st, &n)
+	return &authManager, mockStore, mockNotifier
+}
+
+// Tests if a role request is stored for the session user and admins get notified
+func TestRequestRole(t *testing.T) {
+	authManager, mockStore, mockNotifier := newRoleRequestTestManager()
+	user := UserInfo{Username: "newbie", Email: "newbie@example.org"}
+
+	request, err := authManager.RequestRole(context.Background(), user, "", "I need access", "spoofed@example.org")
+	if err != nil {
+		t.Fatalf("Role request failed: %v", err)
+	}
+	if request.Role != USER || request.Status != store.RoleRequestPending {
+		t.Fatalf("Wrong role request stored: %+v", request)
+	}
+	if request.Email != user.Email {
+		t.Fatalf("Session email was not preferred, got %s", request.Email)
+	}
+	if len(mockStore.RoleRequests) != 1 {
+		t.Fatalf("Role request was not stored")
+	}
+	if len(mockNotifier.GetMessages()) != 1 {
+		t.Fatalf("Admins were not notified")
+	}
+
+	if _, err := authManager.RequestRole(context.Background(), user, "unknown-role", "", ""); err == nil {
+		t.Fatalf("Request for unknown role was accepted")
+	}
+	if _, err := authManager.RequestRole(context.Background(), UserInfo{Username: "u", Roles: []string{USER}}, USER, "", ""); err == nil {
+		t.Fatalf("Request for already granted role was accepted")
+	}
+}
+
+// Tests if role requests are rate limited per user
+func TestRequestRoleRateLimit(t *testing.T) {
+	authManager, _, _ := newRoleRequestTestManager()
+	user := UserInfo{Username: "newbie"}
+
+	if _, err := authManager.RequestRole(context.Background(), user, USER, "", ""); err != nil {
+		t.Fatalf("First role request failed: %v", err)
+	}
+	if _, err := authManager.RequestRole(context.Background(), user, USER, "", ""); !errors.Is(err, ErrRoleRequestLimit) {
+		t.Fatalf("Duplicate pending request was not limited: %v", err)
+	}
+	if _, err := authManager.RequestRole(context.Background(), user, ACCOUNTMANAGER, "", ""); err != nil {
+		t.Fatalf("Second role request failed: %v", err)
+	}
+	if _, err := authManager.RequestRole(context.Background(), user, JOBCONTROL, "", ""); !errors.Is(err, ErrRoleRequestLimit) {
+		t.Fatalf("Requests per day were not limited: %v", err)
+	}
+	if _, err := authManager.RequestRole(context.Background(), UserInfo{Username: "other"}, USER, "", ""); err != nil {
+		t.Fatalf("Rate limit affected other user: %v", err)
+	}
+}
+
+// Tests approval and denial of role requests
+func TestDecideRoleRequest(t *testing.T) {
+	authManager, mockStore, mockNotifier := newRoleRequestTestManager()
+	user := UserInfo{Username: "newbie", Email: "newbie@example.org"}
+
+	approved, _ := authManager.RequestRole(context.Background(), user, USER, "", "")
+	denied, _ := authManager.RequestRole(context.Background(), user, ACCOUNTMANAGER, "", "")
+	mockNotifier.ClearMessages()
+
+	request, err := authManager.DecideRoleRequest(context.Background(), approved.Id, "adminTest", true, "welcome")
+	if err != nil {
+		t.Fatalf("Approval failed: %v", err)
+	}
+	if request.Status != store.RoleRequestApproved || request.DecidedBy != "adminTest" {
+		t.Fatalf("Wrong decision stored: %+v", request)
+	}
+	if !slices.Contains(mockStore.UserRoles["newbie"].Roles, USER) {
+		t.Fatalf("Role was not granted")
+	}
+	if _, err := authManager.DecideRoleRequest(context.Background(), approved.Id, "adminTest", false, ""); err == nil {
+		t.Fatalf("Request was decided twice")
+	}
+
+	if _, err := authManager.DecideRoleRequest(context.Background(), denied.Id, "adminTest", false, "no"); err != nil {
+		t.Fatalf("Denial failed: %v", err)
+	}
+	if slices.Contains(mockStore.UserRoles["newbie"].Roles, ACCOUNTMANAGER) {
+		t.Fatalf("Role was granted on denial")
+	}
+
+	messages := mockNotifier.GetMessages()
+	if len(messages) != 2 || messages[0].Address != user.Email {
+		t.Fatalf("Requester was not notified: %v", messages)
+	}
+}
+
+// Tests if approved roles are granted where the login of the user reads them
+func TestDecideRoleRequestLocalUser(t *testing.T) {
+	authManager, mockStore, _ := newRoleRequestTestManager()
+	mockStore.PutLocalUser(store.LocalUser{Username: "local", Roles: []string{USER}})
+
+	request, _ := authManager.RequestRole(context.Background(), UserInfo{Username: "local", Roles: []string{USER}}, ACCOUNTMANAGER, "", "")
+	if _, err := authManager.DecideRoleRequest(context.Background(), request.Id, "adminTest", true, ""); err != nil {
+		t.Fatalf("Approval failed: %v", err)
+	}
+	if roles := mockStore.LocalUsers["local"].Roles; !slices.Equal(roles, []string{USER, ACCOUNTMANAGER}) {
+		t.Fatalf("Role was not granted to local user, roles %v", roles)
+	}
+	if _, ok := mockStore.UserRoles["local"]; ok {
+		t.Fatalf("Role was granted to OAuth user instead of local user")
+	}
+
+	// The role of bootstrap users is set in the configuration
+	request, _ = authManager.RequestRole(context.Background(), UserInfo{Username: "userTest", Roles: []string{USER}}, ACCOUNTMANAGER, "", "")
+	if _, err := authManager.DecideRoleRequest(context.Background(), request.Id, "adminTest", true, ""); err == nil {
+		t.Fatalf("Request of bootstrap user was approved")
+	}
+	if stored, _ := mockStore.GetRoleRequest(context.Background(), request.Id); stored.Status != store.RoleRequestPending {
+		t.Fatalf("Failed approval was stored: %+v", stored)
+	}
+}
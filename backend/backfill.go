@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"jobmon/logging"
+	"jobmon/utils"
+	"strings"
+	"time"
+)
+
+// Interval in which the progress of the backfills is reported
+const backfillPollInterval = 5 * time.Second
+
+// runBackfill aggregates the historical node data of the aggregations given by the command line
+// option -backfill between -backfill-start and -backfill-stop and waits until all backfills stopped.
+// It returns the exit code of the command: 0 if all backfills succeeded and 1 otherwise.
+func runBackfill() int {
+	start, err := time.Parse(time.RFC3339, config.BackfillStart)
+	if err != nil {
+		logging.Error("main: runBackfill(): Invalid -backfill-start '", config.BackfillStart, "': ", err)
+		return 1
+	}
+	stop := time.Now()
+	if config.BackfillStop != "" {
+		if stop, err = time.Parse(time.RFC3339, config.BackfillStop); err != nil {
+			logging.Error("main: runBackfill(): Invalid -backfill-stop '", config.BackfillStop, "': ", err)
+			return 1
+		}
+	}
+	if !start.Before(stop) {
+		logging.Error("main: runBackfill(): -backfill-start must be before -backfill-stop")
+		return 1
+	}
+
+	ctx := context.Background()
+	var names []string
+	if config.Backfill == "all" {
+		status, err := db.GetAggregationStatus(ctx)
+		if err != nil {
+			logging.Error("main: runBackfill(): Could not get aggregations: ", err)
+			return 1
+		}
+		for _, s := range status {
+			names = append(names, s.Name)
+		}
+	} else {
+		for _, name := range strings.Split(config.Backfill, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	if err := db.BackfillAggregations(start, stop, names); err != nil {
+		logging.Error("main: runBackfill(): Could not start backfill: ", err)
+		return 1
+	}
+	fmt.Printf("Backfilling %s from %s to %s\n", strings.Join(names, ", "), start.Format(time.RFC3339), stop.Format(time.RFC3339))
+
+	for {
+		time.Sleep(backfillPollInterval)
+		status, err := db.GetAggregationStatus(ctx)
+		if err != nil {
+			logging.Error("main: runBackfill(): Could not get backfill progress: ", err)
+			return 1
+		}
+		running := 0
+		failed := []string{}
+		for _, s := range status {
+			if !utils.Contains(names, s.Name) || s.Backfill == nil {
+				continue
+			}
+			if !s.Backfill.Finished {
+				running++
+				fmt.Printf("%s: aggregated up to %s\n", s.Name, s.Backfill.Done.Format(time.RFC3339))
+			} else if s.Backfill.Error != "" {
+				failed = append(failed, fmt.Sprintf("%s: failed at %s: %s", s.Name, s.Backfill.Done.Format(time.RFC3339), s.Backfill.Error))
+			}
+		}
+		if running == 0 {
+			if len(failed) > 0 {
+				fmt.Println(strings.Join(failed, "\n"))
+				fmt.Printf("%d of %d backfills failed\n", len(failed), len(names))
+				return 1
+			}
+			fmt.Printf("Backfilled %d aggregations\n", len(names))
+			return 0
+		}
+	}
+}
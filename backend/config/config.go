@@ -3,10 +3,8 @@ package config
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
 	"jobmon/logging"
 	"jobmon/utils"
 	"os"
@@ -26,6 +24,9 @@ type Configuration struct {
 	JSONWebTokenLifeTime       time.Duration `json:"-"`
 	APITokenLifeTimeString     string        `json:"api_token_life_time"`
 	APITokenLifeTime           time.Duration `json:"-"`
+	// Life time of the tokens used by admins to impersonate users
+	ImpersonationLifeTimeString string        `json:"impersonation_life_time"`
+	ImpersonationLifeTime       time.Duration `json:"-"`
 
 	// Do you want to automatically assign user role to users without assigned roles?
 	AutoAssignUserRole bool `json:"auto_assign_user_role"`
@@ -47,8 +48,22 @@ type Configuration struct {
 
 	// Per partition metric config
 	Metrics []MetricConfig `json:"Metrics"`
-	// Job data LRU cache size
+	// Maximum number of entries in the job data LRU cache; no limit if 0
 	CacheSize int `json:"CacheSize"`
+	// Memory budget of the job data LRU cache in MB; 512 if 0
+	CacheMemory int `json:"CacheMemory,omitempty"`
+	// Time after which cached data of running jobs expires, e.g. "30s"; 30 seconds if empty
+	CacheTTL string `json:"CacheTTL,omitempty"`
+	// Directory whose subdirectory jobmon-cache holds the job data of finished jobs across restarts; disabled if empty
+	CacheDir string `json:"CacheDir,omitempty"`
+	// Size limit of the files in CacheDir in MB; 4096 if 0
+	CacheDiskSize int `json:"CacheDiskSize,omitempty"`
+	// Maximum duration of API requests, e.g. "60s"; no limit if empty. WebSocket connections are not limited.
+	RequestTimeout string `json:"RequestTimeout,omitempty"`
+	// Maximum duration of single queries to the metrics database and the job store; no limit if empty
+	QueryTimeout string `json:"QueryTimeout,omitempty"`
+	// Maximum number of concurrent queries to the metrics database; 16 if 0
+	QueryConcurrency int `json:"QueryConcurrency,omitempty"`
 	// Prefetch job data into LRU cache upon job completion
 	Prefetch bool `json:"Prefetch"`
 	// Sample interval of the metrics as configured in the metric collector
@@ -59,7 +74,8 @@ type Configuration struct {
 	MetricQuantiles []string `json:"MetricQuantiles"`
 	// Secret to use when generating the JWT
 	JWTSecret string `json:"JWTSecret"`
-	// Authentication for local users; Key is username and value is the config
+	// Bootstrap accounts for local users; Key is username and value is the config.
+	// Local users managed in the store take precedence.
 	LocalUsers map[string]LocalUser `json:"LocalUsers"`
 	// Per partition configurations
 	Partitions map[string]PartitionConfig `json:"Partitions"`
@@ -67,8 +83,24 @@ type Configuration struct {
 	MetricCategories []string `json:"MetricCategories"`
 	// Metrics to display in the radar chart; Will be moved to frontend config
 	RadarChartMetrics []string `json:"RadarChartMetrics"`
+	// Metrics used for the roofline analysis; the analysis is disabled if not set
+	Roofline *RooflineConfig `json:"Roofline,omitempty"`
+	// Change point detection used to split jobs into phases; defaults are used if not set
+	PhaseDetection *PhaseDetectionConfig `json:"PhaseDetection,omitempty"`
+	// Analyzers run when a job stops; all registered analyzers run with defaults if not set
+	Analysis *AnalysisConfig `json:"Analysis,omitempty"`
 	// Configuration for email notifications
 	Email EmailConfig `json:"EmailNotification"`
+	// Maximum number of role requests a user can make per day
+	RoleRequestsPerDay int `json:"RoleRequestsPerDay"`
+	// Log level; overrides the -log command line option when set
+	ConfigLogLevel *int `json:"LogLevel,omitempty"`
+	// IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Real-IP headers
+	// are used as client address in the audit log
+	TrustedProxies []string `json:"TrustedProxies,omitempty"`
+
+	// References to environment variables or files of secrets; Key is the field path
+	secretRefs map[string]string
 }
 
 // Config from the command line interface
@@ -76,6 +108,9 @@ type CLIConfig struct {
 	ConfigFile    string // config file
 	LogLevel      int    // log level
 	ListenAddress string // TCP address for the server to listen on
+	Backfill      string // aggregations to backfill instead of starting the server, comma separated or "all"
+	BackfillStart string // start of the backfilled time range in RFC 3339 format
+	BackfillStop  string // stop of the backfilled time range in RFC 3339 format; now if empty
 }
 
 // MetricConfig represents a metric configuration configured by the admin.
@@ -117,6 +152,37 @@ type MetricConfig struct {
 	MaxPerType int `json:"MaxPerType"`
 	// Which aggregation function to use when aggregating pthreads and their corresponding hyperthread
 	PThreadAggFn string `json:"PThreadAggFn"`
+	// Arithmetic expression over the measurements of other metrics, e.g. "flops_any / mem_bw".
+	// If set, the metric is not read from the database but computed per node and timestamp;
+	// Measurement is then only the name of the computed values.
+	Expression string `json:"Expression,omitempty"`
+}
+
+// RooflineConfig contains the metrics used for the roofline analysis.
+type RooflineConfig struct {
+	// GUID of the metric with the floating point performance, e.g. in GFLOP/s
+	FlopsMetric string `json:"FlopsMetric"`
+	// GUID of the metric with the memory bandwidth, e.g. in MB/s
+	MemBandwidthMetric string `json:"MemBandwidthMetric"`
+}
+
+// PhaseDetectionConfig configures the change point detection used to split jobs into phases.
+type PhaseDetectionConfig struct {
+	// Detection method, one of ChangePointMethods; "nonparametric" if empty
+	Method string `json:"Method,omitempty"`
+	// Cost of each change point in multiples of log(n) for n points; only used by method "mean", 3 if 0.
+	// Higher values result in less change points.
+	Penalty float64 `json:"Penalty,omitempty"`
+	// Minimum number of points between two change points; 1 if 0, as before the setting existed
+	MinSegment int `json:"MinSegment,omitempty"`
+}
+
+// AnalysisConfig configures the analyzers run when a job stops.
+type AnalysisConfig struct {
+	// Maximum run time of each analyzer, e.g. "30s"; 30 seconds if empty
+	Timeout string `json:"Timeout,omitempty"`
+	// Names of analyzers that only run for partitions enabling them
+	Disabled []string `json:"Disabled,omitempty"`
 }
 
 // A BasePartitionConfig represents a partition configuration
@@ -125,6 +191,12 @@ type BasePartitionConfig struct {
 	MaxTime int `json:"MaxTime"`
 	// Metrics the partition provides. Array of measurement names as specified in the global metric config.
 	Metrics []string `json:"Metrics"`
+	// Peak floating point performance of a node in FLOP/s; used for the roofline analysis
+	PeakFlops float64 `json:"PeakFlops,omitempty"`
+	// Peak memory bandwidth of a node in B/s; used for the roofline analysis
+	PeakMemBandwidth float64 `json:"PeakMemBandwidth,omitempty"`
+	// Enables (true) or disables (false) analyzers for jobs of the partition; Key is the analyzer name
+	Analyzers map[string]bool `json:"Analyzers,omitempty"`
 }
 
 // VirtualPartitionConfig defines a virtual partition, for a subset of Nodes.
@@ -147,20 +219,22 @@ type PartitionConfig struct {
 type LocalUser struct {
 	// bcrypt hash of password of LocalUser
 	BCryptHash string `json:"BCryptHash"`
-	// Role can be "job-control", "user", "admin"
+	// Role can be "job-control", "user", "account-manager", "admin" or any other role defined in the store
 	Role string `json:"Role"`
 }
 
 // Configuration for performance metrics database
-// current implementation uses InfluxDB only
 type DBConfig struct {
-	// Complete URL of InfluxDB, e.g. http://my-inxuxdb.example.org:9200
+	// Type of the database, one of DBTypes; "influxdb2" if empty
+	DBType string `json:"DBType,omitempty"`
+	// Complete URL of InfluxDB, e.g. http://my-inxuxdb.example.org:9200;
+	// host address for TimescaleDB, e.g. my-timescaledb.example.org:5432
 	DBHost string `json:"DBHost"`
-	// InfluxDB access token to bucket
+	// InfluxDB access token to bucket; "username:password" for InfluxDB 1.x and TimescaleDB
 	DBToken string `json:"DBToken"`
-	// Org the InfluxDB bucket belongs to
+	// Org the InfluxDB bucket belongs to; not used for InfluxDB 1.x and TimescaleDB
 	DBOrg string `json:"DBOrg"`
-	// InfluxDB bucket
+	// InfluxDB bucket; "database" or "database/retention-policy" for InfluxDB 1.x; database for TimescaleDB
 	DBBucket string `json:"DBBucket"`
 }
 
@@ -236,6 +310,10 @@ func (c *Configuration) Init() {
 				" info=", logging.InfoLogLevel,
 				" debug=", logging.DebugLogLevel))
 		flag.StringVar(&c.ListenAddress, "listen-addr", ":8080", "TCP address for the server to listen on")
+		flag.StringVar(&c.Backfill, "backfill", "",
+			"aggregate the historical node data of the comma separated aggregations or \"all\" and exit instead of starting the server")
+		flag.StringVar(&c.BackfillStart, "backfill-start", "", "start of the backfilled time range, e.g. 2024-01-01T00:00:00Z")
+		flag.StringVar(&c.BackfillStop, "backfill-stop", "", "stop of the backfilled time range; now if empty")
 		flag.BoolVar(&help, "help", false, "print this help message")
 		flag.Parse()
 	}
@@ -251,35 +329,66 @@ func (c *Configuration) Init() {
 		logging.Fatal("config: Init(): Could not set log level: ", err)
 	}
 
+	if err := c.load(); err != nil {
+		logging.Fatal("config: Init(): ", err)
+	}
+
+	// Log level from the config file overrides the command line option
+	if c.ConfigLogLevel != nil {
+		if err := logging.SetLogLevel(*c.ConfigLogLevel); err != nil {
+			logging.Fatal("config: Init(): Could not set log level: ", err)
+		}
+	}
+
+	logging.Debug("config: Init(): Configuration: ", fmt.Sprintf("%+v", c.redacted()))
+}
+
+// Load reads the config file cli.ConfigFile and returns the parsed and checked configuration.
+// In contrast to Init it returns an error instead of exiting, so it can be used to reload the configuration.
+func Load(cli CLIConfig) (Configuration, error) {
+	c := Configuration{CLIConfig: cli}
+	err := c.load()
+	return c, err
+}
+
+// Parse parses data in the format of the config file and returns the checked configuration.
+// It is used to apply configurations that were not read from the config file, e.g. stored revisions.
+func Parse(cli CLIConfig, data []byte) (Configuration, error) {
+	c := Configuration{CLIConfig: cli}
+	err := c.parse(data)
+	return c, err
+}
+
+// load reads the config file c.ConfigFile and maps its data to c.
+func (c *Configuration) load() error {
+
 	// Read config file
 	data, err := os.ReadFile(c.ConfigFile)
-	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		logging.Fatal("config: Init(): Could not read config file: '", c.ConfigFile, "' Error: ", err)
+	if err != nil {
+		return fmt.Errorf("could not read config file '%s': %w", c.ConfigFile, err)
 	}
-	logging.Info("config: Init(): Read config file '", c.ConfigFile, "'")
+	logging.Info("config: load(): Read config file '", c.ConfigFile, "'")
+
+	return c.parse(data)
+}
+
+// parse maps data in the format of the config file to c and checks the result.
+func (c *Configuration) parse(data []byte) error {
 
 	// Default config values
 	c.JSONWebTokenLifeTimeString = "24h"
 	c.APITokenLifeTimeString = fmt.Sprint(10*365*24, "h") // API token should "never" expire
+	c.ImpersonationLifeTimeString = "1h"
 	c.AutoAssignUserRole = false
+	c.RoleRequestsPerDay = 3
 
 	// Decode JSON
 	d := json.NewDecoder(bytes.NewReader(data))
 	d.DisallowUnknownFields()
 	if err := d.Decode(c); err != nil {
-		logging.Fatal("config: Init(): Could not decode config file: ", err)
-	}
-	logging.Info("config: Init(): Parsed config file '", c.ConfigFile, "'")
-
-	// Compute JSON web token and API token life time
-	c.JSONWebTokenLifeTime, err = time.ParseDuration(c.JSONWebTokenLifeTimeString)
-	if err != nil {
-		logging.Fatal("config: Init(): Failed to parse json_web_token_life_time `", c.JSONWebTokenLifeTimeString, "`: ", err)
-	}
-	c.APITokenLifeTime, err = time.ParseDuration(c.APITokenLifeTimeString)
-	if err != nil {
-		logging.Fatal("config: Init(): Failed to parse api_token_life_time `", c.APITokenLifeTimeString, "`: ", err)
+		return fmt.Errorf("could not decode config file: %w", err)
 	}
+	logging.Info("config: parse(): Parsed configuration")
 
 	// Add GUIDs to metrics if any are missing
 	for i := range c.Metrics {
@@ -289,44 +398,16 @@ func (c *Configuration) Init() {
 		}
 	}
 
-	// Check for each partition and radar chart that all used metric GUIDs are configured
-	metricAvailable := make(map[string]bool)
-	for _, m := range c.Metrics {
-		metricAvailable[m.GUID] = true
-	}
-	for partName, partConfig := range c.Partitions {
-		for _, partMetrics := range partConfig.Metrics {
-			if !metricAvailable[partMetrics] {
-				logging.Fatal("config: Init(): Metric ", partMetrics, " from partition ", partName, " config is not available")
-			}
-		}
-	}
-	for _, radarChartMetrics := range c.RadarChartMetrics {
-		if !metricAvailable[radarChartMetrics] {
-			logging.Fatal("config: Init(): Metric ", radarChartMetrics, " from radar chart config is not available")
-		}
+	// Resolve secrets before the validation, so missing secrets are detected
+	errs := c.resolveSecrets()
+	if errs = append(errs, c.Validate()...); len(errs) > 0 {
+		return errs
 	}
 
-	// Check that only allowed aggregation functions are used
-	aggFnAvailable := map[string]bool{
-		"max":  true,
-		"mean": true,
-		"min":  true,
-		"sum":  true,
-	}
-
-	for _, metricConfig := range c.Metrics {
-		if metricConfig.AggFn != "" {
-			if !aggFnAvailable[metricConfig.AggFn] {
-				logging.Fatal("config: Init(): Metric ", metricConfig.GUID, " uses unknown AggFn = ", metricConfig.AggFn)
-			}
-		}
-		for _, aggFn := range metricConfig.AvailableAggFns {
-			if !aggFnAvailable[aggFn] {
-				logging.Fatal("config: Init(): Metric ", metricConfig.GUID, " uses unknown AvailableAggFns = ", metricConfig.AggFn)
-			}
-		}
-	}
+	// Compute JSON web token and API token life time; the durations were checked by Validate
+	c.JSONWebTokenLifeTime, _ = time.ParseDuration(c.JSONWebTokenLifeTimeString)
+	c.APITokenLifeTime, _ = time.ParseDuration(c.APITokenLifeTimeString)
+	c.ImpersonationLifeTime, _ = time.ParseDuration(c.ImpersonationLifeTimeString)
 
 	// Sort metrics by DisplayName
 	sort.SliceStable(
@@ -335,7 +416,7 @@ func (c *Configuration) Init() {
 			return c.Metrics[i].DisplayName < c.Metrics[j].DisplayName
 		})
 
-	logging.Debug("config: Init(): Configuration: ", fmt.Sprintf("%+v", *c))
+	return nil
 }
 
 // Flush saves the state of the configuration c into the config.json file.
@@ -354,6 +435,40 @@ func (c *Configuration) Flush() {
 	}
 }
 
+// ForNodes returns the configuration of the virtual partition that contains all nodes,
+// or the configuration of the partition itself if there is none.
+func (pc PartitionConfig) ForNodes(nodes []string) BasePartitionConfig {
+	for _, vp := range pc.VirtualPartitions {
+		matches := true
+		// Check if all nodes are in vp
+		for _, n := range nodes {
+			if !utils.Contains(vp.Nodes, n) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return vp.BasePartitionConfig
+		}
+	}
+	return pc.BasePartitionConfig
+}
+
+// WithDefaults returns c with the defaults for all unset fields.
+// The penalty of method "nonparametric" is fixed to 3.
+func (c PhaseDetectionConfig) WithDefaults() PhaseDetectionConfig {
+	if c.Method == "" {
+		c.Method = "nonparametric"
+	}
+	if c.Penalty == 0 || c.Method == "nonparametric" {
+		c.Penalty = 3
+	}
+	if c.MinSegment == 0 {
+		c.MinSegment = 1
+	}
+	return c
+}
+
 // Metrics parameter contains all metrics GUID that should be deleted
 func (pc *PartitionConfig) RemoveMissingMetrics(metrics []string) {
 	for _, v := range metrics {
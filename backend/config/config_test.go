@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -243,3 +244,27 @@ func TestRemoveMissingMetrics(t *testing.T) {
 		t.Errorf("RemoveMissingMetrics failed, expected: %v, got: %v", expectedMetrics, pc.Metrics)
 	}
 }
+
+// Tests if a marshalled configuration is parsed to the same configuration
+func TestParse(t *testing.T) {
+	c, err := Load(CLIConfig{ConfigFile: "./test_config.json"})
+	if err != nil {
+		t.Fatalf("Could not load test config: %v", err)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Could not marshal config: %v", err)
+	}
+
+	parsed, err := Parse(c.CLIConfig, data)
+	if err != nil {
+		t.Fatalf("Could not parse config: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, c) {
+		t.Fatalf("Parsed config differs from original")
+	}
+
+	if _, err := Parse(c.CLIConfig, []byte(`{"JWTSecret": "s", "json_web_token_life_time": "forever"}`)); err == nil {
+		t.Fatalf("Invalid life time was accepted")
+	}
+}
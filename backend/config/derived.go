@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"jobmon/expr"
+)
+
+// IsDerived returns whether the values of m are computed from other metrics by m.Expression.
+func (m MetricConfig) IsDerived() bool {
+	return m.Expression != ""
+}
+
+// Components returns the metrics the derived metric m is computed from, keyed by the
+// variables of its expression. Variables refer to the measurement of a metric in metrics
+// that is not derived itself.
+func (m MetricConfig) Components(metrics []MetricConfig) (map[string]MetricConfig, error) {
+	e, err := expr.Parse(m.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", m.Expression, err)
+	}
+	components := make(map[string]MetricConfig)
+	for _, name := range e.Variables() {
+		found := 0
+		for _, c := range metrics {
+			if c.Measurement == name && !c.IsDerived() {
+				components[name] = c
+				found++
+			}
+		}
+		switch {
+		case found == 0:
+			return nil, fmt.Errorf("no metric with measurement '%s' configured", name)
+		case found > 1:
+			return nil, fmt.Errorf("measurement '%s' is used by %d metrics", name, found)
+		}
+	}
+	return components, nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"jobmon/logging"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Interval in which the config file is checked for changes
+const WatchInterval = 10 * time.Second
+
+// Author of configuration changes read from the config file
+const FileAuthor = "config file"
+
+// Subscriber is called with the old and the new configuration and the user that applied
+// the change after the configuration changed. Both configurations must not be modified.
+type Subscriber func(oldConf *Configuration, newConf *Configuration, author string)
+
+// Holder holds the current configuration snapshot shared by all subsystems.
+// The snapshot returned by Get is never modified; changes are applied by
+// swapping in a new snapshot with Update, which notifies all subscribers.
+type Holder struct {
+	current     atomic.Pointer[Configuration]
+	subscribers []Subscriber
+	// Serializes updates, so subscribers see the changes in order
+	updateLock sync.Mutex
+	// Modification time of the config file when it was last read or written
+	modTime time.Time
+}
+
+// Init sets c as the current configuration.
+func (h *Holder) Init(c Configuration) {
+	h.current.Store(&c)
+	h.modTime = fileModTime(c.ConfigFile)
+}
+
+// Get returns the current configuration snapshot. It must not be modified.
+func (h *Holder) Get() *Configuration {
+	return h.current.Load()
+}
+
+// Subscribe registers s to be called on every configuration change.
+func (h *Holder) Subscribe(s Subscriber) {
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+	h.subscribers = append(h.subscribers, s)
+}
+
+// Update swaps in c as the current configuration applied by author and notifies all subscribers.
+func (h *Holder) Update(c Configuration, author string) {
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+	h.update(c, author)
+}
+
+func (h *Holder) update(c Configuration, author string) {
+	old := h.current.Swap(&c)
+	for _, s := range h.subscribers {
+		s(old, &c, author)
+	}
+}
+
+// Flush saves the current configuration into the config file.
+func (h *Holder) Flush() {
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+	c := h.Get()
+	c.Flush()
+	h.modTime = fileModTime(c.ConfigFile)
+}
+
+// Reload reads the config file again and applies it if it is valid.
+// On errors the current configuration is kept.
+func (h *Holder) Reload() error {
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+
+	current := h.Get()
+	modTime := fileModTime(current.ConfigFile)
+	c, err := Load(current.CLIConfig)
+	h.modTime = modTime
+	if err != nil {
+		logging.Error("config: Reload(): Keeping current configuration, new configuration is invalid: ", err)
+		return err
+	}
+	h.update(c, FileAuthor)
+	logging.Warning("config: Reload(): Reloaded configuration from '", c.ConfigFile, "'")
+	return nil
+}
+
+// Watch checks the config file every interval for changes and reloads it when it was modified.
+func (h *Holder) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			h.updateLock.Lock()
+			changed := fileModTime(h.Get().ConfigFile).After(h.modTime)
+			h.updateLock.Unlock()
+			if changed {
+				h.Reload()
+			}
+		}
+	}()
+}
+
+// fileModTime returns the modification time of file or the zero time if it cannot be read.
+func fileModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestHolder returns a Holder initialized with a copy of test_config.json in a temporary directory.
+func newTestHolder(t *testing.T) (*Holder, string) {
+	data, err := os.ReadFile("./test_config.json")
+	if err != nil {
+		t.Fatalf("Could not read test config: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatalf("Could not write test config: %v", err)
+	}
+
+	c, err := Load(CLIConfig{ConfigFile: file})
+	if err != nil {
+		t.Fatalf("Could not load test config: %v", err)
+	}
+	h := &Holder{}
+	h.Init(c)
+	return h, file
+}
+
+// Tests if updates swap the snapshot and notify subscribers
+func TestHolderUpdate(t *testing.T) {
+	h, _ := newTestHolder(t)
+	first := h.Get()
+
+	var notified []int
+	h.Subscribe(func(oldConf *Configuration, newConf *Configuration, author string) {
+		notified = append(notified, oldConf.CacheSize, newConf.CacheSize)
+	})
+
+	updated := *first
+	updated.CacheSize = 10
+	h.Update(updated, "adminTest")
+
+	if h.Get().CacheSize != 10 || first.CacheSize != 50 {
+		t.Fatalf("Snapshot was not swapped or old snapshot was modified")
+	}
+	if len(notified) != 2 || notified[0] != 50 || notified[1] != 10 {
+		t.Fatalf("Subscriber was not notified correctly: %v", notified)
+	}
+}
+
+// Tests if a valid config file is applied on reload and an invalid one is rejected
+func TestHolderReload(t *testing.T) {
+	h, file := newTestHolder(t)
+
+	notified := 0
+	h.Subscribe(func(oldConf *Configuration, newConf *Configuration, author string) {
+		notified++
+	})
+
+	c := *h.Get()
+	c.CacheSize = 20
+	c.Flush()
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Could not reload valid config: %v", err)
+	}
+	if h.Get().CacheSize != 20 || notified != 1 {
+		t.Fatalf("Reloaded config was not applied")
+	}
+
+	if err := os.WriteFile(file, []byte(`{"CacheSize": "many"}`), 0644); err != nil {
+		t.Fatalf("Could not write invalid config: %v", err)
+	}
+	if err := h.Reload(); err == nil {
+		t.Fatalf("Invalid config was accepted")
+	}
+	if h.Get().CacheSize != 20 || notified != 1 {
+		t.Fatalf("Invalid config replaced the current config")
+	}
+}
+
+// Tests if Load reports problems instead of exiting
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load(CLIConfig{ConfigFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Fatalf("Missing config file was accepted")
+	}
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(file, []byte(`{"JWTSecret": "s", "RadarChartMetrics": ["unknown"]}`), 0644)
+	if _, err := Load(CLIConfig{ConfigFile: file}); err == nil {
+		t.Fatalf("Config with unknown radar chart metric was accepted")
+	}
+}
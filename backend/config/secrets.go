@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Prefix of secret values that are read from a file, e.g. "file:/run/secrets/jwt"
+const secretFilePrefix = "file:"
+
+// Value that replaces secrets in the debug dump of the configuration
+const redactedSecret = "<redacted>"
+
+// Secret values that are read from an environment variable, e.g. "${JOBMON_JWT_SECRET}"
+var secretEnvPattern = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// secretFields returns pointers to all fields of c that may contain secrets,
+// keyed by their path in the config file.
+func (c *Configuration) secretFields() map[string]*string {
+	return map[string]*string{
+		"JWTSecret":                        &c.JWTSecret,
+		"DBToken":                          &c.DBToken,
+		"JobStore.PSQLPassword":            &c.JobStore.PSQLPassword,
+		"OAuth.Secret":                     &c.OAuth.Secret,
+		"EmailNotification.SenderPassword": &c.Email.SenderPassword,
+	}
+}
+
+// resolveSecrets replaces references to environment variables and files in
+// the secret fields of c by their values. The references are kept, so they
+// instead of the values are written back to the config file.
+func (c *Configuration) resolveSecrets() (errs ValidationErrors) {
+	errs = ValidationErrors{}
+	c.secretRefs = make(map[string]string)
+	for field, value := range c.secretFields() {
+		resolved, isRef, err := resolveSecret(*value)
+		if err != nil {
+			errs.add(field, "%v", err)
+			continue
+		}
+		if isRef {
+			c.secretRefs[field] = *value
+			*value = resolved
+		}
+	}
+	return errs
+}
+
+// resolveSecret returns the value of the secret reference ref
+// and whether ref is a reference at all.
+func resolveSecret(ref string) (value string, isRef bool, err error) {
+	if match := secretEnvPattern.FindStringSubmatch(ref); match != nil {
+		value, ok := os.LookupEnv(match[1])
+		if !ok {
+			return "", true, fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return value, true, nil
+	}
+	if file, ok := strings.CutPrefix(ref, secretFilePrefix); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", true, fmt.Errorf("could not read secret file: %w", err)
+		}
+		// Files written with echo or editors end with a newline
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return ref, false, nil
+}
+
+// MarshalJSON writes the configuration in the format of the config file.
+// Secrets read from environment variables or files are written as their references.
+func (c Configuration) MarshalJSON() ([]byte, error) {
+	for field, value := range c.secretFields() {
+		if ref, ok := c.secretRefs[field]; ok {
+			*value = ref
+		}
+	}
+	// Conversion to a type without methods prevents an endless recursion
+	type configuration Configuration
+	return json.Marshal(configuration(c))
+}
+
+// redacted returns a copy of c with all secrets replaced, e.g. for logging.
+func (c Configuration) redacted() Configuration {
+	c.secretRefs = nil
+	for _, value := range c.secretFields() {
+		if *value != "" {
+			*value = redactedSecret
+		}
+	}
+	return c
+}
+
+// WithoutSecrets returns a copy of c whose JSON representation can be stored, e.g. as configuration
+// revision: secrets read from environment variables or files are written as their references, all
+// other secrets and the password hashes of the local users are redacted. See RestoreSecrets.
+func (c Configuration) WithoutSecrets() Configuration {
+	for field, value := range c.secretFields() {
+		if _, isRef := c.secretRefs[field]; !isRef && *value != "" {
+			*value = redactedSecret
+		}
+	}
+	if c.LocalUsers != nil {
+		users := make(map[string]LocalUser, len(c.LocalUsers))
+		for name, user := range c.LocalUsers {
+			if user.BCryptHash != "" {
+				user.BCryptHash = redactedSecret
+			}
+			users[name] = user
+		}
+		c.LocalUsers = users
+	}
+	return c
+}
+
+// RestoreSecrets replaces the secrets and password hashes redacted by WithoutSecrets with those of
+// current. Redacted values that are not set in current are reported as errors.
+func (c *Configuration) RestoreSecrets(current Configuration) error {
+	errs := ValidationErrors{}
+	currentFields := current.secretFields()
+	fields := c.secretFields()
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		value := fields[field]
+		if *value != redactedSecret {
+			continue
+		}
+		*value = *currentFields[field]
+		if ref, isRef := current.secretRefs[field]; isRef {
+			if c.secretRefs == nil {
+				c.secretRefs = make(map[string]string)
+			}
+			c.secretRefs[field] = ref
+		}
+		if *value == "" {
+			errs.add(field, "secret was not stored and is not set in the current configuration")
+		}
+	}
+
+	for name, user := range c.LocalUsers {
+		if user.BCryptHash != redactedSecret {
+			continue
+		}
+		user.BCryptHash = current.LocalUsers[name].BCryptHash
+		c.LocalUsers[name] = user
+		if user.BCryptHash == "" {
+			errs.add("LocalUsers."+name+".BCryptHash", "password hash was not stored and the user is not in the current configuration")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests if secret references are resolved on load and written back as references
+func TestSecrets(t *testing.T) {
+	t.Setenv("JOBMON_TEST_JWT_SECRET", "jwt-value")
+	secretFile := filepath.Join(t.TempDir(), "db_token")
+	os.WriteFile(secretFile, []byte("token-value\n"), 0600)
+
+	data, _ := json.Marshal(map[string]any{
+		"JWTSecret": "${JOBMON_TEST_JWT_SECRET}",
+		"DBToken":   "file:" + secretFile,
+		"OAuth":     map[string]string{"Secret": "plain-value"},
+	})
+	c, err := Parse(CLIConfig{}, data)
+	if err != nil {
+		t.Fatalf("Could not parse config with secret references: %v", err)
+	}
+	if c.JWTSecret != "jwt-value" || c.DBToken != "token-value" || c.OAuth.Secret != "plain-value" {
+		t.Fatalf("Secrets were not resolved: %s, %s, %s", c.JWTSecret, c.DBToken, c.OAuth.Secret)
+	}
+
+	flushed, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Could not marshal config: %v", err)
+	}
+	if strings.Contains(string(flushed), "jwt-value") || strings.Contains(string(flushed), "token-value") ||
+		!strings.Contains(string(flushed), "${JOBMON_TEST_JWT_SECRET}") {
+		t.Fatalf("Resolved secrets were written back: %s", flushed)
+	}
+	if c.JWTSecret != "jwt-value" {
+		t.Fatalf("Marshalling modified the configuration")
+	}
+
+	redacted := c.redacted()
+	if redacted.JWTSecret != redactedSecret || redacted.OAuth.Secret != redactedSecret || redacted.JobStore.PSQLPassword != "" {
+		t.Fatalf("Secrets were not redacted: %+v", redacted)
+	}
+
+	data, _ = json.Marshal(map[string]any{"JWTSecret": "${JOBMON_TEST_UNSET_SECRET}"})
+	if _, err := Parse(CLIConfig{}, data); err == nil || !strings.Contains(err.Error(), "JWTSecret") {
+		t.Fatalf("Missing environment variable was not reported: %v", err)
+	}
+}
+
+// Tests if stored configurations contain no secrets and if they are restored from the current configuration
+func TestWithoutSecrets(t *testing.T) {
+	t.Setenv("JOBMON_TEST_JWT_SECRET", "jwt-value")
+	data, _ := json.Marshal(map[string]any{
+		"JWTSecret":  "${JOBMON_TEST_JWT_SECRET}",
+		"DBToken":    "token-value",
+		"LocalUsers": map[string]any{"admin": map[string]string{"BCryptHash": "hash-value", "Role": "admin"}},
+	})
+	current, err := Parse(CLIConfig{}, data)
+	if err != nil {
+		t.Fatalf("Could not parse config: %v", err)
+	}
+
+	stored, err := json.Marshal(current.WithoutSecrets())
+	if err != nil {
+		t.Fatalf("Could not marshal config: %v", err)
+	}
+	if strings.Contains(string(stored), "token-value") || strings.Contains(string(stored), "hash-value") ||
+		strings.Contains(string(stored), "jwt-value") || !strings.Contains(string(stored), "${JOBMON_TEST_JWT_SECRET}") {
+		t.Fatalf("Secrets were stored: %s", stored)
+	}
+	if current.DBToken != "token-value" || current.LocalUsers["admin"].BCryptHash != "hash-value" {
+		t.Fatalf("Redaction modified the configuration")
+	}
+
+	revision, err := Parse(CLIConfig{}, stored)
+	if err != nil {
+		t.Fatalf("Could not parse stored config: %v", err)
+	}
+	if err := revision.RestoreSecrets(current); err != nil {
+		t.Fatalf("Could not restore secrets: %v", err)
+	}
+	if revision.JWTSecret != "jwt-value" || revision.DBToken != "token-value" || revision.LocalUsers["admin"].BCryptHash != "hash-value" {
+		t.Fatalf("Secrets were not restored: %+v", revision)
+	}
+
+	// Secrets that are not set in the current configuration cannot be restored
+	revision, _ = Parse(CLIConfig{}, stored)
+	current.DBToken = ""
+	delete(current.LocalUsers, "admin")
+	err = revision.RestoreSecrets(current)
+	if err == nil || !strings.Contains(err.Error(), "DBToken") || !strings.Contains(err.Error(), "LocalUsers.admin") {
+		t.Fatalf("Missing secrets were not reported: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"fmt"
+	"jobmon/logging"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Aggregation functions that can be used for metrics
+var AggFns = []string{"max", "mean", "min", "sum"}
+
+// Types of metrics databases: InfluxDB 2 queried with Flux, InfluxDB 1.x queried with InfluxQL
+// and PostgreSQL with the TimescaleDB extension queried with SQL
+var DBTypes = []string{"influxdb2", "influxdb1", "timescaledb"}
+
+// Change point detection methods that can be used to split jobs into phases
+var ChangePointMethods = []string{"nonparametric", "mean"}
+
+// ValidationError describes a problem with a single field of the configuration.
+type ValidationError struct {
+	// Path of the field, e.g. "Metrics[3].AggFn"
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors contains all problems found in a configuration.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add appends a problem with field to errs.
+func (errs *ValidationErrors) add(field string, format string, a ...any) {
+	*errs = append(*errs, ValidationError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// Validate checks the configuration c and returns all problems found.
+// Checks that need the metrics database are done by db.DB.ValidateMetrics.
+func (c *Configuration) Validate() (errs ValidationErrors) {
+	errs = ValidationErrors{}
+
+	// Token life times, checked in a fixed order so the problems are reported deterministically
+	for _, lifeTime := range []struct {
+		field string
+		value string
+	}{
+		{"json_web_token_life_time", c.JSONWebTokenLifeTimeString},
+		{"api_token_life_time", c.APITokenLifeTimeString},
+		{"impersonation_life_time", c.ImpersonationLifeTimeString},
+	} {
+		if d, err := time.ParseDuration(lifeTime.value); err != nil {
+			errs.add(lifeTime.field, "invalid duration '%s'", lifeTime.value)
+		} else if d <= 0 {
+			errs.add(lifeTime.field, "must be positive")
+		}
+	}
+
+	if c.DBType != "" && !slices.Contains(DBTypes, c.DBType) {
+		errs.add("DBType", "unknown database type '%s', must be one of %v", c.DBType, DBTypes)
+	}
+	if c.JWTSecret == "" {
+		errs.add("JWTSecret", "no JWT secret set")
+	}
+	if c.SampleInterval != "" {
+		if _, err := time.ParseDuration(c.SampleInterval); err != nil {
+			errs.add("SampleInterval", "invalid duration '%s'", c.SampleInterval)
+		}
+	}
+	if c.CacheSize < 0 {
+		errs.add("CacheSize", "must not be negative")
+	}
+	if c.CacheMemory < 0 {
+		errs.add("CacheMemory", "must not be negative")
+	}
+	if c.CacheDiskSize < 0 {
+		errs.add("CacheDiskSize", "must not be negative")
+	}
+	if c.QueryConcurrency < 0 {
+		errs.add("QueryConcurrency", "must not be negative")
+	}
+	// Optional durations
+	for field, value := range map[string]string{
+		"CacheTTL":       c.CacheTTL,
+		"RequestTimeout": c.RequestTimeout,
+		"QueryTimeout":   c.QueryTimeout,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			errs.add(field, "invalid duration '%s'", value)
+		} else if d <= 0 {
+			errs.add(field, "must be positive")
+		}
+	}
+	if c.RoleRequestsPerDay < 0 {
+		errs.add("RoleRequestsPerDay", "must not be negative")
+	}
+	if c.ConfigLogLevel != nil && (*c.ConfigLogLevel < logging.OffLogLevel || *c.ConfigLogLevel > logging.DebugLogLevel) {
+		errs.add("LogLevel", "must be between off = %d and debug = %d", logging.OffLogLevel, logging.DebugLogLevel)
+	}
+	for i, q := range c.MetricQuantiles {
+		if v, err := strconv.ParseFloat(q, 64); err != nil || v < 0 || v > 1 {
+			errs.add(fmt.Sprintf("MetricQuantiles[%d]", i), "'%s' is not a decimal between 0 and 1", q)
+		}
+	}
+	for i, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs.add(fmt.Sprintf("TrustedProxies[%d]", i), "'%s' is neither an IP address nor a CIDR range", proxy)
+		}
+	}
+	for name, u := range c.LocalUsers {
+		if u.BCryptHash == "" {
+			errs.add("LocalUsers."+name+".BCryptHash", "no password hash set")
+		}
+		if u.Role == "" {
+			errs.add("LocalUsers."+name+".Role", "no role set")
+		}
+	}
+
+	// Metrics
+	metricAvailable := make(map[string]bool)
+	for i, m := range c.Metrics {
+		field := fmt.Sprintf("Metrics[%d]", i)
+		if m.GUID != "" {
+			if metricAvailable[m.GUID] {
+				errs.add(field+".GUID", "duplicate GUID %s", m.GUID)
+			}
+			metricAvailable[m.GUID] = true
+		}
+		if m.Measurement == "" {
+			errs.add(field+".Measurement", "no measurement set for metric '%s'", m.DisplayName)
+		}
+		if m.AggFn != "" && !slices.Contains(AggFns, m.AggFn) {
+			errs.add(field+".AggFn", "unknown aggregation function '%s'", m.AggFn)
+		}
+		for j, aggFn := range m.AvailableAggFns {
+			if !slices.Contains(AggFns, aggFn) {
+				errs.add(fmt.Sprintf("%s.AvailableAggFns[%d]", field, j), "unknown aggregation function '%s'", aggFn)
+			}
+		}
+		if m.PThreadAggFn != "" && !slices.Contains(AggFns, m.PThreadAggFn) {
+			errs.add(field+".PThreadAggFn", "unknown aggregation function '%s'", m.PThreadAggFn)
+		}
+		if m.SampleInterval != "" {
+			if _, err := time.ParseDuration(m.SampleInterval); err != nil {
+				errs.add(field+".SampleInterval", "invalid duration '%s'", m.SampleInterval)
+			}
+		}
+		if m.IsDerived() {
+			if _, err := m.Components(c.Metrics); err != nil {
+				errs.add(field+".Expression", "%v", err)
+			}
+			if m.Type != "node" {
+				errs.add(field+".Type", "derived metrics are computed per node and must have type 'node'")
+			}
+			if m.SeparationKey != "hostname" {
+				errs.add(field+".SeparationKey", "derived metrics are separated by 'hostname'")
+			}
+			for _, other := range c.Metrics {
+				if other.Measurement == m.Measurement && other.GUID != m.GUID {
+					errs.add(field+".Measurement", "measurement '%s' of a derived metric must not be used by other metrics", m.Measurement)
+					break
+				}
+			}
+		}
+		if len(c.MetricCategories) > 0 {
+			for j, category := range m.Categories {
+				if !slices.Contains(c.MetricCategories, category) {
+					errs.add(fmt.Sprintf("%s.Categories[%d]", field, j), "unknown category '%s'", category)
+				}
+			}
+		}
+	}
+
+	// Check for each partition and radar chart that all used metric GUIDs are configured
+	for partName, partConfig := range c.Partitions {
+		for _, guid := range partConfig.Metrics {
+			if !metricAvailable[guid] {
+				errs.add("Partitions."+partName+".Metrics", "metric %s is not available", guid)
+			}
+		}
+		for virtName, virtConfig := range partConfig.VirtualPartitions {
+			for _, guid := range virtConfig.Metrics {
+				if !metricAvailable[guid] {
+					errs.add("Partitions."+partName+".VirtualPartitions."+virtName+".Metrics", "metric %s is not available", guid)
+				}
+			}
+		}
+	}
+	for _, guid := range c.RadarChartMetrics {
+		if !metricAvailable[guid] {
+			errs.add("RadarChartMetrics", "metric %s is not available", guid)
+		}
+	}
+
+	if c.Roofline != nil {
+		for field, guid := range map[string]string{
+			"Roofline.FlopsMetric":        c.Roofline.FlopsMetric,
+			"Roofline.MemBandwidthMetric": c.Roofline.MemBandwidthMetric,
+		} {
+			if !metricAvailable[guid] {
+				errs.add(field, "metric %s is not available", guid)
+			}
+		}
+	}
+	if c.Analysis != nil && c.Analysis.Timeout != "" {
+		if d, err := time.ParseDuration(c.Analysis.Timeout); err != nil {
+			errs.add("Analysis.Timeout", "invalid duration '%s'", c.Analysis.Timeout)
+		} else if d <= 0 {
+			errs.add("Analysis.Timeout", "must be positive")
+		}
+	}
+	if c.PhaseDetection != nil {
+		for _, e := range c.PhaseDetection.Validate() {
+			errs.add("PhaseDetection."+e.Field, e.Message)
+		}
+	}
+	for partName, partConfig := range c.Partitions {
+		if partConfig.PeakFlops < 0 || partConfig.PeakMemBandwidth < 0 {
+			errs.add("Partitions."+partName, "peak values must not be negative")
+		}
+		for virtName, virtConfig := range partConfig.VirtualPartitions {
+			if virtConfig.PeakFlops < 0 || virtConfig.PeakMemBandwidth < 0 {
+				errs.add("Partitions."+partName+".VirtualPartitions."+virtName, "peak values must not be negative")
+			}
+		}
+	}
+
+	// Map iteration order is random, report problems in a stable order
+	slices.SortStableFunc(errs, func(a, b ValidationError) bool {
+		return a.Field < b.Field
+	})
+	return errs
+}
+
+// Validate checks the phase detection configuration c and returns all problems found.
+func (c PhaseDetectionConfig) Validate() (errs ValidationErrors) {
+	errs = ValidationErrors{}
+	if c.Method != "" && !slices.Contains(ChangePointMethods, c.Method) {
+		errs.add("Method", "must be one of %s", strings.Join(ChangePointMethods, ", "))
+	}
+	if c.Penalty < 0 {
+		errs.add("Penalty", "must not be negative")
+	} else if c.Penalty > 0 && c.Method != "mean" {
+		errs.add("Penalty", "is only supported by method mean")
+	}
+	if c.MinSegment < 0 {
+		errs.add("MinSegment", "must not be negative")
+	}
+	return errs
+}
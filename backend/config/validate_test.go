@@ -0,0 +1,96 @@
+package config
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Tests if the test config is valid and all problems of an invalid config are reported at once
+func TestValidate(t *testing.T) {
+	c, err := Load(CLIConfig{ConfigFile: "./test_config.json"})
+	if err != nil {
+		t.Fatalf("Could not load test config: %v", err)
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Fatalf("Test config is not valid: %v", errs)
+	}
+
+	c.JWTSecret = ""
+	c.JSONWebTokenLifeTimeString = "1 day"
+	c.APITokenLifeTimeString = "-1h"
+	c.ImpersonationLifeTimeString = "0s"
+	c.Metrics = append(c.Metrics, MetricConfig{GUID: c.Metrics[0].GUID, AggFn: "median"})
+	c.RadarChartMetrics = append(c.RadarChartMetrics, "unknown")
+	c.PhaseDetection = &PhaseDetectionConfig{Method: "binseg", Penalty: 2}
+	c.RequestTimeout = "60"
+	c.QueryTimeout = "-10s"
+	c.QueryConcurrency = -1
+	c.DBType = "influxdb3"
+	c.TrustedProxies = []string{"10.0.0.0/8", "proxy.example.org"}
+
+	errs := c.Validate()
+	fields := make(map[string]bool)
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	last := len(c.Metrics) - 1
+	for _, field := range []string{
+		"JWTSecret",
+		"json_web_token_life_time",
+		"api_token_life_time",
+		"impersonation_life_time",
+		"Metrics[" + strconv.Itoa(last) + "].GUID",
+		"Metrics[" + strconv.Itoa(last) + "].AggFn",
+		"Metrics[" + strconv.Itoa(last) + "].Measurement",
+		"RadarChartMetrics",
+		"PhaseDetection.Method",
+		"PhaseDetection.Penalty",
+		"RequestTimeout",
+		"QueryTimeout",
+		"QueryConcurrency",
+		"DBType",
+		"TrustedProxies[1]",
+	} {
+		if !fields[field] {
+			t.Errorf("Missing problem with %s in %v", field, errs)
+		}
+	}
+	for i := 1; i < len(errs); i++ {
+		if errs[i-1].Field > errs[i].Field {
+			t.Fatalf("Problems are not sorted: %v", errs)
+		}
+	}
+}
+
+// Tests if derived metrics must refer to configured metrics that are not derived themselves
+func TestValidateDerived(t *testing.T) {
+	c := Configuration{
+		JSONWebTokenLifeTimeString:  "1h",
+		APITokenLifeTimeString:      "1h",
+		ImpersonationLifeTimeString: "1h",
+		JWTSecret:                   "secret",
+		Metrics: []MetricConfig{
+			{GUID: "1", Measurement: "flops_any", Type: "cpu"},
+			{GUID: "2", Measurement: "mem_bw", Type: "socket"},
+			{GUID: "3", Measurement: "intensity", Type: "node", SeparationKey: "hostname", Expression: "flops_any / mem_bw"},
+		},
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Fatalf("Valid derived metric was rejected: %v", errs)
+	}
+
+	c.Metrics = append(c.Metrics,
+		MetricConfig{GUID: "4", Measurement: "twice", Type: "node", SeparationKey: "hostname", Expression: "2 * intensity"},
+		MetricConfig{GUID: "5", Measurement: "mem_bw", Type: "socket", SeparationKey: "type-id", Expression: "mem_bw *"},
+	)
+	errs := c.Validate()
+	fields := make(map[string]bool)
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	for _, field := range []string{"Metrics[3].Expression", "Metrics[4].Expression", "Metrics[4].Type", "Metrics[4].Measurement"} {
+		if !fields[field] {
+			t.Errorf("Missing problem with %s in %v", field, errs)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoAggregation is returned by BackfillAggregations of databases that aggregate node data when it is queried.
+var ErrNoAggregation = errors.New("node data is aggregated when it is queried")
+
+// Maximum time range aggregated by one backfill query of InfluxDB
+const backfillWindow = 24 * time.Hour
+
+// AggregationStatus is the status of an aggregation of node data, e.g. an InfluxDB task
+// or a TimescaleDB continuous aggregate.
+type AggregationStatus struct {
+	// Name of the aggregation, e.g. the name of the InfluxDB task
+	Name string
+	// Measurement of the aggregated node data, e.g. "flops_any_sum"
+	Measurement string
+	// Status of the aggregation: "active", "inactive" or "missing" if it does not exist in the database
+	Status string
+	// Time of the last run; zero if it did not run yet
+	LastRun time.Time
+	// Status of the last run, e.g. "success" or "failed"
+	LastRunStatus string `json:",omitempty"`
+	// Error of the last run if it failed
+	LastError string `json:",omitempty"`
+	// Progress of the latest backfill; nil if no backfill was started since the backend started
+	Backfill *BackfillStatus `json:",omitempty"`
+}
+
+// BackfillStatus is the progress of aggregating historical data of an aggregation.
+type BackfillStatus struct {
+	// Time range of the backfill
+	Start time.Time
+	Stop  time.Time
+	// Time up to which the data is aggregated
+	Done time.Time
+	// True if the backfill stopped, either completely or because of Error
+	Finished bool
+	Error    string `json:",omitempty"`
+}
+
+// aggregatingDB is implemented by the databases that aggregate node data in the database.
+type aggregatingDB interface {
+	// useAggregationState tracks the created aggregations and their backfills with a
+	useAggregationState(a *aggregationState)
+	// backfill aggregates the historical data of aggregation name between start and stop
+	backfill(ctx context.Context, name string, start time.Time, stop time.Time) error
+}
+
+// aggregationState tracks the aggregations created since the backend started and their backfills.
+// It is shared by the databases of a Reloadable, so the state survives reloads of the configuration
+// and running backfills continue on the database that replaced the one they were started on.
+type aggregationState struct {
+	mut    sync.Mutex
+	status map[string]*BackfillStatus
+	// Names of the aggregations created since the backend started
+	created map[string]bool
+	// Aggregates the historical data of an aggregation between start and stop
+	backfill func(ctx context.Context, name string, start time.Time, stop time.Time) error
+	// Serializes the reconciliations of the aggregations, which may overlap while a database is replaced
+	reconcile sync.Mutex
+	// Number of the latest reconciliation; earlier reconciliations are outdated
+	reconciliation int
+}
+
+// newAggregationState returns an aggregationState whose backfills aggregate the data with backfill.
+func newAggregationState(backfill func(ctx context.Context, name string, start time.Time, stop time.Time) error) *aggregationState {
+	return &aggregationState{
+		status:   make(map[string]*BackfillStatus),
+		created:  make(map[string]bool),
+		backfill: backfill,
+	}
+}
+
+// nextReconciliation returns the number of a new reconciliation, which outdates the earlier ones.
+func (a *aggregationState) nextReconciliation() int {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.reconciliation++
+	return a.reconciliation
+}
+
+// lockReconciliation waits until no other reconciliation runs. It returns false if reconciliation
+// is outdated, e.g. because its database was replaced; otherwise reconcile must be unlocked afterwards.
+func (a *aggregationState) lockReconciliation(reconciliation int) bool {
+	a.reconcile.Lock()
+	a.mut.Lock()
+	latest := a.reconciliation
+	a.mut.Unlock()
+	if reconciliation != latest {
+		a.reconcile.Unlock()
+		return false
+	}
+	return true
+}
+
+// addCreated records that the aggregations names were created.
+func (a *aggregationState) addCreated(names []string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	for _, name := range names {
+		a.created[name] = true
+	}
+}
+
+// pending returns the sorted names of the created aggregations that exist and were not backfilled yet.
+func (a *aggregationState) pending(exists func(name string) bool) (names []string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	for name := range a.created {
+		if _, ok := a.status[name]; !ok && exists(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns a copy of the status of the latest backfill of aggregation name or nil if none was started.
+func (a *aggregationState) get(name string) *BackfillStatus {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	status, ok := a.status[name]
+	if !ok {
+		return nil
+	}
+	s := *status
+	return &s
+}
+
+// run aggregates the data between start and stop of the aggregations in the order of names in the background.
+// The data of an aggregation is aggregated in consecutive windows of at most window, or at once for the
+// complete time range if window is zero; the backfill of an aggregation stops at its first error.
+// Aggregations with a running backfill are skipped.
+func (a *aggregationState) run(names []string, start time.Time, stop time.Time, window time.Duration) {
+	a.mut.Lock()
+	pending := []string{}
+	for _, name := range names {
+		if status, ok := a.status[name]; ok && !status.Finished {
+			continue
+		}
+		a.status[name] = &BackfillStatus{Start: start, Stop: stop, Done: start}
+		pending = append(pending, name)
+	}
+	a.mut.Unlock()
+
+	go func() {
+		ctx := context.Background()
+		for _, name := range pending {
+			var err error
+			for _, w := range backfillWindows(start, stop, window) {
+				if err = a.backfill(ctx, name, w[0], w[1]); err != nil {
+					break
+				}
+				a.mut.Lock()
+				a.status[name].Done = w[1]
+				a.mut.Unlock()
+			}
+			a.mut.Lock()
+			a.status[name].Finished = true
+			if err != nil {
+				a.status[name].Error = err.Error()
+			}
+			a.mut.Unlock()
+		}
+	}()
+}
+
+// backfillWindows splits the time range between start and stop into consecutive windows
+// with boundaries at multiples of window, so that they align with the aggregation windows.
+func backfillWindows(start time.Time, stop time.Time, window time.Duration) (windows [][2]time.Time) {
+	if window <= 0 {
+		return [][2]time.Time{{start, stop}}
+	}
+	for from := start; from.Before(stop); {
+		to := from.Truncate(window).Add(window)
+		if to.After(stop) {
+			to = stop
+		}
+		windows = append(windows, [2]time.Time{from, to})
+		from = to
+	}
+	return windows
+}
+
+// sortAggregationStatus sorts status by name.
+func sortAggregationStatus(status []AggregationStatus) {
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+}
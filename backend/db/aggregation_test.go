@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"errors"
+	conf "jobmon/config"
+	"jobmon/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// fakeTasksAPI is an in-memory stand-in for the task API of InfluxDB;
+// methods that are not used by the aggregation tasks are not implemented.
+type fakeTasksAPI struct {
+	api.TasksAPI
+	tasks   []domain.Task
+	deleted []string
+	updated []string
+	created []string
+	runs    []domain.Run
+	logs    []domain.LogEvent
+}
+
+func (f *fakeTasksAPI) FindTasks(ctx context.Context, filter *api.TaskFilter) ([]domain.Task, error) {
+	if filter.After != "" {
+		return nil, nil
+	}
+	return f.tasks, nil
+}
+
+func (f *fakeTasksAPI) CreateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	f.created = append(f.created, task.Name)
+	created := *task
+	created.Flux = taskFlux(task.Name, task.Flux)
+	return &created, nil
+}
+
+func (f *fakeTasksAPI) UpdateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	f.updated = append(f.updated, task.Name)
+	return task, nil
+}
+
+func (f *fakeTasksAPI) DeleteTask(ctx context.Context, task *domain.Task) error {
+	f.deleted = append(f.deleted, task.Name)
+	return nil
+}
+
+func (f *fakeTasksAPI) FindRuns(ctx context.Context, task *domain.Task, filter *api.RunFilter) ([]domain.Run, error) {
+	return f.runs, nil
+}
+
+func (f *fakeTasksAPI) FindRunLogs(ctx context.Context, run *domain.Run) ([]domain.LogEvent, error) {
+	return f.logs, nil
+}
+
+// newTasksTest returns an InfluxDB with the metrics whose tasks are managed by tasksAPI
+func newTasksTest(tasksAPI api.TasksAPI, metrics ...conf.MetricConfig) *InfluxDB {
+	orgId := "org-id"
+	db := &InfluxDB{
+		tasksAPI:              tasksAPI,
+		organization:          &domain.Organization{Id: &orgId},
+		organizationName:      "org",
+		bucketName:            "bucket",
+		defaultSampleInterval: "30s",
+		metrics:               make(map[string]conf.MetricConfig),
+	}
+	for _, m := range metrics {
+		db.metrics[m.GUID] = m
+	}
+	db.aggregations = newAggregationState(db.backfill)
+	return db
+}
+
+// Tests if missing tasks are created, changed tasks updated and orphaned tasks deleted
+func TestUpdateAggregationTasks(t *testing.T) {
+	flops := conf.MetricConfig{GUID: "1", Measurement: "flops_any", Type: "cpu", AvailableAggFns: []string{"sum", "max"}}
+	mem := conf.MetricConfig{GUID: "2", Measurement: "mem_bw", Type: "socket", AvailableAggFns: []string{"sum"}}
+
+	// The current task of flops_any with the sum and an outdated one of mem_bw before its type changed
+	db := newTasksTest(nil, flops, mem)
+	sumQuery, err := db.aggregationTaskQuery(aggregationTask{metric: flops, aggFn: "sum"}, taskRange)
+	if err != nil {
+		t.Fatalf("Could not create task query: %v", err)
+	}
+	outdated := mem
+	outdated.Type = "node"
+	memQuery, err := newTasksTest(nil, outdated).aggregationTaskQuery(aggregationTask{metric: outdated, aggFn: "sum"}, taskRange)
+	if err != nil {
+		t.Fatalf("Could not create task query: %v", err)
+	}
+	description := "Downsampling"
+	managed := aggregationTaskDescription
+	fake := &fakeTasksAPI{tasks: []domain.Task{
+		{Id: "1", Name: "bucket_flops_any_sum", Flux: taskFlux("bucket_flops_any_sum", sumQuery)},
+		{Id: "2", Name: "bucket_mem_bw_sum", Flux: taskFlux("bucket_mem_bw_sum", memQuery)},
+		// Task of a deleted aggregation function and a duplicate task
+		{Id: "3", Name: "bucket_flops_any_min", Flux: `option task = { name: "bucket_flops_any_min", every: 1m } from(bucket: "bucket") |> to(bucket: "bucket", org: "org")`},
+		{Id: "4", Name: "bucket_flops_any_sum", Flux: taskFlux("bucket_flops_any_sum", sumQuery)},
+		// Tasks that are not managed by the backend
+		{Id: "5", Name: "bucket_downsampling", Flux: `from(bucket: "bucket") |> to(bucket: "bucket", org: "org")`, Description: &description},
+		{Id: "6", Name: "other", Flux: `from(bucket: "other") |> to(bucket: "other", org: "org")`},
+		// Task of a backend using another bucket of the organization
+		{Id: "7", Name: "bucket_b_flops_any_sum", Flux: `from(bucket: "bucket_b") |> to(bucket: "bucket_b", org: "org")`,
+			Description: &managed},
+	}}
+	db.tasksAPI = fake
+	db.updateAggregationTasks()
+
+	if strings.Join(fake.created, ",") != "bucket_flops_any_max" {
+		t.Errorf("Wrong created tasks %v", fake.created)
+	}
+	if strings.Join(fake.updated, ",") != "bucket_mem_bw_sum" {
+		t.Errorf("Wrong updated tasks %v", fake.updated)
+	}
+	if strings.Join(fake.deleted, ",") != "bucket_flops_any_min,bucket_flops_any_sum" {
+		t.Errorf("Wrong deleted tasks %v", fake.deleted)
+	}
+	if len(db.tasks) != 3 || !db.aggregations.created["bucket_flops_any_max"] || len(db.aggregations.created) != 1 {
+		t.Errorf("Wrong tasks %v, created %v", db.tasks, db.aggregations.created)
+	}
+	for _, task := range db.tasks {
+		if task.Name == "bucket_mem_bw_sum" && !strings.Contains(task.Flux, `r["type"] == "socket"`) {
+			t.Errorf("Task was not updated: %s", task.Flux)
+		}
+	}
+
+	// Reconciling again changes nothing
+	fake.tasks, fake.created, fake.updated, fake.deleted = db.tasks, nil, nil, nil
+	db.updateAggregationTasks()
+	if len(fake.created)+len(fake.updated)+len(fake.deleted) != 0 {
+		t.Errorf("Unchanged tasks were modified: %v %v %v", fake.created, fake.updated, fake.deleted)
+	}
+
+	// The reconciliation of a replaced database does not restore the tasks of its configuration
+	replaced := newTasksTest(fake, flops)
+	replaced.aggregations = db.aggregations
+	replaced.reconciliation = db.aggregations.nextReconciliation()
+	db.reconciliation = db.aggregations.nextReconciliation()
+	replaced.updateAggregationTasks()
+	if len(fake.deleted) != 0 {
+		t.Errorf("Outdated reconciliation deleted tasks %v", fake.deleted)
+	}
+}
+
+// Tests if the last run and its error are read from the runs and run logs
+func TestAggregationStatus(t *testing.T) {
+	flops := conf.MetricConfig{GUID: "1", Measurement: "flops_any", AvailableAggFns: []string{"sum", "max"}}
+	at := func(minute int) *time.Time {
+		t := time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC)
+		return &t
+	}
+	status := func(s domain.RunStatus) *domain.RunStatus { return &s }
+	message := func(s string) *string { return &s }
+	fake := &fakeTasksAPI{
+		tasks: []domain.Task{{Id: "1", Name: "bucket_flops_any_sum", Flux: taskFlux("bucket_flops_any_sum", `from(bucket: "bucket") |> to(bucket: "bucket", org: "org")`), Description: message(aggregationTaskDescription), LatestCompleted: at(2)}},
+		runs: []domain.Run{
+			{ScheduledFor: at(1), StartedAt: at(1), Status: status(domain.RunStatusSuccess)},
+			{ScheduledFor: at(2), StartedAt: at(2), Status: status(domain.RunStatusFailed)},
+			{ScheduledFor: at(3), Status: status(domain.RunStatusStarted)},
+		},
+		logs: []domain.LogEvent{
+			{Message: message("Started task from script")},
+			{Message: message("error exhausting result iterator: bucket not found")},
+			{Message: message("Completed(failed)")},
+		},
+	}
+	db := newTasksTest(fake, flops)
+	db.queryRunner = queryRunner{scheduler: newScheduler(2)}
+
+	result, err := db.GetAggregationStatus(utils.WithUser(context.Background(), "admin"))
+	if err != nil {
+		t.Fatalf("Could not get status: %v", err)
+	}
+	if len(result) != 2 || result[0].Name != "bucket_flops_any_max" || result[0].Status != "missing" {
+		t.Fatalf("Wrong status %+v", result)
+	}
+	sum := result[1]
+	if sum.Status != "active" || !sum.LastRun.Equal(*at(2)) || sum.LastRunStatus != "failed" ||
+		sum.LastError != "error exhausting result iterator: bucket not found" || sum.Measurement != "flops_any_sum" {
+		t.Fatalf("Wrong status %+v", sum)
+	}
+}
+
+// Tests if backfills run in aligned windows and report their progress and errors
+func TestBackfills(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stop := start.Add(48 * time.Hour)
+	windows := backfillWindows(start, stop, backfillWindow)
+	if len(windows) != 3 || !windows[0][1].Equal(start.Add(12*time.Hour)) || !windows[2][1].Equal(stop) {
+		t.Fatalf("Wrong windows %v", windows)
+	}
+	if windows := backfillWindows(start, stop, 0); len(windows) != 1 {
+		t.Fatalf("Wrong windows %v", windows)
+	}
+
+	done := make(chan bool)
+	called := map[string]int{}
+	b := newAggregationState(func(ctx context.Context, name string, from time.Time, to time.Time) error {
+		called[name]++
+		if name == "b" && called[name] == 2 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	b.addCreated([]string{"a", "c", "d"})
+	b.run([]string{"a", "b"}, start, stop, backfillWindow)
+	go func() {
+		for !b.get("b").Finished {
+			time.Sleep(time.Millisecond)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Backfill did not finish")
+	}
+	if a := b.get("a"); !a.Finished || a.Error != "" || !a.Done.Equal(stop) || called["a"] != 3 {
+		t.Errorf("Wrong backfill %+v", a)
+	}
+	if s := b.get("b"); s.Error != "failed" || !s.Done.Equal(windows[0][1]) {
+		t.Errorf("Wrong failed backfill %+v", s)
+	}
+	if b.get("c") != nil {
+		t.Errorf("Wrong backfills")
+	}
+	// Created aggregations are backfilled once
+	if pending := b.pending(func(name string) bool { return name != "d" }); strings.Join(pending, ",") != "c" {
+		t.Errorf("Wrong pending backfills %v", pending)
+	}
+}
@@ -1,41 +1,76 @@
 package db
 
 import (
+	"context"
 	conf "jobmon/config"
 	"jobmon/job"
 	"time"
 )
 
 // DB is the interface that wraps a list of methods used for setting up, closing and
-// working with an InfluxDB.
+// working with an InfluxDB. Queries are canceled when their context ctx is done, e.g. because
+// the client of the request disconnected, and after the configured QueryTimeout.
 type DB interface {
 
-	// Init initializes a InfluxDB connection based on the configuration c.
-	Init(c conf.Configuration)
+	// Init initializes a InfluxDB connection based on the configuration c. It returns an error
+	// if the configuration is incomplete or the database cannot be reached.
+	Init(c conf.Configuration) error
 
 	// Close shuts down the connection to the InfluxDB.
 	Close()
 
 	// GetJobData returns data for job executed on nodes for sampleInterval, if raw is true then
 	// result data contains the raw metric data.
-	GetJobData(job *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error)
+	GetJobData(ctx context.Context, job *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error)
 
-	// GetJobMetadataMetrics returns the metadata metrics data for job j.
-	GetJobMetadataMetrics(job *job.JobMetadata) (data []job.JobMetadataData, err error)
+	// GetJobMetadataMetrics returns the metadata metrics data for job j and sets the results
+	// of the job analyzers in j.
+	GetJobMetadataMetrics(ctx context.Context, job *job.JobMetadata) (data []job.JobMetadataData, err error)
+
+	// GetJobPhases splits job j into phases using the change point detection configured by c.
+	GetJobPhases(ctx context.Context, j *job.JobMetadata, c conf.PhaseDetectionConfig) (phases job.JobPhases, err error)
 
 	// GetAggregatedJobData similar to GetJobData except that it returns the data for single node jobs.
 	// Single node jobs also return aggregated data for metrics with metric granularity finer than per node.
-	GetAggregatedJobData(job *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error)
+	GetAggregatedJobData(ctx context.Context, job *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error)
 
 	// GetMetricDataWithAggFn returns the the metric-data data for job j based on the configuration m
 	// and aggregated by function aggFn.
-	GetMetricDataWithAggFn(j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error)
+	GetMetricDataWithAggFn(ctx context.Context, j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error)
+
+	// ValidateMetrics checks that the measurements of metrics exist and that their FilterFunc
+	// and PostQueryOp are valid in the query language of the database, e.g. Flux.
+	// Fields of the returned errors refer to the index in metrics.
+	ValidateMetrics(ctx context.Context, metrics []conf.MetricConfig) conf.ValidationErrors
+
+	// DiscoverMeasurements inspects the measurements that received data during the last lookback.
+	DiscoverMeasurements(ctx context.Context, lookback time.Duration) ([]MeasurementInfo, error)
 
 	// RunAggregation runs the aggregation for node data in the db.
 	RunAggregation()
 
+	// GetAggregationStatus returns the status of the aggregations of node data of all metrics
+	// and aggregation functions, e.g. the last run and error of the InfluxDB tasks.
+	GetAggregationStatus(ctx context.Context) ([]AggregationStatus, error)
+
+	// BackfillAggregations starts to aggregate the node data between start and stop in the background
+	// for the aggregations with the given names, or for the aggregations created since the backend
+	// started and not backfilled yet if names is empty. The progress is part of the aggregation status.
+	BackfillAggregations(start time.Time, stop time.Time, names []string) error
+
 	// CreateLiveMonitoringChannel creates a channel which periodically returns
 	// the latest metric data for the given job. Also it returns a channel
 	// which can be used to send a close signal.
 	CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.MetricData, chan bool)
 }
+
+// New returns the DB implementation for the database type configured in c. It must be initialized with Init.
+func New(c conf.Configuration) DB {
+	switch c.DBType {
+	case "influxdb1":
+		return &InfluxQL{}
+	case "timescaledb":
+		return &TimescaleDB{}
+	}
+	return &InfluxDB{}
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"fmt"
+	conf "jobmon/config"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Tags written by the metric collector that identify the origin of a data point
+// and are therefore no candidates for the separation key of node metrics
+var collectorTags = []string{"hostname", "type", "type-id", "cluster"}
+
+// MeasurementInfo describes a measurement found in the metrics database.
+type MeasurementInfo struct {
+	Measurement string
+	// Tag keys of the measurement
+	Tags []string
+	// Values of the "type" tag, e.g. "node", "socket" or "accelerator"
+	Types []string
+	// Fields of the measurement
+	Fields []string
+	// Nodes that sent data for the measurement
+	Hostnames []string
+	// Median interval between two data points of a series, e.g. "30s"; empty if unknown
+	SampleInterval string
+	// Time of the latest data point; zero if no data was sent during the inspected time range
+	LastSeen time.Time
+}
+
+// ProposedMetric is a metric configuration proposed for a measurement found in the metrics database.
+type ProposedMetric struct {
+	Config conf.MetricConfig
+	// Partitions whose nodes sent data for the measurement
+	Partitions []string
+}
+
+// MetricDiscovery is the result of inspecting the metrics database.
+type MetricDiscovery struct {
+	Measurements []MeasurementInfo
+	// Metric configurations for measurements that are not configured yet
+	Proposed []ProposedMetric
+	// GUIDs of configured metrics whose measurement did not receive data during the inspected time range;
+	// derived metrics are not included
+	StaleMetrics []string
+}
+
+// ProposeMetrics proposes metric configurations for the measurements that are not configured in c
+// and finds the configured metrics without data. partitionNodes maps partitions to the nodes
+// their jobs ran on and is used to propose the partitions of a metric.
+func ProposeMetrics(
+	measurements []MeasurementInfo,
+	c conf.Configuration,
+	partitionNodes map[string][]string,
+) (discovery MetricDiscovery) {
+
+	discovery.Measurements = measurements
+	discovery.Proposed = []ProposedMetric{}
+	discovery.StaleMetrics = []string{}
+
+	byName := make(map[string]MeasurementInfo)
+	for _, m := range measurements {
+		byName[m.Measurement] = m
+	}
+	configured := make(map[string]bool)
+	for _, mc := range c.Metrics {
+		configured[mc.Measurement+"|"+mc.Type] = true
+		// Derived metrics are computed from other metrics and have no measurement in the database
+		if mc.IsDerived() {
+			continue
+		}
+		if m, ok := byName[mc.Measurement]; !ok || m.LastSeen.IsZero() {
+			discovery.StaleMetrics = append(discovery.StaleMetrics, mc.GUID)
+		}
+	}
+
+	for _, m := range measurements {
+		if m.LastSeen.IsZero() || isAggregatedMeasurement(m.Measurement, byName) {
+			continue
+		}
+
+		types := m.Types
+		if len(types) == 0 {
+			types = []string{"node"}
+		}
+		fields := m.Fields
+		if len(fields) <= 1 {
+			fields = []string{""}
+		}
+		partitions := partitionsOfNodes(m.Hostnames, partitionNodes)
+
+		for _, metricType := range types {
+			if configured[m.Measurement+"|"+metricType] {
+				continue
+			}
+			for _, field := range fields {
+				mc := conf.MetricConfig{
+					Type:            metricType,
+					Categories:      []string{},
+					Measurement:     m.Measurement,
+					AggFn:           "mean",
+					AvailableAggFns: slices.Clone(conf.AggFns),
+					DisplayName:     m.Measurement,
+					SeparationKey:   separationKey(m, metricType),
+				}
+				if m.SampleInterval != "" && m.SampleInterval != c.SampleInterval {
+					mc.SampleInterval = m.SampleInterval
+				}
+				if len(types) > 1 {
+					mc.DisplayName += " (" + metricType + ")"
+				}
+				if field != "" {
+					mc.FilterFunc = fmt.Sprintf(`|> filter(fn: (r) => r["_field"] == "%s")`, field)
+					mc.DisplayName += " " + field
+				}
+				discovery.Proposed = append(discovery.Proposed, ProposedMetric{Config: mc, Partitions: partitions})
+			}
+		}
+	}
+	return
+}
+
+// isAggregatedMeasurement returns whether measurement is written by an aggregation task
+// for another measurement in measurements, e.g. "mem_bw_sum" for "mem_bw".
+func isAggregatedMeasurement(measurement string, measurements map[string]MeasurementInfo) bool {
+	for _, aggFn := range conf.AggFns {
+		if base, ok := strings.CutSuffix(measurement, "_"+aggFn); ok {
+			if _, ok := measurements[base]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// separationKey proposes the tag that separates the series of a node for metrics of type metricType.
+func separationKey(m MeasurementInfo, metricType string) string {
+	if metricType != "node" && slices.Contains(m.Tags, "type-id") {
+		return "type-id"
+	}
+	for _, tag := range m.Tags {
+		if !strings.HasPrefix(tag, "_") && !slices.Contains(collectorTags, tag) {
+			return tag
+		}
+	}
+	return "hostname"
+}
+
+// partitionsOfNodes returns the sorted partitions that contain at least one of hostnames.
+func partitionsOfNodes(hostnames []string, partitionNodes map[string][]string) []string {
+	partitions := []string{}
+	for partition, nodes := range partitionNodes {
+		for _, node := range nodes {
+			if slices.Contains(hostnames, node) {
+				partitions = append(partitions, partition)
+				break
+			}
+		}
+	}
+	sort.Strings(partitions)
+	return partitions
+}
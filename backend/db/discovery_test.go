@@ -0,0 +1,61 @@
+package db
+
+import (
+	conf "jobmon/config"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Tests if metrics are proposed for unconfigured measurements only and configured metrics without data are flagged
+func TestProposeMetrics(t *testing.T) {
+	now := time.Now()
+	measurements := []MeasurementInfo{
+		{
+			Measurement: "mem_bw", Tags: []string{"hostname", "type", "type-id"},
+			Types: []string{"socket"}, Fields: []string{"value"}, Hostnames: []string{"n1"},
+			SampleInterval: "30s", LastSeen: now,
+		},
+		{
+			Measurement: "mem_bw_sum", Tags: []string{"hostname"},
+			Fields: []string{"mem_bw_sum"}, Hostnames: []string{"n1"}, LastSeen: now,
+		},
+		{
+			Measurement: "flops_any", Tags: []string{"hostname", "type", "type-id"},
+			Types: []string{"cpu", "socket"}, Fields: []string{"value"}, Hostnames: []string{"n2"},
+			SampleInterval: "1m0s", LastSeen: now,
+		},
+		{
+			Measurement: "nfs_read", Tags: []string{"filesystem", "hostname"},
+			Fields: []string{"value"}, Hostnames: []string{"n1", "n2"},
+			SampleInterval: "30s", LastSeen: now,
+		},
+	}
+	c := conf.Configuration{
+		SampleInterval: "30s",
+		Metrics: []conf.MetricConfig{
+			{GUID: "bw", Measurement: "mem_bw", Type: "socket"},
+			{GUID: "ib", Measurement: "ib_recv", Type: "node"},
+		},
+	}
+	partitionNodes := map[string][]string{"cpuonly": {"n1"}, "accelerated": {"n2", "n3"}}
+
+	discovery := ProposeMetrics(measurements, c, partitionNodes)
+
+	if !slices.Equal(discovery.StaleMetrics, []string{"ib"}) {
+		t.Fatalf("Wrong stale metrics: %v", discovery.StaleMetrics)
+	}
+	if len(discovery.Proposed) != 3 {
+		t.Fatalf("Expected 3 proposed metrics, got %+v", discovery.Proposed)
+	}
+	cpu, socket, nfs := discovery.Proposed[0], discovery.Proposed[1], discovery.Proposed[2]
+	if cpu.Config.Type != "cpu" || cpu.Config.SeparationKey != "type-id" || cpu.Config.SampleInterval != "1m0s" ||
+		socket.Config.Type != "socket" || !slices.Equal(cpu.Partitions, []string{"accelerated"}) {
+		t.Fatalf("Wrong proposals for flops_any: %+v, %+v", cpu, socket)
+	}
+	if nfs.Config.Type != "node" || nfs.Config.SeparationKey != "filesystem" || nfs.Config.SampleInterval != "" ||
+		!slices.Equal(nfs.Partitions, []string{"accelerated", "cpuonly"}) {
+		t.Fatalf("Wrong proposal for nfs_read: %+v", nfs)
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fluxIdentifier matches valid Flux identifiers, e.g. names of aggregation functions
+var fluxIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fluxString returns s as Flux string literal.
+func fluxString(s string) string {
+	sb := new(strings.Builder)
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '$':
+			// Prevent string interpolation of "${...}"
+			if i+1 < len(s) && s[i+1] == '{' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// fluxStrings returns values as Flux array of string literals.
+func fluxStrings(values []string) string {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = fluxString(v)
+	}
+	return "[" + strings.Join(literals, ", ") + "]"
+}
+
+// fluxRegexp returns a Flux regular expression literal matching exactly one of values.
+func fluxRegexp(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strings.ReplaceAll(regexp.QuoteMeta(v), "/", `\/`)
+	}
+	return "/^(" + strings.Join(quoted, "|") + ")$/"
+}
+
+// fluxDuration returns d as Flux duration literal in the largest unit that represents d exactly.
+func fluxDuration(d time.Duration) string {
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+		{time.Millisecond, "ms"},
+		{time.Microsecond, "us"},
+	} {
+		if d%u.unit == 0 {
+			return fmt.Sprintf("%d%s", d/u.unit, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dns", d)
+}
+
+// fluxRange holds the arguments of a Flux range call.
+type fluxRange struct {
+	start, stop string
+}
+
+// unixRange returns the range between the Unix times start and stop.
+func unixRange(start, stop int) fluxRange {
+	return fluxRange{start: strconv.Itoa(start), stop: strconv.Itoa(stop)}
+}
+
+// relativeRange returns the range of the last duration d.
+func relativeRange(d time.Duration) fluxRange {
+	return fluxRange{start: "-" + fluxDuration(d)}
+}
+
+// taskRange is the range of the last run interval of an InfluxDB task.
+var taskRange = fluxRange{start: "-task.every"}
+
+// String returns the arguments of the range call, e.g. "start: 0, stop: 10".
+func (r fluxRange) String() string {
+	if r.stop == "" {
+		return "start: " + r.start
+	}
+	return "start: " + r.start + ", stop: " + r.stop
+}
+
+// fluxQuery builds a Flux query by piping a source through a sequence of operations.
+// Tag names, values and other arguments of the operations are escaped. The first invalid
+// argument is reported by Query.
+type fluxQuery struct {
+	sb  strings.Builder
+	err error
+}
+
+// newFluxQuery returns a query starting with the Flux expression source, e.g. a variable.
+func newFluxQuery(source string) *fluxQuery {
+	q := new(fluxQuery)
+	q.sb.WriteString(source)
+	return q
+}
+
+// fluxFrom returns a query reading bucket.
+func fluxFrom(bucket string) *fluxQuery {
+	return newFluxQuery("from(bucket: " + fluxString(bucket) + ")")
+}
+
+// Query returns the Flux query or the error of the first invalid argument.
+func (q *fluxQuery) Query() (string, error) {
+	if q.err != nil {
+		return "", q.err
+	}
+	return q.sb.String(), nil
+}
+
+// pipe appends the operation op with arguments args formatted by format.
+func (q *fluxQuery) pipe(format string, args ...any) *fluxQuery {
+	q.sb.WriteString("|> ")
+	fmt.Fprintf(&q.sb, format, args...)
+	return q
+}
+
+// Pipe appends the Flux operations ops unchanged, e.g. the FilterFunc of a metric configuration.
+// ops must be trusted and start with "|>".
+func (q *fluxQuery) Pipe(ops string) *fluxQuery {
+	q.sb.WriteString(ops)
+	return q
+}
+
+// Range restricts the query to the time range r.
+func (q *fluxQuery) Range(r fluxRange) *fluxQuery {
+	return q.pipe("range(%s)", r)
+}
+
+// Filter keeps the rows where tag has value.
+func (q *fluxQuery) Filter(tag string, value string) *fluxQuery {
+	return q.pipe("filter(fn: (r) => r[%s] == %s)", fluxString(tag), fluxString(value))
+}
+
+// FilterSet keeps the rows where tag has one of values.
+func (q *fluxQuery) FilterSet(tag string, values []string) *fluxQuery {
+	if len(values) == 1 {
+		return q.Filter(tag, values[0])
+	}
+	return q.pipe("filter(fn: (r) => r[%s] =~ %s)", fluxString(tag), fluxRegexp(values))
+}
+
+// AggregateWindow aggregates windows of duration every with the function named fn, e.g. "mean".
+func (q *fluxQuery) AggregateWindow(every time.Duration, fn string) *fluxQuery {
+	if !fluxIdentifier.MatchString(fn) && q.err == nil {
+		q.err = fmt.Errorf("invalid aggregation function '%s'", fn)
+	}
+	return q.pipe("aggregateWindow(every: %s, fn: %s, createEmpty: false)", fluxDuration(every), fn)
+}
+
+// AggregateWindowQuantile aggregates windows of duration every to the quantile quantile, e.g. "0.25".
+// See: https://docs.influxdata.com/flux/v0.x/stdlib/universe/quantile/
+func (q *fluxQuery) AggregateWindowQuantile(every time.Duration, quantile string) *fluxQuery {
+	v, err := strconv.ParseFloat(quantile, 64)
+	if (err != nil || v < 0 || v > 1) && q.err == nil {
+		q.err = fmt.Errorf("invalid quantile '%s'", quantile)
+	}
+	// The q parameter of quantile requires a float literal
+	literal := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(literal, ".") {
+		literal += ".0"
+	}
+	return q.pipe(
+		`aggregateWindow(every: %s, fn: (tables=<-, column) => tables |> quantile(column: "_value", q: %s, method: "estimate_tdigest", compression: 1000.0), createEmpty: false)`,
+		fluxDuration(every), literal)
+}
+
+// TruncateTime truncates the times to unit.
+func (q *fluxQuery) TruncateTime(unit time.Duration) *fluxQuery {
+	return q.pipe("truncateTimeColumn(unit: %s)", fluxDuration(unit))
+}
+
+// Group groups the rows by columns; all rows are in one table if no columns are given.
+func (q *fluxQuery) Group(columns ...string) *fluxQuery {
+	if len(columns) == 0 {
+		return q.pipe("group()")
+	}
+	return q.pipe("group(columns: %s)", fluxStrings(columns))
+}
+
+// Keep drops all other columns than columns.
+func (q *fluxQuery) Keep(columns ...string) *fluxQuery {
+	return q.pipe("keep(columns: %s)", fluxStrings(columns))
+}
+
+// Set sets the column key to value in all rows.
+func (q *fluxQuery) Set(key string, value string) *fluxQuery {
+	return q.pipe("set(key: %s, value: %s)", fluxString(key), fluxString(value))
+}
+
+// To writes the rows to bucket of organization org.
+func (q *fluxQuery) To(bucket string, org string) *fluxQuery {
+	return q.pipe("to(bucket: %s, org: %s)", fluxString(bucket), fluxString(org))
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"flag"
+	conf "jobmon/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Run "go test ./db -update" to rewrite the golden files after intended changes of the queries
+var update = flag.Bool("update", false, "update golden files")
+
+// Tests if literals are escaped
+func TestFluxEscaping(t *testing.T) {
+	for input, expected := range map[string]string{
+		`mem_bw`:                `"mem_bw"`,
+		`a"b\c`:                 `"a\"b\\c"`,
+		"line\nbreak\t":         `"line\nbreak\t"`,
+		`${secrets.get(k: "")}`: `"\${secrets.get(k: \"\")}"`,
+		`costs $5`:              `"costs $5"`,
+	} {
+		if s := fluxString(input); s != expected {
+			t.Errorf("Wrong literal of %s: %s instead of %s", input, s, expected)
+		}
+	}
+	if r := fluxRegexp([]string{"n1", "n.2", "a/b)"}); r != `/^(n1|n\.2|a\/b\))$/` {
+		t.Errorf("Wrong regular expression %s", r)
+	}
+	for d, expected := range map[time.Duration]string{
+		30 * time.Second:        "30s",
+		time.Minute:             "1m",
+		90 * time.Second:        "90s",
+		1500 * time.Millisecond: "1500ms",
+		24 * time.Hour:          "24h",
+	} {
+		if s := fluxDuration(d); s != expected {
+			t.Errorf("Wrong duration of %v: %s instead of %s", d, s, expected)
+		}
+	}
+	if _, err := newFluxQuery("data").AggregateWindow(time.Minute, "mean) |> drop(").Query(); err == nil {
+		t.Errorf("Invalid aggregation function was accepted")
+	}
+	if _, err := newFluxQuery("data").AggregateWindowQuantile(time.Minute, "1e9").Query(); err == nil {
+		t.Errorf("Invalid quantile was accepted")
+	}
+}
+
+// Tests the queries against the golden files in testdata/flux
+func TestFluxQueries(t *testing.T) {
+	metric := conf.MetricConfig{
+		GUID: "1", Measurement: "flops_any", Type: "cpu", AggFn: "sum",
+		FilterFunc:  `|> filter(fn: (r) => r["cluster"] == "a")`,
+		PostQueryOp: `|> map(fn: (r) => ({r with _value: r._value / 1000.0}))`,
+	}
+	derived := conf.MetricConfig{GUID: "2", Measurement: "intensity", Type: "node", Expression: "flops_any / mem_bw"}
+	components := map[string]conf.MetricConfig{
+		"flops_any": metric,
+		"mem_bw":    {Measurement: "mem_bw", Type: "socket"},
+	}
+
+	queries := map[string]func() (string, error){
+		"simple": func() (string, error) {
+			return createSimpleMeasurementQuery("bucket", 1000, 2000, "flops_any", "cpu", "n1|n2",
+				30*time.Second, metric.FilterFunc, metric.PostQueryOp, "")
+		},
+		"simple_escaped": func() (string, error) {
+			return createSimpleMeasurementQuery(`my"bucket`, 1000, 2000, `flops") or true or ("`, "", `n1.x|n2/(y)`,
+				time.Minute, "", "", "")
+		},
+		"aggregate": func() (string, error) {
+			return createAggregateMeasurementQuery("bucket", 1000, 2000, "flops_any_sum", "n1",
+				30*time.Second, metric.FilterFunc, "")
+		},
+		"quantile": func() (string, error) {
+			return createQuantileMeasurementQuery("bucket", 1000, 2000, "flops_any_sum", "n1|n2",
+				time.Minute, "", "", []string{"0", "0.25", "0.5", "1"}, "")
+		},
+		"metadata": func() (string, error) {
+			return createMetadataMeasurementsQuery(createMeasurementSource("bucket", unixRange(1000, 2000), "flops_any_sum", ""),
+				"n1|n2", metric.FilterFunc, "")
+		},
+		"derived": func() (string, error) {
+			return createDerivedMeasurementSource("bucket", unixRange(1000, 2000), derived, components, "n1|n2", 30*time.Second)
+		},
+		"task": func() (string, error) {
+			return createAggregationTaskQuery(createMeasurementSource("bucket", taskRange, metric.Measurement, metric.Type),
+				metric, "sum", 30*time.Second, "bucket", "org")
+		},
+	}
+	for name, query := range queries {
+		q, err := query()
+		if err != nil {
+			t.Errorf("Could not create %s query: %v", name, err)
+			continue
+		}
+		file := filepath.Join("testdata", "flux", name+".flux")
+		if *update {
+			if err := os.WriteFile(file, []byte(q), 0644); err != nil {
+				t.Fatalf("Could not write golden file: %v", err)
+			}
+			continue
+		}
+		golden, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Could not read golden file: %v", err)
+		}
+		if q != string(golden) {
+			t.Errorf("Query %s differs from %s:\n%s", name, file, q)
+		}
+	}
+
+	if _, err := createSimpleMeasurementQuery("bucket", 2000, 1000, "flops_any", "", "n1", time.Minute, "", "", ""); err == nil {
+		t.Errorf("Invalid time range was accepted")
+	}
+}
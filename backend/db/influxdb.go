@@ -8,6 +8,7 @@ import (
 	"jobmon/job"
 	"jobmon/logging"
 	"jobmon/utils"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,7 +30,14 @@ type InfluxDB struct {
 	// API to managing Organizations in a InfluxDB server
 	organizationsAPI api.OrganizationsAPI
 
-	tasks                 []domain.Task
+	// Aggregation tasks of the metrics, updated by updateAggregationTasks
+	tasks     []domain.Task
+	tasksLock sync.Mutex
+	// Created aggregation tasks and their backfills; shared with replaced databases, see useAggregationState
+	aggregations *aggregationState
+	// Number of the reconciliation of the aggregation tasks started by Init
+	reconciliation int
+
 	organization          *domain.Organization
 	organizationName      string
 	bucket                *domain.Bucket
@@ -38,40 +46,52 @@ type InfluxDB struct {
 	partitionConfig       map[string]conf.PartitionConfig
 	defaultSampleInterval string
 	metricQuantiles       []string
+	// Configuration passed to the job analyzers
+	analysisConfig conf.Configuration
+	// Schedules the queries and limits their duration
+	queryRunner
 }
 
 // Init implements Init method of DB interface.
-func (db *InfluxDB) Init(c conf.Configuration) {
+func (db *InfluxDB) Init(c conf.Configuration) (err error) {
 
 	// Check if DBHost, and DBToken are set
 	if c.DBHost == "" {
-		logging.Fatal("db: Init(): No Influxdb host set")
+		return fmt.Errorf("no Influxdb host set")
 	}
 	if c.DBToken == "" {
-		logging.Fatal("db: Init(): No Influxdb token set")
+		return fmt.Errorf("no Influxdb token set")
+	}
+	if c.DBOrg == "" {
+		return fmt.Errorf("no Influxdb org set")
+	}
+	if c.DBBucket == "" {
+		return fmt.Errorf("no Influxdb bucket set")
 	}
 
 	// Connect to InfluxDB
 	db.client = influxdb2.NewClient(c.DBHost, c.DBToken)
+	defer func() {
+		if err != nil {
+			db.client.Close()
+		}
+	}()
 	// validate client connection and health
 	if ok, err := db.client.Ping(context.Background()); !ok || err != nil {
-		logging.Fatal("db: Init(): Could not reach influxdb: ", err)
+		return fmt.Errorf("could not reach influxdb: %v", err)
 	}
 	if _, err := db.client.Health(context.Background()); err != nil {
-		logging.Fatal("db: Init(): influxdb health check failed: ", err)
+		return fmt.Errorf("influxdb health check failed: %w", err)
 	}
 	logging.Info("db: Init(): Connected to ", c.DBHost)
 
 	// API to managing Organizations in a InfluxDB server
 	db.organizationsAPI = db.client.OrganizationsAPI()
-	if c.DBOrg == "" {
-		logging.Fatal("db: Init(): No Influxdb org set")
-	}
 	o, err :=
 		db.organizationsAPI.
 			FindOrganizationByName(context.Background(), c.DBOrg)
 	if err != nil {
-		logging.Fatal("db: Init(): Could not get organization from influxdb: ", err)
+		return fmt.Errorf("could not get organization from influxdb: %w", err)
 	}
 	db.organization = o
 	db.organizationName = o.Name
@@ -86,12 +106,9 @@ func (db *InfluxDB) Init(c conf.Configuration) {
 	logging.Info("db: Init(): Initialized task API")
 
 	// Bucket
-	if c.DBBucket == "" {
-		logging.Fatal("db: Init(): No Influxdb bucket set")
-	}
 	b, err := db.client.BucketsAPI().FindBucketByName(context.Background(), c.DBBucket)
 	if err != nil {
-		logging.Fatal("db: Init(): Could not get bucket from influxdb: ", err)
+		return fmt.Errorf("could not get bucket from influxdb: %w", err)
 	}
 	db.bucket = b
 	db.bucketName = b.Name
@@ -106,7 +123,14 @@ func (db *InfluxDB) Init(c conf.Configuration) {
 	db.partitionConfig = c.Partitions
 	db.defaultSampleInterval = c.SampleInterval
 	db.metricQuantiles = c.MetricQuantiles
+	db.analysisConfig = c
+	db.queryRunner.init(c)
+	if db.aggregations == nil {
+		db.aggregations = newAggregationState(db.backfill)
+	}
+	db.reconciliation = db.aggregations.nextReconciliation()
 	go db.updateAggregationTasks()
+	return nil
 }
 
 // Close implements Close method of DB interface.
@@ -117,6 +141,7 @@ func (db *InfluxDB) Close() {
 // GetJobData is just a wrapper for getJobData that initializes the nodes parameter
 // in case it was not specified.
 func (db *InfluxDB) GetJobData(
+	ctx context.Context,
 	j *job.JobMetadata,
 	nodes string,
 	sampleInterval time.Duration,
@@ -129,12 +154,13 @@ func (db *InfluxDB) GetJobData(
 	if nodes == "" {
 		nodes = j.NodeList
 	}
-	return db.getJobData(j, nodes, sampleInterval, raw, false)
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, false)
 }
 
 // GetAggregatedJobData similar to GetJobData except that it returns the data for single node jobs.
 // Single node jobs also return aggregated data for metrics with metric granularity finer than per node.
 func (db *InfluxDB) GetAggregatedJobData(
+	ctx context.Context,
 	j *job.JobMetadata,
 	nodes string,
 	sampleInterval time.Duration,
@@ -148,11 +174,12 @@ func (db *InfluxDB) GetAggregatedJobData(
 		nodes = j.NodeList
 	}
 	forceAggregate := true
-	return db.getJobData(j, nodes, sampleInterval, raw, forceAggregate)
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, forceAggregate)
 }
 
-// GetJobMetadataMetrics returns the metadata metrics data for job j.
-func (db *InfluxDB) GetJobMetadataMetrics(j *job.JobMetadata) (data []job.JobMetadataData, err error) {
+// GetJobMetadataMetrics returns the metadata metrics data for job j and sets the results
+// of the job analyzers in j.
+func (db *InfluxDB) GetJobMetadataMetrics(ctx context.Context, j *job.JobMetadata) (data []job.JobMetadataData, err error) {
 	// Skip jobs that are still running
 	if j.IsRunning {
 		return data, fmt.Errorf("job is still running")
@@ -164,68 +191,182 @@ func (db *InfluxDB) GetJobMetadataMetrics(j *job.JobMetadata) (data []job.JobMet
 	}
 
 	// Computes mean and max values for each metric
-	data, err = db.getMetadataData(j)
+	data, err = db.getMetadataData(ctx, j)
 	if err != nil {
 		return data, err
 	}
 
-	s, err := time.ParseDuration(db.defaultSampleInterval)
+	aggData, err := db.getMetadataJobData(ctx, j)
 	if err != nil {
 		return data, err
 	}
-	_, interval := j.CalculateSampleIntervals(s)
 
-	// Get aggregated metrics
-	raw := false
-	forceAggregate := true
-	aggData, err := db.getJobData(j, j.NodeList, interval, raw, forceAggregate)
+	// Run the analyzers enabled for the partition; they store their results in j
+	j.Data = data
+	j.Phases = nil
+	aggData.Metadata = j
+	analyzers := analysis.Enabled(&db.analysisConfig, db.getPartition(j))
+	analysis.Run(ctx, analyzers, &aggData, &db.analysisConfig)
 
-	// Compute change points that split measurements into
-	// "statistically homogeneous" segments
-	cps := analysis.ChangePointDetection(&aggData)
-	for i := range data {
-		data_i := &data[i]
-		data_i.ChangePoints = cps[data_i.Config.Measurement]
-	}
-
-	return
+	return j.Data, nil
 }
 
-// GetMetricDataWithAggFn returns the the metric-data data for job j based on the configuration m
-// and aggregated by function aggFn.
-func (db *InfluxDB) GetMetricDataWithAggFn(j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
-	tempResult, err := db.queryAggregateMeasurement(m, j, j.NodeList, aggFn, sampleInterval)
+// GetJobPhases implements GetJobPhases method of DB interface.
+func (db *InfluxDB) GetJobPhases(ctx context.Context, j *job.JobMetadata, c conf.PhaseDetectionConfig) (phases job.JobPhases, err error) {
+	aggData, err := db.getMetadataJobData(ctx, j)
 	if err != nil {
-		logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not get quantile data: ", err)
 		return
 	}
+	return analysis.Phases(&aggData, c), nil
+}
 
-	result, err := parseQueryResult(tempResult, "hostname")
+// getMetadataJobData returns the data of all nodes of job j with the sample interval used for the metadata.
+func (db *InfluxDB) getMetadataJobData(ctx context.Context, j *job.JobMetadata) (data job.JobData, err error) {
+	s, err := time.ParseDuration(db.defaultSampleInterval)
 	if err != nil {
-		logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not parse quantile data: ", err)
 		return
 	}
-	m.AggFn = aggFn
-	data =
-		job.MetricData{
-			Data:   result,
-			Config: m,
+	_, interval := j.CalculateSampleIntervals(s)
+
+	// Get aggregated metrics
+	raw := false
+	forceAggregate := true
+	return db.getJobData(ctx, j, j.NodeList, interval, raw, forceAggregate)
+}
+
+// GetMetricDataWithAggFn returns the the metric-data data for job j based on the configuration m
+// and aggregated by function aggFn.
+func (db *InfluxDB) GetMetricDataWithAggFn(ctx context.Context, j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
+	errs := db.runQueries(ctx, 1, func(ctx context.Context, _ int) error {
+		tempResult, err := db.queryAggregateMeasurement(ctx, m, j, j.NodeList, aggFn, sampleInterval)
+		if err != nil {
+			logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not get quantile data: ", err)
+			return err
 		}
-	return data, nil
+
+		result, err := parseQueryResult(tempResult, "hostname")
+		if err != nil {
+			logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not parse quantile data: ", err)
+			return err
+		}
+		m.AggFn = aggFn
+		data =
+			job.MetricData{
+				Data:   result,
+				Config: m,
+			}
+		return nil
+	})
+	return data, errs[0]
 }
 
 // RunAggregation runs the aggregation for node data in the db.
 func (db *InfluxDB) RunAggregation() {
-	for _, task := range db.tasks {
+	db.tasksLock.Lock()
+	tasks := db.tasks
+	db.tasksLock.Unlock()
+	for i := range tasks {
 		go func(task *domain.Task) {
 			_, err := db.tasksAPI.RunManually(context.Background(), task)
 			if err != nil {
-				logging.Error("db: RunAggregation(): Failed to run task ", *task.Description, " manually")
+				logging.Error("db: RunAggregation(): Failed to run task ", task.Name, " manually: ", err)
 			}
-		}(&task)
+		}(&tasks[i])
 	}
 }
 
+// GetAggregationStatus implements GetAggregationStatus method of DB interface.
+// The last run and its error are read from the runs and run logs of the aggregation tasks.
+func (db *InfluxDB) GetAggregationStatus(ctx context.Context) (status []AggregationStatus, err error) {
+	existing, err := db.findAggregationTasks(ctx)
+	if err != nil {
+		logging.Error("db: GetAggregationStatus(): Could not get tasks from influxdb: ", err)
+		return
+	}
+	tasks := make(map[string]domain.Task, len(existing))
+	for _, task := range existing {
+		tasks[task.Name] = task
+	}
+
+	status = []AggregationStatus{}
+	for name, t := range db.aggregationTasks() {
+		status = append(status, AggregationStatus{
+			Name:        name,
+			Measurement: t.metric.Measurement + "_" + t.aggFn,
+			Status:      "missing",
+			Backfill:    db.aggregations.get(name),
+		})
+	}
+	sortAggregationStatus(status)
+
+	// The runs of each task are read as one query
+	db.runQueries(ctx, len(status), func(ctx context.Context, i int) error {
+		task, ok := tasks[status[i].Name]
+		if !ok {
+			return nil
+		}
+		status[i].Status = string(domain.TaskStatusTypeActive)
+		if task.Status != nil {
+			status[i].Status = string(*task.Status)
+		}
+		if err := db.lastRun(ctx, task, &status[i]); err != nil {
+			logging.Error("db: GetAggregationStatus(): Could not get runs of task ", task.Name, ": ", err)
+			return err
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return status, nil
+}
+
+// BackfillAggregations implements BackfillAggregations method of DB interface.
+// The query of an aggregation task is run for the historical data in windows of one day.
+func (db *InfluxDB) BackfillAggregations(start time.Time, stop time.Time, names []string) error {
+	tasks := db.aggregationTasks()
+	if len(names) == 0 {
+		names = db.aggregations.pending(func(name string) bool {
+			_, ok := tasks[name]
+			return ok
+		})
+	}
+	for _, name := range names {
+		if _, ok := tasks[name]; !ok {
+			return fmt.Errorf("unknown aggregation task '%s'", name)
+		}
+	}
+	db.aggregations.run(names, start, stop, backfillWindow)
+	return nil
+}
+
+// backfill runs the query of the aggregation task name for the data between start and stop,
+// which writes the aggregated data to the bucket.
+func (db *InfluxDB) backfill(ctx context.Context, name string, start time.Time, stop time.Time) error {
+	task, ok := db.aggregationTasks()[name]
+	if !ok {
+		return fmt.Errorf("unknown aggregation task '%s'", name)
+	}
+	query, err := db.aggregationTaskQuery(task, unixRange(int(start.Unix()), int(stop.Unix())))
+	if err != nil {
+		return err
+	}
+	result, err := db.queryAPI.Query(ctx, query)
+	if err != nil {
+		logging.Error("db: backfill(): Failed to backfill aggregation task ", name, ": ", err)
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+	}
+	return result.Err()
+}
+
+// useAggregationState tracks the created aggregation tasks and their backfills with a.
+func (db *InfluxDB) useAggregationState(a *aggregationState) {
+	db.aggregations = a
+}
+
 // CreateLiveMonitoringChannel creates a channel which periodically returns
 // the latest metric data for the given job. Also it returns a channel
 // which can be used to send a close signal.
@@ -264,7 +405,10 @@ func (db *InfluxDB) CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.
 // If raw is true then the MetricData contained in the result data contains the raw metric data.
 // Nodes should be specified as a list of nodes separated by a '|' character.
 // If no nodes are specified, data for all nodes are queried.
+// The metric and quantile data are in the order of the partition metrics; metrics whose
+// queries failed are skipped. An error is returned if all queries failed.
 func (db *InfluxDB) getJobData(
+	ctx context.Context,
 	j *job.JobMetadata,
 	nodes string,
 	sampleInterval time.Duration,
@@ -274,151 +418,151 @@ func (db *InfluxDB) getJobData(
 	data job.JobData,
 	err error,
 ) {
-	var metricData []job.MetricData
-	var quantileData []job.QuantileData
-	var wg sync.WaitGroup
-	for _, m := range db.getPartition(j).Metrics {
-
-		// Query metric data
-		wg.Add(1)
-		go func(metric conf.MetricConfig) {
-			defer wg.Done()
-			if raw {
-				result, err := db.queryRaw(metric, j, nodes, sampleInterval, forceAggregate)
-				if err != nil {
-					logging.Error("db: getJobData(): Job ", j.Id, ": could not get raw metric data: ", err)
-					return
-				}
-				metricData =
-					append(metricData,
-						job.MetricData{
-							Config:  metric,
-							RawData: result,
-						},
-					)
-			} else {
-				result, err := db.query(metric, j, nodes, sampleInterval, forceAggregate)
-				if err != nil {
-					logging.Error("db: getJobData(): Job ", j.Id, ": could not get metric data: ", err)
-					return
-				}
-				metricData =
-					append(metricData,
-						job.MetricData{
-							Config: metric,
-							Data:   result,
-						},
-					)
+	metrics := db.partitionMetrics(j)
+	metricData := make([]job.MetricData, len(metrics))
+	quantileData := make([]job.QuantileData, len(metrics))
+
+	// Query metric data and, for finished jobs, quantile measurements
+	numQueries := len(metrics)
+	if !j.IsRunning {
+		numQueries *= 2
+	}
+	errs := db.runQueries(ctx, numQueries, func(ctx context.Context, i int) error {
+		if i >= len(metrics) {
+			metric := metrics[i-len(metrics)]
+			tempRes, err := db.queryQuantileMeasurement(ctx, metric, j, db.metricQuantiles, sampleInterval)
+			if err != nil {
+				logging.Error("db: getJobData(): Job ", j.Id, ": could not get quantile data: ", err)
+				return err
 			}
-		}(db.metrics[m])
+			result, err := parseQueryResult(tempRes, "_field")
+			if err != nil {
+				logging.Error("db: getJobData(): Job ", j.Id, ": could not parse quantile data:", err)
+				return err
+			}
+			quantileData[i-len(metrics)] = job.QuantileData{
+				Config:    metric,
+				Data:      result,
+				Quantiles: db.metricQuantiles,
+			}
+			return nil
+		}
 
-		if !j.IsRunning {
-			wg.Add(1)
+		metric := metrics[i]
+		if raw {
+			result, err := db.queryRaw(ctx, metric, j, nodes, sampleInterval, forceAggregate)
+			if err != nil {
+				logging.Error("db: getJobData(): Job ", j.Id, ": could not get raw metric data: ", err)
+				return err
+			}
+			metricData[i] = job.MetricData{
+				Config:  metric,
+				RawData: result,
+			}
+			return nil
+		}
+		result, err := db.query(ctx, metric, j, nodes, sampleInterval, forceAggregate)
+		if err != nil {
+			logging.Error("db: getJobData(): Job ", j.Id, ": could not get metric data: ", err)
+			return err
+		}
+		metricData[i] = job.MetricData{
+			Config: metric,
+			Data:   result,
+		}
+		return nil
+	})
 
-			// Query quantile measurements
-			go func(metric conf.MetricConfig) {
-				defer wg.Done()
-				tempRes, err := db.queryQuantileMeasurement(metric, j, db.metricQuantiles, sampleInterval)
-				if err != nil {
-					logging.Error("db: getJobData(): Job ", j.Id, ": could not get quantile data: ", err)
-					return
-				}
-				result, err := parseQueryResult(tempRes, "_field")
-				if err != nil {
-					logging.Error("db: getJobData(): Job ", j.Id, ": could not parse quantile data:", err)
-					return
-				}
-				quantileData =
-					append(quantileData,
-						job.QuantileData{
-							Config:    metric,
-							Data:      result,
-							Quantiles: db.metricQuantiles,
-						},
-					)
-			}(db.metrics[m])
-		}
-	}
-	wg.Wait()
-
-	// return metric and quantile data
-	data.MetricData = metricData
-	data.QuantileData = quantileData
+	// Incomplete data of canceled requests must not be cached
+	if err = queriesError(ctx, errs); err != nil {
+		return data, err
+	}
+
+	// return metric and quantile data of the successful queries
+	for i := range metrics {
+		if errs[i] == nil {
+			data.MetricData = append(data.MetricData, metricData[i])
+		}
+		if !j.IsRunning && errs[len(metrics)+i] == nil {
+			data.QuantileData = append(data.QuantileData, quantileData[i])
+		}
+	}
 	data.Metadata = j
-	return data, err
+	return data, nil
 }
 
-// getMetadataData computes mean and max values for each metric of a job j.
+// getMetadataData computes mean and max values for each metric of a job j in the order of the partition metrics.
 // The results are stored as data alongside the jobs metadata
-func (db *InfluxDB) getMetadataData(j *job.JobMetadata) (
+func (db *InfluxDB) getMetadataData(ctx context.Context, j *job.JobMetadata) (
 	data []job.JobMetadataData,
 	err error,
 ) {
-	var wg sync.WaitGroup
-	for _, m := range db.getPartition(j).Metrics {
-		wg.Add(1)
-
-		// start a go function for each configured metric
-		go func(m conf.MetricConfig) {
-			defer wg.Done()
-
-			// Query metrics mean and max values
-			tempRes, err := db.queryMetadataMeasurements(m, j)
-			if err != nil {
-				logging.Error("db: getMetadataData(): Job ", j.Id, ": could not get metadata data: ", err)
-				return
-			}
-
-			separationKey := "result"
-			result, err := parseQueryResult(tempRes, separationKey)
-			if err != nil {
-				logging.Error("db: getMetadataData(): Job ", j.Id, ": could not parse metadata data: ", err)
-				return
-			}
+	metrics := db.partitionMetrics(j)
+	metadataData := make([]job.JobMetadataData, len(metrics))
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+
+		// Query metrics mean and max values
+		tempRes, err := db.queryMetadataMeasurements(ctx, m, j)
+		if err != nil {
+			logging.Error("db: getMetadataData(): Job ", j.Id, ": could not get metadata data: ", err)
+			return err
+		}
 
-			// Initialize job metadata with metric config
-			metadataData := job.JobMetadataData{
-				Config: m,
-			}
+		separationKey := "result"
+		result, err := parseQueryResult(tempRes, separationKey)
+		if err != nil {
+			logging.Error("db: getMetadataData(): Job ", j.Id, ": could not parse metadata data: ", err)
+			return err
+		}
 
-			if res, ok := result["_result"]; ok {
-				// Add metrics mean value to job metadata
-				if len(res) >= 1 {
-					mean := res[0]["_value"]
-					switch v := mean.(type) {
-					case float64:
-						metadataData.Mean = v
-					default:
-					}
+		// Initialize job metadata with metric config; values stay zero in the case metadata is missing
+		metadataData[i] = job.JobMetadataData{
+			Config: m,
+		}
+		if res, ok := result["_result"]; ok {
+			// Add metrics mean value to job metadata
+			if len(res) >= 1 {
+				if v, ok := res[0]["_value"].(float64); ok {
+					metadataData[i].Mean = v
 				}
+			}
 
-				// Add metrics max value to job metadata
-				if len(res) >= 2 {
-					max := res[1]["_value"]
-					switch v := max.(type) {
-					case float64:
-						metadataData.Max = v
-					default:
-					}
+			// Add metrics max value to job metadata
+			if len(res) >= 2 {
+				if v, ok := res[1]["_value"].(float64); ok {
+					metadataData[i].Max = v
 				}
-
-				data = append(data, metadataData)
-			} else {
-				// Add zero values in the case metadata is missing.
-				metadataData.Mean = 0.0
-				metadataData.Max = 0.0
-				data = append(data, metadataData)
 			}
-		}(db.metrics[m])
+		}
+		return nil
+	})
+	if err = queriesError(ctx, errs); err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return
+	for i := range metrics {
+		if errs[i] == nil {
+			data = append(data, metadataData[i])
+		}
+	}
+	return data, nil
+}
+
+// partitionMetrics returns the configurations of the metrics of the partition of job j in their configured order.
+func (db *InfluxDB) partitionMetrics(j *job.JobMetadata) []conf.MetricConfig {
+	guids := db.getPartition(j).Metrics
+	metrics := make([]conf.MetricConfig, len(guids))
+	for i, guid := range guids {
+		metrics[i] = db.metrics[guid]
+	}
+	return metrics
 }
 
 // query returns result which is a map with keys being the separation key and values being the table that
 // corresponds to the job j, list of nodes "nodes", within the sample interval
 // sampleInterval for the metric "metric".
 func (db *InfluxDB) query(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	nodes string,
@@ -435,12 +579,12 @@ func (db *InfluxDB) query(
 	separationKey := metric.SeparationKey
 	// If only one node is specified, always return detailed data, never aggregated data
 	if numNodes := strings.Count(nodes, "|") + 1; numNodes == 1 && !forceAggregate {
-		queryResult, err = db.querySimpleMeasurement(metric, j, nodes, sampleInterval)
+		queryResult, err = db.querySimpleMeasurement(ctx, metric, j, nodes, sampleInterval)
 	} else {
 		if metric.Type != "node" {
-			queryResult, err = db.queryAggregateMeasurement(metric, j, nodes, metric.AggFn, sampleInterval)
+			queryResult, err = db.queryAggregateMeasurement(ctx, metric, j, nodes, metric.AggFn, sampleInterval)
 		} else {
-			queryResult, err = db.querySimpleMeasurement(metric, j, nodes, sampleInterval)
+			queryResult, err = db.querySimpleMeasurement(ctx, metric, j, nodes, sampleInterval)
 		}
 		separationKey = "hostname"
 	}
@@ -465,6 +609,7 @@ func (db *InfluxDB) query(
 // queryRaw checks if the metric.Type is "node", if that's the case then it calls the function queryAggregateMeasurementRaw
 // otherwise it calls querySimpleMeasurementRaw.
 func (db *InfluxDB) queryRaw(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	node string,
@@ -475,9 +620,9 @@ func (db *InfluxDB) queryRaw(
 	err error,
 ) {
 	if metric.Type != "node" && forceAggregate {
-		result, err = db.queryAggregateMeasurementRaw(metric, j, node, metric.AggFn, sampleInterval)
+		result, err = db.queryAggregateMeasurementRaw(ctx, metric, j, node, metric.AggFn, sampleInterval)
 	} else {
-		result, err = db.querySimpleMeasurementRaw(metric, j, node, sampleInterval)
+		result, err = db.querySimpleMeasurementRaw(ctx, metric, j, node, sampleInterval)
 	}
 	return result, err
 }
@@ -485,6 +630,7 @@ func (db *InfluxDB) queryRaw(
 // querySimpleMeasurement returns a flux table result corresponding to a simple query based on the
 // given parameters.
 func (db *InfluxDB) querySimpleMeasurement(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	nodes string,
@@ -493,15 +639,24 @@ func (db *InfluxDB) querySimpleMeasurement(
 	result *api.QueryTableResult,
 	err error,
 ) {
-	query := createSimpleMeasurementQuery(
+	source, err := db.derivedSource(metric, db.metricConfigs(), jobRange(j), nodes)
+	if err != nil {
+		return
+	}
+	query, err := createSimpleMeasurementQuery(
 		db.bucketName,
 		j.StartTime, j.StopTime,
 		metric.Measurement, metric.Type,
 		nodes,
 		sampleInterval,
 		metric.FilterFunc, metric.PostQueryOp,
+		source,
 	)
-	result, err = db.queryAPI.Query(context.Background(), query)
+	if err != nil {
+		logging.Error("db: querySimpleMeasurement(): Could not create simple query: ", err)
+		return
+	}
+	result, err = db.queryAPI.Query(ctx, query)
 	if err != nil {
 		logging.Error("db: querySimpleMeasurement(): Error at simple query: '", query, "': ", err)
 	}
@@ -511,6 +666,7 @@ func (db *InfluxDB) querySimpleMeasurement(
 // querySimpleMeasurementRaw is similar to querySimpleMeasurement except that this one returns the table as string,
 // with table annotations according to dialect.
 func (db *InfluxDB) querySimpleMeasurementRaw(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	nodes string,
@@ -519,15 +675,24 @@ func (db *InfluxDB) querySimpleMeasurementRaw(
 	result string,
 	err error,
 ) {
-	query := createSimpleMeasurementQuery(
+	source, err := db.derivedSource(metric, db.metricConfigs(), jobRange(j), nodes)
+	if err != nil {
+		return
+	}
+	query, err := createSimpleMeasurementQuery(
 		db.bucketName,
 		j.StartTime, j.StopTime,
 		metric.Measurement, metric.Type,
 		nodes,
 		sampleInterval,
 		metric.FilterFunc, metric.PostQueryOp,
+		source,
 	)
-	result, err = db.queryAPI.QueryRaw(context.Background(), query, api.DefaultDialect())
+	if err != nil {
+		logging.Error("db: querySimpleMeasurementRaw(): Could not create simple raw query: ", err)
+		return
+	}
+	result, err = db.queryAPI.QueryRaw(ctx, query, api.DefaultDialect())
 	if err != nil {
 		logging.Error("db: querySimpleMeasurementRaw(): Error at simple raw query: '", query, "': ", err)
 	}
@@ -620,6 +785,7 @@ func parseQueryResult(
 // queryAggregateMeasurement is similar to querySimpleMeasurement except that here an aggregation
 // over the metric type is performed.
 func (db *InfluxDB) queryAggregateMeasurement(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	nodes string,
@@ -629,11 +795,15 @@ func (db *InfluxDB) queryAggregateMeasurement(
 	result *api.QueryTableResult,
 	err error,
 ) {
+	// Derived metrics are computed per node, there is nothing to aggregate
+	if metric.IsDerived() {
+		return db.querySimpleMeasurement(ctx, metric, j, nodes, sampleInterval)
+	}
 	measurement := metric.Measurement
 	if aggFn != "" {
 		measurement += "_" + aggFn
 	}
-	query := createAggregateMeasurementQuery(
+	query, err := createAggregateMeasurementQuery(
 		db.bucketName,
 		j.StartTime, j.StopTime,
 		measurement,
@@ -642,7 +812,11 @@ func (db *InfluxDB) queryAggregateMeasurement(
 		metric.FilterFunc,
 		metric.PostQueryOp,
 	)
-	result, err = db.queryAPI.Query(context.Background(), query)
+	if err != nil {
+		logging.Error("db: queryAggregateMeasurement(): Could not create aggregate query: ", err)
+		return
+	}
+	result, err = db.queryAPI.Query(ctx, query)
 	if err != nil {
 		logging.Error("db: queryAggregateMeasurement(): Error at aggregate query '", query, "': ", err)
 	}
@@ -652,6 +826,7 @@ func (db *InfluxDB) queryAggregateMeasurement(
 // queryAggregateMeasurementRaw is similar to querySimpleMeasurementRaw except that here an aggregation
 // over the metric type is performed.
 func (db *InfluxDB) queryAggregateMeasurementRaw(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	nodes string,
@@ -661,11 +836,15 @@ func (db *InfluxDB) queryAggregateMeasurementRaw(
 	result string,
 	err error,
 ) {
+	// Derived metrics are computed per node, there is nothing to aggregate
+	if metric.IsDerived() {
+		return db.querySimpleMeasurementRaw(ctx, metric, j, nodes, sampleInterval)
+	}
 	measurement := metric.Measurement
 	if aggFn != "" {
 		measurement += "_" + aggFn
 	}
-	query := createAggregateMeasurementQuery(
+	query, err := createAggregateMeasurementQuery(
 		db.bucketName,
 		j.StartTime, j.StopTime,
 		measurement,
@@ -674,7 +853,11 @@ func (db *InfluxDB) queryAggregateMeasurementRaw(
 		metric.FilterFunc,
 		metric.PostQueryOp,
 	)
-	result, err = db.queryAPI.QueryRaw(context.Background(), query, api.DefaultDialect())
+	if err != nil {
+		logging.Error("db: queryAggregateMeasurementRaw(): Could not create aggregate raw query: ", err)
+		return
+	}
+	result, err = db.queryAPI.QueryRaw(ctx, query, api.DefaultDialect())
 	if err != nil {
 		logging.Error("db: queryAggregateMeasurementRaw(): Error at aggregate raw query '", query, "': ", err)
 	}
@@ -683,6 +866,7 @@ func (db *InfluxDB) queryAggregateMeasurementRaw(
 
 // queryQuantileMeasurement is similar to querySimpleMeasurement except that here the query is extended with quantiles.
 func (db *InfluxDB) queryQuantileMeasurement(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 	quantiles []string,
@@ -698,14 +882,18 @@ func (db *InfluxDB) queryQuantileMeasurement(
 	// then use their values to compute the quantiles
 	measurement := metric.Measurement
 	filterFunc := metric.FilterFunc
-	if j.NumNodes > 1 {
+	source, err := db.derivedSource(metric, db.metricConfigs(), jobRange(j), j.NodeList)
+	if err != nil {
+		return
+	}
+	if j.NumNodes > 1 && !metric.IsDerived() {
 		if metric.AggFn != "" {
 			measurement += "_" + metric.AggFn
 		}
 		filterFunc = ""
 	}
 
-	query := createQuantileMeasurementQuery(
+	query, err := createQuantileMeasurementQuery(
 		db.bucketName,
 		j.StartTime, j.StopTime,
 		measurement,
@@ -713,9 +901,14 @@ func (db *InfluxDB) queryQuantileMeasurement(
 		sampleInterval,
 		filterFunc,
 		metric.PostQueryOp,
-		quantiles)
+		quantiles,
+		source)
+	if err != nil {
+		logging.Error("db: queryQuantileMeasurement(): Could not create quantile query: ", err)
+		return
+	}
 
-	result, err = db.queryAPI.Query(context.Background(), query)
+	result, err = db.queryAPI.Query(ctx, query)
 	if err != nil {
 		logging.Error("db: queryQuantileMeasurement(): Error at quantile query '", query, "': ", err)
 	}
@@ -728,6 +921,7 @@ func (db *InfluxDB) queryQuantileMeasurement(
 // mean and max values for  metric 'metric' and job j.
 // The results are stored as data alongside the jobs metadata
 func (db *InfluxDB) queryMetadataMeasurements(
+	ctx context.Context,
 	metric conf.MetricConfig,
 	j *job.JobMetadata,
 ) (
@@ -736,31 +930,33 @@ func (db *InfluxDB) queryMetadataMeasurements(
 ) {
 	// measurement name for the metric
 	measurement := metric.Measurement
-	if metric.AggFn != "" {
+	if metric.AggFn != "" && !metric.IsDerived() {
 		measurement += "_" + metric.AggFn
 	}
+	derived, err := db.derivedSource(metric, db.metricConfigs(), jobRange(j), j.NodeList)
+	if err != nil {
+		return
+	}
+	source := newFluxQuery(derived)
+	if derived == "" {
+		source = createMeasurementSource(db.bucketName, jobRange(j), measurement, "")
+	}
 
 	// Query mean and max values for the metric
-	query :=
-		fmt.Sprintf(
-			MetadataMeasurementsQuery,
-			db.bucketName,
-			j.StartTime, j.StopTime,
-			measurement,
-			j.NodeList,
-			metric.FilterFunc,
-			metric.PostQueryOp,
-		)
-	result, err = db.queryAPI.Query(context.Background(), query)
+	query, err := createMetadataMeasurementsQuery(source, j.NodeList, metric.FilterFunc, metric.PostQueryOp)
+	if err != nil {
+		logging.Error("db: queryMetadataMeasurements(): Could not create metadata query: ", err)
+		return
+	}
+	result, err = db.queryAPI.Query(ctx, query)
 	if err != nil {
 		logging.Error("db: queryMetadataMeasurements(): Error at metadata query '", query, "': ", err)
 	}
 	return
 }
 
-// queryLastDatapoints returns a slice of metricData metricData for job j.
+// queryLastDatapoints returns a slice of metricData metricData for job j in the order of the partition metrics.
 func (db *InfluxDB) queryLastDatapoints(j job.JobMetadata) (metricData []job.MetricData, err error) {
-	var wg sync.WaitGroup
 	if j.IsRunning {
 		j.StopTime = int(time.Now().Unix())
 	}
@@ -768,38 +964,127 @@ func (db *InfluxDB) queryLastDatapoints(j job.JobMetadata) (metricData []job.Met
 	if err != nil {
 		sampleInterval = 30 * time.Second
 	}
-	for _, m := range db.getPartition(&j).Metrics {
-		wg.Add(1)
-		go func(m conf.MetricConfig) {
-			defer wg.Done()
-			m.PostQueryOp += "|> last()"
-			var queryResult *api.QueryTableResult
-			separationKey := "hostname"
-			if j.NumNodes == 1 {
-				queryResult, err = db.querySimpleMeasurement(m, &j, j.NodeList, sampleInterval)
-				separationKey = m.SeparationKey
-			} else {
-				queryResult, err = db.queryAggregateMeasurement(m, &j, j.NodeList, m.AggFn, sampleInterval)
-			}
-			if err != nil {
-				logging.Error("db: queryLastDatapoints(): Job ", j.Id, ": could not get last datapoints: ", err)
-				return
-			}
-			if err == nil {
-				result, err := parseQueryResult(queryResult, separationKey)
-				if err != nil {
-					logging.Error("Job ", j.Id, ": could not parse last datapoints: ", err)
-					return
-				}
-				metricData = append(metricData, job.MetricData{Config: m, Data: result})
+	metrics := db.partitionMetrics(&j)
+	results := make([]job.MetricData, len(metrics))
+	// Live monitoring is not bound to a request
+	ctx := context.Background()
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+		m.PostQueryOp += "|> last()"
+		var queryResult *api.QueryTableResult
+		var err error
+		separationKey := "hostname"
+		if j.NumNodes == 1 {
+			queryResult, err = db.querySimpleMeasurement(ctx, m, &j, j.NodeList, sampleInterval)
+			separationKey = m.SeparationKey
+		} else {
+			queryResult, err = db.queryAggregateMeasurement(ctx, m, &j, j.NodeList, m.AggFn, sampleInterval)
+		}
+		if err != nil {
+			logging.Error("db: queryLastDatapoints(): Job ", j.Id, ": could not get last datapoints: ", err)
+			return err
+		}
+		result, err := parseQueryResult(queryResult, separationKey)
+		if err != nil {
+			logging.Error("Job ", j.Id, ": could not parse last datapoints: ", err)
+			return err
+		}
+		results[i] = job.MetricData{Config: m, Data: result}
+		return nil
+	})
+	for i := range metrics {
+		if errs[i] == nil {
+			metricData = append(metricData, results[i])
+		}
+	}
+	return metricData, nil
+}
+
+// Aggregation tasks are run every minute
+const aggregationTaskEvery = "1m"
+
+// Description of the tasks managed by the backend
+const aggregationTaskDescription = "JobMon aggregation of node data"
+
+// aggregationTask is an aggregation task of the node data of metric with aggregation function aggFn.
+type aggregationTask struct {
+	metric conf.MetricConfig
+	aggFn  string
+}
+
+// aggregationTasks returns the aggregation tasks of the available aggregation functions of all metrics by name.
+func (db *InfluxDB) aggregationTasks() map[string]aggregationTask {
+	tasks := make(map[string]aggregationTask)
+	for _, metric := range db.metrics {
+		for _, aggFn := range metric.AvailableAggFns {
+			tasks[db.bucketName+"_"+metric.Measurement+"_"+aggFn] = aggregationTask{metric: metric, aggFn: aggFn}
+		}
+	}
+	return tasks
+}
+
+// aggregationTaskQuery returns the Flux query of task t that aggregates the node data in range r,
+// e.g. in taskRange for the scheduled runs of the task.
+func (db *InfluxDB) aggregationTaskQuery(t aggregationTask, r fluxRange) (query string, err error) {
+	sampleInterval := t.metric.SampleInterval
+	if sampleInterval == "" {
+		sampleInterval = db.defaultSampleInterval
+	}
+	every, err := time.ParseDuration(sampleInterval)
+	if err != nil {
+		return
+	}
+
+	derived, err := db.derivedSource(t.metric, db.metricConfigs(), r, "")
+	if err != nil {
+		return
+	}
+	source := newFluxQuery(derived)
+	if derived == "" {
+		source = createMeasurementSource(db.bucketName, r, t.metric.Measurement, t.metric.Type)
+	}
+	return createAggregationTaskQuery(source, t.metric, t.aggFn, every, db.bucketName, db.organizationName)
+}
+
+// taskFlux returns the complete Flux script of the task name with query as stored by InfluxDB,
+// which prepends the task options like the InfluxDB client.
+func taskFlux(name string, query string) string {
+	return fmt.Sprintf(`option task = { name: "%s", every: %s } %s`, name, aggregationTaskEvery, query)
+}
+
+// isAggregationTask returns true if task is an aggregation task managed by the backend: it is named after
+// the bucket and writes to it, so tasks of backends using other buckets of the organization are kept.
+// Tasks created by older versions have no description.
+func (db *InfluxDB) isAggregationTask(task domain.Task) bool {
+	if task.Description != nil && *task.Description != "" && *task.Description != aggregationTaskDescription {
+		return false
+	}
+	return strings.HasPrefix(task.Name, db.bucketName+"_") &&
+		strings.Contains(task.Flux, "|> to(bucket: "+fluxString(db.bucketName))
+}
+
+// findAggregationTasks returns the aggregation tasks managed by the backend in the organization.
+func (db *InfluxDB) findAggregationTasks(ctx context.Context) (tasks []domain.Task, err error) {
+	filter := &api.TaskFilter{OrgID: *db.organization.Id, Limit: 500}
+	for {
+		page, err := db.tasksAPI.FindTasks(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range page {
+			if db.isAggregationTask(task) {
+				tasks = append(tasks, task)
 			}
-		}(db.metrics[m])
+		}
+		if len(page) < filter.Limit {
+			return tasks, nil
+		}
+		filter.After = page[len(page)-1].Id
 	}
-	wg.Wait()
-	return
 }
 
-// createTask appends task with name taskName, with query taskStr and id orgID to db.task.
+// createTask creates the aggregation task with name taskName and Flux query taskStr
+// in the organization with id orgID.
 func (db *InfluxDB) createTask(
 	taskName string,
 	taskStr string,
@@ -808,128 +1093,427 @@ func (db *InfluxDB) createTask(
 	task *domain.Task,
 	err error,
 ) {
-	task, err = db.tasksAPI.CreateTaskWithEvery(
+	every := aggregationTaskEvery
+	description := aggregationTaskDescription
+	status := domain.TaskStatusTypeActive
+	task, err = db.tasksAPI.CreateTask(
 		context.Background(),
-		taskName,
-		taskStr, // flux task script
-		"1m",    // run every minute
-		orgId)
+		&domain.Task{
+			Name:        taskName,
+			Flux:        taskStr, // flux task script
+			Every:       &every,
+			Description: &description,
+			Status:      &status,
+			OrgID:       orgId,
+		})
 	if err != nil {
 		logging.Error("db: createTask(): Could not create task: ", err)
-		return
 	}
-	db.tasks = append(db.tasks, *task)
 	return
 }
 
-// createAggregationTask prepares the query for the createTask method, this query
-// is used to build aggregated metrics where the aggregation is done based on the aggFn function.
-//
-// createAggregationTasks calls the function createTask where the query is built on top
-// of the metric, aggFn and orgID arguments.
-func (db *InfluxDB) createAggregationTask(
+// updateAggregationTasks reconciles the aggregation tasks in InfluxDB with the metrics: for each metric
+// and its available aggregation functions a task is created if it is missing or updated if its
+// Flux query changed, e.g. because of the FilterFunc, SampleInterval or Type of the metric.
+// Tasks of deleted metrics or aggregation functions and duplicate tasks are deleted.
+// Reconciliations do not overlap and are skipped once the database was replaced, so that they
+// do not restore the tasks of an outdated configuration.
+func (db *InfluxDB) updateAggregationTasks() {
+	if !db.aggregations.lockReconciliation(db.reconciliation) {
+		logging.Info("db: updateAggregationTasks(): Skipped outdated reconciliation of aggregation tasks")
+		return
+	}
+	defer db.aggregations.reconcile.Unlock()
+
+	// Measure required time to update aggregation tasks
+	start := time.Now()
+	ctx := context.Background()
+
+	existing, err := db.findAggregationTasks(ctx)
+	if err != nil {
+		logging.Error("db: updateAggregationTasks(): Could not get tasks from influxdb: ", err)
+		return
+	}
+
+	desired := db.aggregationTasks()
+	found := make(map[string]bool)
+	tasks := []domain.Task{}
+	for _, task := range existing {
+		t, ok := desired[task.Name]
+		if !ok || found[task.Name] {
+			logging.Info("db: updateAggregationTasks(): Delete orphaned aggregation task ", task.Name)
+			if err := db.tasksAPI.DeleteTask(ctx, &task); err != nil {
+				logging.Error("db: updateAggregationTasks(): Failed to delete aggregation task ", task.Name, ": ", err)
+			}
+			continue
+		}
+		found[task.Name] = true
+
+		// Tasks whose query cannot be created are kept unchanged
+		query, err := db.aggregationTaskQuery(t, taskRange)
+		if err != nil {
+			logging.Error("db: updateAggregationTasks(): Could not create query of aggregation task ", task.Name, ": ", err)
+			tasks = append(tasks, task)
+			continue
+		}
+		if flux := taskFlux(task.Name, query); task.Flux != flux {
+			logging.Info("db: updateAggregationTasks(): Update changed aggregation task ", task.Name)
+			description := aggregationTaskDescription
+			task.Flux = flux
+			task.Description = &description
+			// The options of the task are part of its Flux script
+			task.Every, task.Cron = nil, nil
+			updated, err := db.tasksAPI.UpdateTask(ctx, &task)
+			if err != nil {
+				logging.Error("db: updateAggregationTasks(): Failed to update aggregation task ", task.Name, ": ", err)
+			} else {
+				task = *updated
+			}
+		}
+		tasks = append(tasks, task)
+	}
+
+	// Create missing tasks
+	created := []string{}
+	for name, t := range desired {
+		if found[name] {
+			continue
+		}
+		logging.Info("db: updateAggregationTasks(): Create missing aggregation task ", name)
+		query, err := db.aggregationTaskQuery(t, taskRange)
+		if err != nil {
+			logging.Error("db: updateAggregationTasks(): Could not create query of aggregation task ", name, ": ", err)
+			continue
+		}
+		task, err := db.createTask(name, query, *db.organization.Id)
+		if err != nil {
+			logging.Error("db: updateAggregationTasks(): Failed to create aggregation task: ", err)
+			continue
+		}
+		tasks = append(tasks, *task)
+		created = append(created, name)
+	}
+
+	db.tasksLock.Lock()
+	db.tasks = tasks
+	db.tasksLock.Unlock()
+	db.aggregations.addCreated(created)
+
+	logging.Info("db: updateAggregationTasks() took ", time.Since(start))
+}
+
+// lastRun sets the time, status and error of the last completed run of task in s
+// from the runs and run logs of InfluxDB.
+func (db *InfluxDB) lastRun(ctx context.Context, task domain.Task, s *AggregationStatus) error {
+	if task.LatestCompleted == nil {
+		return nil
+	}
+	// Runs are scheduled every minute, so the last run is scheduled shortly before the latest completed time
+	runs, err := db.tasksAPI.FindRuns(ctx, &task, &api.RunFilter{AfterTime: task.LatestCompleted.Add(-2 * time.Minute)})
+	if err != nil {
+		return err
+	}
+	var last *domain.Run
+	for i, run := range runs {
+		if run.Status == nil || run.ScheduledFor == nil ||
+			*run.Status == domain.RunStatusScheduled || *run.Status == domain.RunStatusStarted {
+			continue
+		}
+		if last == nil || run.ScheduledFor.After(*last.ScheduledFor) {
+			last = &runs[i]
+		}
+	}
+	if last == nil {
+		// Fall back to the summary of the task
+		s.LastRun = *task.LatestCompleted
+		if task.LastRunStatus != nil {
+			s.LastRunStatus = string(*task.LastRunStatus)
+		}
+		if task.LastRunError != nil {
+			s.LastError = *task.LastRunError
+		}
+		return nil
+	}
+
+	s.LastRun = *last.ScheduledFor
+	if last.StartedAt != nil {
+		s.LastRun = *last.StartedAt
+	}
+	s.LastRunStatus = string(*last.Status)
+	if *last.Status != domain.RunStatusFailed {
+		return nil
+	}
+	events, err := db.tasksAPI.FindRunLogs(ctx, last)
+	if err != nil {
+		return err
+	}
+	s.LastError = runError(events)
+	return nil
+}
+
+// runError returns the error message in the log events of a failed run, which is the last message
+// except for the messages about the start and completion of the run, or else the last message.
+func runError(events []domain.LogEvent) string {
+	last, message := "", ""
+	for _, event := range events {
+		if event.Message == nil {
+			continue
+		}
+		last = *event.Message
+		if !strings.HasPrefix(last, "Started") && !strings.HasPrefix(last, "Completed") {
+			message = last
+		}
+	}
+	if message == "" {
+		return last
+	}
+	return message
+}
+
+// getPartition returns a partition configuration for job j.
+func (db *InfluxDB) getPartition(j *job.JobMetadata) conf.BasePartitionConfig {
+	return db.partitionConfig[j.Partition].ForNodes(strings.Split(j.NodeList, "|"))
+}
+
+// derivedSource returns the flux source computing the values of metric in range rangeArgs
+// on nodes if metric is derived from the metrics in metrics; otherwise it returns the empty string.
+func (db *InfluxDB) derivedSource(
 	metric conf.MetricConfig,
-	aggFn string,
-	orgId string,
+	metrics []conf.MetricConfig,
+	r fluxRange,
+	nodes string,
 ) (
-	task *domain.Task,
+	source string,
 	err error,
 ) {
-	aggMeasurement := metric.Measurement + "_" + aggFn
-	aggTaskName := db.bucketName + "_" + metric.Measurement + "_" + aggFn
+	if !metric.IsDerived() {
+		return "", nil
+	}
+	components, err := metric.Components(metrics)
+	if err != nil {
+		logging.Error("db: derivedSource(): Derived metric ", metric.GUID, ": ", err)
+		return
+	}
 	sampleInterval := metric.SampleInterval
 	if sampleInterval == "" {
 		sampleInterval = db.defaultSampleInterval
 	}
-
-	// Create a InfluxDB task (scheduled Flux script)
-	sb := new(strings.Builder)
-	fmt.Fprintf(sb, `from(bucket: "%s")`, db.bucketName)
-	fmt.Fprintf(sb, `|> range(start: -task.every)`)
-	fmt.Fprintf(sb, `|> filter(fn: (r) => r["_measurement"] == "%s")`, metric.Measurement)
-	if metric.Type != "" {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["type"] == "%s")`, metric.Type)
-	}
-	if len(metric.FilterFunc) > 0 {
-		fmt.Fprintf(sb, `%s`, metric.FilterFunc)
+	every, err := time.ParseDuration(sampleInterval)
+	if err != nil {
+		logging.Error("db: derivedSource(): Derived metric ", metric.GUID, ": ", err)
+		return
 	}
-	if len(metric.PostQueryOp) > 0 {
-		fmt.Fprintf(sb, `%s`, metric.PostQueryOp)
+	source, err = createDerivedMeasurementSource(db.bucketName, r, metric, components, nodes, every)
+	if err != nil {
+		logging.Error("db: derivedSource(): ", err)
 	}
-	fmt.Fprintf(sb, `|> group(columns: ["_measurement", "hostname"], mode:"by")`)
-	fmt.Fprintf(sb, `|> aggregateWindow(every: %s, fn: %s, createEmpty: false)`, sampleInterval, aggFn)
-	fmt.Fprintf(sb, `|> group(columns: ["hostname"], mode:"by")`)
-	fmt.Fprintf(sb, `|> keep(columns: ["hostname", "_start", "_stop", "_time", "_value", "cluster"])`)
-	fmt.Fprintf(sb, `|> set(key: "_measurement", value: "%s")`, aggMeasurement)
-	fmt.Fprintf(sb, `|> set(key: "_field", value: "%s")`, aggMeasurement)
-	fmt.Fprintf(sb, `|> to(bucket: "%s", org: "%s")`, db.bucketName, db.organizationName)
+	return
+}
 
-	query := sb.String()
-	return db.createTask(aggTaskName, query, orgId)
+// metricConfigs returns the configurations of all metrics.
+func (db *InfluxDB) metricConfigs() []conf.MetricConfig {
+	metrics := make([]conf.MetricConfig, 0, len(db.metrics))
+	for _, mc := range db.metrics {
+		metrics = append(metrics, mc)
+	}
+	return metrics
 }
 
-// updateAggregationTask finds first all the missing tasks for job metrics.
-// then for each metric find the available aggregation functions adding this tasks
-// to db, finally it launches an aggregation task for each missing metric.
-func (db *InfluxDB) updateAggregationTasks() {
-	// Measure required time to update aggregation tasks
-	start := time.Now()
+// jobRange returns the flux range for the run time of job j.
+func jobRange(j *job.JobMetadata) fluxRange {
+	return unixRange(j.StartTime, j.StopTime)
+}
 
-	for _, metricConfig := range db.metrics {
-		for _, aggFn := range metricConfig.AvailableAggFns {
+// ValidateMetrics implements ValidateMetrics method of DB interface.
+func (db *InfluxDB) ValidateMetrics(ctx context.Context, metrics []conf.MetricConfig) conf.ValidationErrors {
+	errs := conf.ValidationErrors{}
 
-			// For each configured metric and its aggregation functions create a aggregation task
-			aggTaskName := db.bucketName + "_" + metricConfig.Measurement + "_" + aggFn
+	measurements, err := db.getMeasurements(ctx)
+	if err != nil {
+		errs = append(errs, conf.ValidationError{Field: "DBBucket", Message: fmt.Sprintf("could not list measurements: %v", err)})
+	}
 
-			// Check if task is already created
-			tasks, err := db.tasksAPI.FindTasks(
-				context.Background(),
-				&api.TaskFilter{
-					Name: aggTaskName,
-				})
-			if err != nil {
-				logging.Error("db: updateAggregationTasks(): Could not get tasks from influxdb: ", err)
-				continue
+	// Run the Flux query of each derived metric and each metric with custom Flux code
+	// on a short time range to check that it compiles
+	now := int(time.Now().Unix())
+	for i, m := range metrics {
+		field := fmt.Sprintf("Metrics[%d]", i)
+		if m.Measurement != "" && measurements != nil && !measurements[m.Measurement] && !m.IsDerived() {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".Measurement",
+				Message: fmt.Sprintf("measurement '%s' does not exist in bucket %s", m.Measurement, db.bucketName),
+			})
+		}
+		if m.Measurement == "" || (m.FilterFunc == "" && m.PostQueryOp == "" && !m.IsDerived()) {
+			continue
+		}
+		source, err := db.derivedSource(m, metrics, unixRange(now-60, now), "")
+		if err != nil {
+			// Problems with the expression are reported by Validate
+			continue
+		}
+		query, err := createSimpleMeasurementQuery(
+			db.bucketName,
+			now-60, now,
+			m.Measurement, m.Type,
+			"",
+			time.Minute,
+			m.FilterFunc, m.PostQueryOp,
+			source,
+		)
+		if err == nil {
+			err = db.checkQuery(ctx, query)
+		}
+		if err != nil {
+			field += ".FilterFunc"
+			if m.IsDerived() {
+				field = fmt.Sprintf("Metrics[%d].Expression", i)
 			}
+			errs = append(errs, conf.ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("query of the metric is not valid Flux: %v", err),
+			})
+		}
+	}
+	return errs
+}
 
-			if len(tasks) > 1 {
-				logging.Error("db: updateAggregationTasks(): Multiple InfluxDB tasks exists for aggregation ", aggTaskName)
-				continue
-			}
+// getMeasurements returns the set of measurements in the bucket.
+func (db *InfluxDB) getMeasurements(ctx context.Context) (map[string]bool, error) {
+	names, err := db.queryStrings(ctx, fmt.Sprintf(MeasurementsQuery, fluxString(db.bucketName)))
+	if err != nil {
+		return nil, err
+	}
+	measurements := make(map[string]bool)
+	for _, name := range names {
+		measurements[name] = true
+	}
+	return measurements, nil
+}
 
-			// If task is missing, create it
-			found := len(tasks) > 0
-			if !found {
-				logging.Info("db: updateAggregationTasks(): Create missing aggregation task ", aggTaskName)
-				if _, err := db.createAggregationTask(
-					metricConfig,
-					aggFn,
-					*db.organization.Id,
-				); err != nil {
-					logging.Error("db: updateAggregationTasks(): Failed to create aggregation task: ", err)
-				}
-			}
+// DiscoverMeasurements implements DiscoverMeasurements method of DB interface.
+func (db *InfluxDB) DiscoverMeasurements(ctx context.Context, lookback time.Duration) (measurements []MeasurementInfo, err error) {
+	start := time.Now()
+
+	names, err := db.getMeasurements(ctx)
+	if err != nil {
+		logging.Error("db: DiscoverMeasurements(): Could not list measurements: ", err)
+		return
+	}
+
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	// The inspection of a measurement is scheduled as one query
+	infos := make([]MeasurementInfo, len(sortedNames))
+	errs := db.runQueries(ctx, len(sortedNames), func(ctx context.Context, i int) error {
+		info, err := db.inspectMeasurement(ctx, sortedNames[i], lookback)
+		if err != nil {
+			logging.Error("db: DiscoverMeasurements(): Could not inspect measurement ", sortedNames[i], ": ", err)
+			return err
+		}
+		infos[i] = info
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for i := range sortedNames {
+		if errs[i] == nil {
+			measurements = append(measurements, infos[i])
 		}
 	}
 
-	logging.Info("db: updateAggregationTasks() took ", time.Since(start))
+	logging.Info("db: DiscoverMeasurements() took ", time.Since(start))
+	return measurements, nil
 }
 
-// getPartition returns a partition configuration for job j.
-func (db *InfluxDB) getPartition(j *job.JobMetadata) conf.BasePartitionConfig {
-	nodes := strings.Split(j.NodeList, "|")
-	for _, vp := range db.partitionConfig[j.Partition].VirtualPartitions {
-		matches := true
-		// Check if all nodes are in vp
-		for _, n := range nodes {
-			if !utils.Contains(vp.Nodes, n) {
-				matches = false
-				break
+// inspectMeasurement returns the tags, fields, nodes and sample interval
+// of measurement during the last lookback.
+func (db *InfluxDB) inspectMeasurement(ctx context.Context, measurement string, lookback time.Duration) (info MeasurementInfo, err error) {
+	info.Measurement = measurement
+	r := relativeRange(lookback)
+	bucket, name := fluxString(db.bucketName), fluxString(measurement)
+
+	info.Tags, err = db.queryStrings(ctx, fmt.Sprintf(MeasurementSchemaQuery, bucket, name, "measurementTagKeys", r.start))
+	if err != nil {
+		return
+	}
+	info.Fields, err = db.queryStrings(ctx, fmt.Sprintf(MeasurementSchemaQuery, bucket, name, "measurementFieldKeys", r.start))
+	if err != nil {
+		return
+	}
+	if utils.Contains(info.Tags, "type") {
+		info.Types, err = db.queryStrings(ctx, fmt.Sprintf(MeasurementTagValuesQuery, bucket, name, fluxString("type"), r.start))
+		if err != nil {
+			return
+		}
+	}
+	info.Hostnames, err = db.queryStrings(ctx, fmt.Sprintf(MeasurementTagValuesQuery, bucket, name, fluxString("hostname"), r.start))
+	if err != nil {
+		return
+	}
+	sort.Strings(info.Tags)
+	sort.Strings(info.Fields)
+	sort.Strings(info.Types)
+	sort.Strings(info.Hostnames)
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	result, err := db.queryAPI.Query(ctx, fmt.Sprintf(MeasurementIntervalQuery, bucket, r, name))
+	if err != nil {
+		return
+	}
+	defer result.Close()
+	for result.Next() {
+		value, ok := result.Record().Value().(float64)
+		if !ok {
+			continue
+		}
+		switch result.Record().Field() {
+		case "interval":
+			if value > 0 {
+				info.SampleInterval = (time.Duration(value) * time.Second).String()
 			}
+		case "last":
+			info.LastSeen = time.Unix(int64(value), 0)
 		}
-		if matches {
-			return vp.BasePartitionConfig
+	}
+	return info, result.Err()
+}
+
+// queryStrings runs query and returns the string values of the result, e.g. of schema queries.
+func (db *InfluxDB) queryStrings(ctx context.Context, query string) (values []string, err error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	result, err := db.queryAPI.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	values = []string{}
+	for result.Next() {
+		if value, ok := result.Record().Value().(string); ok {
+			values = append(values, value)
 		}
 	}
-	return db.partitionConfig[j.Partition].BasePartitionConfig
+	return values, result.Err()
+}
+
+// checkQuery runs query and returns the error reported by InfluxDB, if any.
+func (db *InfluxDB) checkQuery(ctx context.Context, query string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	result, err := db.queryAPI.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+	}
+	return result.Err()
 }
@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	conf "jobmon/config"
+	"jobmon/expr"
 	"jobmon/logging"
 	"strings"
 	"time"
@@ -16,6 +18,7 @@ import (
 // * an optional additional metric filter function
 // * optional post query operations
 // Query result is aggregated and truncated to duration "sample interval"
+// If source is set, it is used instead of reading measurement, e.g. for derived metrics.
 func createSimpleMeasurementQuery(
 	bucket string,
 	StartTime int, StopTime int,
@@ -25,50 +28,29 @@ func createSimpleMeasurementQuery(
 	sampleInterval time.Duration,
 	metricFilterFunc string,
 	metricPostQueryOp string,
+	source string,
 ) (
 	q string,
+	err error,
 ) {
-
-	if bucket == "" {
-		logging.Error("db: createSimpleMeasurementQuery(): Missing bucket configuration")
-		return
-	}
-
-	if measurement == "" {
-		logging.Error("db: createSimpleMeasurementQuery(): Missing measurement configuration")
+	if err = checkQueryArguments(bucket, measurement, StartTime, StopTime); err != nil {
 		return
 	}
 
-	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
-		logging.Error("db: createSimpleMeasurementQuery(): Wrong start time = ", StartTime, ", StopTime = ", StopTime, " configuration")
-		return
-	}
-
-	sb := new(strings.Builder)
-	fmt.Fprintf(sb, `from(bucket: "%s")`, bucket)
-	fmt.Fprintf(sb, `|> range(start: %d, stop: %d)`, StartTime, StopTime)
-	fmt.Fprintf(sb, `|> filter(fn: (r) => r["_measurement"] == "%s")`, measurement)
-	if metricType != "" {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["type"] == "%s")`, metricType)
-	}
-	if strings.Contains(nodes, "|") {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] =~ /^(%s)$/)`, nodes)
-	} else {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] == "%s")`, nodes)
-	}
-
-	if len(metricFilterFunc) > 0 {
-		fmt.Fprintf(sb, `%s`, metricFilterFunc)
+	query := newFluxQuery(source)
+	if source == "" {
+		query = createMeasurementSource(bucket, unixRange(StartTime, StopTime), measurement, metricType)
 	}
-	if len(metricPostQueryOp) > 0 {
-		fmt.Fprintf(sb, `%s`, metricPostQueryOp)
-	}
-	// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
-	// https://docs.influxdata.com/flux/v0.x/stdlib/universe/mean/
-	fmt.Fprintf(sb, `|> aggregateWindow(every: %v, fn: mean, createEmpty: false)`, sampleInterval)
-	// Truncate time to sampleInterval to synchronize measurements from different nodes
-	fmt.Fprintf(sb, `|> truncateTimeColumn(unit: %v)`, sampleInterval)
-	q = sb.String()
+	q, err = query.
+		FilterSet("hostname", strings.Split(nodes, "|")).
+		Pipe(metricFilterFunc).
+		Pipe(metricPostQueryOp).
+		// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
+		// https://docs.influxdata.com/flux/v0.x/stdlib/universe/mean/
+		AggregateWindow(sampleInterval, "mean").
+		// Truncate time to sampleInterval to synchronize measurements from different nodes
+		TruncateTime(sampleInterval).
+		Query()
 
 	logging.Debug("db: createSimpleMeasurementQuery(): flux query string = ", q)
 	return
@@ -91,48 +73,27 @@ func createAggregateMeasurementQuery(
 	sampleInterval time.Duration,
 	metricFilterFunc string,
 	metricPostQueryOp string,
-) (q string) {
-
-	if bucket == "" {
-		logging.Error("db: createAggregateMeasurementQuery(): Missing bucket configuration")
-		return
-	}
-
-	if measurement == "" {
-		logging.Error("db: createAggregateMeasurementQuery(): Missing measurement configuration")
-		return
-	}
-
-	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
-		logging.Error("db: createAggregateMeasurementQuery(): Wrong start time = ", StartTime, ", StopTime = ", StopTime, " configuration")
+) (q string, err error) {
+	if err = checkQueryArguments(bucket, measurement, StartTime, StopTime); err != nil {
 		return
 	}
 
-	sb := new(strings.Builder)
-	fmt.Fprintf(sb, `from(bucket: "%s")`, bucket)
-	fmt.Fprintf(sb, `|> range(start: %d, stop: %d)`, StartTime, StopTime)
-	fmt.Fprintf(sb, `|> filter(fn: (r) => r["_measurement"] == "%s")`, measurement)
-	if strings.Contains(nodes, "|") {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] =~ /^(%s)$/)`, nodes)
-	} else {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] == "%s")`, nodes)
-	}
-	if len(metricFilterFunc) > 0 {
-		fmt.Fprintf(sb, `%s`, metricFilterFunc)
-	}
-	if len(metricPostQueryOp) > 0 {
-		fmt.Fprintf(sb, `%s`, metricPostQueryOp)
-	}
-	// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
-	fmt.Fprintf(sb, `|> aggregateWindow(every: %v, fn: mean, createEmpty: false)`, sampleInterval)
-	// Truncate time to sampleInterval to synchronize measurements from different nodes
-	fmt.Fprintf(sb, `|> truncateTimeColumn(unit: %v)`, sampleInterval)
-	q = sb.String()
+	q, err = createMeasurementSource(bucket, unixRange(StartTime, StopTime), measurement, "").
+		FilterSet("hostname", strings.Split(nodes, "|")).
+		Pipe(metricFilterFunc).
+		Pipe(metricPostQueryOp).
+		// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
+		AggregateWindow(sampleInterval, "mean").
+		// Truncate time to sampleInterval to synchronize measurements from different nodes
+		TruncateTime(sampleInterval).
+		Query()
 
 	logging.Debug("db: createAggregateMeasurementQuery(): flux query string = ", q)
 	return
 }
 
+// createQuantileMeasurementQuery creates an flux query string to query quantiles of a measurement
+// over all nodes. If source is set, it is used instead of reading measurement, e.g. for derived metrics.
 func createQuantileMeasurementQuery(
 	bucket string,
 	StartTime int, StopTime int,
@@ -142,78 +103,248 @@ func createQuantileMeasurementQuery(
 	metricFilterFunc string,
 	metricPostQueryOp string,
 	quantiles []string,
-) (q string) {
-
-	if bucket == "" {
-		logging.Error("db: createQuantileMeasurementQuery(): Missing bucket configuration")
+	source string,
+) (q string, err error) {
+	if err = checkQueryArguments(bucket, measurement, StartTime, StopTime); err != nil {
 		return
 	}
 
-	if measurement == "" {
-		logging.Error("db: createQuantileMeasurementQuery(): Missing measurement configuration")
-		return
+	query := newFluxQuery(source)
+	if source == "" {
+		query = createMeasurementSource(bucket, unixRange(StartTime, StopTime), measurement, "")
 	}
-
-	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
-		logging.Error("db: createQuantileMeasurementQuery(): Wrong start time = ", StartTime, ", StopTime = ", StopTime, " configuration")
+	data, err := query.
+		FilterSet("hostname", strings.Split(nodes, "|")).
+		Pipe(metricFilterFunc).
+		Pipe(metricPostQueryOp).
+		// Un-group all measurements
+		Group().
+		Query()
+	if err != nil {
 		return
 	}
 
 	sb := new(strings.Builder)
-	fmt.Fprintf(sb, `data = from(bucket: "%s")`, bucket)
-	fmt.Fprintf(sb, `|> range(start: %d, stop: %d)`, StartTime, StopTime)
-	fmt.Fprintf(sb, `|> filter(fn: (r) => r["_measurement"] == "%s")`, measurement)
-	if strings.Contains(nodes, "|") {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] =~ /^(%s)$/)`, nodes)
-	} else {
-		fmt.Fprintf(sb, `|> filter(fn: (r) => r["hostname"] == "%s")`, nodes)
-	}
-	if len(metricFilterFunc) > 0 {
-		fmt.Fprintf(sb, `%s`, metricFilterFunc)
-	}
-	if len(metricPostQueryOp) > 0 {
-		fmt.Fprintf(sb, `%s`, metricPostQueryOp)
-	}
-	// Un-group all measurements
-	fmt.Fprintf(sb, `|> group()`)
-	fmt.Fprintf(sb, "\n")
+	fmt.Fprintf(sb, "data = %s\n", data)
 
 	// For each defined quantile create a separate result stream
 	streamNames := make([]string, len(quantiles))
-	streamName := 'A'
-	for i, q := range quantiles {
-		streamNames[i] = string(streamName)
-		streamName++
-		fmt.Fprintf(sb, "%s = data", streamNames[i])
-		// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
-		// Use quantile as aggregation function
-		// See: https://docs.influxdata.com/flux/v0.x/stdlib/universe/quantile/
-		fmt.Fprintf(sb, `|> aggregateWindow(every: %v, fn: (tables=<-, column) => tables |> quantile(column: "_value", q: %s, method: "estimate_tdigest", compression: 1000.0), createEmpty: false)`, sampleInterval, q)
-		// Truncate time to sampleInterval to synchronize measurements from different nodes
-		fmt.Fprintf(sb, `|> truncateTimeColumn(unit: %v)`, sampleInterval)
-		fmt.Fprintf(sb, `|> set(key: "_field", value: "%s")`, q)
-		fmt.Fprintf(sb, `|> set(key: "_measurement", value: "%s_quant")`, measurement)
-		fmt.Fprintf(sb, "\n")
+	for i, quantile := range quantiles {
+		streamNames[i] = fmt.Sprintf("q%d", i)
+		stream, err := newFluxQuery("data").
+			// Aggregation to sampleInterval after all filtering to aggregate on all metric data available
+			// Use quantile as aggregation function
+			AggregateWindowQuantile(sampleInterval, quantile).
+			// Truncate time to sampleInterval to synchronize measurements from different nodes
+			TruncateTime(sampleInterval).
+			Set("_field", quantile).
+			Set("_measurement", measurement+"_quant").
+			Query()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sb, "%s = %s\n", streamNames[i], stream)
 	}
 
 	// Create a union of the separate result stream
-	fmt.Fprintf(sb, `union(tables: [%s])`, strings.Join(streamNames, ","))
-	fmt.Fprintf(sb, `|> group(columns: ["_field"])`)
+	union, _ := newFluxQuery("union(tables: [" + strings.Join(streamNames, ", ") + "])").
+		Group("_field").
+		Query()
+	sb.WriteString(union)
 	q = sb.String()
 
 	logging.Debug("db: createQuantileMeasurementQuery(): flux query string = ", q)
 	return
 }
 
-// Parameters: bucket, startTime, stopTime, measurement,
-// nodelist, filterFunc, postQueryOp
+// createMetadataMeasurementsQuery creates a flux query string that computes the mean and max values of
+// source, e.g. created by createMeasurementSource, on nodes after the filter function and post query operations.
+func createMetadataMeasurementsQuery(
+	source *fluxQuery,
+	nodes string,
+	metricFilterFunc string,
+	metricPostQueryOp string,
+) (q string, err error) {
+	data, err := source.
+		FilterSet("hostname", strings.Split(nodes, "|")).
+		Pipe(metricFilterFunc).
+		Pipe(metricPostQueryOp).
+		Query()
+	if err != nil {
+		return
+	}
+	return fmt.Sprintf(MetadataMeasurementsQuery, data), nil
+}
+
+// createAggregationTaskQuery creates the flux query string of a task that aggregates source, e.g. created by
+// createMeasurementSource, per node with aggFn to duration every and writes the result as measurement
+// "<measurement>_<aggFn>" to bucket of organization org.
+func createAggregationTaskQuery(
+	source *fluxQuery,
+	metric conf.MetricConfig,
+	aggFn string,
+	every time.Duration,
+	bucket string,
+	org string,
+) (string, error) {
+	aggMeasurement := metric.Measurement + "_" + aggFn
+	return source.
+		Pipe(metric.FilterFunc).
+		Pipe(metric.PostQueryOp).
+		Group("_measurement", "hostname").
+		AggregateWindow(every, aggFn).
+		Group("hostname").
+		Keep("hostname", "_start", "_stop", "_time", "_value", "cluster").
+		Set("_measurement", aggMeasurement).
+		Set("_field", aggMeasurement).
+		To(bucket, org).
+		Query()
+}
+
+// checkQueryArguments checks the arguments shared by all metric queries.
+func checkQueryArguments(bucket string, measurement string, StartTime int, StopTime int) error {
+	if bucket == "" {
+		return fmt.Errorf("missing bucket configuration")
+	}
+	if measurement == "" {
+		return fmt.Errorf("missing measurement configuration")
+	}
+	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
+		return fmt.Errorf("wrong start time = %d, stop time = %d", StartTime, StopTime)
+	}
+	return nil
+}
+
+// createMeasurementSource returns a flux query that reads measurement in range r
+// with an optional filter by type.
+func createMeasurementSource(bucket string, r fluxRange, measurement string, metricType string) *fluxQuery {
+	q := fluxFrom(bucket).
+		Range(r).
+		Filter("_measurement", measurement)
+	if metricType != "" {
+		q.Filter("type", metricType)
+	}
+	return q
+}
+
+// createDerivedMeasurementSource creates a flux query string that computes the values of the derived
+// metric from its components and can be used instead of reading a measurement. The source contains:
+// * for each component a filter by type, nodes, its filter function and post query operations
+// * for each component an aggregation per node by its aggregation function to duration "sample interval"
+// * the expression of the metric evaluated per node and timestamp where all components have values
+// Result rows have the columns _time, _value, _measurement, _field and hostname
+func createDerivedMeasurementSource(
+	bucket string,
+	r fluxRange,
+	metric conf.MetricConfig,
+	components map[string]conf.MetricConfig,
+	nodes string,
+	sampleInterval time.Duration,
+) (q string, err error) {
+
+	e, err := expr.Parse(metric.Expression)
+	if err != nil {
+		return "", fmt.Errorf("invalid expression of derived metric %s: %w", metric.GUID, err)
+	}
+	variables := e.Variables()
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "union(tables: [")
+	for i, name := range variables {
+		c, ok := components[name]
+		if !ok {
+			return "", fmt.Errorf("missing component %s of derived metric %s", name, metric.GUID)
+		}
+		aggFn := c.AggFn
+		if aggFn == "" {
+			aggFn = "mean"
+		}
+		if i > 0 {
+			fmt.Fprintf(sb, ",")
+		}
+		fmt.Fprintf(sb, "\n\t")
+		component := createMeasurementSource(bucket, r, c.Measurement, c.Type)
+		if nodes != "" {
+			component.FilterSet("hostname", strings.Split(nodes, "|"))
+		}
+		source, err := component.
+			Pipe(c.FilterFunc).
+			Pipe(c.PostQueryOp).
+			Pipe("|> toFloat()").
+			Group("hostname").
+			AggregateWindow(sampleInterval, aggFn).
+			Set("_field", name).
+			Query()
+		if err != nil {
+			return "", fmt.Errorf("component %s of derived metric %s: %w", name, metric.GUID, err)
+		}
+		sb.WriteString(source)
+	}
+	fmt.Fprintf(sb, "\n])")
+	fmt.Fprintf(sb, `|> group(columns: ["hostname"])`)
+	fmt.Fprintf(sb, `|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`)
+
+	// Only evaluate the expression where all components have values
+	column := func(name string) string { return "r[" + fluxString(name) + "]" }
+	exists := make([]string, len(variables))
+	for i, name := range variables {
+		exists[i] = "exists " + column(name)
+	}
+	fmt.Fprintf(sb, `|> filter(fn: (r) => %s)`, strings.Join(exists, " and "))
+	fmt.Fprintf(sb,
+		`|> map(fn: (r) => ({_time: r._time, hostname: r.hostname, _measurement: %[1]s, _field: %[1]s, _value: %[2]s}))`,
+		fluxString(metric.Measurement), e.Flux(column))
+	return sb.String(), nil
+}
+
+// String parameters of the following queries are Flux string literals, see fluxString
+
+// Parameters: bucket
+const MeasurementsQuery = `
+import "influxdata/influxdb/schema"
+schema.measurements(bucket: %v)
+`
+
+// Parameters: bucket, measurement, schema function, e.g. measurementTagKeys, start
+const MeasurementSchemaQuery = `
+import "influxdata/influxdb/schema"
+schema.%[3]v(bucket: %[1]v, measurement: %[2]v, start: %[4]v)
+`
+
+// Parameters: bucket, measurement, tag, start
+const MeasurementTagValuesQuery = `
+import "influxdata/influxdb/schema"
+schema.measurementTagValues(bucket: %v, measurement: %v, tag: %v, start: %v)
+`
+
+// Parameters: bucket, range arguments, measurement
+// Returns the median interval between two data points of a series in seconds
+// and the time of the latest data point
+const MeasurementIntervalQuery = `
+data = from(bucket: %v)
+	|> range(%v)
+	|> filter(fn: (r) => r["_measurement"] == %v)
+
+interval = data
+	|> elapsed(unit: 1s)
+	|> group()
+	|> median(column: "elapsed")
+	|> map(fn: (r) => ({_field: "interval", _value: float(v: r.elapsed)}))
+
+last = data
+	|> last()
+	|> group()
+	|> sort(columns: ["_time"], desc: true)
+	|> limit(n: 1)
+	|> map(fn: (r) => ({_field: "last", _value: float(v: uint(v: r._time)) / 1000000000.0}))
+
+union(tables: [interval, last])
+`
+
+// Parameters: source filtered by nodes, filter function and post query operations,
+// see createMetadataMeasurementsQuery
 const MetadataMeasurementsQuery = `
-data = from(bucket: "%v")
-	|> range(start: %v, stop: %v)
-	|> filter(fn: (r) => r["_measurement"] == "%v")
-	|> filter(fn: (r) => r["hostname"] =~ /^(%v)$/)
-	%v
-	%v
+data = %v
 mean = data
 	|> mean(column: "_value")
 	|> group()
@@ -222,8 +353,8 @@ mean = data
 
 max = data
 	|> highestMax(n:5, groupColumns: ["_time"])
-  	|> median()
-  	|> set(key: "_field", value: "max")
-	
-union(tables: [mean, max])	
+	|> median()
+	|> set(key: "_field", value: "max")
+
+union(tables: [mean, max])
 `
@@ -0,0 +1,39 @@
+package db
+
+import (
+	conf "jobmon/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests if the source of a derived metric reads all components per node and evaluates the expression
+func TestCreateDerivedMeasurementSource(t *testing.T) {
+	metric := conf.MetricConfig{GUID: "3", Measurement: "intensity", Type: "node", Expression: "flops_any / mem_bw"}
+	components := map[string]conf.MetricConfig{
+		"flops_any": {Measurement: "flops_any", Type: "cpu", AggFn: "sum"},
+		"mem_bw":    {Measurement: "mem_bw", Type: "socket", FilterFunc: `|> filter(fn: (r) => r["cluster"] == "a")`},
+	}
+
+	q, err := createDerivedMeasurementSource("bucket", unixRange(1, 2), metric, components, "n1|n2", 30*time.Second)
+	if err != nil {
+		t.Fatalf("Could not create source: %v", err)
+	}
+	for _, part := range []string{
+		`r["_measurement"] == "flops_any")|> filter(fn: (r) => r["type"] == "cpu")|> filter(fn: (r) => r["hostname"] =~ /^(n1|n2)$/)`,
+		`aggregateWindow(every: 30s, fn: sum, createEmpty: false)|> set(key: "_field", value: "flops_any")`,
+		`r["cluster"] == "a")|> toFloat()|> group(columns: ["hostname"])|> aggregateWindow(every: 30s, fn: mean, createEmpty: false)`,
+		`pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+		`filter(fn: (r) => exists r["flops_any"] and exists r["mem_bw"])`,
+		`_measurement: "intensity", _field: "intensity", _value: (if r["mem_bw"] == 0.0 then 0.0 else r["flops_any"] / r["mem_bw"])`,
+	} {
+		if !strings.Contains(q, part) {
+			t.Errorf("Source does not contain %s:\n%s", part, q)
+		}
+	}
+
+	delete(components, "mem_bw")
+	if _, err := createDerivedMeasurementSource("bucket", unixRange(1, 2), metric, components, "", 30*time.Second); err == nil {
+		t.Fatalf("Missing component was accepted")
+	}
+}
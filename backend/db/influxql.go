@@ -0,0 +1,984 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"jobmon/analysis"
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// InfluxQL represents an InfluxDB 1.x server used for storing job performance metric data.
+// It is queried with InfluxQL over the 1.x HTTP API. Instead of aggregation tasks, the per device data
+// of a metric is aggregated to node data on the fly; quantiles and metadata are computed from the
+// queried series. FilterFunc of a metric is an InfluxQL condition, e.g. `"cluster" = 'a'`;
+// PostQueryOp and derived metrics are not supported.
+type InfluxQL struct {
+	// client to communicate with the InfluxDB server
+	client *http.Client
+	// URL of the InfluxDB server
+	host string
+	// Database and optional retention policy of the metrics, configured as "database/retention-policy"
+	database        string
+	retentionPolicy string
+	// Credentials sent as token authorization, "username:password" for InfluxDB 1.x
+	token string
+
+	metrics               map[string]conf.MetricConfig
+	partitionConfig       map[string]conf.PartitionConfig
+	defaultSampleInterval string
+	metricQuantiles       []string
+	// Configuration passed to the job analyzers
+	analysisConfig conf.Configuration
+	// Schedules the queries and limits their duration
+	queryRunner
+}
+
+// influxQLResult is the result of an InfluxQL statement.
+type influxQLResult struct {
+	Series []influxQLSeries `json:"series"`
+	Error  string           `json:"error"`
+}
+
+// influxQLSeries is a series of an InfluxQL result; the first column is the time.
+type influxQLSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]any           `json:"values"`
+}
+
+// Init implements Init method of DB interface.
+func (db *InfluxQL) Init(c conf.Configuration) error {
+	if c.DBHost == "" {
+		return fmt.Errorf("no Influxdb host set")
+	}
+	if c.DBBucket == "" {
+		return fmt.Errorf("no Influxdb database set")
+	}
+	db.client = &http.Client{}
+	db.host = strings.TrimSuffix(c.DBHost, "/")
+	db.database, db.retentionPolicy, _ = strings.Cut(c.DBBucket, "/")
+	db.token = c.DBToken
+	if err := db.ping(); err != nil {
+		return fmt.Errorf("could not reach influxdb: %w", err)
+	}
+	logging.Info("db: Init(): Connected to ", c.DBHost, ", database ", db.database)
+
+	// Metrics
+	db.metrics = make(map[string]conf.MetricConfig)
+	for _, mc := range c.Metrics {
+		db.metrics[mc.GUID] = mc
+	}
+
+	db.partitionConfig = c.Partitions
+	db.defaultSampleInterval = c.SampleInterval
+	db.metricQuantiles = c.MetricQuantiles
+	db.analysisConfig = c
+	db.queryRunner.init(c)
+	return nil
+}
+
+// Close implements Close method of DB interface.
+func (db *InfluxQL) Close() {
+	db.client.CloseIdleConnections()
+}
+
+// GetJobData implements GetJobData method of DB interface.
+func (db *InfluxQL) GetJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	// if no subset of job nodes is selected then use all nodes from NodeList
+	if nodes == "" {
+		nodes = j.NodeList
+	}
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, false)
+}
+
+// GetAggregatedJobData implements GetAggregatedJobData method of DB interface.
+func (db *InfluxQL) GetAggregatedJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	// if no subset of job nodes is selected then use all nodes from NodeList
+	if nodes == "" {
+		nodes = j.NodeList
+	}
+	forceAggregate := true
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, forceAggregate)
+}
+
+// GetJobMetadataMetrics implements GetJobMetadataMetrics method of DB interface.
+func (db *InfluxQL) GetJobMetadataMetrics(ctx context.Context, j *job.JobMetadata) (data []job.JobMetadataData, err error) {
+	// Skip jobs that are still running
+	if j.IsRunning {
+		return data, fmt.Errorf("job is still running")
+	}
+
+	// Skip jobs with stop time before start time
+	if j.StopTime <= j.StartTime {
+		return data, fmt.Errorf("job stop time is less or equal to start")
+	}
+
+	// Computes mean and max values for each metric
+	data, err = db.getMetadataData(ctx, j)
+	if err != nil {
+		return data, err
+	}
+
+	aggData, err := db.getMetadataJobData(ctx, j)
+	if err != nil {
+		return data, err
+	}
+
+	// Run the analyzers enabled for the partition; they store their results in j
+	j.Data = data
+	j.Phases = nil
+	aggData.Metadata = j
+	analyzers := analysis.Enabled(&db.analysisConfig, db.getPartition(j))
+	analysis.Run(ctx, analyzers, &aggData, &db.analysisConfig)
+
+	return j.Data, nil
+}
+
+// GetJobPhases implements GetJobPhases method of DB interface.
+func (db *InfluxQL) GetJobPhases(ctx context.Context, j *job.JobMetadata, c conf.PhaseDetectionConfig) (phases job.JobPhases, err error) {
+	aggData, err := db.getMetadataJobData(ctx, j)
+	if err != nil {
+		return
+	}
+	return analysis.Phases(&aggData, c), nil
+}
+
+// GetMetricDataWithAggFn implements GetMetricDataWithAggFn method of DB interface.
+func (db *InfluxQL) GetMetricDataWithAggFn(ctx context.Context, j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
+	errs := db.runQueries(ctx, 1, func(ctx context.Context, _ int) error {
+		m.AggFn = aggFn
+		series, err := db.querySeries(ctx, m, j.StartTime, j.StopTime, j.NodeList, sampleInterval)
+		if err != nil {
+			logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not get metric data: ", err)
+			return err
+		}
+		data = job.MetricData{
+			Data:   aggregateNodes(series, m),
+			Config: m,
+		}
+		return nil
+	})
+	return data, errs[0]
+}
+
+// RunAggregation implements RunAggregation method of DB interface.
+// There is nothing to run, as node data is aggregated when it is queried.
+func (db *InfluxQL) RunAggregation() {}
+
+// GetAggregationStatus implements GetAggregationStatus method of DB interface.
+// There are no aggregations, as node data is aggregated when it is queried.
+func (db *InfluxQL) GetAggregationStatus(ctx context.Context) ([]AggregationStatus, error) {
+	return []AggregationStatus{}, nil
+}
+
+// BackfillAggregations implements BackfillAggregations method of DB interface.
+func (db *InfluxQL) BackfillAggregations(start time.Time, stop time.Time, names []string) error {
+	return ErrNoAggregation
+}
+
+// CreateLiveMonitoringChannel implements CreateLiveMonitoringChannel method of DB interface.
+func (db *InfluxQL) CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.MetricData, chan bool) {
+	duration, err := time.ParseDuration(db.defaultSampleInterval)
+	if err != nil {
+		duration = 30 * time.Second
+	}
+	monitor := make(chan []job.MetricData)
+	done := make(chan bool)
+	ticker := time.NewTicker(duration)
+
+	liveJ := *j
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				data, err := db.queryLastDatapoints(liveJ, duration)
+				if err != nil {
+					logging.Error("db: CreateLiveMonitoringChannel(): Error getting job data for live monitoring: ", err)
+					continue
+				}
+				monitor <- data
+			case <-done:
+				ticker.Stop()
+				close(done)
+				close(monitor)
+				return
+			}
+		}
+	}()
+	return monitor, done
+}
+
+// ValidateMetrics implements ValidateMetrics method of DB interface.
+func (db *InfluxQL) ValidateMetrics(ctx context.Context, metrics []conf.MetricConfig) conf.ValidationErrors {
+	errs := conf.ValidationErrors{}
+
+	measurements, err := db.getMeasurements(ctx)
+	if err != nil {
+		errs = append(errs, conf.ValidationError{Field: "DBBucket", Message: fmt.Sprintf("could not list measurements: %v", err)})
+	}
+
+	// Run the query of each metric with a condition on a short time range to check that it is valid
+	now := int(time.Now().Unix())
+	for i, m := range metrics {
+		field := fmt.Sprintf("Metrics[%d]", i)
+		if m.IsDerived() {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".Expression",
+				Message: "derived metrics are not supported by InfluxDB 1.x",
+			})
+			continue
+		}
+		if m.PostQueryOp != "" {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".PostQueryOp",
+				Message: "post query operations are not supported by InfluxDB 1.x",
+			})
+		}
+		if m.Measurement != "" && measurements != nil && !measurements[m.Measurement] {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".Measurement",
+				Message: fmt.Sprintf("measurement '%s' does not exist in database %s", m.Measurement, db.database),
+			})
+		}
+		if m.Measurement == "" || m.FilterFunc == "" {
+			continue
+		}
+		if _, err := db.querySeries(ctx, m, now-60, now, "", time.Minute); err != nil {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".FilterFunc",
+				Message: fmt.Sprintf("condition of the metric is not valid InfluxQL: %v", err),
+			})
+		}
+	}
+	return errs
+}
+
+// DiscoverMeasurements implements DiscoverMeasurements method of DB interface.
+func (db *InfluxQL) DiscoverMeasurements(ctx context.Context, lookback time.Duration) (measurements []MeasurementInfo, err error) {
+	start := time.Now()
+
+	names, err := db.getMeasurements(ctx)
+	if err != nil {
+		logging.Error("db: DiscoverMeasurements(): Could not list measurements: ", err)
+		return
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	// The inspection of a measurement is scheduled as one query
+	infos := make([]MeasurementInfo, len(sortedNames))
+	errs := db.runQueries(ctx, len(sortedNames), func(ctx context.Context, i int) error {
+		info, err := db.inspectMeasurement(ctx, sortedNames[i], lookback)
+		if err != nil {
+			logging.Error("db: DiscoverMeasurements(): Could not inspect measurement ", sortedNames[i], ": ", err)
+			return err
+		}
+		infos[i] = info
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for i := range sortedNames {
+		if errs[i] == nil {
+			measurements = append(measurements, infos[i])
+		}
+	}
+
+	logging.Info("db: DiscoverMeasurements() took ", time.Since(start))
+	return measurements, nil
+}
+
+// getJobData returns the data for job j for the given nodes and sampleInterval, see InfluxDB.getJobData.
+func (db *InfluxQL) getJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+	forceAggregate bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	metrics := db.partitionMetrics(j)
+	metricData := make([]job.MetricData, len(metrics))
+	quantileData := make([]job.QuantileData, len(metrics))
+
+	// The metric and quantile data of a metric are computed from the same series
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		metric := metrics[i]
+		series, err := db.querySeries(ctx, metric, j.StartTime, j.StopTime, nodes, sampleInterval)
+		if err != nil {
+			logging.Error("db: getJobData(): Job ", j.Id, ": could not get metric data: ", err)
+			return err
+		}
+		result := metricResult(metric, series, nodes, forceAggregate)
+		if raw {
+			metricData[i] = job.MetricData{Config: metric, RawData: rawCSV(result)}
+		} else {
+			metricData[i] = job.MetricData{Config: metric, Data: result}
+		}
+		if j.IsRunning {
+			return nil
+		}
+
+		// Quantiles are computed over all nodes of the job
+		if nodes != j.NodeList {
+			series, err = db.querySeries(ctx, metric, j.StartTime, j.StopTime, j.NodeList, sampleInterval)
+			if err != nil {
+				logging.Error("db: getJobData(): Job ", j.Id, ": could not get quantile data: ", err)
+				return err
+			}
+		}
+		source := bySeries(series)
+		if j.NumNodes > 1 && metric.AggFn != "" {
+			source = aggregateNodes(series, metric)
+		}
+		quantileData[i] = job.QuantileData{
+			Config:    metric,
+			Data:      quantileSeries(source, db.metricQuantiles, metric.Measurement+"_quant"),
+			Quantiles: db.metricQuantiles,
+		}
+		return nil
+	})
+
+	// Incomplete data of canceled requests must not be cached
+	if err = queriesError(ctx, errs); err != nil {
+		return data, err
+	}
+
+	// return metric and quantile data of the successful queries
+	for i := range metrics {
+		if errs[i] != nil {
+			continue
+		}
+		data.MetricData = append(data.MetricData, metricData[i])
+		if !j.IsRunning {
+			data.QuantileData = append(data.QuantileData, quantileData[i])
+		}
+	}
+	data.Metadata = j
+	return data, nil
+}
+
+// getMetadataData computes mean and max values for each metric of a job j in the order of the partition metrics.
+// The values are computed from the node data for metrics with an aggregation function.
+func (db *InfluxQL) getMetadataData(ctx context.Context, j *job.JobMetadata) (
+	data []job.JobMetadataData,
+	err error,
+) {
+	metrics := db.partitionMetrics(j)
+	metadataData := make([]job.JobMetadataData, len(metrics))
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+		series, err := db.querySeries(ctx, m, j.StartTime, j.StopTime, j.NodeList, db.metricSampleInterval(m))
+		if err != nil {
+			logging.Error("db: getMetadataData(): Job ", j.Id, ": could not get metadata data: ", err)
+			return err
+		}
+		source := bySeries(series)
+		if m.AggFn != "" {
+			source = aggregateNodes(series, m)
+		}
+		metadataData[i] = job.JobMetadataData{Config: m}
+		metadataData[i].Mean, metadataData[i].Max = metadataValues(source)
+		return nil
+	})
+	if err = queriesError(ctx, errs); err != nil {
+		return nil, err
+	}
+	for i := range metrics {
+		if errs[i] == nil {
+			data = append(data, metadataData[i])
+		}
+	}
+	return data, nil
+}
+
+// getMetadataJobData returns the data of all nodes of job j with the sample interval used for the metadata.
+func (db *InfluxQL) getMetadataJobData(ctx context.Context, j *job.JobMetadata) (data job.JobData, err error) {
+	s, err := time.ParseDuration(db.defaultSampleInterval)
+	if err != nil {
+		return
+	}
+	_, interval := j.CalculateSampleIntervals(s)
+
+	// Get aggregated metrics
+	raw := false
+	forceAggregate := true
+	return db.getJobData(ctx, j, j.NodeList, interval, raw, forceAggregate)
+}
+
+// queryLastDatapoints returns the latest data point of each series of the metrics of job j
+// in the order of the partition metrics.
+func (db *InfluxQL) queryLastDatapoints(j job.JobMetadata, sampleInterval time.Duration) (metricData []job.MetricData, err error) {
+	stopTime := int(time.Now().Unix())
+	// Only the last few sample intervals are read
+	startTime := stopTime - int(5*sampleInterval.Seconds())
+	if startTime < j.StartTime {
+		startTime = j.StartTime
+	}
+	metrics := db.partitionMetrics(&j)
+	results := make([]job.MetricData, len(metrics))
+	// Live monitoring is not bound to a request
+	ctx := context.Background()
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+		series, err := db.querySeries(ctx, m, startTime, stopTime, j.NodeList, sampleInterval)
+		if err != nil {
+			logging.Error("db: queryLastDatapoints(): Job ", j.Id, ": could not get last datapoints: ", err)
+			return err
+		}
+		result := metricResult(m, series, j.NodeList, false)
+		for key, rows := range result {
+			result[key] = rows[len(rows)-1:]
+		}
+		results[i] = job.MetricData{Config: m, Data: result}
+		return nil
+	})
+	for i := range metrics {
+		if errs[i] == nil {
+			metricData = append(metricData, results[i])
+		}
+	}
+	return metricData, nil
+}
+
+// inspectMeasurement returns the tags, fields, nodes and sample interval
+// of measurement during the last lookback.
+func (db *InfluxQL) inspectMeasurement(ctx context.Context, measurement string, lookback time.Duration) (info MeasurementInfo, err error) {
+	info.Measurement = measurement
+	name, since := influxQLIdentifier(measurement), influxQLDuration(lookback)
+
+	info.Tags, err = db.queryStrings(ctx, "SHOW TAG KEYS FROM "+name, "tagKey")
+	if err != nil {
+		return
+	}
+	info.Fields, err = db.queryStrings(ctx, "SHOW FIELD KEYS FROM "+name, "fieldKey")
+	if err != nil {
+		return
+	}
+	tagValues := func(tag string) ([]string, error) {
+		return db.queryStrings(ctx,
+			fmt.Sprintf("SHOW TAG VALUES FROM %s WITH KEY = %s WHERE time > now() - %s", name, influxQLIdentifier(tag), since),
+			"value")
+	}
+	if slices.Contains(info.Tags, "type") {
+		info.Types, err = tagValues("type")
+		if err != nil {
+			return
+		}
+	}
+	info.Hostnames, err = tagValues("hostname")
+	if err != nil {
+		return
+	}
+	sort.Strings(info.Tags)
+	sort.Strings(info.Fields)
+	sort.Strings(info.Types)
+	sort.Strings(info.Hostnames)
+
+	// The sample interval is the median of the average intervals of the series
+	counts, err := db.query(ctx, fmt.Sprintf(InfluxQLCountQuery, name, since))
+	if err != nil {
+		return
+	}
+	intervals := []float64{}
+	for _, s := range counts {
+		for _, row := range s.rows("count_", 0) {
+			if count := row["_value"].(float64); count > 0 {
+				intervals = append(intervals, lookback.Seconds()/count)
+			}
+		}
+	}
+	if len(intervals) > 0 {
+		sort.Float64s(intervals)
+		info.SampleInterval = (time.Duration(math.Round(intervals[len(intervals)/2])) * time.Second).String()
+	}
+
+	last, err := db.query(ctx, fmt.Sprintf(InfluxQLLastQuery, name, since))
+	if err != nil {
+		return
+	}
+	for _, s := range last {
+		for _, row := range s.rows("last_", 0) {
+			if t := row["_time"].(time.Time); t.After(info.LastSeen) {
+				info.LastSeen = t
+			}
+		}
+	}
+	return info, nil
+}
+
+// getMeasurements returns the set of measurements in the database.
+func (db *InfluxQL) getMeasurements(ctx context.Context) (map[string]bool, error) {
+	names, err := db.queryStrings(ctx, "SHOW MEASUREMENTS", "name")
+	if err != nil {
+		return nil, err
+	}
+	measurements := make(map[string]bool)
+	for _, name := range names {
+		measurements[name] = true
+	}
+	return measurements, nil
+}
+
+// querySeries returns the series of the mean values of metric in windows of sampleInterval
+// between startTime and stopTime on nodes. The rows contain the tags of their series.
+func (db *InfluxQL) querySeries(
+	ctx context.Context,
+	metric conf.MetricConfig,
+	startTime int, stopTime int,
+	nodes string,
+	sampleInterval time.Duration,
+) (
+	series [][]job.QueryResult,
+	err error,
+) {
+	if metric.IsDerived() {
+		return nil, fmt.Errorf("derived metric %s is not supported by InfluxDB 1.x", metric.GUID)
+	}
+	statement, err := createInfluxQLQuery(startTime, stopTime, metric.Measurement, metric.Type, nodes, sampleInterval, metric.FilterFunc)
+	if err != nil {
+		return
+	}
+	result, err := db.query(ctx, statement)
+	if err != nil {
+		logging.Error("db: querySeries(): Error at query '", statement, "': ", err)
+		return
+	}
+	for _, s := range result {
+		// Windows are labeled by their stop time like the results of the Flux queries
+		if rows := s.rows("mean_", sampleInterval); len(rows) > 0 {
+			series = append(series, rows)
+		}
+	}
+	return series, nil
+}
+
+// queryStrings runs statement and returns the string values of column, e.g. of SHOW statements.
+func (db *InfluxQL) queryStrings(ctx context.Context, statement string, column string) (values []string, err error) {
+	result, err := db.query(ctx, statement)
+	if err != nil {
+		return
+	}
+	for _, s := range result {
+		i := slices.Index(s.Columns, column)
+		if i < 0 {
+			continue
+		}
+		for _, v := range s.Values {
+			if value, ok := v[i].(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// query runs the InfluxQL statement and returns the series of its result. Times are in Unix milliseconds.
+func (db *InfluxQL) query(ctx context.Context, statement string) ([]influxQLSeries, error) {
+	logging.Debug("db: query(): InfluxQL statement = ", statement)
+	form := url.Values{"db": {db.database}, "q": {statement}, "epoch": {"ms"}}
+	if db.retentionPolicy != "" {
+		form.Set("rp", db.retentionPolicy)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, db.host+"/query", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	db.authorize(req)
+	resp, err := db.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Results []influxQLResult `json:"results"`
+		Error   string           `json:"error"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("influxdb responded with status %s", resp.Status)
+		}
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	if len(response.Results) == 0 {
+		return nil, nil
+	}
+	if response.Results[0].Error != "" {
+		return nil, fmt.Errorf("%s", response.Results[0].Error)
+	}
+	return response.Results[0].Series, nil
+}
+
+// ping checks that the InfluxDB server is reachable.
+func (db *InfluxQL) ping() error {
+	req, err := http.NewRequest(http.MethodGet, db.host+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	db.authorize(req)
+	resp, err := db.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("influxdb responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// authorize adds the credentials to req.
+func (db *InfluxQL) authorize(req *http.Request) {
+	if db.token != "" {
+		req.Header.Set("Authorization", "Token "+db.token)
+	}
+}
+
+// getPartition returns a partition configuration for job j.
+func (db *InfluxQL) getPartition(j *job.JobMetadata) conf.BasePartitionConfig {
+	return db.partitionConfig[j.Partition].ForNodes(strings.Split(j.NodeList, "|"))
+}
+
+// partitionMetrics returns the configurations of the metrics of the partition of job j in their configured order.
+func (db *InfluxQL) partitionMetrics(j *job.JobMetadata) []conf.MetricConfig {
+	guids := db.getPartition(j).Metrics
+	metrics := make([]conf.MetricConfig, len(guids))
+	for i, guid := range guids {
+		metrics[i] = db.metrics[guid]
+	}
+	return metrics
+}
+
+// metricSampleInterval returns the sample interval of metric m.
+func (db *InfluxQL) metricSampleInterval(m conf.MetricConfig) time.Duration {
+	sampleInterval := m.SampleInterval
+	if sampleInterval == "" {
+		sampleInterval = db.defaultSampleInterval
+	}
+	d, err := time.ParseDuration(sampleInterval)
+	if err != nil {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// rows returns the rows of series s with the tags of s as columns. The values are read from the column of
+// the field "value" with the given prefix, e.g. "mean_" for "mean(*)", or the first column with the prefix.
+// Rows without value are skipped and times are shifted by shift.
+func (s influxQLSeries) rows(prefix string, shift time.Duration) []job.QueryResult {
+	column := slices.Index(s.Columns, prefix+"value")
+	if column < 0 {
+		column = slices.IndexFunc(s.Columns, func(c string) bool { return strings.HasPrefix(c, prefix) })
+	}
+	if column < 1 {
+		return nil
+	}
+	field := strings.TrimPrefix(s.Columns[column], prefix)
+
+	rows := make([]job.QueryResult, 0, len(s.Values))
+	for _, v := range s.Values {
+		ms, err := toNumber(v[0])
+		value, err2 := toNumber(v[column])
+		if err != nil || err2 != nil {
+			continue
+		}
+		row := job.QueryResult{
+			"_time":        time.UnixMilli(int64(ms)).Add(shift).UTC(),
+			"_value":       value,
+			"_measurement": s.Name,
+			"_field":       field,
+		}
+		for tag, tagValue := range s.Tags {
+			row[tag] = tagValue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// toNumber returns the JSON number v as float64.
+func toNumber(v any) (float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("'%v' is not a number", v)
+	}
+	return n.Float64()
+}
+
+// metricResult returns the series of metric separated like the results of InfluxDB.query: by the separation key
+// of metric for data of a single node, otherwise by hostname with the per device data aggregated to node data.
+func metricResult(metric conf.MetricConfig, series [][]job.QueryResult, nodes string, forceAggregate bool) map[string][]job.QueryResult {
+	if numNodes := strings.Count(nodes, "|") + 1; numNodes == 1 && !forceAggregate {
+		return separate(series, metric.SeparationKey)
+	}
+	if metric.Type != "node" {
+		return aggregateNodes(series, metric)
+	}
+	return separate(series, "hostname")
+}
+
+// separate returns the rows of series separated by the value of column key.
+// Rows of series with the same key are merged in time order.
+func separate(series [][]job.QueryResult, key string) map[string][]job.QueryResult {
+	result := make(map[string][]job.QueryResult)
+	merged := make(map[string]bool)
+	for _, rows := range series {
+		k, _ := rows[0][key].(string)
+		if _, ok := result[k]; ok {
+			merged[k] = true
+		}
+		result[k] = append(result[k], rows...)
+	}
+	for k := range merged {
+		rows := result[k]
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i]["_time"].(time.Time).Before(rows[j]["_time"].(time.Time))
+		})
+	}
+	return result
+}
+
+// bySeries returns series as map with a key per series.
+func bySeries(series [][]job.QueryResult) map[string][]job.QueryResult {
+	result := make(map[string][]job.QueryResult, len(series))
+	for i, rows := range series {
+		result[strconv.Itoa(i)] = rows
+	}
+	return result
+}
+
+// aggregateNodes aggregates the values of series per node and time with the aggregation function of metric,
+// like the aggregation tasks of InfluxDB 2, e.g. the sum over all sockets of a node. The mean is used if
+// metric has no aggregation function. The result is separated by hostname.
+func aggregateNodes(series [][]job.QueryResult, metric conf.MetricConfig) map[string][]job.QueryResult {
+	aggFn := metric.AggFn
+	if aggFn == "" {
+		aggFn = "mean"
+	}
+	measurement := metric.Measurement + "_" + aggFn
+
+	type point struct {
+		value float64
+		count int
+	}
+	nodes := make(map[string]map[time.Time]*point)
+	for _, rows := range series {
+		for _, row := range rows {
+			host, _ := row["hostname"].(string)
+			t := row["_time"].(time.Time)
+			v := row["_value"].(float64)
+			if nodes[host] == nil {
+				nodes[host] = make(map[time.Time]*point)
+			}
+			p, ok := nodes[host][t]
+			if !ok {
+				nodes[host][t] = &point{value: v, count: 1}
+				continue
+			}
+			switch aggFn {
+			case "min":
+				p.value = math.Min(p.value, v)
+			case "max":
+				p.value = math.Max(p.value, v)
+			default:
+				p.value += v
+			}
+			p.count++
+		}
+	}
+
+	result := make(map[string][]job.QueryResult, len(nodes))
+	for host, points := range nodes {
+		rows := make([]job.QueryResult, 0, len(points))
+		for t, p := range points {
+			value := p.value
+			if aggFn == "mean" {
+				value /= float64(p.count)
+			}
+			rows = append(rows, job.QueryResult{
+				"_time":        t,
+				"_value":       value,
+				"_measurement": measurement,
+				"_field":       measurement,
+				"hostname":     host,
+			})
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i]["_time"].(time.Time).Before(rows[j]["_time"].(time.Time))
+		})
+		result[host] = rows
+	}
+	return result
+}
+
+// quantileSeries returns for each quantile of quantiles the series of the quantile of the values of all series
+// of data at each time. The result is separated by quantile like the results of the Flux quantile queries.
+func quantileSeries(data map[string][]job.QueryResult, quantiles []string, measurement string) map[string][]job.QueryResult {
+	values := make(map[time.Time][]float64)
+	for _, rows := range data {
+		for _, row := range rows {
+			t := row["_time"].(time.Time)
+			values[t] = append(values[t], row["_value"].(float64))
+		}
+	}
+	times := make([]time.Time, 0, len(values))
+	for t := range values {
+		times = append(times, t)
+		sort.Float64s(values[t])
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	result := make(map[string][]job.QueryResult, len(quantiles))
+	for _, q := range quantiles {
+		p, err := strconv.ParseFloat(q, 64)
+		if err != nil {
+			continue
+		}
+		rows := make([]job.QueryResult, len(times))
+		for i, t := range times {
+			rows[i] = job.QueryResult{
+				"_time":        t,
+				"_value":       quantile(values[t], p),
+				"_measurement": measurement,
+				"_field":       q,
+			}
+		}
+		result[q] = rows
+	}
+	return result
+}
+
+// quantile returns the quantile p of the sorted values, interpolated linearly between the closest values.
+func quantile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// metadataValues returns the mean of the means of the series of data and, as robust maximum,
+// the median of the five highest values, like the Flux metadata query.
+func metadataValues(data map[string][]job.QueryResult) (mean float64, max float64) {
+	means := []float64{}
+	all := []float64{}
+	for _, rows := range data {
+		sum := 0.0
+		for _, row := range rows {
+			v := row["_value"].(float64)
+			sum += v
+			all = append(all, v)
+		}
+		if len(rows) > 0 {
+			means = append(means, sum/float64(len(rows)))
+		}
+	}
+	if len(means) == 0 {
+		return 0, 0
+	}
+	for _, m := range means {
+		mean += m
+	}
+	mean /= float64(len(means))
+
+	sort.Sort(sort.Reverse(sort.Float64Slice(all)))
+	if len(all) > 5 {
+		all = all[:5]
+	}
+	sort.Float64s(all)
+	return mean, quantile(all, 0.5)
+}
+
+// rawCSV returns data as CSV with a header line. The columns are the time, value, measurement, field
+// and the tags in alphabetical order; the rows are ordered by key of data and time.
+func rawCSV(data map[string][]job.QueryResult) string {
+	columns := []string{"_time", "_value", "_measurement", "_field"}
+	tags := map[string]bool{}
+	keys := make([]string, 0, len(data))
+	for key, rows := range data {
+		keys = append(keys, key)
+		for _, row := range rows {
+			for column := range row {
+				if !slices.Contains(columns, column) {
+					tags[column] = true
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	tagColumns := make([]string, 0, len(tags))
+	for tag := range tags {
+		tagColumns = append(tagColumns, tag)
+	}
+	sort.Strings(tagColumns)
+	columns = append(columns, tagColumns...)
+
+	sb := new(strings.Builder)
+	w := csv.NewWriter(sb)
+	w.Write(columns)
+	for _, key := range keys {
+		for _, row := range data[key] {
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				switch v := row[column].(type) {
+				case time.Time:
+					record[i] = v.Format(time.RFC3339)
+				case float64:
+					record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+				case string:
+					record[i] = v
+				}
+			}
+			w.Write(record)
+		}
+	}
+	w.Flush()
+	return sb.String()
+}
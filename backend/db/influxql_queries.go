@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// influxQLIdentifier returns name as quoted InfluxQL identifier, e.g. of a measurement or tag.
+func influxQLIdentifier(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
+
+// influxQLString returns s as InfluxQL string literal.
+func influxQLString(s string) string {
+	return `'` + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + `'`
+}
+
+// influxQLDuration returns d as InfluxQL duration literal.
+func influxQLDuration(d time.Duration) string {
+	s := fluxDuration(d)
+	// InfluxQL uses "u" for microseconds; the other units equal the Flux units
+	if strings.HasSuffix(s, "us") {
+		return strings.TrimSuffix(s, "s")
+	}
+	return s
+}
+
+// influxQLTagCondition returns a condition that is true if tag has one of values.
+func influxQLTagCondition(tag string, values []string) string {
+	conditions := make([]string, len(values))
+	for i, v := range values {
+		conditions[i] = influxQLIdentifier(tag) + " = " + influxQLString(v)
+	}
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// createInfluxQLQuery creates an InfluxQL query that selects the mean of all fields of measurement
+// per series in windows of duration sampleInterval. The query contains:
+// * a condition on the time range between StartTime (inclusive) and StopTime (exclusive)
+// * an optional condition on the type
+// * a condition on nodes / host names
+// * an optional additional condition of the metric, e.g. `"cluster" = 'a'`
+// Each series of the result is identified by all tags of the measurement.
+func createInfluxQLQuery(
+	StartTime int, StopTime int,
+	measurement string,
+	metricType string,
+	nodes string,
+	sampleInterval time.Duration,
+	metricFilter string,
+) (q string, err error) {
+	if measurement == "" {
+		return "", fmt.Errorf("missing measurement configuration")
+	}
+	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
+		return "", fmt.Errorf("wrong start time = %d, stop time = %d", StartTime, StopTime)
+	}
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, `SELECT mean(*) FROM %s`, influxQLIdentifier(measurement))
+	fmt.Fprintf(sb, ` WHERE time >= %ds AND time < %ds`, StartTime, StopTime)
+	if metricType != "" {
+		fmt.Fprintf(sb, ` AND %s`, influxQLTagCondition("type", []string{metricType}))
+	}
+	if nodes != "" {
+		fmt.Fprintf(sb, ` AND %s`, influxQLTagCondition("hostname", strings.Split(nodes, "|")))
+	}
+	if metricFilter != "" {
+		fmt.Fprintf(sb, ` AND (%s)`, metricFilter)
+	}
+	fmt.Fprintf(sb, ` GROUP BY time(%s), * fill(none)`, influxQLDuration(sampleInterval))
+	return sb.String(), nil
+}
+
+// Parameters: measurement as identifier, lookback as duration literal
+// Returns the number of data points per series and field
+const InfluxQLCountQuery = `SELECT count(*) FROM %v WHERE time > now() - %v GROUP BY *`
+
+// Parameters: measurement as identifier, lookback as duration literal
+// Returns the time of the latest data point
+const InfluxQLLastQuery = `SELECT last(*) FROM %v WHERE time > now() - %v`
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	conf "jobmon/config"
+	"jobmon/job"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxQLStandIn is a local stand-in for the HTTP API of InfluxDB 1.x that answers
+// queries of a measurement with fixed series
+type influxQLStandIn struct {
+	lock       sync.Mutex
+	statements []string
+	// Series per measurement; measurements without series return an error
+	series map[string][]influxQLSeries
+}
+
+func (s *influxQLStandIn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Token user:secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path == "/ping" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.FormValue("db") != "metrics" || req.FormValue("rp") != "autogen" || req.FormValue("epoch") != "ms" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	statement := req.FormValue("q")
+	s.lock.Lock()
+	s.statements = append(s.statements, statement)
+	s.lock.Unlock()
+
+	result := influxQLResult{Error: "measurement not found"}
+	for measurement, series := range s.series {
+		if !strings.Contains(statement, "FROM "+influxQLIdentifier(measurement)+" ") {
+			continue
+		}
+		// Only the series of the selected hosts are returned
+		result = influxQLResult{Series: []influxQLSeries{}}
+		for _, s := range series {
+			if !strings.Contains(statement, `"hostname" = `) ||
+				strings.Contains(statement, `"hostname" = `+influxQLString(s.Tags["hostname"])) {
+				result.Series = append(result.Series, s)
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"results": []influxQLResult{result}})
+}
+
+// cpuSeries returns the series of a cpu of host with values at the seconds 0 and 60
+func cpuSeries(host string, cpu string, v0 float64, v1 float64) influxQLSeries {
+	return influxQLSeries{
+		Name:    "flops_any",
+		Tags:    map[string]string{"hostname": host, "type": "cpu", "type-id": cpu},
+		Columns: []string{"time", "mean_value"},
+		Values:  [][]any{{json.Number("0"), json.Number(fmt.Sprint(v0))}, {json.Number("60000"), json.Number(fmt.Sprint(v1))}},
+	}
+}
+
+// newInfluxQLTest returns an initialized InfluxQL connected to a stand-in
+func newInfluxQLTest(t *testing.T) (*InfluxQL, *influxQLStandIn) {
+	standIn := &influxQLStandIn{series: map[string][]influxQLSeries{
+		"flops_any": {
+			cpuSeries("n1", "0", 1, 2),
+			cpuSeries("n1", "1", 3, 4),
+			cpuSeries("n2", "0", 5, 6),
+			cpuSeries("n2", "1", 7, 8),
+		},
+	}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	c := conf.Configuration{
+		DBConfig:        conf.DBConfig{DBType: "influxdb1", DBHost: server.URL + "/", DBToken: "user:secret", DBBucket: "metrics/autogen"},
+		SampleInterval:  "1m",
+		MetricQuantiles: []string{"0", "0.5", "1"},
+		Metrics: []conf.MetricConfig{
+			{GUID: "mem", Measurement: "mem_bw", Type: "node", SeparationKey: "hostname"},
+			{GUID: "flops", Measurement: "flops_any", Type: "cpu", AggFn: "sum", SeparationKey: "type-id"},
+		},
+		Partitions: map[string]conf.PartitionConfig{
+			"p": {BasePartitionConfig: conf.BasePartitionConfig{Metrics: []string{"flops", "mem"}}},
+		},
+	}
+	db := New(c).(*InfluxQL)
+	db.Init(c)
+	return db, standIn
+}
+
+// values returns the times in seconds and values of rows
+func values(rows []job.QueryResult) (times []int64, values []float64) {
+	for _, row := range rows {
+		times = append(times, row["_time"].(time.Time).Unix())
+		values = append(values, row["_value"].(float64))
+	}
+	return
+}
+
+// Tests if job data is aggregated per node, separated and complemented with quantiles
+func TestInfluxQLJobData(t *testing.T) {
+	db, standIn := newInfluxQLTest(t)
+	j := &job.JobMetadata{Id: 1, Partition: "p", NodeList: "n1|n2", NumNodes: 2, StartTime: 0, StopTime: 120}
+
+	data, err := db.GetJobData(context.Background(), j, "", time.Minute, false)
+	if err != nil {
+		t.Fatalf("Could not get job data: %v", err)
+	}
+	// The metric without data is skipped
+	if len(data.MetricData) != 1 || len(data.QuantileData) != 1 || data.MetricData[0].Config.GUID != "flops" {
+		t.Fatalf("Wrong metrics %+v", data.MetricData)
+	}
+	times, sums := values(data.MetricData[0].Data["n2"])
+	if len(times) != 2 || times[0] != 60 || times[1] != 120 || sums[0] != 12 || sums[1] != 14 {
+		t.Fatalf("Wrong node data %v %v", times, sums)
+	}
+	_, medians := values(data.QuantileData[0].Data["0.5"])
+	_, maxima := values(data.QuantileData[0].Data["1"])
+	if len(medians) != 2 || medians[0] != 8 || maxima[1] != 14 {
+		t.Fatalf("Wrong quantiles %v %v", medians, maxima)
+	}
+
+	// Data of a single node is separated by the separation key of the metric
+	data, err = db.GetJobData(context.Background(), j, "n1", time.Minute, false)
+	if err != nil {
+		t.Fatalf("Could not get job data: %v", err)
+	}
+	if _, cpu1 := values(data.MetricData[0].Data["1"]); len(cpu1) != 2 || cpu1[0] != 3 {
+		t.Fatalf("Wrong cpu data %v", data.MetricData[0].Data)
+	}
+
+	data, err = db.GetJobData(context.Background(), j, "", time.Minute, true)
+	if err != nil || !strings.HasPrefix(data.MetricData[0].RawData, "_time,_value,_measurement,_field,hostname\n") {
+		t.Fatalf("Wrong raw data %q: %v", data.MetricData[0].RawData, err)
+	}
+
+	metadata, err := db.getMetadataData(context.Background(), j)
+	if err != nil || len(metadata) != 1 || metadata[0].Mean != 9 || metadata[0].Max != 9 {
+		t.Fatalf("Wrong metadata %+v: %v", metadata, err)
+	}
+
+	for _, statement := range standIn.statements {
+		if strings.Contains(statement, "flops_any") &&
+			!strings.Contains(statement, `WHERE time >= 0s AND time < 120s AND "type" = 'cpu' AND ("hostname" = 'n1' OR "hostname" = 'n2') GROUP BY time(1m), * fill(none)`) &&
+			!strings.Contains(statement, `"hostname" = 'n1' GROUP BY`) {
+			t.Errorf("Unexpected statement %s", statement)
+		}
+	}
+}
+
+// Tests if identifiers, literals and conditions are escaped in statements
+func TestInfluxQLQueries(t *testing.T) {
+	q, err := createInfluxQLQuery(1000, 2000, `cpu"load`, "", `n1|n'2`, 1500*time.Millisecond, `"cluster" = 'a'`)
+	if err != nil {
+		t.Fatalf("Could not create query: %v", err)
+	}
+	expected := `SELECT mean(*) FROM "cpu\"load" WHERE time >= 1000s AND time < 2000s AND ("hostname" = 'n1' OR "hostname" = 'n\'2') AND ("cluster" = 'a') GROUP BY time(1500ms), * fill(none)`
+	if q != expected {
+		t.Fatalf("Wrong query:\n%s\ninstead of\n%s", q, expected)
+	}
+	if _, err := createInfluxQLQuery(2000, 1000, "cpu_load", "", "n1", time.Minute, ""); err == nil {
+		t.Fatalf("Invalid time range was accepted")
+	}
+	if d := influxQLDuration(1500 * time.Microsecond); d != "1500u" {
+		t.Fatalf("Wrong duration %s", d)
+	}
+}
+
+// Tests if metrics that cannot be queried with InfluxQL are reported
+func TestInfluxQLValidateMetrics(t *testing.T) {
+	db, _ := newInfluxQLTest(t)
+	errs := db.ValidateMetrics(context.Background(), []conf.MetricConfig{
+		{GUID: "a", Measurement: "flops_any", FilterFunc: `"cluster" = 'a'`},
+		{GUID: "b", Measurement: "intensity", Expression: "flops_any / mem_bw"},
+		{GUID: "c", Measurement: "flops_any", PostQueryOp: "|> last()"},
+	})
+	fields := make(map[string]bool)
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	if len(errs) != 3 || !fields["Metrics[1].Expression"] || !fields["Metrics[2].PostQueryOp"] || !fields["DBBucket"] {
+		t.Fatalf("Wrong problems %v", errs)
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+	"errors"
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+	"sync"
+	"time"
+)
+
+// Reloadable is a DB that can be re-initialized while it is in use. Init creates and connects a new
+// database next to the current one and replaces it only if that succeeded, so requests never
+// see a partially initialized database. The replaced database is closed after the calls that were
+// running on it returned. All databases share one query scheduler, so the concurrency limit also
+// holds while a replaced database finishes its queries. The created aggregations and their backfills
+// are also shared; running backfills continue on the replacing database.
+// The zero value is ready to be initialized with Init.
+type Reloadable struct {
+	mut     sync.RWMutex
+	current *reloadableBackend
+	// Scheduler of the queries of all databases
+	queries *scheduler
+	// Aggregations of all databases
+	aggregations *aggregationState
+}
+
+// scheduledDB is implemented by the databases whose queries are scheduled by a scheduler.
+type scheduledDB interface {
+	useScheduler(s *scheduler)
+}
+
+// reloadableBackend is a database of Reloadable together with the calls running on it.
+type reloadableBackend struct {
+	DB
+	// Held for reading by the running calls and for writing when the database is closed
+	running sync.RWMutex
+}
+
+// Init implements Init method of DB interface. The current database is kept if the new
+// database cannot be initialized.
+func (r *Reloadable) Init(c conf.Configuration) error {
+	r.mut.Lock()
+	if r.queries == nil {
+		r.queries = newScheduler(defaultQueryConcurrency)
+		r.aggregations = newAggregationState(r.backfill)
+	}
+	queries, aggregations := r.queries, r.aggregations
+	r.mut.Unlock()
+
+	next := &reloadableBackend{DB: New(c)}
+	if db, ok := next.DB.(scheduledDB); ok {
+		db.useScheduler(queries)
+	}
+	if db, ok := next.DB.(aggregatingDB); ok {
+		db.useAggregationState(aggregations)
+	}
+	if err := next.Init(c); err != nil {
+		return err
+	}
+
+	r.mut.Lock()
+	old := r.current
+	r.current = next
+	r.mut.Unlock()
+
+	if old != nil {
+		go old.close()
+	}
+	return nil
+}
+
+// Close implements Close method of DB interface. Unlike a replaced database, the
+// current database is closed without waiting for the running calls.
+func (r *Reloadable) Close() {
+	r.mut.Lock()
+	old := r.current
+	r.current = nil
+	r.mut.Unlock()
+
+	if old != nil {
+		old.DB.Close()
+	}
+}
+
+// close closes the database after the running calls returned.
+func (b *reloadableBackend) close() {
+	b.running.Lock()
+	defer b.running.Unlock()
+	b.DB.Close()
+	logging.Info("db: close(): Closed replaced database")
+}
+
+// acquire returns the current database; release must be called when the call on it returned.
+func (r *Reloadable) acquire() *reloadableBackend {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	if r.current == nil {
+		logging.Fatal("db: acquire(): Database is not initialized")
+	}
+	r.current.running.RLock()
+	return r.current
+}
+
+// release marks a call on b as returned.
+func (b *reloadableBackend) release() {
+	b.running.RUnlock()
+}
+
+// GetJobData implements GetJobData method of DB interface.
+func (r *Reloadable) GetJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (data job.JobData, err error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetJobData(ctx, j, nodes, sampleInterval, raw)
+}
+
+// GetJobMetadataMetrics implements GetJobMetadataMetrics method of DB interface.
+func (r *Reloadable) GetJobMetadataMetrics(ctx context.Context, j *job.JobMetadata) (data []job.JobMetadataData, err error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetJobMetadataMetrics(ctx, j)
+}
+
+// GetJobPhases implements GetJobPhases method of DB interface.
+func (r *Reloadable) GetJobPhases(ctx context.Context, j *job.JobMetadata, c conf.PhaseDetectionConfig) (phases job.JobPhases, err error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetJobPhases(ctx, j, c)
+}
+
+// GetAggregatedJobData implements GetAggregatedJobData method of DB interface.
+func (r *Reloadable) GetAggregatedJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (data job.JobData, err error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetAggregatedJobData(ctx, j, nodes, sampleInterval, raw)
+}
+
+// GetMetricDataWithAggFn implements GetMetricDataWithAggFn method of DB interface.
+func (r *Reloadable) GetMetricDataWithAggFn(
+	ctx context.Context,
+	j *job.JobMetadata,
+	m conf.MetricConfig,
+	aggFn string,
+	sampleInterval time.Duration,
+) (data job.MetricData, err error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetMetricDataWithAggFn(ctx, j, m, aggFn, sampleInterval)
+}
+
+// ValidateMetrics implements ValidateMetrics method of DB interface.
+func (r *Reloadable) ValidateMetrics(ctx context.Context, metrics []conf.MetricConfig) conf.ValidationErrors {
+	b := r.acquire()
+	defer b.release()
+	return b.ValidateMetrics(ctx, metrics)
+}
+
+// DiscoverMeasurements implements DiscoverMeasurements method of DB interface.
+func (r *Reloadable) DiscoverMeasurements(ctx context.Context, lookback time.Duration) ([]MeasurementInfo, error) {
+	b := r.acquire()
+	defer b.release()
+	return b.DiscoverMeasurements(ctx, lookback)
+}
+
+// RunAggregation implements RunAggregation method of DB interface.
+func (r *Reloadable) RunAggregation() {
+	b := r.acquire()
+	defer b.release()
+	b.RunAggregation()
+}
+
+// GetAggregationStatus implements GetAggregationStatus method of DB interface.
+func (r *Reloadable) GetAggregationStatus(ctx context.Context) ([]AggregationStatus, error) {
+	b := r.acquire()
+	defer b.release()
+	return b.GetAggregationStatus(ctx)
+}
+
+// BackfillAggregations implements BackfillAggregations method of DB interface.
+// Backfills that are still running when the database is replaced fail and have to be restarted.
+func (r *Reloadable) BackfillAggregations(start time.Time, stop time.Time, names []string) error {
+	b := r.acquire()
+	defer b.release()
+	return b.BackfillAggregations(start, stop, names)
+}
+
+// backfill aggregates the historical data of aggregation name between start and stop with the current
+// database, so backfills started on a replaced database continue on the database that replaced it.
+func (r *Reloadable) backfill(ctx context.Context, name string, start time.Time, stop time.Time) error {
+	r.mut.RLock()
+	b := r.current
+	if b != nil {
+		b.running.RLock()
+	}
+	r.mut.RUnlock()
+	if b == nil {
+		return errors.New("database is closed")
+	}
+	defer b.release()
+
+	db, ok := b.DB.(aggregatingDB)
+	if !ok {
+		return ErrNoAggregation
+	}
+	return db.backfill(ctx, name, start, stop)
+}
+
+// CreateLiveMonitoringChannel implements CreateLiveMonitoringChannel method of DB interface.
+// The database of the channel is kept open until the channel is closed.
+func (r *Reloadable) CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.MetricData, chan bool) {
+	b := r.acquire()
+	monitor, done := b.CreateLiveMonitoringChannel(j)
+
+	forward := make(chan []job.MetricData)
+	go func() {
+		defer b.release()
+		for data := range monitor {
+			forward <- data
+		}
+		close(forward)
+	}()
+	return forward, done
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	conf "jobmon/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Tests if the database is only replaced by databases that could be initialized
+// and if replaced databases are closed after their running calls returned
+func TestReloadable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	c := conf.Configuration{DBConfig: conf.DBConfig{DBType: "influxdb1", DBHost: server.URL, DBBucket: "telegraf"}}
+
+	var r Reloadable
+	if err := r.Init(c); err != nil {
+		t.Fatalf("Could not initialize database: %v", err)
+	}
+	first := r.current
+
+	unreachable := c
+	unreachable.DBHost = "http://127.0.0.1:1"
+	if err := r.Init(unreachable); err == nil {
+		t.Fatalf("Unreachable database was accepted")
+	}
+	if r.current != first {
+		t.Fatalf("Database was replaced by an unreachable database")
+	}
+
+	// A running call keeps the replaced database open
+	replaced := &closeRecorder{closed: make(chan bool)}
+	r.current = &reloadableBackend{DB: replaced}
+	running := r.acquire()
+	if err := r.Init(c); err != nil {
+		t.Fatalf("Could not initialize database: %v", err)
+	}
+	if r.current.DB == replaced {
+		t.Fatalf("Database was not replaced")
+	}
+	select {
+	case <-replaced.closed:
+		t.Fatalf("Replaced database was closed while a call was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	running.release()
+	select {
+	case <-replaced.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Replaced database was not closed")
+	}
+}
+
+// Tests if the query limit holds for the queries of replaced databases
+func TestReloadableQueryLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	c := conf.Configuration{
+		DBConfig:         conf.DBConfig{DBType: "influxdb1", DBHost: server.URL, DBBucket: "telegraf"},
+		QueryConcurrency: 1,
+	}
+
+	var r Reloadable
+	if err := r.Init(c); err != nil {
+		t.Fatalf("Could not initialize database: %v", err)
+	}
+	first := r.current.DB.(*InfluxQL).scheduler
+	if err := first.acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("Could not start query: %v", err)
+	}
+
+	// The query of the replaced database is still running
+	if err := r.Init(c); err != nil {
+		t.Fatalf("Could not initialize database: %v", err)
+	}
+	second := r.current.DB.(*InfluxQL).scheduler
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := second.acquire(ctx, "b"); err == nil {
+		t.Fatalf("Query limit was exceeded after the database was replaced")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- second.acquire(context.Background(), "b")
+	}()
+	waitForQueued(t, second, 1)
+	first.release()
+	if err := <-done; err != nil {
+		t.Fatalf("Query was not started after the running query returned: %v", err)
+	}
+	second.release()
+}
+
+// Tests if a running backfill continues on the database that replaced the one it was started on
+// and if its status is kept
+func TestReloadableBackfill(t *testing.T) {
+	var r Reloadable
+	r.aggregations = newAggregationState(r.backfill)
+	replaced := &backfillRecorder{entered: make(chan bool), block: make(chan bool)}
+	r.current = &reloadableBackend{DB: replaced}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.aggregations.run([]string{"a"}, start, start.Add(3*backfillWindow), backfillWindow)
+
+	// The database is replaced while the first window is aggregated
+	<-replaced.entered
+	replacing := &backfillRecorder{}
+	r.mut.Lock()
+	r.current = &reloadableBackend{DB: replacing}
+	r.mut.Unlock()
+	close(replaced.block)
+
+	for status := r.aggregations.get("a"); !status.Finished; status = r.aggregations.get("a") {
+		time.Sleep(time.Millisecond)
+	}
+	if status := r.aggregations.get("a"); status.Error != "" || !status.Done.Equal(start.Add(3*backfillWindow)) {
+		t.Fatalf("Wrong backfill %+v", status)
+	}
+	if len(replaced.windows) != 1 || len(replacing.windows) != 2 || !replacing.windows[0].Equal(start.Add(backfillWindow)) {
+		t.Fatalf("Wrong windows of replaced database %v and replacing database %v", replaced.windows, replacing.windows)
+	}
+}
+
+// backfillRecorder is a DB that records the start of the backfilled windows.
+type backfillRecorder struct {
+	DB
+	// Signaled when the backfill of a window starts, which then waits until block is closed; unused if nil
+	entered chan bool
+	block   chan bool
+	windows []time.Time
+}
+
+func (b *backfillRecorder) useAggregationState(a *aggregationState) {}
+
+func (b *backfillRecorder) backfill(ctx context.Context, name string, start time.Time, stop time.Time) error {
+	if b.entered != nil {
+		b.entered <- true
+		<-b.block
+	}
+	b.windows = append(b.windows, start)
+	return nil
+}
+
+// closeRecorder is a DB that records if it was closed.
+type closeRecorder struct {
+	DB
+	closed chan bool
+}
+
+func (c *closeRecorder) Close() {
+	close(c.closed)
+}
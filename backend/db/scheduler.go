@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"errors"
+	conf "jobmon/config"
+	"jobmon/utils"
+	"sync"
+	"time"
+)
+
+// Number of concurrent InfluxDB queries if not configured
+const defaultQueryConcurrency = 16
+
+// scheduler limits the number of concurrent queries. Waiting queries are started round robin
+// per user, so users with many queries, e.g. for long jobs with many metrics, do not delay
+// the queries of other users.
+type scheduler struct {
+	mut     sync.Mutex
+	limit   int
+	running int
+	// Waiting queries per user in the order they were scheduled
+	queues map[string][]chan struct{}
+	// Users with waiting queries in the order they are served
+	users []string
+}
+
+// newScheduler returns a scheduler running up to limit queries at once.
+func newScheduler(limit int) *scheduler {
+	return &scheduler{limit: limit, queues: make(map[string][]chan struct{})}
+}
+
+// setLimit changes the number of concurrent queries; running queries are not affected.
+func (s *scheduler) setLimit(limit int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.limit = limit
+	s.dispatch()
+}
+
+// acquire blocks until a query of user may start or ctx is done.
+// Each successful acquire must be followed by a release.
+func (s *scheduler) acquire(ctx context.Context, user string) error {
+	s.mut.Lock()
+	if s.running < s.limit && len(s.users) == 0 {
+		s.running++
+		s.mut.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if len(s.queues[user]) == 0 {
+		s.users = append(s.users, user)
+	}
+	s.queues[user] = append(s.queues[user], ready)
+	s.mut.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		select {
+		case <-ready:
+			// Started at the same time, pass the slot on
+			s.running--
+			s.dispatch()
+		default:
+			s.dequeue(user, ready)
+		}
+		return ctx.Err()
+	}
+}
+
+// release ends a query started by acquire.
+func (s *scheduler) release() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.running--
+	s.dispatch()
+}
+
+// dispatch starts waiting queries while below the limit, taking the first query of each user in turn.
+func (s *scheduler) dispatch() {
+	for s.running < s.limit && len(s.users) > 0 {
+		user := s.users[0]
+		s.users = s.users[1:]
+		queue := s.queues[user]
+		close(queue[0])
+		s.running++
+		if len(queue) > 1 {
+			s.queues[user] = queue[1:]
+			s.users = append(s.users, user)
+		} else {
+			delete(s.queues, user)
+		}
+	}
+}
+
+// dequeue removes the waiting query ready of user.
+func (s *scheduler) dequeue(user string, ready chan struct{}) {
+	queue := s.queues[user]
+	for i, q := range queue {
+		if q == ready {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		s.queues[user] = queue
+		return
+	}
+	delete(s.queues, user)
+	for i, u := range s.users {
+		if u == user {
+			s.users = append(s.users[:i:i], s.users[i+1:]...)
+			break
+		}
+	}
+}
+
+// queryRunner schedules the queries of a DB implementation and limits their duration.
+type queryRunner struct {
+	// Maximum duration of a query; no limit if 0
+	queryTimeout time.Duration
+	// Limits the number of concurrent queries; shared with the databases that replace
+	// or are replaced by this one, see useScheduler
+	scheduler *scheduler
+}
+
+// useScheduler schedules the queries with s, so databases that are replaced while their
+// queries are running share the concurrency limit and the queues of the users.
+func (db *queryRunner) useScheduler(s *scheduler) {
+	db.scheduler = s
+}
+
+// init applies the query timeout and concurrency of configuration c.
+// A scheduler set by useScheduler is kept and gets the concurrency of c as limit.
+func (db *queryRunner) init(c conf.Configuration) {
+	// The timeout was checked by Validate
+	db.queryTimeout, _ = time.ParseDuration(c.QueryTimeout)
+	queryConcurrency := c.QueryConcurrency
+	if queryConcurrency <= 0 {
+		queryConcurrency = defaultQueryConcurrency
+	}
+	if db.scheduler == nil {
+		db.scheduler = newScheduler(queryConcurrency)
+	} else {
+		db.scheduler.setLimit(queryConcurrency)
+	}
+}
+
+// queryContext returns ctx limited by the query timeout. The returned context must be
+// kept until the query result is read.
+func (db *queryRunner) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// runQueries calls fn for the indices 0 to n-1 concurrently, each call scheduled as a query of the user of ctx
+// (see utils.WithUser) and limited by the query timeout. Results must be stored by index, so they keep the order of the inputs.
+// The errors of the calls are returned by index.
+func (db *queryRunner) runQueries(ctx context.Context, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	user := utils.UserOf(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.scheduler.acquire(ctx, user); err != nil {
+				errs[i] = err
+				return
+			}
+			defer db.scheduler.release()
+			ctx, cancel := db.queryContext(ctx)
+			defer cancel()
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// queriesError returns the error of queries with the errors errs: the error of ctx if it is done,
+// the joined errors if all queries failed and nil if some data could be read.
+func queriesError(ctx context.Context, errs []error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"jobmon/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForQueued waits until n queries are waiting in scheduler s
+func waitForQueued(t *testing.T, s *scheduler, n int) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		s.mut.Lock()
+		queued := 0
+		for _, queue := range s.queues {
+			queued += len(queue)
+		}
+		s.mut.Unlock()
+		if queued == n {
+			return
+		}
+	}
+	t.Fatalf("Queries were not queued")
+}
+
+// Tests if waiting queries are started round robin per user
+func TestSchedulerFairness(t *testing.T) {
+	s := newScheduler(1)
+	if err := s.acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("Could not acquire: %v", err)
+	}
+
+	var lock sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	schedule := func(user string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.acquire(context.Background(), user); err != nil {
+				t.Errorf("Could not acquire: %v", err)
+				return
+			}
+			lock.Lock()
+			order = append(order, user)
+			lock.Unlock()
+			s.release()
+		}()
+	}
+	// User a queues many queries before user b
+	for i := 0; i < 3; i++ {
+		schedule("a")
+		waitForQueued(t, s, i+1)
+	}
+	schedule("b")
+	waitForQueued(t, s, 4)
+
+	s.release()
+	wg.Wait()
+	expected := []string{"a", "b", "a", "a"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Wrong order %v", order)
+		}
+	}
+}
+
+// Tests if the number of concurrent queries is limited and canceled queries leave the queue
+func TestSchedulerLimit(t *testing.T) {
+	s := newScheduler(2)
+	for i := 0; i < 2; i++ {
+		if err := s.acquire(context.Background(), "a"); err != nil {
+			t.Fatalf("Could not acquire: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.acquire(ctx, "b")
+	}()
+	waitForQueued(t, s, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Waiting query was not canceled: %v", err)
+	}
+	if len(s.queues) != 0 || len(s.users) != 0 || s.running != 2 {
+		t.Fatalf("Canceled query was not removed: %+v", s)
+	}
+
+	go func() {
+		done <- s.acquire(context.Background(), "b")
+	}()
+	waitForQueued(t, s, 1)
+	s.setLimit(3)
+	if err := <-done; err != nil {
+		t.Fatalf("Query was not started after raising the limit: %v", err)
+	}
+}
+
+// Tests if the errors of runQueries are returned by index and aggregated
+func TestRunQueries(t *testing.T) {
+	db := &queryRunner{scheduler: newScheduler(2)}
+	ctx := utils.WithUser(context.Background(), "a")
+	results := make([]int, 10)
+	errs := db.runQueries(ctx, len(results), func(ctx context.Context, i int) error {
+		if utils.UserOf(ctx) != "a" {
+			t.Errorf("User of query is missing")
+		}
+		if i%3 == 0 {
+			return errors.New("failed")
+		}
+		results[i] = i
+		return nil
+	})
+	for i := range results {
+		if (errs[i] != nil) != (i%3 == 0) || (errs[i] == nil && results[i] != i) {
+			t.Fatalf("Wrong result %d of query %d: %v", results[i], i, errs[i])
+		}
+	}
+	if err := queriesError(ctx, errs); err != nil {
+		t.Fatalf("Partial results were rejected: %v", err)
+	}
+	if err := queriesError(ctx, errs[:1]); err == nil {
+		t.Fatalf("Failed queries were accepted")
+	}
+	if s := db.scheduler; s.running != 0 {
+		t.Fatalf("Queries were not released")
+	}
+}
@@ -0,0 +1 @@
+CREATE MATERIALIZED VIEW IF NOT EXISTS "flops_any_mean" WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS SELECT time_bucket(INTERVAL '30 seconds', time) AS time, hostname, avg(value) AS value FROM "flops_any" WHERE "type" = 'cpu' AND (cluster = 'a') GROUP BY time_bucket(INTERVAL '30 seconds', time), hostname WITH NO DATA
\ No newline at end of file
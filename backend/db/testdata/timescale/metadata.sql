@@ -0,0 +1 @@
+WITH data AS (SELECT "hostname", "type", "type-id", value FROM "flops_any" WHERE time >= to_timestamp(1000) AND time < to_timestamp(2000) AND "type" = 'cpu' AND hostname IN ('n1', 'n2') AND (cluster = 'a')) SELECT (SELECT avg(mean) FROM (SELECT avg(value) AS mean FROM data GROUP BY "hostname", "type", "type-id") AS means) AS mean, (SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY value) FROM (SELECT value FROM data ORDER BY value DESC LIMIT 5) AS top) AS max
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT add_continuous_aggregate_policy('"flops_any_sum"', start_offset => INTERVAL '3600 seconds', end_offset => INTERVAL '30 seconds', schedule_interval => INTERVAL '30 seconds', if_not_exists => true)
\ No newline at end of file
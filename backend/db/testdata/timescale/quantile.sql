@@ -0,0 +1 @@
+SELECT time_bucket(INTERVAL '60 seconds', time) + INTERVAL '60 seconds' AS time, percentile_cont(ARRAY[0, 0.25, 0.5, 1]::double precision[]) WITHIN GROUP (ORDER BY value) AS value FROM "flops_any_sum" WHERE time >= to_timestamp(1000) AND time < to_timestamp(2000) AND hostname IN ('n1', 'n2') GROUP BY 1 ORDER BY 1
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT time_bucket(INTERVAL '30 seconds', time) + INTERVAL '30 seconds' AS time, "hostname", "type", "type-id", avg(value) AS value FROM "flops_any" WHERE time >= to_timestamp(1000) AND time < to_timestamp(2000) AND "type" = 'cpu' AND hostname IN ('n1', 'n2') AND (cluster = 'a') GROUP BY 1, "hostname", "type", "type-id" ORDER BY "hostname", "type", "type-id", 1
\ No newline at end of file
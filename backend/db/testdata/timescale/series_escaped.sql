@@ -0,0 +1 @@
+SELECT time_bucket(INTERVAL '1.5 seconds', time) + INTERVAL '1.5 seconds' AS time, "hostname", avg(value) AS value FROM "flops""; DROP TABLE jobs; --" WHERE time >= to_timestamp(1000) AND time < to_timestamp(2000) AND hostname IN ('n1', 'n''2') GROUP BY 1, "hostname" ORDER BY "hostname", 1
\ No newline at end of file
@@ -0,0 +1,941 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"jobmon/analysis"
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/extra/bundebug"
+	"golang.org/x/exp/slices"
+)
+
+// TimescaleDB represents a PostgreSQL database with the TimescaleDB extension used for storing job performance
+// metric data. Each measurement is a hypertable with the columns time, hostname, type, type-id and value.
+// Continuous aggregates named like the aggregated measurements in InfluxDB, e.g. "flops_any_sum", replace the
+// aggregation tasks and hold the node data with the columns time, hostname and value.
+// FilterFunc of a metric is an SQL condition, e.g. `cluster = 'a'`; PostQueryOp and derived metrics are not supported.
+type TimescaleDB struct {
+	// Connection pool of the database
+	db *bun.DB
+	// Name of the database
+	database string
+
+	metrics               map[string]conf.MetricConfig
+	partitionConfig       map[string]conf.PartitionConfig
+	defaultSampleInterval string
+	metricQuantiles       []string
+	// Configuration passed to the job analyzers
+	analysisConfig conf.Configuration
+	// Schedules the queries and limits their duration
+	queryRunner
+
+	// Created continuous aggregates and their backfills; shared with replaced databases, see useAggregationState
+	aggregations *aggregationState
+	// Number of the reconciliation of the continuous aggregates started by Init
+	reconciliation int
+}
+
+// Init implements Init method of DB interface.
+func (db *TimescaleDB) Init(c conf.Configuration) error {
+	if c.DBHost == "" {
+		return fmt.Errorf("no TimescaleDB host set")
+	}
+	if c.DBBucket == "" {
+		return fmt.Errorf("no TimescaleDB database set")
+	}
+	db.database = c.DBBucket
+	username, password, _ := strings.Cut(c.DBToken, ":")
+	psqldb :=
+		sql.OpenDB(
+			pgdriver.NewConnector(
+				pgdriver.WithNetwork("tcp"),
+				pgdriver.WithAddr(c.DBHost),
+				pgdriver.WithTLSConfig(&tls.Config{InsecureSkipVerify: true}),
+				pgdriver.WithInsecure(true),
+				pgdriver.WithUser(username),
+				pgdriver.WithPassword(password),
+				pgdriver.WithDatabase(db.database),
+				pgdriver.WithApplicationName("jobmon-backend"),
+			),
+		)
+	db.db = bun.NewDB(psqldb, pgdialect.New())
+
+	// Verify connection to database
+	if err := db.db.Ping(); err != nil {
+		db.db.Close()
+		return fmt.Errorf("could not connect to TimescaleDB: %w", err)
+	}
+	logging.Info("db: Init(): Connected to ", c.DBHost, ", database ", db.database)
+
+	// Allow SQL statement debugging
+	db.db.AddQueryHook(bundebug.NewQueryHook(
+		bundebug.WithVerbose(false),
+		bundebug.FromEnv("BUNDEBUG"),
+	))
+
+	// Metrics
+	db.metrics = make(map[string]conf.MetricConfig)
+	for _, mc := range c.Metrics {
+		db.metrics[mc.GUID] = mc
+	}
+
+	db.partitionConfig = c.Partitions
+	db.defaultSampleInterval = c.SampleInterval
+	db.metricQuantiles = c.MetricQuantiles
+	db.analysisConfig = c
+	db.queryRunner.init(c)
+	if db.aggregations == nil {
+		db.aggregations = newAggregationState(db.backfill)
+	}
+	db.reconciliation = db.aggregations.nextReconciliation()
+
+	go db.updateContinuousAggregates()
+	return nil
+}
+
+// Close implements Close method of DB interface.
+func (db *TimescaleDB) Close() {
+	db.db.Close()
+}
+
+// GetJobData implements GetJobData method of DB interface.
+func (db *TimescaleDB) GetJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	// if no subset of job nodes is selected then use all nodes from NodeList
+	if nodes == "" {
+		nodes = j.NodeList
+	}
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, false)
+}
+
+// GetAggregatedJobData implements GetAggregatedJobData method of DB interface.
+func (db *TimescaleDB) GetAggregatedJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	// if no subset of job nodes is selected then use all nodes from NodeList
+	if nodes == "" {
+		nodes = j.NodeList
+	}
+	forceAggregate := true
+	return db.getJobData(ctx, j, nodes, sampleInterval, raw, forceAggregate)
+}
+
+// GetJobMetadataMetrics implements GetJobMetadataMetrics method of DB interface.
+func (db *TimescaleDB) GetJobMetadataMetrics(ctx context.Context, j *job.JobMetadata) (data []job.JobMetadataData, err error) {
+	// Skip jobs that are still running
+	if j.IsRunning {
+		return data, fmt.Errorf("job is still running")
+	}
+
+	// Skip jobs with stop time before start time
+	if j.StopTime <= j.StartTime {
+		return data, fmt.Errorf("job stop time is less or equal to start")
+	}
+
+	// Computes mean and max values for each metric
+	data, err = db.getMetadataData(ctx, j)
+	if err != nil {
+		return data, err
+	}
+
+	aggData, err := db.getMetadataJobData(ctx, j)
+	if err != nil {
+		return data, err
+	}
+
+	// Run the analyzers enabled for the partition; they store their results in j
+	j.Data = data
+	j.Phases = nil
+	aggData.Metadata = j
+	analyzers := analysis.Enabled(&db.analysisConfig, db.getPartition(j))
+	analysis.Run(ctx, analyzers, &aggData, &db.analysisConfig)
+
+	return j.Data, nil
+}
+
+// GetJobPhases implements GetJobPhases method of DB interface.
+func (db *TimescaleDB) GetJobPhases(ctx context.Context, j *job.JobMetadata, c conf.PhaseDetectionConfig) (phases job.JobPhases, err error) {
+	aggData, err := db.getMetadataJobData(ctx, j)
+	if err != nil {
+		return
+	}
+	return analysis.Phases(&aggData, c), nil
+}
+
+// GetMetricDataWithAggFn implements GetMetricDataWithAggFn method of DB interface.
+func (db *TimescaleDB) GetMetricDataWithAggFn(ctx context.Context, j *job.JobMetadata, m conf.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
+	errs := db.runQueries(ctx, 1, func(ctx context.Context, _ int) error {
+		m.AggFn = aggFn
+		series, err := db.querySeries(ctx, continuousAggregateName(m, aggFn), []string{"hostname"}, "",
+			j.StartTime, j.StopTime, j.NodeList, sampleInterval, "")
+		if err != nil {
+			logging.Error("db: GetMetricDataWithAggFn(): Job ", j.Id, ": could not get metric data: ", err)
+			return err
+		}
+		data = job.MetricData{
+			Data:   separate(series, "hostname"),
+			Config: m,
+		}
+		return nil
+	})
+	return data, errs[0]
+}
+
+// RunAggregation implements RunAggregation method of DB interface.
+// It refreshes all continuous aggregates over the complete time range.
+func (db *TimescaleDB) RunAggregation() {
+	for name := range db.continuousAggregates() {
+		go func(name string) {
+			statement := fmt.Sprintf("CALL refresh_continuous_aggregate(%s, NULL, NULL)", timescaleString(timescaleIdentifier(name)))
+			if _, err := db.db.ExecContext(context.Background(), statement); err != nil {
+				logging.Error("db: RunAggregation(): Failed to refresh continuous aggregate ", name, ": ", err)
+			}
+		}(name)
+	}
+}
+
+// GetAggregationStatus implements GetAggregationStatus method of DB interface.
+// The last run and its error are the ones of the refresh policy of the continuous aggregate.
+func (db *TimescaleDB) GetAggregationStatus(ctx context.Context) (status []AggregationStatus, err error) {
+	status = []AggregationStatus{}
+	for name := range db.continuousAggregates() {
+		status = append(status, AggregationStatus{
+			Name:        name,
+			Measurement: name,
+			Status:      "missing",
+			Backfill:    db.aggregations.get(name),
+		})
+	}
+	sortAggregationStatus(status)
+
+	errs := db.runQueries(ctx, 1, func(ctx context.Context, _ int) error {
+		rows, err := db.db.QueryContext(ctx, TimescaleAggregationStatusQuery)
+		if err != nil {
+			logging.Error("db: GetAggregationStatus(): Could not get status of continuous aggregates: ", err)
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			var scheduled sql.NullBool
+			var lastRun sql.NullTime
+			var lastRunStatus, lastError sql.NullString
+			if err := rows.Scan(&name, &scheduled, &lastRun, &lastRunStatus, &lastError); err != nil {
+				return err
+			}
+			i := sort.Search(len(status), func(i int) bool { return status[i].Name >= name })
+			if i == len(status) || status[i].Name != name {
+				continue
+			}
+			status[i].Status = "inactive"
+			if scheduled.Bool {
+				status[i].Status = "active"
+			}
+			if lastRun.Valid {
+				status[i].LastRun = lastRun.Time.UTC()
+			}
+			status[i].LastRunStatus = strings.ToLower(lastRunStatus.String)
+			status[i].LastError = lastError.String
+		}
+		return rows.Err()
+	})
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	return status, nil
+}
+
+// BackfillAggregations implements BackfillAggregations method of DB interface.
+// The continuous aggregates are refreshed for the historical data, which TimescaleDB materializes in batches.
+func (db *TimescaleDB) BackfillAggregations(start time.Time, stop time.Time, names []string) error {
+	aggregates := db.continuousAggregates()
+	if len(names) == 0 {
+		names = db.aggregations.pending(func(name string) bool { return aggregates[name] })
+	}
+	for _, name := range names {
+		if !aggregates[name] {
+			return fmt.Errorf("unknown continuous aggregate '%s'", name)
+		}
+	}
+	db.aggregations.run(names, start, stop, 0)
+	return nil
+}
+
+// backfill refreshes the continuous aggregate name for the data between start and stop.
+func (db *TimescaleDB) backfill(ctx context.Context, name string, start time.Time, stop time.Time) error {
+	if !db.continuousAggregates()[name] {
+		return fmt.Errorf("unknown continuous aggregate '%s'", name)
+	}
+	statement := fmt.Sprintf(TimescaleRefreshQuery, timescaleString(timescaleIdentifier(name)), start.Unix(), stop.Unix())
+	if _, err := db.db.ExecContext(ctx, statement); err != nil {
+		logging.Error("db: backfill(): Failed to refresh continuous aggregate ", name, ": ", err)
+		return err
+	}
+	return nil
+}
+
+// useAggregationState tracks the created continuous aggregates and their backfills with a.
+func (db *TimescaleDB) useAggregationState(a *aggregationState) {
+	db.aggregations = a
+}
+
+// CreateLiveMonitoringChannel implements CreateLiveMonitoringChannel method of DB interface.
+func (db *TimescaleDB) CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.MetricData, chan bool) {
+	duration, err := time.ParseDuration(db.defaultSampleInterval)
+	if err != nil {
+		duration = 30 * time.Second
+	}
+	monitor := make(chan []job.MetricData)
+	done := make(chan bool)
+	ticker := time.NewTicker(duration)
+
+	liveJ := *j
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				data, err := db.queryLastDatapoints(liveJ, duration)
+				if err != nil {
+					logging.Error("db: CreateLiveMonitoringChannel(): Error getting job data for live monitoring: ", err)
+					continue
+				}
+				monitor <- data
+			case <-done:
+				ticker.Stop()
+				close(done)
+				close(monitor)
+				return
+			}
+		}
+	}()
+	return monitor, done
+}
+
+// ValidateMetrics implements ValidateMetrics method of DB interface.
+func (db *TimescaleDB) ValidateMetrics(ctx context.Context, metrics []conf.MetricConfig) conf.ValidationErrors {
+	errs := conf.ValidationErrors{}
+
+	measurements, err := db.getMeasurements(ctx)
+	if err != nil {
+		errs = append(errs, conf.ValidationError{Field: "DBBucket", Message: fmt.Sprintf("could not list hypertables: %v", err)})
+	}
+
+	// Run the query of each metric with a condition on a short time range to check that it is valid
+	now := int(time.Now().Unix())
+	for i, m := range metrics {
+		field := fmt.Sprintf("Metrics[%d]", i)
+		if m.IsDerived() {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".Expression",
+				Message: "derived metrics are not supported by TimescaleDB",
+			})
+			continue
+		}
+		if m.PostQueryOp != "" {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".PostQueryOp",
+				Message: "post query operations are not supported by TimescaleDB",
+			})
+		}
+		if m.Measurement != "" && measurements != nil && !measurements[m.Measurement] {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".Measurement",
+				Message: fmt.Sprintf("hypertable '%s' does not exist in database %s", m.Measurement, db.database),
+			})
+			continue
+		}
+		if m.Measurement == "" || m.FilterFunc == "" {
+			continue
+		}
+		if _, err := db.querySeries(ctx, m.Measurement, timescaleTags, m.Type, now-60, now, "", time.Minute, m.FilterFunc); err != nil {
+			errs = append(errs, conf.ValidationError{
+				Field:   field + ".FilterFunc",
+				Message: fmt.Sprintf("condition of the metric is not valid SQL: %v", err),
+			})
+		}
+	}
+	return errs
+}
+
+// DiscoverMeasurements implements DiscoverMeasurements method of DB interface.
+func (db *TimescaleDB) DiscoverMeasurements(ctx context.Context, lookback time.Duration) (measurements []MeasurementInfo, err error) {
+	start := time.Now()
+
+	names, err := db.getMeasurements(ctx)
+	if err != nil {
+		logging.Error("db: DiscoverMeasurements(): Could not list hypertables: ", err)
+		return
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	// The inspection of a measurement is scheduled as one query
+	infos := make([]MeasurementInfo, len(sortedNames))
+	errs := db.runQueries(ctx, len(sortedNames), func(ctx context.Context, i int) error {
+		info, err := db.inspectMeasurement(ctx, sortedNames[i], lookback)
+		if err != nil {
+			logging.Error("db: DiscoverMeasurements(): Could not inspect measurement ", sortedNames[i], ": ", err)
+			return err
+		}
+		infos[i] = info
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for i := range sortedNames {
+		if errs[i] == nil {
+			measurements = append(measurements, infos[i])
+		}
+	}
+
+	logging.Info("db: DiscoverMeasurements() took ", time.Since(start))
+	return measurements, nil
+}
+
+// getJobData returns the data for job j for the given nodes and sampleInterval, see InfluxDB.getJobData.
+func (db *TimescaleDB) getJobData(
+	ctx context.Context,
+	j *job.JobMetadata,
+	nodes string,
+	sampleInterval time.Duration,
+	raw bool,
+	forceAggregate bool,
+) (
+	data job.JobData,
+	err error,
+) {
+	metrics := db.partitionMetrics(j)
+	metricData := make([]job.MetricData, len(metrics))
+	quantileData := make([]job.QuantileData, len(metrics))
+
+	// Query metric data and, for finished jobs, quantiles
+	numQueries := len(metrics)
+	if !j.IsRunning {
+		numQueries *= 2
+	}
+	errs := db.runQueries(ctx, numQueries, func(ctx context.Context, i int) error {
+		if i >= len(metrics) {
+			metric := metrics[i-len(metrics)]
+			result, err := db.queryQuantiles(ctx, metric, j, sampleInterval)
+			if err != nil {
+				logging.Error("db: getJobData(): Job ", j.Id, ": could not get quantile data: ", err)
+				return err
+			}
+			quantileData[i-len(metrics)] = job.QuantileData{
+				Config:    metric,
+				Data:      result,
+				Quantiles: db.metricQuantiles,
+			}
+			return nil
+		}
+
+		metric := metrics[i]
+		result, err := db.query(ctx, metric, j.StartTime, j.StopTime, nodes, sampleInterval, forceAggregate)
+		if err != nil {
+			logging.Error("db: getJobData(): Job ", j.Id, ": could not get metric data: ", err)
+			return err
+		}
+		if raw {
+			metricData[i] = job.MetricData{Config: metric, RawData: rawCSV(result)}
+		} else {
+			metricData[i] = job.MetricData{Config: metric, Data: result}
+		}
+		return nil
+	})
+
+	// Incomplete data of canceled requests must not be cached
+	if err = queriesError(ctx, errs); err != nil {
+		return data, err
+	}
+
+	// return metric and quantile data of the successful queries
+	for i := range metrics {
+		if errs[i] == nil {
+			data.MetricData = append(data.MetricData, metricData[i])
+		}
+		if !j.IsRunning && errs[len(metrics)+i] == nil {
+			data.QuantileData = append(data.QuantileData, quantileData[i])
+		}
+	}
+	data.Metadata = j
+	return data, nil
+}
+
+// getMetadataData computes mean and max values for each metric of a job j in the order of the partition metrics.
+// The values are computed from the continuous aggregate for metrics with an aggregation function.
+func (db *TimescaleDB) getMetadataData(ctx context.Context, j *job.JobMetadata) (
+	data []job.JobMetadataData,
+	err error,
+) {
+	metrics := db.partitionMetrics(j)
+	metadataData := make([]job.JobMetadataData, len(metrics))
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+		table, tags, metricType, filter := m.Measurement, timescaleTags, m.Type, m.FilterFunc
+		if m.AggFn != "" {
+			table, tags, metricType, filter = continuousAggregateName(m, m.AggFn), []string{"hostname"}, "", ""
+		}
+		query, err := createTimescaleMetadataQuery(j.StartTime, j.StopTime, table, tags, metricType, j.NodeList, filter)
+		if err != nil {
+			logging.Error("db: getMetadataData(): Could not create metadata query: ", err)
+			return err
+		}
+
+		// Values stay zero in the case metadata is missing
+		var mean, max sql.NullFloat64
+		if err := db.db.QueryRowContext(ctx, query).Scan(&mean, &max); err != nil {
+			logging.Error("db: getMetadataData(): Job ", j.Id, ": error at metadata query '", query, "': ", err)
+			return err
+		}
+		metadataData[i] = job.JobMetadataData{Config: m, Mean: mean.Float64, Max: max.Float64}
+		return nil
+	})
+	if err = queriesError(ctx, errs); err != nil {
+		return nil, err
+	}
+	for i := range metrics {
+		if errs[i] == nil {
+			data = append(data, metadataData[i])
+		}
+	}
+	return data, nil
+}
+
+// getMetadataJobData returns the data of all nodes of job j with the sample interval used for the metadata.
+func (db *TimescaleDB) getMetadataJobData(ctx context.Context, j *job.JobMetadata) (data job.JobData, err error) {
+	s, err := time.ParseDuration(db.defaultSampleInterval)
+	if err != nil {
+		return
+	}
+	_, interval := j.CalculateSampleIntervals(s)
+
+	// Get aggregated metrics
+	raw := false
+	forceAggregate := true
+	return db.getJobData(ctx, j, j.NodeList, interval, raw, forceAggregate)
+}
+
+// queryLastDatapoints returns the latest data point of each series of the metrics of job j
+// in the order of the partition metrics.
+func (db *TimescaleDB) queryLastDatapoints(j job.JobMetadata, sampleInterval time.Duration) (metricData []job.MetricData, err error) {
+	stopTime := int(time.Now().Unix())
+	// Only the last few sample intervals are read
+	startTime := stopTime - int(5*sampleInterval.Seconds())
+	if startTime < j.StartTime {
+		startTime = j.StartTime
+	}
+	metrics := db.partitionMetrics(&j)
+	results := make([]job.MetricData, len(metrics))
+	// Live monitoring is not bound to a request
+	ctx := context.Background()
+	errs := db.runQueries(ctx, len(metrics), func(ctx context.Context, i int) error {
+		m := metrics[i]
+		result, err := db.query(ctx, m, startTime, stopTime, j.NodeList, sampleInterval, false)
+		if err != nil {
+			logging.Error("db: queryLastDatapoints(): Job ", j.Id, ": could not get last datapoints: ", err)
+			return err
+		}
+		for key, rows := range result {
+			result[key] = rows[len(rows)-1:]
+		}
+		results[i] = job.MetricData{Config: m, Data: result}
+		return nil
+	})
+	for i := range metrics {
+		if errs[i] == nil {
+			metricData = append(metricData, results[i])
+		}
+	}
+	return metricData, nil
+}
+
+// query returns the data of metric between startTime and stopTime on nodes separated like the results of
+// InfluxDB.query: by the separation key of metric for data of a single node, otherwise by hostname with
+// the node data read from the continuous aggregate of the aggregation function of metric.
+func (db *TimescaleDB) query(
+	ctx context.Context,
+	metric conf.MetricConfig,
+	startTime int, stopTime int,
+	nodes string,
+	sampleInterval time.Duration,
+	forceAggregate bool,
+) (
+	result map[string][]job.QueryResult,
+	err error,
+) {
+	start := time.Now()
+	if metric.IsDerived() {
+		return nil, fmt.Errorf("derived metric %s is not supported by TimescaleDB", metric.GUID)
+	}
+
+	// If only one node is specified, always return detailed data, never aggregated data
+	if numNodes := strings.Count(nodes, "|") + 1; numNodes == 1 && !forceAggregate {
+		series, err := db.querySeries(ctx, metric.Measurement, timescaleTags, metric.Type,
+			startTime, stopTime, nodes, sampleInterval, metric.FilterFunc)
+		if err != nil {
+			return nil, err
+		}
+		result = separate(series, metric.SeparationKey)
+	} else if metric.Type != "node" && metric.AggFn != "" {
+		series, err := db.querySeries(ctx, continuousAggregateName(metric, metric.AggFn), []string{"hostname"}, "",
+			startTime, stopTime, nodes, sampleInterval, "")
+		if err != nil {
+			return nil, err
+		}
+		result = separate(series, "hostname")
+	} else {
+		series, err := db.querySeries(ctx, metric.Measurement, timescaleTags, metric.Type,
+			startTime, stopTime, nodes, sampleInterval, metric.FilterFunc)
+		if err != nil {
+			return nil, err
+		}
+		result = metricResult(metric, series, nodes, forceAggregate)
+	}
+
+	logging.Info("db: query for metric ", metric.GUID, " took ", time.Since(start))
+	return result, nil
+}
+
+// queryQuantiles returns the quantiles of the values of metric for job j separated by quantile like the results
+// of the Flux quantile queries. The node data is used for jobs with more than one node if metric has an
+// aggregation function.
+func (db *TimescaleDB) queryQuantiles(
+	ctx context.Context,
+	metric conf.MetricConfig,
+	j *job.JobMetadata,
+	sampleInterval time.Duration,
+) (
+	result map[string][]job.QueryResult,
+	err error,
+) {
+	table, metricType, filter := metric.Measurement, metric.Type, metric.FilterFunc
+	if j.NumNodes > 1 && metric.AggFn != "" {
+		table, metricType, filter = continuousAggregateName(metric, metric.AggFn), "", ""
+	}
+	query, err := createTimescaleQuantileQuery(j.StartTime, j.StopTime, table, metricType, j.NodeList,
+		sampleInterval, filter, db.metricQuantiles)
+	if err != nil {
+		logging.Error("db: queryQuantiles(): Could not create quantile query: ", err)
+		return
+	}
+	rows, err := db.db.QueryContext(ctx, query)
+	if err != nil {
+		logging.Error("db: queryQuantiles(): Error at quantile query '", query, "': ", err)
+		return
+	}
+	defer rows.Close()
+
+	result = make(map[string][]job.QueryResult, len(db.metricQuantiles))
+	for rows.Next() {
+		var t time.Time
+		var values []float64
+		if err = rows.Scan(&t, pgdialect.Array(&values)); err != nil {
+			return nil, err
+		}
+		for i, q := range db.metricQuantiles {
+			if i >= len(values) {
+				break
+			}
+			result[q] = append(result[q], job.QueryResult{
+				"_time":        t.UTC(),
+				"_value":       values[i],
+				"_measurement": metric.Measurement + "_quant",
+				"_field":       q,
+			})
+		}
+	}
+	return result, rows.Err()
+}
+
+// querySeries returns the series of the mean values of table in buckets of sampleInterval between startTime
+// and stopTime on nodes. Each series is identified by the tag columns tags, which the rows contain.
+func (db *TimescaleDB) querySeries(
+	ctx context.Context,
+	table string,
+	tags []string,
+	metricType string,
+	startTime int, stopTime int,
+	nodes string,
+	sampleInterval time.Duration,
+	metricFilter string,
+) (
+	series [][]job.QueryResult,
+	err error,
+) {
+	query, err := createTimescaleSeriesQuery(startTime, stopTime, table, tags, metricType, nodes, sampleInterval, metricFilter)
+	if err != nil {
+		return
+	}
+	rows, err := db.db.QueryContext(ctx, query)
+	if err != nil {
+		logging.Error("db: querySeries(): Error at query '", query, "': ", err)
+		return
+	}
+	defer rows.Close()
+
+	// Rows are ordered by series, so a new series starts when a tag changes
+	var previous []sql.NullString
+	for rows.Next() {
+		var t time.Time
+		var value sql.NullFloat64
+		values := make([]sql.NullString, len(tags))
+		dest := []any{&t}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(append(dest, &value)...); err != nil {
+			return nil, err
+		}
+		if !value.Valid {
+			continue
+		}
+		row := job.QueryResult{
+			"_time":        t.UTC(),
+			"_value":       value.Float64,
+			"_measurement": table,
+			"_field":       "value",
+		}
+		for i, tag := range tags {
+			if values[i].Valid {
+				row[tag] = values[i].String
+			}
+		}
+		if len(series) == 0 || !slices.Equal(values, previous) {
+			series = append(series, nil)
+		}
+		series[len(series)-1] = append(series[len(series)-1], row)
+		previous = values
+	}
+	return series, rows.Err()
+}
+
+// inspectMeasurement returns the columns, types, nodes and sample interval
+// of the hypertable of measurement during the last lookback.
+func (db *TimescaleDB) inspectMeasurement(ctx context.Context, measurement string, lookback time.Duration) (info MeasurementInfo, err error) {
+	info.Measurement = measurement
+	name, since := timescaleIdentifier(measurement), timescaleInterval(lookback)
+
+	columns, err := db.queryStrings(ctx, fmt.Sprintf(TimescaleColumnsQuery, timescaleString(measurement)))
+	if err != nil {
+		return
+	}
+	for _, column := range columns {
+		switch column {
+		case "time":
+		case "value":
+			info.Fields = append(info.Fields, column)
+		default:
+			info.Tags = append(info.Tags, column)
+		}
+	}
+	if slices.Contains(info.Tags, "type") {
+		info.Types, err = db.queryStrings(ctx, fmt.Sprintf(TimescaleValuesQuery, timescaleIdentifier("type"), name, since))
+		if err != nil {
+			return
+		}
+	}
+	info.Hostnames, err = db.queryStrings(ctx, fmt.Sprintf(TimescaleValuesQuery, "hostname", name, since))
+	if err != nil {
+		return
+	}
+	sort.Strings(info.Tags)
+	sort.Strings(info.Fields)
+	sort.Strings(info.Types)
+	sort.Strings(info.Hostnames)
+
+	// The sample interval is the median of the average intervals of the series
+	series := []string{}
+	for _, tag := range timescaleTags {
+		if slices.Contains(info.Tags, tag) {
+			series = append(series, tag)
+		}
+	}
+	rows, err := db.db.QueryContext(ctx, fmt.Sprintf(TimescaleCountQuery, name, since, timescaleIdentifiers(series)))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	intervals := []float64{}
+	for rows.Next() {
+		var count int64
+		var last time.Time
+		if err = rows.Scan(&count, &last); err != nil {
+			return
+		}
+		if count > 0 {
+			intervals = append(intervals, lookback.Seconds()/float64(count))
+		}
+		if last.After(info.LastSeen) {
+			info.LastSeen = last.UTC()
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if len(intervals) > 0 {
+		sort.Float64s(intervals)
+		info.SampleInterval = (time.Duration(math.Round(intervals[len(intervals)/2])) * time.Second).String()
+	}
+	return info, nil
+}
+
+// getMeasurements returns the set of hypertables in the database.
+func (db *TimescaleDB) getMeasurements(ctx context.Context) (map[string]bool, error) {
+	names, err := db.queryStrings(ctx, TimescaleMeasurementsQuery)
+	if err != nil {
+		return nil, err
+	}
+	measurements := make(map[string]bool)
+	for _, name := range names {
+		measurements[name] = true
+	}
+	return measurements, nil
+}
+
+// queryStrings runs query and returns the values of its first column; NULL values are skipped.
+func (db *TimescaleDB) queryStrings(ctx context.Context, query string) (values []string, err error) {
+	rows, err := db.db.QueryContext(ctx, query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value sql.NullString
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			values = append(values, value.String)
+		}
+	}
+	return values, rows.Err()
+}
+
+// continuousAggregates returns the set of names of the continuous aggregates
+// of the available aggregation functions of all metrics.
+func (db *TimescaleDB) continuousAggregates() map[string]bool {
+	aggregates := make(map[string]bool)
+	for _, metric := range db.metrics {
+		if metric.IsDerived() {
+			continue
+		}
+		for _, aggFn := range metric.AvailableAggFns {
+			aggregates[continuousAggregateName(metric, aggFn)] = true
+		}
+	}
+	return aggregates
+}
+
+// updateContinuousAggregates creates the missing continuous aggregates and their refresh policies
+// for the available aggregation functions of all metrics. Reconciliations do not overlap and are
+// skipped once the database was replaced.
+func (db *TimescaleDB) updateContinuousAggregates() {
+	if !db.aggregations.lockReconciliation(db.reconciliation) {
+		logging.Info("db: updateContinuousAggregates(): Skipped outdated reconciliation of continuous aggregates")
+		return
+	}
+	defer db.aggregations.reconcile.Unlock()
+
+	// Measure required time to update continuous aggregates
+	start := time.Now()
+
+	ctx := context.Background()
+	names, err := db.queryStrings(ctx, TimescaleContinuousAggregatesQuery)
+	if err != nil {
+		logging.Error("db: updateContinuousAggregates(): Could not list continuous aggregates: ", err)
+		return
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	created := []string{}
+	for _, metric := range db.metrics {
+		if metric.IsDerived() {
+			continue
+		}
+		every := db.metricSampleInterval(metric)
+		for _, aggFn := range metric.AvailableAggFns {
+			name := continuousAggregateName(metric, aggFn)
+			query, err := createContinuousAggregateQuery(metric, aggFn, every)
+			if err != nil {
+				logging.Error("db: updateContinuousAggregates(): Could not create continuous aggregate ", name, ": ", err)
+				continue
+			}
+			if _, err := db.db.ExecContext(ctx, query); err != nil {
+				logging.Error("db: updateContinuousAggregates(): Failed to create continuous aggregate ", name, ": ", err)
+				continue
+			}
+			if !existing[name] {
+				created = append(created, name)
+			}
+			if _, err := db.db.ExecContext(ctx, createContinuousAggregatePolicyQuery(name, every)); err != nil {
+				logging.Error("db: updateContinuousAggregates(): Failed to add refresh policy of ", name, ": ", err)
+			}
+		}
+	}
+
+	db.aggregations.addCreated(created)
+
+	logging.Info("db: updateContinuousAggregates() took ", time.Since(start))
+}
+
+// getPartition returns a partition configuration for job j.
+func (db *TimescaleDB) getPartition(j *job.JobMetadata) conf.BasePartitionConfig {
+	return db.partitionConfig[j.Partition].ForNodes(strings.Split(j.NodeList, "|"))
+}
+
+// partitionMetrics returns the configurations of the metrics of the partition of job j in their configured order.
+func (db *TimescaleDB) partitionMetrics(j *job.JobMetadata) []conf.MetricConfig {
+	guids := db.getPartition(j).Metrics
+	metrics := make([]conf.MetricConfig, len(guids))
+	for i, guid := range guids {
+		metrics[i] = db.metrics[guid]
+	}
+	return metrics
+}
+
+// metricSampleInterval returns the sample interval of metric m.
+func (db *TimescaleDB) metricSampleInterval(m conf.MetricConfig) time.Duration {
+	sampleInterval := m.SampleInterval
+	if sampleInterval == "" {
+		sampleInterval = db.defaultSampleInterval
+	}
+	d, err := time.ParseDuration(sampleInterval)
+	if err != nil {
+		return 30 * time.Second
+	}
+	return d
+}
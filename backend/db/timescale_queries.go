@@ -0,0 +1,248 @@
+package db
+
+import (
+	"fmt"
+	conf "jobmon/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag columns of the hypertables of measurements
+var timescaleTags = []string{"hostname", "type", "type-id"}
+
+// SQL aggregate functions of the aggregation functions of metrics
+var timescaleAggFns = map[string]string{"max": "max", "mean": "avg", "min": "min", "sum": "sum"}
+
+// timescaleIdentifier returns name as quoted SQL identifier, e.g. of a table or column.
+func timescaleIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// timescaleIdentifiers returns names as list of quoted SQL identifiers.
+func timescaleIdentifiers(names []string) string {
+	identifiers := make([]string, len(names))
+	for i, name := range names {
+		identifiers[i] = timescaleIdentifier(name)
+	}
+	return strings.Join(identifiers, ", ")
+}
+
+// timescaleString returns s as SQL string literal.
+func timescaleString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
+
+// timescaleInterval returns d as SQL interval literal, e.g. INTERVAL '30 seconds'.
+func timescaleInterval(d time.Duration) string {
+	return "INTERVAL '" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + " seconds'"
+}
+
+// timescaleConditions returns the conditions of a query between StartTime (inclusive) and StopTime (exclusive)
+// on the type, the nodes and an additional condition of the metric. Empty arguments are skipped.
+func timescaleConditions(StartTime int, StopTime int, metricType string, nodes string, metricFilter string) string {
+	conditions := []string{fmt.Sprintf("time >= to_timestamp(%d) AND time < to_timestamp(%d)", StartTime, StopTime)}
+	if metricType != "" {
+		conditions = append(conditions, timescaleIdentifier("type")+" = "+timescaleString(metricType))
+	}
+	if nodes != "" {
+		hosts := strings.Split(nodes, "|")
+		for i, host := range hosts {
+			hosts[i] = timescaleString(host)
+		}
+		conditions = append(conditions, "hostname IN ("+strings.Join(hosts, ", ")+")")
+	}
+	if metricFilter != "" {
+		conditions = append(conditions, "("+metricFilter+")")
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// checkTimescaleArguments checks the arguments shared by the TimescaleDB queries.
+func checkTimescaleArguments(StartTime int, StopTime int, table string, sampleInterval time.Duration) error {
+	if table == "" {
+		return fmt.Errorf("missing measurement configuration")
+	}
+	if StartTime < 0 || StopTime < 0 || StartTime >= StopTime {
+		return fmt.Errorf("wrong start time = %d, stop time = %d", StartTime, StopTime)
+	}
+	if sampleInterval <= 0 {
+		return fmt.Errorf("wrong sample interval %v", sampleInterval)
+	}
+	return nil
+}
+
+// createTimescaleSeriesQuery creates an SQL query that selects the mean of the values of table per series
+// in buckets of duration sampleInterval. The buckets are labeled by their end like the windows of Flux.
+// Each series is identified by the tag columns tags; the rows are ordered by series and time.
+// The query contains:
+// * a condition on the time range between StartTime (inclusive) and StopTime (exclusive)
+// * an optional condition on the type
+// * a condition on nodes / host names
+// * an optional additional condition of the metric, e.g. `cluster = 'a'`
+func createTimescaleSeriesQuery(
+	StartTime int, StopTime int,
+	table string,
+	tags []string,
+	metricType string,
+	nodes string,
+	sampleInterval time.Duration,
+	metricFilter string,
+) (q string, err error) {
+	if err := checkTimescaleArguments(StartTime, StopTime, table, sampleInterval); err != nil {
+		return "", err
+	}
+	interval := timescaleInterval(sampleInterval)
+	columns := timescaleIdentifiers(tags)
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "SELECT time_bucket(%s, time) + %s AS time, %s, avg(value) AS value", interval, interval, columns)
+	fmt.Fprintf(sb, " FROM %s", timescaleIdentifier(table))
+	fmt.Fprintf(sb, " WHERE %s", timescaleConditions(StartTime, StopTime, metricType, nodes, metricFilter))
+	fmt.Fprintf(sb, " GROUP BY 1, %s ORDER BY %s, 1", columns, columns)
+	return sb.String(), nil
+}
+
+// createTimescaleQuantileQuery creates an SQL query that selects the quantiles of the values of table
+// in buckets of duration sampleInterval as array in the order of quantiles.
+func createTimescaleQuantileQuery(
+	StartTime int, StopTime int,
+	table string,
+	metricType string,
+	nodes string,
+	sampleInterval time.Duration,
+	metricFilter string,
+	quantiles []string,
+) (q string, err error) {
+	if err := checkTimescaleArguments(StartTime, StopTime, table, sampleInterval); err != nil {
+		return "", err
+	}
+	fractions := make([]string, len(quantiles))
+	for i, quantile := range quantiles {
+		p, err := strconv.ParseFloat(quantile, 64)
+		if err != nil || p < 0 || p > 1 {
+			return "", fmt.Errorf("invalid quantile '%s'", quantile)
+		}
+		fractions[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	interval := timescaleInterval(sampleInterval)
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "SELECT time_bucket(%s, time) + %s AS time,", interval, interval)
+	fmt.Fprintf(sb, " percentile_cont(ARRAY[%s]::double precision[]) WITHIN GROUP (ORDER BY value) AS value", strings.Join(fractions, ", "))
+	fmt.Fprintf(sb, " FROM %s", timescaleIdentifier(table))
+	fmt.Fprintf(sb, " WHERE %s", timescaleConditions(StartTime, StopTime, metricType, nodes, metricFilter))
+	fmt.Fprintf(sb, " GROUP BY 1 ORDER BY 1")
+	return sb.String(), nil
+}
+
+// createTimescaleMetadataQuery creates an SQL query that selects the mean of the means of the series
+// of table, identified by tags, and, as robust maximum, the median of the five highest values.
+func createTimescaleMetadataQuery(
+	StartTime int, StopTime int,
+	table string,
+	tags []string,
+	metricType string,
+	nodes string,
+	metricFilter string,
+) (q string, err error) {
+	if err := checkTimescaleArguments(StartTime, StopTime, table, time.Second); err != nil {
+		return "", err
+	}
+	columns := timescaleIdentifiers(tags)
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "WITH data AS (SELECT %s, value FROM %s", columns, timescaleIdentifier(table))
+	fmt.Fprintf(sb, " WHERE %s)", timescaleConditions(StartTime, StopTime, metricType, nodes, metricFilter))
+	fmt.Fprintf(sb, " SELECT (SELECT avg(mean) FROM (SELECT avg(value) AS mean FROM data GROUP BY %s) AS means) AS mean,", columns)
+	fmt.Fprintf(sb, " (SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY value) FROM (SELECT value FROM data ORDER BY value DESC LIMIT 5) AS top) AS max")
+	return sb.String(), nil
+}
+
+// continuousAggregateName returns the name of the continuous aggregate of metric with aggregation function aggFn,
+// which equals the measurement of the node data in InfluxDB.
+func continuousAggregateName(metric conf.MetricConfig, aggFn string) string {
+	return metric.Measurement + "_" + aggFn
+}
+
+// createContinuousAggregateQuery creates the SQL statement of the continuous aggregate that aggregates
+// the values of metric per node in buckets of duration every with aggregation function aggFn,
+// e.g. the sum over all sockets of a node. The continuous aggregate replaces the aggregation task of InfluxDB.
+// Real time aggregation includes the data that is not materialized yet.
+func createContinuousAggregateQuery(metric conf.MetricConfig, aggFn string, every time.Duration) (q string, err error) {
+	if metric.Measurement == "" {
+		return "", fmt.Errorf("missing measurement configuration")
+	}
+	fn, ok := timescaleAggFns[aggFn]
+	if !ok {
+		return "", fmt.Errorf("unknown aggregation function '%s'", aggFn)
+	}
+	if every <= 0 {
+		return "", fmt.Errorf("wrong sample interval %v", every)
+	}
+	bucket := fmt.Sprintf("time_bucket(%s, time)", timescaleInterval(every))
+	conditions := []string{}
+	if metric.Type != "" {
+		conditions = append(conditions, timescaleIdentifier("type")+" = "+timescaleString(metric.Type))
+	}
+	if metric.FilterFunc != "" {
+		conditions = append(conditions, "("+metric.FilterFunc+")")
+	}
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "CREATE MATERIALIZED VIEW IF NOT EXISTS %s", timescaleIdentifier(continuousAggregateName(metric, aggFn)))
+	fmt.Fprintf(sb, " WITH (timescaledb.continuous, timescaledb.materialized_only = false)")
+	fmt.Fprintf(sb, " AS SELECT %s AS time, hostname, %s(value) AS value", bucket, fn)
+	fmt.Fprintf(sb, " FROM %s", timescaleIdentifier(metric.Measurement))
+	if len(conditions) > 0 {
+		fmt.Fprintf(sb, " WHERE %s", strings.Join(conditions, " AND "))
+	}
+	fmt.Fprintf(sb, " GROUP BY %s, hostname WITH NO DATA", bucket)
+	return sb.String(), nil
+}
+
+// createContinuousAggregatePolicyQuery creates the SQL statement of the policy that refreshes the continuous
+// aggregate name every interval. The last hour, but at least four buckets, are refreshed
+// except for the current bucket, which is aggregated in real time.
+func createContinuousAggregatePolicyQuery(name string, every time.Duration) string {
+	startOffset := time.Hour
+	if startOffset < 4*every {
+		startOffset = 4 * every
+	}
+	return fmt.Sprintf(
+		"SELECT add_continuous_aggregate_policy(%s, start_offset => %s, end_offset => %s, schedule_interval => %s, if_not_exists => true)",
+		timescaleString(timescaleIdentifier(name)), timescaleInterval(startOffset), timescaleInterval(every), timescaleInterval(every))
+}
+
+// Returns the hypertables of the current schema, which are the measurements
+const TimescaleMeasurementsQuery = `SELECT hypertable_name FROM timescaledb_information.hypertables WHERE hypertable_schema = current_schema()`
+
+// Parameters: hypertable as string literal
+// Returns the columns of the hypertable
+const TimescaleColumnsQuery = `SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = %s`
+
+// Parameters: column as identifier, hypertable as identifier, lookback as interval literal
+// Returns the distinct values of the column
+const TimescaleValuesQuery = `SELECT DISTINCT %s FROM %s WHERE time > now() - %s`
+
+// Parameters: hypertable as identifier, lookback as interval literal, tag columns as identifiers
+// Returns the number of rows per series and the time of the latest row
+const TimescaleCountQuery = `SELECT count(*), max(time) FROM %s WHERE time > now() - %s GROUP BY %s`
+
+// Returns the continuous aggregates of the current schema
+const TimescaleContinuousAggregatesQuery = `SELECT view_name FROM timescaledb_information.continuous_aggregates WHERE view_schema = current_schema()`
+
+// Returns for each continuous aggregate of the current schema whether its refresh policy is scheduled,
+// the start and status of the last run of the policy and the error of the last run
+const TimescaleAggregationStatusQuery = `SELECT ca.view_name, j.scheduled, s.last_run_started_at, s.last_run_status, e.err_message
+FROM timescaledb_information.continuous_aggregates ca
+LEFT JOIN timescaledb_information.jobs j ON j.proc_name = 'policy_refresh_continuous_aggregate'
+	AND j.hypertable_schema = ca.materialization_hypertable_schema AND j.hypertable_name = ca.materialization_hypertable_name
+LEFT JOIN timescaledb_information.job_stats s ON s.job_id = j.job_id
+LEFT JOIN LATERAL (SELECT err_message FROM timescaledb_information.job_errors
+	WHERE job_id = j.job_id AND finish_time >= s.last_run_started_at ORDER BY finish_time DESC LIMIT 1) e ON true
+WHERE ca.view_schema = current_schema()`
+
+// Parameters: continuous aggregate as string literal of the identifier, start and stop as Unix time
+// Materializes the continuous aggregate between start and stop
+const TimescaleRefreshQuery = `CALL refresh_continuous_aggregate(%s, to_timestamp(%d), to_timestamp(%d))`
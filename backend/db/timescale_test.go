@@ -0,0 +1,75 @@
+package db
+
+import (
+	conf "jobmon/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests the queries against the golden files in testdata/timescale
+func TestTimescaleQueries(t *testing.T) {
+	metric := conf.MetricConfig{
+		GUID: "1", Measurement: "flops_any", Type: "cpu", AggFn: "sum",
+		FilterFunc: `cluster = 'a'`,
+	}
+
+	queries := map[string]func() (string, error){
+		"series": func() (string, error) {
+			return createTimescaleSeriesQuery(1000, 2000, "flops_any", timescaleTags, "cpu", "n1|n2",
+				30*time.Second, metric.FilterFunc)
+		},
+		"series_escaped": func() (string, error) {
+			return createTimescaleSeriesQuery(1000, 2000, `flops"; DROP TABLE jobs; --`, []string{"hostname"}, "", `n1|n'2`,
+				1500*time.Millisecond, "")
+		},
+		"quantile": func() (string, error) {
+			return createTimescaleQuantileQuery(1000, 2000, "flops_any_sum", "", "n1|n2",
+				time.Minute, "", []string{"0", "0.25", "0.5", "1"})
+		},
+		"metadata": func() (string, error) {
+			return createTimescaleMetadataQuery(1000, 2000, "flops_any", timescaleTags, "cpu", "n1|n2", metric.FilterFunc)
+		},
+		"continuous_aggregate": func() (string, error) {
+			return createContinuousAggregateQuery(metric, "mean", 30*time.Second)
+		},
+		"policy": func() (string, error) {
+			return createContinuousAggregatePolicyQuery(continuousAggregateName(metric, "sum"), 30*time.Second), nil
+		},
+	}
+	for name, query := range queries {
+		q, err := query()
+		if err != nil {
+			t.Errorf("Could not create %s query: %v", name, err)
+			continue
+		}
+		file := filepath.Join("testdata", "timescale", name+".sql")
+		if *update {
+			if err := os.WriteFile(file, []byte(q), 0644); err != nil {
+				t.Fatalf("Could not write golden file: %v", err)
+			}
+			continue
+		}
+		golden, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Could not read golden file: %v", err)
+		}
+		if q != string(golden) {
+			t.Errorf("Query %s differs from %s:\n%s", name, file, q)
+		}
+	}
+
+	if _, err := createTimescaleSeriesQuery(2000, 1000, "flops_any", timescaleTags, "", "n1", time.Minute, ""); err == nil {
+		t.Errorf("Invalid time range was accepted")
+	}
+	if _, err := createTimescaleQuantileQuery(1000, 2000, "flops_any", "", "n1", time.Minute, "", []string{"0.5) --"}); err == nil {
+		t.Errorf("Invalid quantile was accepted")
+	}
+	if _, err := createContinuousAggregateQuery(metric, "median", time.Minute); err == nil {
+		t.Errorf("Unknown aggregation function was accepted")
+	}
+	if _, ok := New(conf.Configuration{DBConfig: conf.DBConfig{DBType: "timescaledb"}}).(*TimescaleDB); !ok {
+		t.Errorf("Wrong implementation for TimescaleDB")
+	}
+}
@@ -0,0 +1,241 @@
+// Package expr parses arithmetic expressions over named values, e.g. "flops_any / mem_bw",
+// used to define metrics that are computed from other metrics.
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed arithmetic expression.
+// Supported are numbers, variables, parentheses, unary minus and the operators + - * /.
+type Expression struct {
+	root node
+}
+
+// node is an element of the syntax tree of an expression.
+type node interface {
+	// flux writes the node as Flux expression to sb; variable maps variable names to Flux expressions
+	flux(sb *strings.Builder, variable func(name string) string)
+	// variables adds the names of all variables used by the node to names
+	variables(names map[string]bool)
+}
+
+type number float64
+
+type variable string
+
+type negation struct {
+	operand node
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+// Parse parses s into an Expression.
+func Parse(s string) (*Expression, error) {
+	p := parser{input: s}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.token, p.tokenPos)
+	}
+	return &Expression{root: root}, nil
+}
+
+// Variables returns the sorted names of all variables used by e.
+func (e *Expression) Variables() []string {
+	names := make(map[string]bool)
+	e.root.variables(names)
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Flux returns e as Flux expression of type float; variable maps variable names to Flux expressions
+// of type float. Divisions by zero result in 0, as Flux would return infinite values.
+func (e *Expression) Flux(variable func(name string) string) string {
+	sb := new(strings.Builder)
+	e.root.flux(sb, variable)
+	return sb.String()
+}
+
+func (n number) flux(sb *strings.Builder, _ func(string) string) {
+	// Flux does not convert integers to floats implicitly
+	s := strconv.FormatFloat(float64(n), 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	sb.WriteString(s)
+}
+
+func (n number) variables(map[string]bool) {}
+
+func (v variable) flux(sb *strings.Builder, variable func(string) string) {
+	sb.WriteString(variable(string(v)))
+}
+
+func (v variable) variables(names map[string]bool) {
+	names[string(v)] = true
+}
+
+func (n negation) flux(sb *strings.Builder, variable func(string) string) {
+	sb.WriteString("(-")
+	n.operand.flux(sb, variable)
+	sb.WriteString(")")
+}
+
+func (n negation) variables(names map[string]bool) {
+	n.operand.variables(names)
+}
+
+func (b binary) flux(sb *strings.Builder, variable func(string) string) {
+	if b.op == '/' {
+		sb.WriteString("(if ")
+		b.right.flux(sb, variable)
+		sb.WriteString(" == 0.0 then 0.0 else ")
+		b.left.flux(sb, variable)
+		sb.WriteString(" / ")
+		b.right.flux(sb, variable)
+		sb.WriteString(")")
+		return
+	}
+	sb.WriteString("(")
+	b.left.flux(sb, variable)
+	sb.WriteString(" " + string(b.op) + " ")
+	b.right.flux(sb, variable)
+	sb.WriteString(")")
+}
+
+func (b binary) variables(names map[string]bool) {
+	b.left.variables(names)
+	b.right.variables(names)
+}
+
+// parser is a recursive descent parser for expressions.
+type parser struct {
+	input string
+	pos   int
+	// Current token and its position; empty at the end of the input
+	token    string
+	tokenPos int
+}
+
+// next reads the next token from the input.
+func (p *parser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	p.tokenPos = p.pos
+	if p.pos >= len(p.input) {
+		p.token = ""
+		return
+	}
+	start := p.pos
+	switch c := p.input[p.pos]; {
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+	case isLetter(c):
+		for p.pos < len(p.input) && (isLetter(p.input[p.pos]) || isDigit(p.input[p.pos])) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.token = p.input[start:p.pos]
+}
+
+// parseSum parses terms separated by + and -.
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.token == "+" || p.token == "-" {
+		op := p.token[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct parses factors separated by * and /.
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.token == "*" || p.token == "/" {
+		op := p.token[0]
+		p.next()
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseFactor parses numbers, variables, negations and expressions in parentheses.
+func (p *parser) parseFactor() (node, error) {
+	token, pos := p.token, p.tokenPos
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "-":
+		p.next()
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand: operand}, nil
+	case token == "(":
+		p.next()
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.token != ")" {
+			return nil, fmt.Errorf("missing ')' for '(' at position %d", pos)
+		}
+		p.next()
+		return inner, nil
+	case isDigit(token[0]) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", token, pos)
+		}
+		p.next()
+		return number(value), nil
+	case isLetter(token[0]):
+		p.next()
+		return variable(token), nil
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", token, pos)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
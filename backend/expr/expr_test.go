@@ -0,0 +1,48 @@
+package expr
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// Tests if expressions are translated to Flux with operator precedence and guarded divisions
+func TestFlux(t *testing.T) {
+	column := func(name string) string { return `r["` + name + `"]` }
+	for s, want := range map[string]string{
+		"flops_any / mem_bw":    `(if r["mem_bw"] == 0.0 then 0.0 else r["flops_any"] / r["mem_bw"])`,
+		"100 * a / b":           `(if r["b"] == 0.0 then 0.0 else (100.0 * r["a"]) / r["b"])`,
+		"a + b * 2.5":           `(r["a"] + (r["b"] * 2.5))`,
+		"-(a - b) - c":          `((-(r["a"] - r["b"])) - r["c"])`,
+		" ( used ) * 0.001 ":    `(r["used"] * 0.001)`,
+		"nv_mem_used2/nv_total": `(if r["nv_total"] == 0.0 then 0.0 else r["nv_mem_used2"] / r["nv_total"])`,
+	} {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Could not parse '%s': %v", s, err)
+		}
+		if got := e.Flux(column); got != want {
+			t.Errorf("Wrong Flux for '%s':\n got  %s\n want %s", s, got, want)
+		}
+	}
+}
+
+// Tests if invalid expressions are rejected
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "a +", "(a + b", "a b", "a % b", "1.2.3", "a / )"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Invalid expression '%s' was accepted", s)
+		}
+	}
+}
+
+// Tests if all variables are returned once
+func TestVariables(t *testing.T) {
+	e, err := Parse("(a + b) / a * 3")
+	if err != nil {
+		t.Fatalf("Could not parse expression: %v", err)
+	}
+	if v := e.Variables(); !slices.Equal(v, []string{"a", "b"}) {
+		t.Fatalf("Wrong variables %v", v)
+	}
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"time"
+
+	"jobmon/config"
+)
+
+// Series is the columnar representation of a time series.
+type Series struct {
+	// Times of the points in Unix milliseconds
+	Times []int64
+	// Values of the points; nil for missing values
+	Values []*float64
+}
+
+// ColumnarMetricData is MetricData with the series in columnar representation.
+type ColumnarMetricData struct {
+	Config  config.MetricConfig
+	Data    map[string]Series
+	RawData string
+}
+
+// ColumnarQuantileData is QuantileData with the series in columnar representation.
+type ColumnarQuantileData struct {
+	Config    config.MetricConfig
+	Quantiles []string
+	Data      map[string]Series
+	RawData   string
+}
+
+// ColumnarJobData is JobData with the series in columnar representation.
+// Instead of a map with repeated keys per point, each series consists of one array of times
+// and one array of values, which is considerably smaller and faster to encode.
+type ColumnarJobData struct {
+	Metadata        *JobMetadata
+	MetricData      []ColumnarMetricData
+	QuantileData    []ColumnarQuantileData
+	SampleInterval  float64
+	SampleIntervals []float64
+	Imbalance       []MetricImbalance `json:",omitempty"`
+}
+
+// Columnar returns the series rows in columnar representation. Rows without time are skipped,
+// other columns than "_time" and "_value", like the hostname, are dropped as they are part of the series key.
+func Columnar(rows []QueryResult) Series {
+	series := Series{
+		Times:  make([]int64, 0, len(rows)),
+		Values: make([]*float64, 0, len(rows)),
+	}
+	for _, row := range rows {
+		t, ok := row["_time"].(time.Time)
+		if !ok {
+			continue
+		}
+		var value *float64
+		switch v := row["_value"].(type) {
+		case float64:
+			value = &v
+		case int64:
+			f := float64(v)
+			value = &f
+		case uint64:
+			f := float64(v)
+			value = &f
+		}
+		series.Times = append(series.Times, t.UnixMilli())
+		series.Values = append(series.Values, value)
+	}
+	return series
+}
+
+// columnarData returns all series of data in columnar representation.
+func columnarData(data map[string][]QueryResult) map[string]Series {
+	if data == nil {
+		return nil
+	}
+	result := make(map[string]Series, len(data))
+	for key, rows := range data {
+		result[key] = Columnar(rows)
+	}
+	return result
+}
+
+// Columnar returns the metric data m in columnar representation.
+func (m MetricData) Columnar() ColumnarMetricData {
+	return ColumnarMetricData{Config: m.Config, Data: columnarData(m.Data), RawData: m.RawData}
+}
+
+// Columnar returns the quantile data q in columnar representation.
+func (q QuantileData) Columnar() ColumnarQuantileData {
+	return ColumnarQuantileData{Config: q.Config, Quantiles: q.Quantiles, Data: columnarData(q.Data), RawData: q.RawData}
+}
+
+// Columnar returns the job data d in columnar representation.
+func (d JobData) Columnar() ColumnarJobData {
+	result := ColumnarJobData{
+		Metadata:        d.Metadata,
+		MetricData:      make([]ColumnarMetricData, len(d.MetricData)),
+		QuantileData:    make([]ColumnarQuantileData, len(d.QuantileData)),
+		SampleInterval:  d.SampleInterval,
+		SampleIntervals: d.SampleIntervals,
+		Imbalance:       d.Imbalance,
+	}
+	for i, m := range d.MetricData {
+		result.MetricData[i] = m.Columnar()
+	}
+	for i, q := range d.QuantileData {
+		result.QuantileData[i] = q.Columnar()
+	}
+	return result
+}
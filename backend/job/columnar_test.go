@@ -0,0 +1,63 @@
+package job
+
+import (
+	"encoding/json"
+	"jobmon/config"
+	"testing"
+	"time"
+)
+
+// Tests if the columnar representation contains the same points as the rows encoded as JSON
+func TestColumnar(t *testing.T) {
+	t0 := time.UnixMilli(1700000000000).UTC()
+	rows := []QueryResult{
+		{"_time": t0, "_value": 1.5, "hostname": "n1"},
+		{"_time": t0.Add(30 * time.Second), "_value": int64(2), "hostname": "n1"},
+		{"_time": t0.Add(60 * time.Second), "_value": nil, "hostname": "n1"},
+		// Rows without time are skipped
+		{"_value": 3.0},
+	}
+	data := JobData{
+		Metadata: &JobMetadata{Id: 1},
+		MetricData: []MetricData{
+			{Config: config.MetricConfig{GUID: "m"}, Data: map[string][]QueryResult{"n1": rows}},
+			{Config: config.MetricConfig{GUID: "raw"}, RawData: "csv"},
+		},
+		QuantileData:   []QuantileData{{Quantiles: []string{"0.5"}, Data: map[string][]QueryResult{"0.5": rows[:1]}}},
+		SampleInterval: 30,
+	}
+
+	// Times and values of the rows as sent to clients in JSON
+	var decoded []struct {
+		Time  time.Time `json:"_time"`
+		Value *float64  `json:"_value"`
+	}
+	encoded, err := json.Marshal(rows[:3])
+	if err != nil {
+		t.Fatalf("Could not marshal rows: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Could not unmarshal rows: %v", err)
+	}
+
+	columnar := data.Columnar()
+	series := columnar.MetricData[0].Data["n1"]
+	if len(series.Times) != 3 || len(series.Values) != 3 {
+		t.Fatalf("Wrong series %+v", series)
+	}
+	for i, row := range decoded {
+		if series.Times[i] != row.Time.UnixMilli() ||
+			(series.Values[i] == nil) != (row.Value == nil) ||
+			(row.Value != nil && *series.Values[i] != *row.Value) {
+			t.Errorf("Point %d differs: %d %v, JSON %v %v", i, series.Times[i], series.Values[i], row.Time, row.Value)
+		}
+	}
+
+	if columnar.Metadata != data.Metadata || columnar.SampleInterval != 30 ||
+		columnar.MetricData[1].Data != nil || columnar.MetricData[1].RawData != "csv" {
+		t.Errorf("Wrong job data %+v", columnar)
+	}
+	if q := columnar.QuantileData[0]; len(q.Quantiles) != 1 || len(q.Data["0.5"].Times) != 1 {
+		t.Errorf("Wrong quantile data %+v", q)
+	}
+}
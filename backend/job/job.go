@@ -32,6 +32,8 @@ type JobMetadata struct {
 	ExitCode     int       // global job exit code
 	Tags         []*JobTag `bun:"m2m:job_to_tags,join:Job=Tag"`
 	Data         []JobMetadataData
+	Phases       *JobPhases       // Phases of the job; set when the job is stopped
+	Analysis     []AnalyzerResult // Results of the job analyzers; set when the job is stopped
 }
 
 // JobMetaData represents the job data.
@@ -43,6 +45,10 @@ type JobMetadataData struct {
 	Max float64
 	// Change points
 	ChangePoints []time.Time
+	// Mean coefficient of variation of the metric across the nodes; 0 for single node jobs
+	Imbalance float64
+	// Nodes whose values deviate strongly from the other nodes
+	OutlierNodes []string
 }
 
 // StopJob stores the ExitCode of a job and the end time.
@@ -93,6 +99,15 @@ type JobFilter struct {
 	NumGpus   *RangeFilter
 	Time      *RangeFilter
 	Tags      *[]JobTag
+	// Restricts the result to jobs the requesting user is allowed to view
+	Visibility *JobVisibility
+}
+
+// JobVisibility restricts jobs to the ones submitted by UserName or
+// charged to one of Accounts. A nil *JobVisibility does not restrict anything.
+type JobVisibility struct {
+	UserName *string
+	Accounts []string
 }
 
 // RangeFilter represents an integer interval.
@@ -124,6 +139,102 @@ type QuantileData struct {
 	RawData string
 }
 
+// ImbalancePoint describes how evenly a metric is distributed over the nodes of a job at one point in time.
+type ImbalancePoint struct {
+	Time time.Time
+	// Standard deviation divided by mean of the values of all nodes
+	CoefficientOfVariation float64
+	// Maximum divided by mean of the values of all nodes
+	MaxMeanRatio float64
+}
+
+// OutlierNode is a node whose values deviate strongly from the other nodes of a job.
+type OutlierNode struct {
+	Hostname string
+	// Mean of the values of the node
+	Mean float64
+	// Deviation of Mean from the median of all nodes in robust standard deviations;
+	// negative values indicate idle or slow nodes
+	Score float64
+}
+
+// MetricImbalance describes how evenly a metric is distributed over the nodes of a job.
+type MetricImbalance struct {
+	GUID   string
+	Points []ImbalancePoint
+	// Mean coefficient of variation over time
+	Score    float64
+	Outliers []OutlierNode
+}
+
+// PhaseStatistics contains the statistics of a metric during a segment or phase.
+type PhaseStatistics struct {
+	Mean float64
+	Max  float64
+}
+
+// PhaseSegment is the time span of a metric between two change points.
+type PhaseSegment struct {
+	Start time.Time
+	Stop  time.Time
+	PhaseStatistics
+}
+
+// MetricSegments contains the change points of a metric and the segments between them.
+type MetricSegments struct {
+	GUID         string
+	ChangePoints []time.Time
+	Segments     []PhaseSegment
+}
+
+// JobPhase is a time span of a job between two change points of any metric.
+type JobPhase struct {
+	Start time.Time
+	Stop  time.Time
+	// Description of the dominating load, e.g. "compute", "I/O load" or "idle tail"
+	Label string
+	// Statistics of each metric during the phase; Key is the metric GUID
+	Metrics map[string]PhaseStatistics
+}
+
+// JobPhases is the segmentation of a job into phases.
+type JobPhases struct {
+	// Change point detection settings used for the segmentation
+	Method     string
+	Penalty    float64
+	MinSegment int
+	Phases     []JobPhase
+	Metrics    []MetricSegments
+}
+
+// Finding is a result of a job analyzer.
+type Finding struct {
+	// Name of the analyzer
+	Analyzer string
+	// Kind of the finding, e.g. "outlier-nodes"
+	Kind string
+	// "info" or "warning"
+	Severity string
+	// GUID of the concerned metric; empty if the finding concerns the whole job
+	Metric string `json:",omitempty"`
+	// Concerned nodes; empty if the finding concerns all nodes
+	Nodes []string `json:",omitempty"`
+	// Time span of the finding; nil if the finding concerns the whole job
+	Start *time.Time `json:",omitempty"`
+	Stop  *time.Time `json:",omitempty"`
+	// Kind specific value, e.g. the imbalance score
+	Value   float64
+	Message string
+}
+
+// AnalyzerResult contains the findings of an analyzer for a job.
+type AnalyzerResult struct {
+	Analyzer string
+	Findings []Finding
+	// Set if the analyzer failed or timed out
+	Error string `json:",omitempty"`
+}
+
 // JobData stores job metadata, metric data, quantile data etc.
 type JobData struct {
 	Metadata        *JobMetadata
@@ -131,6 +242,8 @@ type JobData struct {
 	QuantileData    []QuantileData
 	SampleInterval  float64
 	SampleIntervals []float64
+	// Imbalance of the metrics across nodes; only set for data separated by node
+	Imbalance []MetricImbalance `json:",omitempty"`
 }
 
 // Expired checks if job TTL has expired. If TTL == 0 then the job will never expire.
@@ -196,6 +309,22 @@ func (j *JobMetadata) RemoveTag(tag *JobTag) {
 	j.Tags = newTags
 }
 
+// Matches checks if job j is visible according to v.
+func (v *JobVisibility) Matches(j *JobMetadata) bool {
+	if v == nil {
+		return true
+	}
+	if v.UserName != nil && *v.UserName == j.UserName {
+		return true
+	}
+	for _, a := range v.Accounts {
+		if a == j.Account {
+			return true
+		}
+	}
+	return false
+}
+
 // IsIn checks of tags belong to the job tag t.
 func (t *JobTag) IsIn(tags []*JobTag) bool {
 	if tags == nil {
@@ -12,12 +12,14 @@ import (
 	"jobmon/utils"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 )
 
 var (
 	store       jobstore.Store
 	config      = conf.Configuration{}
+	configs     = conf.Holder{}
 	db          database.DB
 	jobCache    = cache.LRUCache{}
 	authManager = auth.AuthManager{}
@@ -33,9 +35,16 @@ func main() {
 	// parse the json configuration file and map the data to config.
 	config.Init()
 
-	// create and initialize the InfluxDB
-	db = &database.InfluxDB{}
-	db.Init(config)
+	// create and initialize the metrics database, it is replaced when the configuration changes
+	db = &database.Reloadable{}
+	if err := db.Init(config); err != nil {
+		logging.Fatal("main: Could not initialize the metrics database: ", err)
+	}
+
+	// run the backfill command instead of the server
+	if config.Backfill != "" {
+		os.Exit(runBackfill())
+	}
 
 	// create and initialize a PostgresStore
 	store = &jobstore.PostgresStore{}
@@ -51,11 +60,73 @@ func main() {
 	// setup the authentication manager
 	authManager.Init(config, &store, &notifier)
 
+	// share the configuration and apply changes on reload
+	configs.Init(config)
+	registerReload()
+
 	// cleanup everything
 	registerCleanup()
 
 	// start the server
-	router.Init(store, &config, &db, &jobCache, &authManager, &webLogger, &notifier)
+	router.Init(store, &configs, &db, &jobCache, &authManager, &webLogger, &notifier)
+}
+
+// registerReload reloads the configuration on SIGHUP and when the config file
+// changes, and re-initializes the components affected by configuration changes.
+func registerReload() {
+	configs.Subscribe(applyConfig)
+	configs.Watch(conf.WatchInterval)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			configs.Reload()
+		}
+	}()
+}
+
+// applyConfig re-initializes only the components whose configuration differs between old and new.
+// Changes of the job store connection and of the command line options require a restart.
+func applyConfig(oldConf *conf.Configuration, newConf *conf.Configuration, _ string) {
+	if !reflect.DeepEqual(oldConf.ConfigLogLevel, newConf.ConfigLogLevel) {
+		logLevel := newConf.LogLevel
+		if newConf.ConfigLogLevel != nil {
+			logLevel = *newConf.ConfigLogLevel
+		}
+		if err := logging.SetLogLevel(logLevel); err != nil {
+			logging.Error("main: applyConfig(): Could not set log level: ", err)
+		}
+	}
+	if oldConf.DBConfig != newConf.DBConfig ||
+		oldConf.SampleInterval != newConf.SampleInterval ||
+		oldConf.QueryTimeout != newConf.QueryTimeout ||
+		oldConf.QueryConcurrency != newConf.QueryConcurrency ||
+		!reflect.DeepEqual(oldConf.Metrics, newConf.Metrics) ||
+		!reflect.DeepEqual(oldConf.Partitions, newConf.Partitions) ||
+		!reflect.DeepEqual(oldConf.PhaseDetection, newConf.PhaseDetection) ||
+		!reflect.DeepEqual(oldConf.Analysis, newConf.Analysis) {
+		// The current database is kept if the new one cannot be reached
+		if err := db.Init(*newConf); err != nil {
+			logging.Error("main: applyConfig(): Could not initialize the metrics database, keeping the previous one: ", err)
+		}
+	}
+	store.Reconfigure(*newConf)
+	// The cache also drops data computed with outdated metric configurations
+	jobCache.Reconfigure(*newConf)
+	if oldConf.Email != newConf.Email {
+		notifier.Init(*newConf)
+	}
+	if oldConf.JSONWebTokenLifeTime != newConf.JSONWebTokenLifeTime ||
+		oldConf.APITokenLifeTime != newConf.APITokenLifeTime ||
+		oldConf.ImpersonationLifeTime != newConf.ImpersonationLifeTime ||
+		oldConf.JWTSecret != newConf.JWTSecret ||
+		oldConf.OAuth != newConf.OAuth ||
+		oldConf.RoleRequestsPerDay != newConf.RoleRequestsPerDay ||
+		!reflect.DeepEqual(oldConf.LocalUsers, newConf.LocalUsers) {
+		authManager.Reconfigure(*newConf)
+	}
 }
 
 // registerCleanup performs all the necessary cleanups before starting a fresh
@@ -68,7 +139,7 @@ func registerCleanup() {
 		<-sigChan
 		store.Flush()
 		db.Close()
-		config.Flush()
+		configs.Flush()
 		os.Exit(0)
 	}()
 }
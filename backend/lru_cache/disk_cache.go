@@ -0,0 +1,247 @@
+package lru_cache
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size limit of the disk cache if not configured
+const defaultDiskSize = 4096 << 20
+
+// Suffix of the files of the disk cache
+const diskFileSuffix = ".gob.gz"
+
+// Subdirectory of the configured cache directory that is owned by the disk cache.
+// Only its content is removed, so the configured directory may be shared, e.g. /var/cache.
+const diskCacheSubdir = "jobmon-cache"
+
+func init() {
+	// Query results contain times as interface values
+	gob.Register(time.Time{})
+}
+
+// diskCache persists the job data of finished jobs as gzip compressed gob files.
+// Files are stored in a subdirectory per configuration hash of the jobmon-cache directory,
+// so data computed with other metric configurations is never returned.
+type diskCache struct {
+	mut      sync.Mutex
+	dir      string
+	maxBytes int64
+	files    map[string]diskFile
+	bytes    int64
+}
+
+// diskFile describes a file of the disk cache.
+type diskFile struct {
+	size int64
+	// Time of the last access, used to evict the least recently used files
+	used time.Time
+}
+
+// newDiskCache opens the disk cache for configuration hash configHash in the jobmon-cache
+// subdirectory of root and removes the data of all other configurations.
+func newDiskCache(root string, configHash string, maxBytes int64) (*diskCache, error) {
+	root = filepath.Join(root, diskCacheSubdir)
+	d := &diskCache{
+		dir:      filepath.Join(root, configHash),
+		maxBytes: maxBytes,
+		files:    make(map[string]diskFile),
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	// Remove data computed with other configurations
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if _, err := hex.DecodeString(e.Name()); err == nil && len(e.Name()) == len(configHash) &&
+			e.IsDir() && e.Name() != configHash {
+			if err := os.RemoveAll(filepath.Join(root, e.Name())); err != nil {
+				logging.Warning("lru_cache: newDiskCache(): Could not remove outdated cache ", e.Name(), ": ", err)
+			}
+		}
+	}
+
+	// Index existing files; the modification time is the time of the last access
+	entries, err = os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), diskFileSuffix) {
+			// Temporary file of an interrupted write
+			os.Remove(filepath.Join(d.dir, e.Name()))
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		d.files[e.Name()] = diskFile{size: info.Size(), used: info.ModTime()}
+		d.bytes += info.Size()
+	}
+	d.evict(0)
+	return d, nil
+}
+
+// get returns the job data stored for key.
+func (d *diskCache) get(key Key) (data job.JobData, ok bool) {
+	name := diskFileName(key)
+	d.mut.Lock()
+	file, ok := d.files[name]
+	if ok {
+		file.used = time.Now()
+		d.files[name] = file
+	}
+	d.mut.Unlock()
+	if !ok {
+		return data, false
+	}
+
+	path := filepath.Join(d.dir, name)
+	if err := readGob(path, &data); err != nil {
+		logging.Warning("lru_cache: get(): Could not read ", path, ": ", err)
+		d.remove(name)
+		return data, false
+	}
+	os.Chtimes(path, file.used, file.used)
+	return data, true
+}
+
+// put stores the job data for key without its metadata, which may still change.
+func (d *diskCache) put(key Key, data job.JobData) {
+	data.Metadata = nil
+	name := diskFileName(key)
+	path := filepath.Join(d.dir, name)
+
+	// Write to a temporary file first, so readers never see partial files
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		logging.Warning("lru_cache: put(): Could not create file in ", d.dir, ": ", err)
+		return
+	}
+	zw := gzip.NewWriter(tmp)
+	err = gob.NewEncoder(zw).Encode(data)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		logging.Warning("lru_cache: put(): Could not write ", path, ": ", err)
+		os.Remove(tmp.Name())
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if old, ok := d.files[name]; ok {
+		d.bytes -= old.size
+	}
+	d.files[name] = diskFile{size: info.Size(), used: time.Now()}
+	d.bytes += info.Size()
+	d.evict(0)
+}
+
+// stats returns the number of files and their size.
+func (d *diskCache) stats() (files int, bytes int64) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return len(d.files), d.bytes
+}
+
+// remove deletes the file name.
+func (d *diskCache) remove(name string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.removeLocked(name)
+}
+
+func (d *diskCache) removeLocked(name string) {
+	file, ok := d.files[name]
+	if !ok {
+		return
+	}
+	delete(d.files, name)
+	d.bytes -= file.size
+	if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+		logging.Warning("lru_cache: remove(): Could not remove ", name, ": ", err)
+	}
+}
+
+// evict removes the least recently used files until size more bytes fit into the cache.
+func (d *diskCache) evict(size int64) {
+	if d.bytes+size <= d.maxBytes {
+		return
+	}
+	names := make([]string, 0, len(d.files))
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return d.files[names[i]].used.Before(d.files[names[j]].used)
+	})
+	for _, name := range names {
+		if d.bytes+size <= d.maxBytes {
+			break
+		}
+		d.removeLocked(name)
+	}
+}
+
+// diskFileName returns the name of the file storing the data for key.
+func diskFileName(key Key) string {
+	nodes := sha256.Sum256([]byte(key.Nodes))
+	return fmt.Sprintf("%d-%s-%d-%t%s",
+		key.JobId, hex.EncodeToString(nodes[:8]), int64(key.SampleInterval.Seconds()), key.Raw, diskFileSuffix)
+}
+
+// readGob decodes the gzip compressed gob file path into v.
+func readGob(path string, v any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(zr).Decode(v)
+}
+
+// configHash returns a hash of the parts of config that determine the job data.
+func configHash(config conf.Configuration) string {
+	data, _ := json.Marshal(struct {
+		Metrics         []conf.MetricConfig
+		Partitions      map[string]conf.PartitionConfig
+		SampleInterval  string
+		MetricQuantiles []string
+	}{config.Metrics, config.Partitions, config.SampleInterval, config.MetricQuantiles})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:8])
+}
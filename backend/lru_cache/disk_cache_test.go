@@ -0,0 +1,91 @@
+package lru_cache
+
+import (
+	"jobmon/config"
+	"jobmon/job"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests if job data is restored with times and missing values
+func TestDiskCacheRoundTrip(t *testing.T) {
+	d, err := newDiskCache(t.TempDir(), "0123456789abcdef", 1<<20)
+	if err != nil {
+		t.Fatalf("Could not open disk cache: %v", err)
+	}
+	key := Key{JobId: 1, Nodes: "n1|n2", SampleInterval: 30 * time.Second}
+	t0 := time.Unix(1000, 0).UTC()
+	data := job.JobData{
+		Metadata: &job.JobMetadata{Id: 1},
+		MetricData: []job.MetricData{{
+			Config: config.MetricConfig{GUID: "m"},
+			Data:   map[string][]job.QueryResult{"n1": {{"_time": t0, "_value": 1.5}, {"_time": t0, "_value": nil}}},
+		}},
+	}
+	d.put(key, data)
+
+	restored, ok := d.get(key)
+	if !ok {
+		t.Fatalf("Stored data was not found")
+	}
+	if restored.Metadata != nil {
+		t.Fatalf("Metadata was stored")
+	}
+	rows := restored.MetricData[0].Data["n1"]
+	if len(rows) != 2 || rows[0]["_time"] != t0 || rows[0]["_value"] != 1.5 || rows[1]["_value"] != nil {
+		t.Fatalf("Wrong restored rows %v", rows)
+	}
+	if _, ok := d.get(Key{JobId: 1, Nodes: "n1", SampleInterval: 30 * time.Second}); ok {
+		t.Fatalf("Returned data of other nodes")
+	}
+}
+
+// Tests if the least recently used files are evicted and data of other configurations is removed
+func TestDiskCacheEviction(t *testing.T) {
+	root := t.TempDir()
+	outdated := filepath.Join(root, diskCacheSubdir, "fedcba9876543210")
+	other := filepath.Join(root, diskCacheSubdir, "other")
+	// Directory of another application in a shared cache directory
+	shared := filepath.Join(root, "0011223344556677")
+	os.MkdirAll(outdated, 0700)
+	os.MkdirAll(other, 0700)
+	os.MkdirAll(shared, 0700)
+
+	d, err := newDiskCache(root, "0123456789abcdef", 1<<20)
+	if err != nil {
+		t.Fatalf("Could not open disk cache: %v", err)
+	}
+	if _, err := os.Stat(outdated); !os.IsNotExist(err) {
+		t.Fatalf("Data of other configuration was not removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("Unrelated directory was removed")
+	}
+	if _, err := os.Stat(shared); err != nil {
+		t.Fatalf("Directory outside of the cache was removed")
+	}
+
+	d.put(Key{JobId: 1}, job.JobData{})
+	time.Sleep(10 * time.Millisecond)
+	d.put(Key{JobId: 2}, job.JobData{})
+	_, size := d.stats()
+	d.maxBytes = size
+	time.Sleep(10 * time.Millisecond)
+	d.get(Key{JobId: 1})
+	d.put(Key{JobId: 3}, job.JobData{})
+
+	if _, ok := d.get(Key{JobId: 2}); ok {
+		t.Fatalf("Least recently used file was not evicted")
+	}
+	if _, ok := d.get(Key{JobId: 1}); !ok {
+		t.Fatalf("Recently used file was evicted")
+	}
+
+	// Files are found again after a restart
+	d, _ = newDiskCache(root, "0123456789abcdef", 1<<20)
+	if files, _ := d.stats(); files != 2 {
+		t.Fatalf("Wrong number of files after restart: %d", files)
+	}
+}
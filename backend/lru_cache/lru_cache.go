@@ -2,92 +2,337 @@ package lru_cache
 
 import (
 	"container/list"
-	"fmt"
+	"context"
 	conf "jobmon/config"
 	"jobmon/db"
 	"jobmon/job"
+	"jobmon/logging"
 	"jobmon/store"
+	"jobmon/utils"
 	"sync"
 	"time"
 )
 
+// Memory budget of the cache if not configured
+const defaultMemory = 512 << 20
+
+// Time after which cached data of running jobs expires if not configured
+const defaultTTL = 30 * time.Second
+
+// Estimated memory used by a query result row and by each of its values, including the map overhead
+const (
+	rowSize   = 48
+	valueSize = 64
+)
+
 // LRUCache represent a "Least Recently Used" caches used for storing job data.
+// Entries are identified by job, nodes, sample interval and raw flag and the cache is bounded
+// by the number of entries and the estimated memory of the job data. Concurrent requests for
+// the same entry share a single database query.
 type LRUCache struct {
-	size  int
-	list  *list.List
-	mut   sync.Mutex
-	db    *db.DB
-	store *store.Store
+	size     int
+	maxBytes int64
+	ttl      time.Duration
+	list     *list.List
+	items    map[Key]*list.Element
+	inflight map[Key]*call
+	bytes    int64
+	stats    Stats
+	// Second tier for finished jobs; nil if disabled
+	disk       *diskCache
+	diskRoot   string
+	diskSize   int64
+	configHash string
+	mut        sync.Mutex
+	db         *db.DB
+	store      *store.Store
+}
+
+// Key identifies the job data of a cache entry.
+type Key struct {
+	JobId          int
+	Nodes          string
+	SampleInterval time.Duration
+	Raw            bool
+}
+
+// Stats contains the state of the cache and counts its requests since the start.
+type Stats struct {
+	Entries  int
+	Bytes    int64
+	MaxBytes int64
+	Hits     uint64
+	Misses   uint64
+	// Requests that waited for the database query of a concurrent request
+	Coalesced uint64
+	// Entries removed to stay within the limits
+	Evictions uint64
+	// Entries of running jobs removed after their TTL
+	Expirations uint64
+	// Misses of the memory tier that were read from the disk tier
+	DiskHits  uint64
+	DiskFiles int
+	DiskBytes int64
+}
+
+// entry is an element of the cache.
+type entry struct {
+	key  Key
+	data job.JobData
+	size int64
+	// Zero if the entry does not expire
+	expires time.Time
 }
 
-// Item represents a cache element.
-type Item struct {
-	id   int
+// call is a database query shared by concurrent requests for the same key.
+type call struct {
+	done chan struct{}
 	data job.JobData
+	err  error
+	// Number of requests waiting for the query; it is canceled when all of them are gone
+	waiters int
+	cancel  context.CancelFunc
 }
 
 // Init sets up the cache based on the configuration config, for db and store.
 func (c *LRUCache) Init(config conf.Configuration, db *db.DB, store *store.Store) {
-	c.size = config.CacheSize
 	c.list = new(list.List)
+	c.items = make(map[Key]*list.Element)
+	c.inflight = make(map[Key]*call)
 	c.db = db
 	c.store = store
+	c.Reconfigure(config)
 }
 
-// Get returns the job data for the given sample interval form cache by job metadata.
-func (c *LRUCache) Get(j *job.JobMetadata, sampleInterval time.Duration) (data job.JobData, err error) {
+// Reconfigure applies the cache limits of config and evicts the least recently used items that no longer fit.
+// All entries are removed if the metrics, partitions or sample interval of config changed.
+func (c *LRUCache) Reconfigure(config conf.Configuration) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	data, err = c.find(j.Id)
-	if err == nil {
-		return data, err
+	hash := configHash(config)
+	if hash != c.configHash {
+		c.list.Init()
+		c.items = make(map[Key]*list.Element)
+		c.bytes = 0
+	}
+	diskSize := int64(config.CacheDiskSize) << 20
+	if diskSize == 0 {
+		diskSize = defaultDiskSize
 	}
-	if j.NumNodes == 1 {
-		data, err = (*c.db).GetJobData(j, j.NodeList, sampleInterval, false)
+	if config.CacheDir != c.diskRoot || diskSize != c.diskSize || hash != c.configHash {
+		c.disk = nil
+		if config.CacheDir != "" {
+			disk, err := newDiskCache(config.CacheDir, hash, diskSize)
+			if err != nil {
+				logging.Error("lru_cache: Reconfigure(): Could not open disk cache in ", config.CacheDir, ": ", err)
+			} else {
+				c.disk = disk
+			}
+		}
+	}
+	c.configHash = hash
+	c.diskRoot = config.CacheDir
+	c.diskSize = diskSize
+
+	c.size = config.CacheSize
+	c.maxBytes = int64(config.CacheMemory) << 20
+	if c.maxBytes == 0 {
+		c.maxBytes = defaultMemory
+	}
+	c.ttl = defaultTTL
+	if config.CacheTTL != "" {
+		// The TTL was checked by Validate
+		c.ttl, _ = time.ParseDuration(config.CacheTTL)
+	}
+	c.evict(0, 0)
+}
+
+// Get returns the job data of job j on nodes for the given sample interval and raw flag
+// from the cache, or queries it from the database. Data of running jobs expires after the TTL,
+// data of finished jobs is also stored in the disk tier. Get returns when ctx is done; the
+// database query is only canceled when all requests waiting for it are done.
+func (c *LRUCache) Get(ctx context.Context, j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
+	if nodes == "" && j.NumNodes == 1 {
+		nodes = j.NodeList
+	}
+	key := Key{JobId: j.Id, Nodes: nodes, SampleInterval: sampleInterval, Raw: raw}
+
+	c.mut.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			c.list.MoveToFront(el)
+			c.stats.Hits++
+			c.mut.Unlock()
+			return e.data, nil
+		}
+		c.remove(el)
+		c.stats.Expirations++
+	}
+	cl, ok := c.inflight[key]
+	if ok {
+		c.stats.Coalesced++
+		cl.waiters++
 	} else {
-		data, err = (*c.db).GetJobData(j, "", sampleInterval, false)
+		c.stats.Misses++
+		// The shared query is scheduled for the user of the first request
+		queryCtx, cancel := context.WithCancel(utils.WithUser(context.Background(), utils.UserOf(ctx)))
+		cl = &call{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		c.inflight[key] = cl
+		disk := c.disk
+		if j.IsRunning {
+			disk = nil
+		}
+		// Callers may modify j, cached data must not change
+		metadata := *j
+		go c.load(queryCtx, cl, key, &metadata, disk)
+	}
+	c.mut.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.data, cl.err
+	case <-ctx.Done():
+		c.mut.Lock()
+		cl.waiters--
+		if cl.waiters == 0 {
+			// Later requests must not join the canceled query
+			if c.inflight[key] == cl {
+				delete(c.inflight, key)
+			}
+			cl.cancel()
+		}
+		c.mut.Unlock()
+		return data, ctx.Err()
+	}
+}
+
+// load reads the data of call cl for key from the disk tier disk, if not nil, or queries it from the database,
+// and puts it into the cache.
+func (c *LRUCache) load(ctx context.Context, cl *call, key Key, j *job.JobMetadata, disk *diskCache) {
+	defer cl.cancel()
+
+	var data job.JobData
+	var err error
+	fromDisk := false
+	if disk != nil {
+		data, fromDisk = disk.get(key)
+	}
+	if !fromDisk {
+		data, err = (*c.db).GetJobData(ctx, j, key.Nodes, key.SampleInterval, key.Raw)
+		if err == nil && disk != nil {
+			go disk.put(key, data)
+		}
 	}
 	if err == nil {
-		c.put(Item{id: j.Id, data: data})
+		data.Metadata = j
 	}
-	return data, err
+
+	c.mut.Lock()
+	if c.inflight[key] == cl {
+		delete(c.inflight, key)
+	}
+	if fromDisk {
+		c.stats.DiskHits++
+	}
+	if err == nil {
+		e := &entry{key: key, data: data, size: estimateSize(data)}
+		if j.IsRunning {
+			e.expires = time.Now().Add(c.ttl)
+		}
+		c.put(e)
+	}
+	c.mut.Unlock()
+
+	cl.data, cl.err = data, err
+	close(cl.done)
 }
 
-// UpdateJob updates the data stored in the cache for job identified with id.
+// UpdateJob updates the metadata stored in the cache for job identified with id.
 func (c *LRUCache) UpdateJob(id int) {
+	job, err := (*c.store).GetJob(context.Background(), id)
+	if err != nil {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for key, el := range c.items {
+		if key.JobId == id {
+			e := el.Value.(*entry)
+			metadata := job
+			e.data.Metadata = &metadata
+		}
+	}
+}
+
+// Stats returns the current statistics of the cache.
+func (c *LRUCache) Stats() Stats {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	data, err := c.find(id)
-	if err != nil {
-		return
+	stats := c.stats
+	stats.Entries = c.list.Len()
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	if c.disk != nil {
+		stats.DiskFiles, stats.DiskBytes = c.disk.stats()
 	}
-	job, err := (*c.store).GetJob(id)
-	if err != nil {
+	return stats
+}
+
+// put puts an entry in the cache and evicts the least recently used entries that no longer fit.
+// Entries larger than the memory budget are not cached.
+func (c *LRUCache) put(e *entry) {
+	if e.size > c.maxBytes {
 		return
 	}
-	// Job is at front after retrieving it so it is fine to remove front.
-	c.list.Remove(c.list.Front())
-	data.Metadata = &job
-	c.put(Item{id: job.Id, data: data})
+	if el, ok := c.items[e.key]; ok {
+		c.remove(el)
+	}
+	c.evict(1, e.size)
+	c.items[e.key] = c.list.PushFront(e)
+	c.bytes += e.size
 }
 
-// put puts an item in the cache.
-func (c *LRUCache) put(data Item) {
-	if c.list.Len() >= c.size {
-		c.list.Remove(c.list.Back())
+// evict removes the least recently used entries until the given number of entries
+// with size bytes in total fit into the cache.
+func (c *LRUCache) evict(entries int, size int64) {
+	for c.list.Len() > 0 &&
+		((c.size > 0 && c.list.Len()+entries > c.size) || c.bytes+size > c.maxBytes) {
+		c.remove(c.list.Back())
+		c.stats.Evictions++
 	}
-	c.list.PushFront(data)
 }
 
-// find searches for an item in the cache, it returns its job data.
-func (c *LRUCache) find(id int) (data job.JobData, err error) {
-	for el := c.list.Front(); el != nil; el = el.Next() {
-		if el.Value.(Item).id == id {
-			c.list.MoveToFront(el)
-			return el.Value.(Item).data, nil
+// remove removes the entry of el from the cache.
+func (c *LRUCache) remove(el *list.Element) {
+	e := c.list.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// estimateSize returns the estimated memory used by data.
+func estimateSize(data job.JobData) int64 {
+	size := int64(0)
+	for _, m := range data.MetricData {
+		size += estimateRowsSize(m.Data) + int64(len(m.RawData))
+	}
+	for _, q := range data.QuantileData {
+		size += estimateRowsSize(q.Data) + int64(len(q.RawData))
+	}
+	return size
+}
+
+// estimateRowsSize returns the estimated memory used by the query result rows in data.
+func estimateRowsSize(data map[string][]job.QueryResult) int64 {
+	size := int64(0)
+	for key, rows := range data {
+		size += int64(len(key))
+		for _, row := range rows {
+			size += rowSize + int64(len(row))*valueSize
 		}
 	}
-	return data, fmt.Errorf("key %v not found in cache", id)
+	return size
 }
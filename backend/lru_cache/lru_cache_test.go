@@ -1,77 +1,253 @@
 package lru_cache
 
 import (
+	"context"
 	"jobmon/config"
 	"jobmon/db"
 	"jobmon/job"
 	"jobmon/store"
 	"jobmon/test"
+	"jobmon/utils"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func TestPutCleanup(t *testing.T) {
-	cache := LRUCache{}
-	config := config.Configuration{CacheSize: 3}
-	var db db.DB = &test.MockDB{}
+// slowDB returns job data with one row per node after a delay and counts the queries and canceled queries
+type slowDB struct {
+	test.MockDB
+	queries  atomic.Int32
+	canceled atomic.Int32
+	rows     int
+}
+
+func (db *slowDB) GetJobData(ctx context.Context, j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
+	db.queries.Add(1)
+	select {
+	case <-time.After(50 * time.Millisecond):
+	case <-ctx.Done():
+		db.canceled.Add(1)
+		return data, ctx.Err()
+	}
+	rows := make([]job.QueryResult, db.rows)
+	for i := range rows {
+		rows[i] = job.QueryResult{"_time": time.Unix(int64(i), 0), "_value": 1.0}
+	}
+	data = job.JobData{
+		Metadata:   j,
+		MetricData: []job.MetricData{{Data: map[string][]job.QueryResult{nodes: rows}}},
+	}
+	return data, nil
+}
+
+func newCache(c config.Configuration, database db.DB) *LRUCache {
+	cache := &LRUCache{}
 	var store store.Store = &test.MockStore{}
-	cache.Init(config, &db, &store)
+	cache.Init(c, &database, &store)
+	return cache
+}
+
+func TestPutCleanup(t *testing.T) {
+	cache := newCache(config.Configuration{CacheSize: 3}, &test.MockDB{})
 
 	if cache.list.Len() != 0 {
 		t.Fatalf("List length is not 0")
 	}
-	cache.put(Item{id: 1})
-	cache.put(Item{id: 2})
-	cache.put(Item{id: 3})
-	cache.put(Item{id: 4})
+	for id := 1; id <= 4; id++ {
+		cache.put(&entry{key: Key{JobId: id}})
+	}
 	if cache.list.Len() != 3 {
 		t.Fatalf("List length is not 3")
 	}
-	_, err := cache.find(1)
-	if err == nil {
+	if _, ok := cache.items[Key{JobId: 1}]; ok {
 		t.Fatalf("Did not clean up least recently used item")
 	}
 }
 
 func TestPutCleanupAfterAccess(t *testing.T) {
-	cache := LRUCache{}
-	config := config.Configuration{CacheSize: 3}
-	var db db.DB = &test.MockDB{}
-	var store store.Store = &test.MockStore{}
-	cache.Init(config, &db, &store)
+	var database db.DB = &test.MockDB{}
+	cache := newCache(config.Configuration{CacheSize: 3}, database)
 
-	cache.put(Item{id: 1})
-	cache.put(Item{id: 2})
-	cache.put(Item{id: 3})
-	_, err := cache.find(1)
-	if err != nil {
-		t.Fatalf("Error while finding item")
+	for id := 1; id <= 3; id++ {
+		cache.Get(context.Background(), &job.JobMetadata{Id: id}, "", 30*time.Second, false)
 	}
-	cache.put(Item{id: 4})
-	_, err = cache.find(2)
-	if err == nil {
+	cache.Get(context.Background(), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+	cache.Get(context.Background(), &job.JobMetadata{Id: 4}, "", 30*time.Second, false)
+	if _, ok := cache.items[Key{JobId: 2, SampleInterval: 30 * time.Second}]; ok {
 		t.Fatalf("Did not clean up least recently used item")
 	}
+	if _, ok := cache.items[Key{JobId: 1, SampleInterval: 30 * time.Second}]; !ok {
+		t.Fatalf("Cleaned up recently used item")
+	}
 }
 
 func TestGet(t *testing.T) {
-	cache := LRUCache{}
-	config := config.Configuration{CacheSize: 3}
-	var db db.DB = &test.MockDB{}
-	var store store.Store = &test.MockStore{}
-	cache.Init(config, &db, &store)
+	var database db.DB = &test.MockDB{}
+	cache := newCache(config.Configuration{CacheSize: 3}, database)
 
 	job := job.JobMetadata{Id: 1}
-	cache.Get(&job, 30*time.Second)
-	if db.(*test.MockDB).Calls != 1 {
+	cache.Get(context.Background(), &job, "", 30*time.Second, false)
+	if database.(*test.MockDB).Calls != 1 {
 		t.Fatalf("Did not retrieve job data from db")
 	}
 
-	dat, _ := cache.Get(&job, 30*time.Second)
+	dat, _ := cache.Get(context.Background(), &job, "", 30*time.Second, false)
 	if dat.Metadata.Id != 1 {
 		t.Fatalf("Retrieved wrong item from cache")
 	}
-	if db.(*test.MockDB).Calls != 1 {
+	if database.(*test.MockDB).Calls != 1 {
 		t.Fatalf("Called db on cached item")
 	}
+
+	// Other sample intervals and raw data are separate entries
+	cache.Get(context.Background(), &job, "", 60*time.Second, false)
+	cache.Get(context.Background(), &job, "", 30*time.Second, true)
+	if database.(*test.MockDB).Calls != 3 {
+		t.Fatalf("Returned cached data of other sample interval or raw data")
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 3 {
+		t.Fatalf("Wrong statistics %+v", stats)
+	}
+}
+
+// Tests if concurrent requests for the same entry share one query
+func TestGetCoalesced(t *testing.T) {
+	database := &slowDB{}
+	cache := newCache(config.Configuration{}, database)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get(context.Background(), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+		}()
+	}
+	wg.Wait()
+
+	if n := database.queries.Load(); n != 1 {
+		t.Fatalf("Concurrent requests triggered %d queries", n)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits+stats.Coalesced != 19 {
+		t.Fatalf("Wrong statistics %+v", stats)
+	}
+}
+
+// userDB records the user the queries are scheduled for
+type userDB struct {
+	test.MockDB
+	user string
+}
+
+func (d *userDB) GetJobData(ctx context.Context, j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
+	d.user = utils.UserOf(ctx)
+	return job.JobData{Metadata: j}, nil
+}
+
+// Tests if the query is scheduled for the user of the request
+func TestGetUser(t *testing.T) {
+	database := &userDB{}
+	cache := newCache(config.Configuration{}, database)
+
+	_, err := cache.Get(utils.WithUser(context.Background(), "a"), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+	if err != nil {
+		t.Fatalf("Could not get job data: %v", err)
+	}
+	if database.user != "a" {
+		t.Fatalf("Query was scheduled for user '%s'", database.user)
+	}
+}
+
+// Tests if the query is only canceled when all coalesced requests are canceled
+func TestGetCanceled(t *testing.T) {
+	database := &slowDB{}
+	cache := newCache(config.Configuration{}, database)
+
+	// The remaining request still receives the data
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var err error
+	go func() {
+		defer wg.Done()
+		_, err = cache.Get(context.Background(), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+	}()
+	if _, err := cache.Get(ctx, &job.JobMetadata{Id: 1}, "", 30*time.Second, false); err != context.Canceled {
+		t.Fatalf("Canceled request returned %v", err)
+	}
+	wg.Wait()
+	if err != nil || database.canceled.Load() != 0 {
+		t.Fatalf("Query of remaining request was canceled: %v", err)
+	}
+
+	// The query is canceled without requests and nothing is cached
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.Get(ctx, &job.JobMetadata{Id: 2}, "", 30*time.Second, false); err != context.DeadlineExceeded {
+		t.Fatalf("Request did not time out: %v", err)
+	}
+	for i := 0; i < 100 && database.canceled.Load() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if database.canceled.Load() != 1 || cache.Stats().Entries != 1 {
+		t.Fatalf("Query was not canceled: %+v", cache.Stats())
+	}
+}
+
+// Tests if the memory budget evicts entries and data of running jobs expires
+func TestGetMemoryAndTTL(t *testing.T) {
+	database := &slowDB{rows: 5000}
+	// About 0.9 MB per entry
+	cache := newCache(config.Configuration{CacheMemory: 2, CacheTTL: "100ms"}, database)
+
+	for id := 1; id <= 3; id++ {
+		cache.Get(context.Background(), &job.JobMetadata{Id: id}, "", 30*time.Second, false)
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 || stats.Bytes > stats.MaxBytes {
+		t.Fatalf("Memory budget was not applied: %+v", stats)
+	}
+
+	running := job.JobMetadata{Id: 4, IsRunning: true}
+	cache.Get(context.Background(), &running, "", 30*time.Second, false)
+	cache.Get(context.Background(), &running, "", 30*time.Second, false)
+	time.Sleep(150 * time.Millisecond)
+	cache.Get(context.Background(), &running, "", 30*time.Second, false)
+	if stats := cache.Stats(); stats.Expirations != 1 || database.queries.Load() != 5 {
+		t.Fatalf("Data of running job did not expire: %+v", stats)
+	}
+}
+
+// Tests if data of finished jobs is read from the disk tier after a restart
+func TestGetDisk(t *testing.T) {
+	database := &slowDB{rows: 10}
+	c := config.Configuration{CacheDir: t.TempDir()}
+	cache := newCache(c, database)
+
+	cache.Get(context.Background(), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+	// Files are written in the background
+	for i := 0; i < 100 && cache.Stats().DiskFiles == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cache = newCache(c, database)
+	data, err := cache.Get(context.Background(), &job.JobMetadata{Id: 1, JobName: "restarted"}, "", 30*time.Second, false)
+	if err != nil || database.queries.Load() != 1 || cache.Stats().DiskHits != 1 {
+		t.Fatalf("Data was not read from disk: %v %+v", err, cache.Stats())
+	}
+	if data.Metadata.JobName != "restarted" || len(data.MetricData[0].Data[""]) != 10 {
+		t.Fatalf("Wrong data read from disk: %+v", data)
+	}
+
+	// Changes of the metrics invalidate the stored data
+	c.Metrics = []config.MetricConfig{{GUID: "new"}}
+	cache.Reconfigure(c)
+	cache.Get(context.Background(), &job.JobMetadata{Id: 1}, "", 30*time.Second, false)
+	if database.queries.Load() != 2 {
+		t.Fatalf("Outdated data was returned after metric change")
+	}
 }
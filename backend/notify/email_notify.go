@@ -3,6 +3,7 @@ package notify
 import (
 	"jobmon/config"
 	"jobmon/logging"
+	"sync"
 
 	"crypto/tls"
 
@@ -17,10 +18,14 @@ type EmailNotifier struct {
 	ReceiverAddress string
 	SmtpHost        string
 	SmtpPort        int
+	// Guards the settings, which are replaced by Init when the configuration changes
+	mut sync.RWMutex
 }
 
 // Init reads email-addresses and passwords from the configuration
 func (em *EmailNotifier) Init(c config.Configuration) {
+	em.mut.Lock()
+	defer em.mut.Unlock()
 	em.SenderAddress = c.Email.SenderAddress
 	em.SenderPassword = c.Email.SenderPassword
 	em.ReceiverAddress = c.Email.ReceiverAddress
@@ -32,21 +37,36 @@ func (em *EmailNotifier) Init(c config.Configuration) {
 // Sends a notification with the given message
 func (em *EmailNotifier) Notify(subject string, message string) error {
 	logging.Info("EmailNotifier: Notify(): Sending message \"", subject, "\" via email")
+	em.mut.RLock()
+	address := em.ReceiverAddress
+	em.mut.RUnlock()
+	return em.send(address, subject, message)
+}
+
+// Sends a notification with the given message to address
+func (em *EmailNotifier) NotifyUser(address string, subject string, message string) error {
+	logging.Info("EmailNotifier: NotifyUser(): Sending message \"", subject, "\" via email to ", address)
+	return em.send(address, subject, message)
+}
 
+// send sends an email with subject and message to address
+func (em *EmailNotifier) send(address string, subject string, message string) error {
+	em.mut.RLock()
 	m := gomail.NewMessage()
 	m.SetHeader("From", em.SenderAddress)
-	m.SetHeader("To", em.ReceiverAddress)
+	m.SetHeader("To", address)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/plain", message)
 
 	// Settings for SMTP server
 	d := gomail.NewDialer(em.SmtpHost, em.SmtpPort, em.SenderAddress, em.SenderPassword)
 	d.TLSConfig = &tls.Config{ServerName: em.SmtpHost}
+	em.mut.RUnlock()
 
 	// Send E-Mail
 	err := d.DialAndSend(m)
 	if err != nil {
-		logging.Error("EmailNotifier: Notify(): Failed to send Email")
+		logging.Error("EmailNotifier: send(): Failed to send Email")
 		return err
 	}
 
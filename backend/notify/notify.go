@@ -4,11 +4,14 @@ import (
 	"jobmon/config"
 )
 
-// Notifier provides functions to send notifications to administrators.
+// Notifier provides functions to send notifications to administrators and users.
 type Notifier interface {
 	// Init initializes the Notifier
 	Init(c config.Configuration)
 
 	// Notify sends a notification
 	Notify(subject string, message string) error
+
+	// NotifyUser sends a notification to the user with the given address
+	NotifyUser(address string, subject string, message string) error
 }
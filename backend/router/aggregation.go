@@ -0,0 +1,95 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/logging"
+	"net/http"
+	"strings"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// BackfillPayload selects the aggregations and the time range of a backfill.
+type BackfillPayload struct {
+	// Time range as Unix times; Stop defaults to now
+	Start int64
+	Stop  int64
+	// Names of the aggregations; the aggregations created since the backend started if empty
+	Names []string
+}
+
+// GetAggregationStatus writes the status of the aggregations of node data as []database.AggregationStatus to w.
+func (r *Router) GetAggregationStatus(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	status, err := (*r.db).GetAggregationStatus(req.Context())
+	if err != nil {
+		logging.Error("Router: GetAggregationStatus(): Could not get status of aggregations: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		logging.Error("Router: GetAggregationStatus(): Could not marshal status of aggregations")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// BackfillAggregations starts to aggregate the historical node data selected by the BackfillPayload
+// in the request body. The progress is part of the status of the aggregations.
+func (r *Router) BackfillAggregations(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: BackfillAggregations(): Could not read http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload BackfillPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		logging.Error("Router: BackfillAggregations(): Could not unmarshal http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	stop := time.Now()
+	if payload.Stop != 0 {
+		stop = time.Unix(payload.Stop, 0)
+	}
+	start := time.Unix(payload.Start, 0)
+	if payload.Start <= 0 || !start.Before(stop) {
+		errStr := fmt.Sprintf("Router: BackfillAggregations(): Invalid time range from %d to %d", payload.Start, payload.Stop)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	if err := (*r.db).BackfillAggregations(start, stop, payload.Names); err != nil {
+		errStr := fmt.Sprintf("Router: BackfillAggregations(): Could not start backfill: %v", err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, user, audit.AggregationBackfill, strings.Join(payload.Names, ","), nil)
+
+	w.WriteHeader(http.StatusAccepted)
+}
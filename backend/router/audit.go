@@ -0,0 +1,108 @@
+package router
+
+import (
+	"encoding/json"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/job"
+	"jobmon/logging"
+	jobstore "jobmon/store"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// audit records action on target performed by user in the audit log.
+// changes may be nil for actions that do not modify configuration or roles.
+func (r *Router) audit(
+	req *http.Request,
+	user auth.UserInfo,
+	action string,
+	target string,
+	changes []jobstore.AuditChange,
+) {
+	r.auditor.Log(
+		jobstore.AuditEntry{
+			Username:       user.Username,
+			ImpersonatedBy: user.ImpersonatedBy,
+			RemoteAddr:     audit.RemoteAddr(req, r.config.Get().TrustedProxies),
+			Action:         action,
+			Target:         target,
+			Changes:        changes,
+		})
+}
+
+// GetAuditLog writes the audit log entries matching the query parameters to w.
+// With format=jsonl the entries are written as JSON lines for export.
+func (r *Router) GetAuditLog(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	query := req.URL.Query()
+	filter := parseAuditFilter(query)
+
+	entries, err := r.store.GetAuditEntries(req.Context(), filter)
+	if err != nil {
+		logging.Error("Router: GetAuditLog(): Could not get audit entries: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"audit.jsonl\"")
+		encoder := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				logging.Error("Router: GetAuditLog(): Could not write audit entry ", e.Id, ": ", err)
+				return
+			}
+		}
+		return
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logging.Error("Router: GetAuditLog(): Could not marshal audit entries")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// parseAuditFilter creates an audit filter from the query parameters Username, Action, Target, Time and Limit.
+func parseAuditFilter(params url.Values) (filter jobstore.AuditFilter) {
+	if str := params.Get("Username"); str != "" {
+		filter.Username = &str
+	}
+	if str := params.Get("Action"); str != "" {
+		filter.Action = &str
+	}
+	if str := params.Get("Target"); str != "" {
+		filter.Target = &str
+	}
+	if str := params.Get("Time"); str != "" {
+		if parts := strings.Split(str, ","); len(parts) == 2 {
+			filter.Time = &job.RangeFilter{}
+			if from, err := strconv.Atoi(parts[0]); err == nil {
+				filter.Time.From = &from
+			}
+			if to, err := strconv.Atoi(parts[1]); err == nil {
+				filter.Time.To = &to
+			}
+		}
+	}
+	if str := params.Get("Limit"); str != "" {
+		if limit, err := strconv.Atoi(str); err == nil && limit > 0 {
+			filter.Limit = limit
+		}
+	}
+	return
+}
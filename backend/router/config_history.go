@@ -0,0 +1,295 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jobmon/analysis"
+	"jobmon/audit"
+	"jobmon/auth"
+	conf "jobmon/config"
+	"jobmon/logging"
+	jobstore "jobmon/store"
+	"net/http"
+	"strconv"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// ConfigDiff contains the changes between two configuration revisions.
+type ConfigDiff struct {
+	// Revision ids; 0 is the current configuration
+	From int64
+	To   int64
+	// Changed fields of the configuration; secrets are redacted
+	Changes []jobstore.AuditChange
+	// GUIDs of the metrics that are configured in From but not in To
+	DeletedMetrics []string
+}
+
+// recordConfigRevision stores newConf applied by author as new configuration revision.
+// Configurations without changes are not stored. Secrets are stored as their references
+// to environment variables or files, or redacted; see conf.Configuration.WithoutSecrets.
+func (r *Router) recordConfigRevision(oldConf *conf.Configuration, newConf *conf.Configuration, author string) {
+	if oldConf != nil && len(audit.Diff(oldConf, newConf)) == 0 {
+		return
+	}
+	data, err := json.Marshal(newConf.WithoutSecrets())
+	if err != nil {
+		logging.Error("Router: recordConfigRevision(): Could not marshal configuration: ", err)
+		return
+	}
+	revision :=
+		jobstore.ConfigRevision{
+			Author:    author,
+			CreatedAt: time.Now(),
+			Config:    data,
+		}
+	if err := r.store.AddConfigRevision(&revision); err != nil {
+		logging.Error("Router: recordConfigRevision(): Could not store configuration revision: ", err)
+		return
+	}
+	logging.Info("Router: recordConfigRevision(): Stored configuration revision ", revision.Id, " by ", author)
+}
+
+// recordStartupRevision stores the configuration read at startup, if it differs from the latest revision.
+func (r *Router) recordStartupRevision() {
+	current := r.config.Get()
+	revisions, err := r.store.GetConfigRevisions(context.Background())
+	if err == nil && len(revisions) > 0 {
+		latest, err := r.store.GetConfigRevision(context.Background(), revisions[0].Id)
+		if err == nil && len(audit.Diff(latest.Config, current.WithoutSecrets())) == 0 {
+			return
+		}
+	}
+	r.recordConfigRevision(nil, current, conf.FileAuthor)
+}
+
+// configOfRevision returns the configuration stored in the revision with id 'id',
+// or the current configuration if id is 0. Redacted secrets are taken from the current configuration.
+func (r *Router) configOfRevision(ctx context.Context, id int64) (conf.Configuration, error) {
+	current := r.config.Get()
+	if id == 0 {
+		return *current, nil
+	}
+	revision, err := r.store.GetConfigRevision(ctx, id)
+	if err != nil {
+		return conf.Configuration{}, fmt.Errorf("unknown configuration revision %d", id)
+	}
+	c, err := conf.Parse(current.CLIConfig, revision.Config)
+	if err != nil {
+		return c, err
+	}
+	return c, c.RestoreSecrets(*current)
+}
+
+// diffConfigRevisions returns the changes from revision 'from' to revision 'to'.
+func (r *Router) diffConfigRevisions(ctx context.Context, from int64, to int64) (diff ConfigDiff, err error) {
+	fromConf, err := r.configOfRevision(ctx, from)
+	if err != nil {
+		return
+	}
+	toConf, err := r.configOfRevision(ctx, to)
+	if err != nil {
+		return
+	}
+	diff =
+		ConfigDiff{
+			From:           from,
+			To:             to,
+			Changes:        audit.Diff(fromConf, toConf),
+			DeletedMetrics: toConf.GetDeletedMetrics(fromConf),
+		}
+	return
+}
+
+// GetConfigRevisions writes all configuration revisions without their configuration to w.
+func (r *Router) GetConfigRevisions(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	revisions, err := r.store.GetConfigRevisions(req.Context())
+	if err != nil {
+		logging.Error("Router: GetConfigRevisions(): Could not get configuration revisions: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		logging.Error("Router: GetConfigRevisions(): Could not marshal configuration revisions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// GetConfigRevision writes the configuration revision given by the http request parameter id to w.
+func (r *Router) GetConfigRevision(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	id, ok := parseRevisionId(w, params.ByName("id"), "GetConfigRevision")
+	if !ok {
+		return
+	}
+
+	revision, err := r.store.GetConfigRevision(req.Context(), id)
+	if err != nil {
+		logging.Error("Router: GetConfigRevision(): Could not get configuration revision ", id, ": ", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Revisions stored before secrets were redacted may contain them
+	c, err := conf.Parse(r.config.Get().CLIConfig, revision.Config)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: GetConfigRevision(): Could not read configuration revision %d: %v", id, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errStr))
+		return
+	}
+	revision.Config, err = json.Marshal(c.WithoutSecrets())
+	if err != nil {
+		logging.Error("Router: GetConfigRevision(): Could not marshal configuration of revision ", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(revision)
+	if err != nil {
+		logging.Error("Router: GetConfigRevision(): Could not marshal configuration revision ", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// DiffConfigRevisions writes the changes from the revision given by the http request parameter id
+// to the revision given by the query parameter to (default: current configuration) to w.
+func (r *Router) DiffConfigRevisions(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	from, ok := parseRevisionId(w, params.ByName("id"), "DiffConfigRevisions")
+	if !ok {
+		return
+	}
+	var to int64
+	if str := req.URL.Query().Get("to"); str != "" {
+		if to, ok = parseRevisionId(w, str, "DiffConfigRevisions"); !ok {
+			return
+		}
+	}
+
+	diff, err := r.diffConfigRevisions(req.Context(), from, to)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: DiffConfigRevisions(): Could not diff configuration revisions %d and %d: %v", from, to, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	data, err := json.Marshal(diff)
+	if err != nil {
+		logging.Error("Router: DiffConfigRevisions(): Could not marshal configuration diff")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// RollbackConfig applies the configuration revision given by the http request parameter id.
+// If the rollback removes metrics, it is only applied with the query parameter force=true.
+func (r *Router) RollbackConfig(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	id, ok := parseRevisionId(w, params.ByName("id"), "RollbackConfig")
+	if !ok {
+		return
+	}
+
+	target, err := r.configOfRevision(req.Context(), id)
+	if err == nil && id == 0 {
+		err = fmt.Errorf("no revision given")
+	}
+	// Revisions may be invalid for the current code and database, they are validated like updates
+	var errs conf.ValidationErrors
+	if err == nil {
+		errs = append(target.Validate(), analysis.ValidateConfig(&target)...)
+	} else {
+		errors.As(err, &errs)
+	}
+	if len(errs) > 0 {
+		logging.Error("Router: RollbackConfig(): Rejected invalid configuration revision ", id, ": ", errs)
+		data, _ := json.Marshal(ConfigValidationResult{Valid: false, Errors: errs})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(data)
+		return
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Router: RollbackConfig(): Could not roll back to configuration revision %d: %v", id, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	current := r.config.Get()
+	diff :=
+		ConfigDiff{
+			From:           0,
+			To:             id,
+			Changes:        audit.Diff(current, target),
+			DeletedMetrics: target.GetDeletedMetrics(*current),
+		}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		logging.Error("Router: RollbackConfig(): Could not marshal configuration diff")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Warn about removed metrics instead of silently dropping them
+	if len(diff.DeletedMetrics) > 0 && req.URL.Query().Get("force") != "true" {
+		logging.Warning("Router: RollbackConfig(): Rollback to revision ", id, " would remove metrics ", diff.DeletedMetrics)
+		w.WriteHeader(http.StatusConflict)
+		w.Write(data)
+		return
+	}
+
+	r.config.Update(target, user.Username)
+	r.config.Flush()
+	r.audit(req, user, audit.ConfigRollback, strconv.FormatInt(id, 10), diff.Changes)
+
+	w.Write(data)
+	logging.Warning("Router: RollbackConfig(): ", user.Username, " rolled back configuration to revision ", id)
+}
+
+// parseRevisionId converts str to a configuration revision id.
+func parseRevisionId(w http.ResponseWriter, str string, caller string) (int64, bool) {
+	id, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || id < 0 {
+		logging.Error("Router: ", caller, "(): Could not convert '", str, "' to configuration revision id")
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
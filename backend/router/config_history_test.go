@@ -0,0 +1,163 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/config"
+	jobstore "jobmon/store"
+	"jobmon/test"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// newConfigHistoryTestRouter returns a Router recording the configuration revisions of a copy
+// of the test config file. Revision 1 is the test config, revision 2 adds the metric "added".
+func newConfigHistoryTestRouter(t *testing.T) (*Router, *test.MockStore) {
+	data, err := os.ReadFile("../config/test_config.json")
+	if err != nil {
+		t.Fatalf("Could not read test config: %v", err)
+	}
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, data, 0600); err != nil {
+		t.Fatalf("Could not write test config: %v", err)
+	}
+	c, err := config.Load(config.CLIConfig{ConfigFile: configFile})
+	if err != nil {
+		t.Fatalf("Could not load test config: %v", err)
+	}
+
+	r, mockStore := newTestRouter(t, c)
+	r.recordStartupRevision()
+	r.config.Subscribe(r.recordConfigRevision)
+
+	added := *r.config.Get()
+	metric := added.Metrics[0]
+	metric.GUID = "added"
+	added.Metrics = append(append([]config.MetricConfig{}, added.Metrics...), metric)
+	r.config.Update(added, "admin")
+	if len(mockStore.Revisions) != 2 {
+		t.Fatalf("Wrong number of revisions recorded: %+v", mockStore.Revisions)
+	}
+	return r, mockStore
+}
+
+// hasMetric returns whether c contains the metric with guid.
+func hasMetric(c *config.Configuration, guid string) bool {
+	for _, m := range c.Metrics {
+		if m.GUID == guid {
+			return true
+		}
+	}
+	return false
+}
+
+// hasChange returns whether one of changes sets or removes value.
+func hasChange(changes []jobstore.AuditChange, value string) bool {
+	for _, change := range changes {
+		if change.Before == value || change.After == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Tests if the revisions are listed newest first without their configuration
+// and if the secrets of the config file are not stored in the revisions
+func TestGetConfigRevisions(t *testing.T) {
+	r, mockStore := newConfigHistoryTestRouter(t)
+
+	w := httptest.NewRecorder()
+	r.GetConfigRevisions(w, httptest.NewRequest(http.MethodGet, "/api/config/revisions", nil), nil, auth.UserInfo{Username: "admin"})
+	var revisions []jobstore.ConfigRevision
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("Wrong response %d: %v", w.Code, err)
+	}
+	if len(revisions) != 2 || revisions[0].Id != 2 || revisions[0].Author != "admin" ||
+		revisions[1].Author != config.FileAuthor || revisions[0].Config != nil {
+		t.Fatalf("Wrong revisions %+v", revisions)
+	}
+
+	for _, revision := range mockStore.Revisions {
+		for _, secret := range []string{"my-jwtsecret", "my-token", "my-password", "my-secret"} {
+			if bytes.Contains(revision.Config, []byte(secret)) {
+				t.Errorf("Revision %d contains secret %s", revision.Id, secret)
+			}
+		}
+	}
+}
+
+// Tests if the diff between revisions reports the changes and the metrics removed by them
+func TestDiffConfigRevisions(t *testing.T) {
+	r, _ := newConfigHistoryTestRouter(t)
+
+	diff := func(id string, target string) (d ConfigDiff) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		r.DiffConfigRevisions(w, req, httprouter.Params{{Key: "id", Value: id}}, auth.UserInfo{Username: "admin"})
+		if err := json.Unmarshal(w.Body.Bytes(), &d); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: wrong response %d: %v", target, w.Code, err)
+		}
+		return
+	}
+
+	// The current configuration added the metric to revision 1
+	d := diff("1", "/api/config/revisions/1/diff")
+	if d.From != 1 || d.To != 0 || len(d.DeletedMetrics) != 0 || !hasChange(d.Changes, "added") {
+		t.Fatalf("Wrong diff to current configuration %+v", d)
+	}
+	d = diff("2", "/api/config/revisions/2/diff?to=1")
+	if d.From != 2 || d.To != 1 || !hasChange(d.Changes, "added") ||
+		len(d.DeletedMetrics) != 1 || d.DeletedMetrics[0] != "added" {
+		t.Fatalf("Wrong diff to revision 1 %+v", d)
+	}
+	for _, change := range d.Changes {
+		if change.Field == "JWTSecret" {
+			t.Fatalf("Unchanged secret in diff %+v", change)
+		}
+	}
+}
+
+// Tests if a rollback that removes metrics is only applied with force=true
+func TestRollbackConfig(t *testing.T) {
+	r, mockStore := newConfigHistoryTestRouter(t)
+	params := httprouter.Params{{Key: "id", Value: "1"}}
+	admin := auth.UserInfo{Username: "admin"}
+
+	w := httptest.NewRecorder()
+	r.RollbackConfig(w, httptest.NewRequest(http.MethodPost, "/api/config/revisions/1/rollback", nil), params, admin)
+	var d ConfigDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &d); w.Code != http.StatusConflict || err != nil {
+		t.Fatalf("Rollback removing metrics was not rejected: %d %v", w.Code, err)
+	}
+	if len(d.DeletedMetrics) != 1 || d.DeletedMetrics[0] != "added" {
+		t.Fatalf("Wrong removed metrics %+v", d)
+	}
+	if !hasMetric(r.config.Get(), "added") {
+		t.Fatalf("Rejected rollback was applied")
+	}
+
+	w = httptest.NewRecorder()
+	r.RollbackConfig(w, httptest.NewRequest(http.MethodPost, "/api/config/revisions/1/rollback?force=true", nil), params, admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Forced rollback failed: %d %s", w.Code, w.Body.String())
+	}
+	if hasMetric(r.config.Get(), "added") {
+		t.Fatalf("Forced rollback was not applied")
+	}
+	if r.config.Get().JWTSecret != "my-jwtsecret" {
+		t.Fatalf("Secret was not restored on rollback: %s", r.config.Get().JWTSecret)
+	}
+	if len(mockStore.Revisions) != 3 || mockStore.Revisions[2].Author != "admin" {
+		t.Fatalf("Rollback was not recorded as revision %+v", mockStore.Revisions)
+	}
+	if entry := auditEntry(t, mockStore, audit.ConfigRollback); entry.Target != "1" {
+		t.Fatalf("Wrong audit entry %+v", entry)
+	}
+}
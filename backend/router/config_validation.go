@@ -0,0 +1,47 @@
+package router
+
+import (
+	"encoding/json"
+	"jobmon/analysis"
+	"jobmon/auth"
+	conf "jobmon/config"
+	"jobmon/logging"
+	"net/http"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// ConfigValidationResult contains all problems found in a configuration.
+type ConfigValidationResult struct {
+	Valid  bool
+	Errors conf.ValidationErrors
+}
+
+// ValidateConfig checks the configuration update in the body of req like UpdateConfig
+// without applying it. Besides the checks of Validate, it checks that the measurements
+// of all metrics exist, that their Flux code compiles and that the analyzers exist.
+func (r *Router) ValidateConfig(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	_, updated, ok := r.mergeConfigUpdate(w, req, "ValidateConfig")
+	if !ok {
+		return
+	}
+
+	errs := updated.Validate()
+	errs = append(errs, analysis.ValidateConfig(&updated)...)
+	errs = append(errs, (*r.db).ValidateMetrics(req.Context(), updated.Metrics)...)
+
+	data, err := json.Marshal(ConfigValidationResult{Valid: len(errs) == 0, Errors: errs})
+	if err != nil {
+		logging.Error("Router: ValidateConfig(): Could not marshal validation result")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
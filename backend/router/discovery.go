@@ -0,0 +1,81 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jobmon/auth"
+	database "jobmon/db"
+	"jobmon/job"
+	"jobmon/logging"
+	"net/http"
+	"strings"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// Default time range inspected by DiscoverMetrics
+const defaultDiscoveryLookback = 24 * time.Hour
+
+// DiscoverMetrics inspects the metrics database and writes proposed metric configurations,
+// their partitions and the configured metrics without data as database.MetricDiscovery to w.
+func (r *Router) DiscoverMetrics(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	lookback := defaultDiscoveryLookback
+	if str := req.URL.Query().Get("lookback"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil || d <= 0 {
+			errStr := fmt.Sprintf("Router: DiscoverMetrics(): Invalid lookback '%s'", str)
+			logging.Error(errStr)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errStr))
+			return
+		}
+		lookback = d
+	}
+
+	measurements, err := (*r.db).DiscoverMeasurements(req.Context(), lookback)
+	if err != nil {
+		logging.Error("Router: DiscoverMetrics(): Could not inspect metrics database: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	discovery := database.ProposeMetrics(measurements, *r.config.Get(), r.partitionNodes(req.Context(), lookback))
+	data, err := json.Marshal(discovery)
+	if err != nil {
+		logging.Error("Router: DiscoverMetrics(): Could not marshal metric discovery")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// partitionNodes returns the nodes of each partition used by jobs that started during the last lookback.
+func (r *Router) partitionNodes(ctx context.Context, lookback time.Duration) map[string][]string {
+	from := int(time.Now().Add(-lookback).Unix())
+	jobs, err := r.store.GetFilteredJobs(ctx, job.JobFilter{Time: &job.RangeFilter{From: &from}})
+	if err != nil {
+		logging.Error("Router: partitionNodes(): Could not get jobs: ", err)
+		return map[string][]string{}
+	}
+
+	seen := make(map[string]bool)
+	partitionNodes := make(map[string][]string)
+	for _, j := range jobs {
+		for _, node := range strings.Split(j.NodeList, "|") {
+			if key := j.Partition + "|" + node; node != "" && !seen[key] {
+				seen[key] = true
+				partitionNodes[j.Partition] = append(partitionNodes[j.Partition], node)
+			}
+		}
+	}
+	return partitionNodes
+}
@@ -0,0 +1,159 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	// Pure Go Brotli encoder and decoder
+	"github.com/andybalholm/brotli"
+	// MessagePack encoding for Golang
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Media types of the supported response encodings
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeMsgpack = "application/msgpack"
+)
+
+// compressWriter is a writer of a compressed content encoding that can be reused with Reset.
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// Reused writers per content encoding; creating a writer allocates several hundred kilobytes
+var compressWriters = map[string]*sync.Pool{
+	"br": {
+		New: func() any {
+			return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+		},
+	},
+	"gzip": {
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	},
+}
+
+// acceptsMsgpack checks if the client of req accepts MessagePack responses.
+func acceptsMsgpack(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		if mediaType == mediaTypeMsgpack || mediaType == "application/x-msgpack" {
+			return true
+		}
+	}
+	return false
+}
+
+// columnar checks if the time series of the response to req should be in columnar representation,
+// requested with query parameter format=columnar or implied by MessagePack responses.
+func columnar(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "columnar" || acceptsMsgpack(req)
+}
+
+// encode marshals v as MessagePack if accepted by the client of req and as JSON otherwise.
+// The struct fields use the same names in both encodings.
+func encode(req *http.Request, v any) (data []byte, contentType string, err error) {
+	if !acceptsMsgpack(req) {
+		data, err = json.Marshal(v)
+		return data, mediaTypeJSON, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	err = enc.Encode(v)
+	return buf.Bytes(), mediaTypeMsgpack, err
+}
+
+// acceptedEncoding returns the content encoding for the response to req: br if accepted
+// by the client, otherwise gzip if accepted, otherwise "" for uncompressed responses.
+func acceptedEncoding(req *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, accept := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		// Codings with quality 0 are not acceptable
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+	}
+	for _, encoding := range []string{"br", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compress returns a handler compressing the responses of h with Brotli or gzip for clients accepting it.
+// WebSocket connections are passed through unchanged.
+func compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		encoding := acceptedEncoding(req)
+		if encoding == "" || strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			h.ServeHTTP(w, req)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		h.ServeHTTP(cw, req)
+	})
+}
+
+// compressResponseWriter compresses the body written to the ResponseWriter with encoding.
+// The response is only compressed once a body is written, so responses consisting
+// of a status code only are sent without Content-Encoding.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	cw       compressWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.cw == nil {
+		header := w.Header()
+		if header.Get("Content-Type") == "" {
+			// Detect the type of the uncompressed data, not of the compressed one
+			header.Set("Content-Type", http.DetectContentType(b))
+		}
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		header.Add("Vary", "Accept-Encoding")
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.cw = compressWriters[w.encoding].Get().(compressWriter)
+		w.cw.Reset(w.ResponseWriter)
+	}
+	return w.cw.Write(b)
+}
+
+// close finishes the compressed body or sends the status code if no body was written.
+func (w *compressResponseWriter) close() {
+	if w.cw == nil {
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return
+	}
+	w.cw.Close()
+	compressWriters[w.encoding].Put(w.cw)
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"jobmon/config"
+	"jobmon/job"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Tests if MessagePack is negotiated with the Accept header and decodes to the same data as JSON
+func TestEncode(t *testing.T) {
+	value := 1.5
+	data := job.ColumnarJobData{
+		MetricData: []job.ColumnarMetricData{{
+			Config: config.MetricConfig{GUID: "m", Measurement: "flops_any"},
+			Data:   map[string]job.Series{"n1": {Times: []int64{1000, 2000}, Values: []*float64{&value, nil}}},
+		}},
+		SampleInterval: 30,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/job/1", nil)
+	encoded, contentType, err := encode(req, data)
+	if err != nil || contentType != mediaTypeJSON {
+		t.Fatalf("Wrong JSON encoding %s: %v", contentType, err)
+	}
+	if columnar(req) {
+		t.Fatalf("Columnar representation without request")
+	}
+	var fromJSON job.ColumnarJobData
+	if err := json.Unmarshal(encoded, &fromJSON); err != nil {
+		t.Fatalf("Could not unmarshal JSON: %v", err)
+	}
+
+	req.Header.Set("Accept", "text/html, application/msgpack;q=0.9")
+	encoded, contentType, err = encode(req, data)
+	if err != nil || contentType != mediaTypeMsgpack {
+		t.Fatalf("Wrong MessagePack encoding %s: %v", contentType, err)
+	}
+	if !columnar(req) {
+		t.Fatalf("MessagePack does not imply the columnar representation")
+	}
+	var fromMsgpack job.ColumnarJobData
+	dec := msgpack.NewDecoder(bytes.NewReader(encoded))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&fromMsgpack); err != nil {
+		t.Fatalf("Could not unmarshal MessagePack: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromMsgpack) {
+		t.Fatalf("Encodings differ:\nJSON %+v\nMessagePack %+v", fromJSON, fromMsgpack)
+	}
+
+	if !columnar(httptest.NewRequest(http.MethodGet, "/api/job/1?format=columnar", nil)) {
+		t.Fatalf("Columnar representation was not requested with format=columnar")
+	}
+}
+
+// Tests if responses are compressed with the encoding accepted by the client
+// and if WebSocket upgrades and responses without body are passed through
+func TestCompress(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"_time":"2024-01-01T00:00:00Z","_value":1.5},`), 100)
+	handler := compress(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"":     func(r io.Reader) (io.Reader, error) { return r, nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	tests := []struct {
+		acceptEncoding string
+		upgrade        string
+		encoding       string
+	}{
+		{"", "", ""},
+		{"gzip, deflate", "", "gzip"},
+		{"gzip, br", "", "br"},
+		{"br;q=0, GZIP;q=0.5", "", "gzip"},
+		{"identity", "", ""},
+		{"gzip, br", "websocket", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/job/1", nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		req.Header.Set("Upgrade", test.upgrade)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated || w.Header().Get("Content-Encoding") != test.encoding ||
+			w.Header().Get("Content-Type") != mediaTypeJSON {
+			t.Errorf("%q: wrong response %d %v", test.acceptEncoding, w.Code, w.Header())
+			continue
+		}
+		r, err := decoders[test.encoding](w.Body)
+		if err != nil {
+			t.Errorf("%q: could not decode response: %v", test.acceptEncoding, err)
+			continue
+		}
+		if decoded, err := io.ReadAll(r); err != nil || !bytes.Equal(decoded, body) {
+			t.Errorf("%q: wrong body: %v", test.acceptEncoding, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/empty", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+		t.Errorf("Wrong response without body %d %v", w.Code, w.Header())
+	}
+}
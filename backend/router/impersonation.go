@@ -0,0 +1,105 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/logging"
+	"net/http"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// Cookie holding the session of the admin while they impersonate another user
+const impersonatorCookie = "AuthorizationImpersonator"
+
+// ImpersonationResponse is returned when an admin starts impersonating a user.
+type ImpersonationResponse struct {
+	Token   string
+	Expires time.Time
+	User    auth.UserInfo
+}
+
+// Impersonate lets the admin user view the system as the user given by the http request parameter user.
+// The admins session cookie is kept, so it can be restored by StopImpersonation.
+func (r *Router) Impersonate(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+	target := params.ByName("user")
+
+	token, impersonated, expires, err := r.authManager.GenerateImpersonationJWT(req.Context(), user, target)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: Impersonate(): Could not impersonate user '%s': %v", target, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, user, audit.ImpersonationStart, target, nil)
+
+	data, err := json.Marshal(ImpersonationResponse{Token: token, Expires: expires, User: impersonated})
+	if err != nil {
+		logging.Error("Router: Impersonate(): Could not marshal impersonation response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if adminCookie, err := req.Cookie("Authorization"); err == nil {
+		http.SetCookie(w,
+			&http.Cookie{
+				Name:     impersonatorCookie,
+				Value:    adminCookie.Value,
+				Expires:  time.Now().Add(r.config.Get().JSONWebTokenLifeTime),
+				Path:     "/",
+				HttpOnly: true,
+			})
+	}
+	http.SetCookie(w,
+		&http.Cookie{
+			Name:    "Authorization",
+			Value:   "Bearer " + token,
+			Expires: expires,
+			Path:    "/",
+		})
+	w.Write(data)
+}
+
+// StopImpersonation ends the impersonation session of the user and restores the admins session cookie.
+func (r *Router) StopImpersonation(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	if err := r.authManager.StopImpersonation(user); err != nil {
+		logging.Error("Router: StopImpersonation(): ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.audit(req, user, audit.ImpersonationStop, user.Username, nil)
+
+	authorization := http.Cookie{
+		Name:    "Authorization",
+		Value:   "",
+		Expires: time.Unix(0, 0),
+		Path:    "/",
+	}
+	if adminCookie, err := req.Cookie(impersonatorCookie); err == nil {
+		authorization.Value = adminCookie.Value
+		authorization.Expires = time.Now().Add(r.config.Get().JSONWebTokenLifeTime)
+	}
+	http.SetCookie(w, &authorization)
+	http.SetCookie(w,
+		&http.Cookie{
+			Name:    impersonatorCookie,
+			Value:   "",
+			Expires: time.Unix(0, 0),
+			Path:    "/",
+		})
+	w.WriteHeader(http.StatusOK)
+}
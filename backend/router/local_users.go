@@ -0,0 +1,188 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/logging"
+	"net/http"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetLocalUsers writes all local users managed in the store to w.
+func (r *Router) GetLocalUsers(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	users, err := r.authManager.GetLocalUsers(req.Context())
+	if err != nil {
+		logging.Error("Router: GetLocalUsers(): Could not get local users: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(users)
+	if err != nil {
+		logging.Error("Router: GetLocalUsers(): Could not marshal local users")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// CreateLocalUser creates the local user given in the http request body.
+func (r *Router) CreateLocalUser(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	caller auth.UserInfo) {
+
+	payload, ok := parseLocalUserPayload(w, req, "CreateLocalUser")
+	if !ok {
+		return
+	}
+
+	user, err := r.authManager.CreateLocalUser(req.Context(), payload)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: CreateLocalUser(): Could not create local user '%s': %v", payload.Username, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, caller, audit.LocalUserCreate, user.Username, audit.Diff(nil, user))
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		logging.Error("Router: CreateLocalUser(): Could not marshal local user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// UpdateLocalUser updates the local user given by the http request parameter user.
+func (r *Router) UpdateLocalUser(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	caller auth.UserInfo) {
+	userStr := params.ByName("user")
+
+	payload, ok := parseLocalUserPayload(w, req, "UpdateLocalUser")
+	if !ok {
+		return
+	}
+
+	before, _ := r.store.GetLocalUser(req.Context(), userStr)
+	user, err := r.authManager.UpdateLocalUser(req.Context(), userStr, payload)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: UpdateLocalUser(): Could not update local user '%s': %v", userStr, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, caller, audit.LocalUserUpdate, userStr, audit.Diff(before, user))
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		logging.Error("Router: UpdateLocalUser(): Could not marshal local user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// RemoveLocalUser removes the local user given by the http request parameter user.
+func (r *Router) RemoveLocalUser(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	caller auth.UserInfo) {
+	userStr := params.ByName("user")
+
+	before, _ := r.store.GetLocalUser(req.Context(), userStr)
+	err := r.authManager.RemoveLocalUser(req.Context(), userStr)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: RemoveLocalUser(): Could not remove local user '%s': %v", userStr, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, caller, audit.LocalUserRemove, userStr, audit.Diff(before, nil))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ChangePassword changes the password of the authenticated local user.
+func (r *Router) ChangePassword(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: ChangePassword(): Could not read http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var payload auth.PasswordChangePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		logging.Error("Router: ChangePassword(): Could not unmarshal http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = r.authManager.ChangePassword(req.Context(), user.Username, payload)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: ChangePassword(): Could not change password of user '%s': %v", user.Username, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	r.audit(req, user, audit.PasswordChange, user.Username, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseLocalUserPayload reads an auth.LocalUserPayload from the http request body.
+func parseLocalUserPayload(
+	w http.ResponseWriter,
+	req *http.Request,
+	caller string,
+) (
+	payload auth.LocalUserPayload,
+	ok bool,
+) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: ", caller, "(): Could not read http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		logging.Error("Router: ", caller, "(): Could not unmarshal http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ok = true
+	return
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"encoding/json"
+	"jobmon/auth"
+	conf "jobmon/config"
+	"jobmon/logging"
+	"net/http"
+	"strconv"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetJobPhases writes the phases of the job given by the http request parameter id to w.
+// The phases stored with the job are returned unless the job is still running or
+// the query parameters request a different change point detection.
+func (r *Router) GetJobPhases(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	strId := params.ByName("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		logging.Error("Router: GetJobPhases(): Could not convert '", strId, "' to job id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	detection, err := r.phaseDetection(req)
+	if err != nil {
+		logging.Error("Router: GetJobPhases(): Invalid phase detection: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	j, err := r.store.GetJob(req.Context(), id)
+	if err != nil {
+		logging.Error("Router: GetJobPhases(): Could not get job meta data (job ID = ", id, "): ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !user.CanViewJob(&j) {
+		logging.Error("Router: GetJobPhases(): User ", user.Username, " is not permitted to access job ", j.Id)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	phases := j.Phases
+	if j.IsRunning || phases == nil ||
+		phases.Method != detection.Method ||
+		phases.Penalty != detection.Penalty ||
+		phases.MinSegment != detection.MinSegment {
+		if j.IsRunning {
+			j.StopTime = int(time.Now().Unix())
+		}
+		computed, err := (*r.db).GetJobPhases(req.Context(), &j, detection)
+		if err != nil {
+			logging.Error("Router: GetJobPhases(): Could not get phases of job ", j.Id, ": ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		phases = &computed
+	}
+
+	data, err := json.Marshal(phases)
+	if err != nil {
+		logging.Error("Router: GetJobPhases(): Could not marshal phases of job ", j.Id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// phaseDetection returns the configured phase detection with the defaults applied,
+// overridden by the query parameters method, penalty and min_segment of req.
+func (r *Router) phaseDetection(req *http.Request) (detection conf.PhaseDetectionConfig, err error) {
+	if c := r.config.Get().PhaseDetection; c != nil {
+		detection = *c
+	}
+
+	query := req.URL.Query()
+	if method := query.Get("method"); method != "" {
+		// The configured penalty may not be supported by the requested method
+		detection = conf.PhaseDetectionConfig{Method: method, MinSegment: detection.MinSegment}
+	}
+	if penalty := query.Get("penalty"); penalty != "" {
+		detection.Penalty, err = strconv.ParseFloat(penalty, 64)
+		if err != nil {
+			return detection, err
+		}
+	}
+	if minSegment := query.Get("min_segment"); minSegment != "" {
+		detection.MinSegment, err = strconv.Atoi(minSegment)
+		if err != nil {
+			return detection, err
+		}
+	}
+	if errs := detection.Validate(); len(errs) > 0 {
+		return detection, errs
+	}
+	return detection.WithDefaults(), nil
+}
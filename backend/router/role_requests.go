@@ -0,0 +1,186 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/logging"
+	jobstore "jobmon/store"
+	"net/http"
+	"strconv"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+)
+
+// RoleRequestPayload is the body of a role request.
+type RoleRequestPayload struct {
+	// Requested role, defaults to "user"
+	Role string
+	// Reason for the request
+	Reason string
+	// Contact address, only used if the session carries no email address
+	Email string
+}
+
+// RoleDecisionPayload is the body of an approval or denial of a role request.
+type RoleDecisionPayload struct {
+	Comment string
+}
+
+// RequestRole stores a role request for the authenticated user and notifies the administrators.
+func (r *Router) RequestRole(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: RequestRole(): Could not read http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var dat RoleRequestPayload
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &dat)
+		if err != nil {
+			logging.Error("Router: RequestRole(): Could not unmarshal http request body")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	request, err := r.authManager.RequestRole(req.Context(), user, dat.Role, dat.Reason, dat.Email)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: RequestRole(): Could not request role for user '%s': %v", user.Username, err)
+		logging.Error(errStr)
+		if errors.Is(err, auth.ErrRoleRequestLimit) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(errStr))
+		return
+	}
+
+	data, err := json.Marshal(&request)
+	if err != nil {
+		logging.Error("Router: RequestRole(): Could not marshal role request")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// GetRoleRequests writes all role requests to w. The optional http request
+// parameter status restricts the result to requests with this status.
+func (r *Router) GetRoleRequests(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	status := req.URL.Query().Get("status")
+	requests, err := r.store.GetRoleRequests(req.Context(), status)
+	if err != nil {
+		logging.Error("Router: GetRoleRequests(): Could not get role requests: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(requests)
+	if err != nil {
+		logging.Error("Router: GetRoleRequests(): Could not marshal role requests")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// ApproveRoleRequest grants the requested role to the requester.
+func (r *Router) ApproveRoleRequest(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+	r.decideRoleRequest(w, req, params, user, true)
+}
+
+// DenyRoleRequest denies the role request.
+func (r *Router) DenyRoleRequest(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+	r.decideRoleRequest(w, req, params, user, false)
+}
+
+// decideRoleRequest approves or denies the role request given by the http request parameter id.
+func (r *Router) decideRoleRequest(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo,
+	approve bool) {
+
+	strId := params.ByName("id")
+	id, err := strconv.ParseInt(strId, 10, 64)
+	if err != nil {
+		logging.Error("Router: decideRoleRequest(): Could not convert '", strId, "' to role request id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: decideRoleRequest(): Could not read http request body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var dat RoleDecisionPayload
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &dat)
+		if err != nil {
+			logging.Error("Router: decideRoleRequest(): Could not unmarshal http request body")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var before jobstore.UserRoles
+	if pending, err := r.store.GetRoleRequest(req.Context(), id); err == nil {
+		before = r.authManager.UserRoles(req.Context(), pending.Username)
+	}
+	request, err := r.authManager.DecideRoleRequest(req.Context(), id, user.Username, approve, dat.Comment)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: decideRoleRequest(): Could not decide role request %d: %v", id, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+	action := audit.RoleRequestDeny
+	var changes []jobstore.AuditChange
+	if approve {
+		action = audit.RoleRequestApprove
+		changes = audit.Diff(before, r.authManager.UserRoles(req.Context(), request.Username))
+	}
+	r.audit(req, user, action, request.Username, changes)
+
+	data, err := json.Marshal(&request)
+	if err != nil {
+		logging.Error("Router: decideRoleRequest(): Could not marshal role request")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
@@ -0,0 +1,46 @@
+package router
+
+import (
+	"context"
+	"jobmon/audit"
+	"jobmon/auth"
+	"jobmon/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Tests if approving the role request of a local user managed in the store records the added role
+func TestApproveLocalUserRoleRequest(t *testing.T) {
+	r, mockStore := newTestRouter(t, config.Configuration{RoleRequestsPerDay: 1})
+	password := "alice-password"
+	roles := []string{auth.USER}
+	if _, err := r.authManager.CreateLocalUser(context.Background(), auth.LocalUserPayload{Username: "alice", Password: &password, Roles: &roles}); err != nil {
+		t.Fatalf("Could not create local user: %v", err)
+	}
+	request, err := r.authManager.RequestRole(context.Background(), auth.UserInfo{Username: "alice", Roles: roles}, auth.ACCOUNTMANAGER, "", "")
+	if err != nil {
+		t.Fatalf("Could not request role: %v", err)
+	}
+
+	id := strconv.FormatInt(request.Id, 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/role_requests/"+id+"/approve", strings.NewReader(`{"Comment":"ok"}`))
+	w := httptest.NewRecorder()
+	params := httprouter.Params{{Key: "id", Value: id}}
+	r.ApproveRoleRequest(w, req, params, auth.UserInfo{Username: "admin"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status %d: %s", w.Code, w.Body.String())
+	}
+	if roles := mockStore.LocalUsers["alice"].Roles; len(roles) != 2 || roles[1] != request.Role {
+		t.Fatalf("Role was not added to local user: %v", roles)
+	}
+
+	entry := auditEntry(t, mockStore, audit.RoleRequestApprove)
+	if entry.Target != "alice" || len(entry.Changes) != 1 || entry.Changes[0].After != request.Role {
+		t.Fatalf("Wrong audit entry %+v", entry)
+	}
+}
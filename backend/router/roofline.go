@@ -0,0 +1,109 @@
+package router
+
+import (
+	"encoding/json"
+	"jobmon/analysis"
+	"jobmon/auth"
+	conf "jobmon/config"
+	"jobmon/job"
+	"jobmon/logging"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// HttpRouter is a lightweight high performance HTTP request router (also called multiplexer or just mux for short) for Go
+	"github.com/julienschmidt/httprouter"
+	// Package slices defines various functions useful with slices of any type
+	"golang.org/x/exp/slices"
+)
+
+// GetRoofline writes the roofline analysis of the job given by the http request parameter id to w.
+func (r *Router) GetRoofline(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	strId := params.ByName("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		logging.Error("Router: GetRoofline(): Could not convert '", strId, "' to job id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	config := r.config.Get()
+	if config.Roofline == nil {
+		errStr := "Router: GetRoofline(): Roofline analysis is not configured"
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errStr))
+		return
+	}
+	flopsMetric, ok1 := findMetric(config, config.Roofline.FlopsMetric)
+	memBandwidthMetric, ok2 := findMetric(config, config.Roofline.MemBandwidthMetric)
+	if !ok1 || !ok2 {
+		logging.Error("Router: GetRoofline(): Roofline metrics are not configured")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	j, err := r.store.GetJob(req.Context(), id)
+	if err != nil {
+		logging.Error("Router: GetRoofline(): Could not get job meta data (job ID = ", id, "): ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !user.CanViewJob(&j) {
+		logging.Error("Router: GetRoofline(): User ", user.Username, " is not permitted to access job ", j.Id)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if j.IsRunning {
+		j.StopTime = int(time.Now().Unix())
+	}
+
+	dur, _ := time.ParseDuration(config.SampleInterval)
+	_, sampleInterval := j.CalculateSampleIntervals(dur)
+
+	// Sum up the values of all devices of a node
+	var metricData [2]job.MetricData
+	for i, m := range []conf.MetricConfig{flopsMetric, memBandwidthMetric} {
+		aggFn := "sum"
+		if m.Type == "node" {
+			aggFn = ""
+		}
+		metricData[i], err = (*r.db).GetMetricDataWithAggFn(req.Context(), &j, m, aggFn, sampleInterval)
+		if err != nil {
+			logging.Error("Router: GetRoofline(): Could not get metric ", m.GUID, " of job ", j.Id, ": ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	partition := config.Partitions[j.Partition].ForNodes(strings.Split(j.NodeList, "|"))
+	roofline := analysis.Roofline(metricData[0], metricData[1], partition.PeakFlops, partition.PeakMemBandwidth)
+
+	data, err := json.Marshal(roofline)
+	if err != nil {
+		logging.Error("Router: GetRoofline(): Could not marshal roofline of job ", j.Id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// findMetric returns the configuration of the metric with GUID guid.
+func findMetric(config *conf.Configuration, guid string) (conf.MetricConfig, bool) {
+	i := slices.IndexFunc(
+		config.Metrics,
+		func(c conf.MetricConfig) bool {
+			return c.GUID == guid
+		})
+	if i == -1 {
+		return conf.MetricConfig{}, false
+	}
+	return config.Metrics[i], true
+}
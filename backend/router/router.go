@@ -1,9 +1,12 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"jobmon/analysis"
+	"jobmon/audit"
 	"jobmon/auth"
 	conf "jobmon/config"
 	database "jobmon/db"
@@ -33,19 +36,20 @@ import (
 // Router
 type Router struct {
 	store       jobstore.Store
-	config      *conf.Configuration
+	config      *conf.Holder
 	db          *database.DB
 	jobCache    *cache.LRUCache
 	authManager *auth.AuthManager
 	upgrader    websocket.Upgrader
 	logger      *utils.WebLogger
 	notifier    *notify.Notifier
+	auditor     audit.Logger
 }
 
 // Init starts up the server and sets up all the necessary handlers then it start the main web server.
 func (r *Router) Init(
 	store jobstore.Store,
-	config *conf.Configuration,
+	config *conf.Holder,
 	db *database.DB,
 	jobCache *cache.LRUCache,
 	authManager *auth.AuthManager,
@@ -63,43 +67,90 @@ func (r *Router) Init(
 		}}
 	r.logger = logger
 	r.notifier = notifier
+	r.auditor.Init(&r.store)
+
+	// Keep a history of all applied configurations
+	r.recordStartupRevision()
+	r.config.Subscribe(r.recordConfigRevision)
 
 	router := httprouter.New()
 	router.GET("/auth/oauth/login", r.LoginOAuth)
 	router.GET("/auth/oauth/callback", r.LoginOAuthCallback)
-	router.PUT("/api/job_start", authManager.Protected(r.JobStart, auth.JOBCONTROL))
-	router.PATCH("/api/job_stop/:id", authManager.Protected(r.JobStop, auth.JOBCONTROL))
-	router.GET("/api/jobs", authManager.Protected(r.GetJobs, auth.USER))
-	router.GET("/api/job/:id", authManager.Protected(r.GetJob, auth.USER))
-	router.GET("/api/metric/:id", authManager.Protected(r.GetMetric, auth.USER))
-	router.GET("/api/live/:id", authManager.Protected(r.LiveMonitoring, auth.USER))
-	router.GET("/api/search/user/:term", authManager.Protected(r.SearchUser, auth.ADMIN))
-	router.GET("/api/search/job/:term", authManager.Protected(r.SearchJob, auth.USER))
-	router.GET("/api/search/tag/:term", authManager.Protected(r.SearchTag, auth.USER))
+	router.PUT("/api/job_start", authManager.Protected(r.JobStart, auth.PermJobControl))
+	router.PATCH("/api/job_stop/:id", authManager.Protected(r.JobStop, auth.PermJobControl))
+	router.GET("/api/jobs", authManager.Protected(r.GetJobs, auth.PermViewOwnJobs))
+	router.GET("/api/job/:id", authManager.Protected(r.GetJob, auth.PermViewOwnJobs))
+	router.GET("/api/job/:id/roofline", authManager.Protected(r.GetRoofline, auth.PermViewOwnJobs))
+	router.GET("/api/job/:id/phases", authManager.Protected(r.GetJobPhases, auth.PermViewOwnJobs))
+	router.GET("/api/metric/:id", authManager.Protected(r.GetMetric, auth.PermViewOwnJobs))
+	router.GET("/api/live/:id", authManager.Protected(r.LiveMonitoring, auth.PermViewOwnJobs))
+	router.GET("/api/search/user/:term", authManager.Protected(r.SearchUser, auth.PermViewAllJobs))
+	router.GET("/api/search/job/:term", authManager.Protected(r.SearchJob, auth.PermViewOwnJobs))
+	router.GET("/api/search/tag/:term", authManager.Protected(r.SearchTag, auth.PermViewOwnJobs))
 	router.POST("/api/login", r.Login)
-	router.POST("/api/logout", authManager.Protected(r.Logout, auth.USER))
-	router.POST("/api/generateAPIKey", authManager.Protected(r.GenerateAPIKey, auth.ADMIN))
-	router.POST("/api/tags/add_tag", authManager.Protected(r.AddTag, auth.USER))
-	router.POST("/api/tags/remove_tag", authManager.Protected(r.RemoveTag, auth.USER))
-	router.GET("/api/config", authManager.Protected(r.GetConfig, auth.ADMIN))
-	router.PATCH("/api/config/update", authManager.Protected(r.UpdateConfig, auth.ADMIN))
-	router.GET("/api/admin/livelog", authManager.Protected(r.LiveLog, auth.ADMIN))
-	router.POST("/api/admin/refresh_metadata/:id", authManager.Protected(r.RefreshMetadata, auth.ADMIN))
-	router.GET("/api/config/users/:user", authManager.Protected(r.GetUserConfig, auth.ADMIN))
-	router.PATCH("/api/config/users/:user", authManager.Protected(r.SetUserConfig, auth.ADMIN))
-	router.POST("/api/notify/admin", r.NotifyAdmin)
+	router.POST("/api/logout", authManager.Protected(r.Logout, auth.PermOwnSession))
+	router.POST("/api/generateAPIKey", authManager.Protected(r.GenerateAPIKey, auth.PermManageUsers))
+	router.POST("/api/tags/add_tag", authManager.Protected(r.AddTag, auth.PermManageTags))
+	router.POST("/api/tags/remove_tag", authManager.Protected(r.RemoveTag, auth.PermManageTags))
+	router.GET("/api/config", authManager.Protected(r.GetConfig, auth.PermEditConfig))
+	router.PATCH("/api/config/update", authManager.Protected(r.UpdateConfig, auth.PermEditConfig))
+	router.POST("/api/config/validate", authManager.Protected(r.ValidateConfig, auth.PermEditConfig))
+	router.GET("/api/config/revisions", authManager.Protected(r.GetConfigRevisions, auth.PermEditConfig))
+	router.GET("/api/config/revisions/:id", authManager.Protected(r.GetConfigRevision, auth.PermEditConfig))
+	router.GET("/api/config/revisions/:id/diff", authManager.Protected(r.DiffConfigRevisions, auth.PermEditConfig))
+	router.POST("/api/config/revisions/:id/rollback", authManager.Protected(r.RollbackConfig, auth.PermEditConfig))
+	router.GET("/api/admin/livelog", authManager.Protected(r.LiveLog, auth.PermLiveLog))
+	router.POST("/api/admin/refresh_metadata/:id", authManager.Protected(r.RefreshMetadata, auth.PermEditConfig))
+	router.GET("/api/admin/discover_metrics", authManager.Protected(r.DiscoverMetrics, auth.PermEditConfig))
+	router.GET("/api/admin/cache", authManager.Protected(r.GetCacheStats, auth.PermEditConfig))
+	router.GET("/api/admin/aggregations", authManager.Protected(r.GetAggregationStatus, auth.PermEditConfig))
+	router.POST("/api/admin/aggregations/backfill", authManager.Protected(r.BackfillAggregations, auth.PermEditConfig))
+	router.GET("/api/config/users/:user", authManager.Protected(r.GetUserConfig, auth.PermManageUsers))
+	router.PATCH("/api/config/users/:user", authManager.Protected(r.SetUserConfig, auth.PermManageUsers))
+	router.GET("/api/config/roles", authManager.Protected(r.GetRoles, auth.PermManageUsers))
+	router.PUT("/api/config/roles/:role", authManager.Protected(r.SetRole, auth.PermManageUsers))
+	router.DELETE("/api/config/roles/:role", authManager.Protected(r.RemoveRole, auth.PermManageUsers))
+	router.GET("/api/admin/local_users", authManager.Protected(r.GetLocalUsers, auth.PermManageUsers))
+	router.POST("/api/admin/local_users", authManager.Protected(r.CreateLocalUser, auth.PermManageUsers))
+	router.PATCH("/api/admin/local_users/:user", authManager.Protected(r.UpdateLocalUser, auth.PermManageUsers))
+	router.DELETE("/api/admin/local_users/:user", authManager.Protected(r.RemoveLocalUser, auth.PermManageUsers))
+	router.POST("/api/user/password", authManager.Protected(r.ChangePassword, auth.PermOwnSession))
+	router.POST("/api/admin/impersonate/:user", authManager.Protected(r.Impersonate, auth.PermManageUsers))
+	router.POST("/api/impersonate/stop", authManager.Protected(r.StopImpersonation, ""))
+	router.POST("/api/role_requests", authManager.Protected(r.RequestRole, auth.PermOwnSession))
+	router.POST("/api/notify/admin", authManager.Protected(r.RequestRole, auth.PermOwnSession)) // deprecated, use /api/role_requests
+	router.GET("/api/admin/role_requests", authManager.Protected(r.GetRoleRequests, auth.PermManageUsers))
+	router.POST("/api/admin/role_requests/:id/approve", authManager.Protected(r.ApproveRoleRequest, auth.PermManageUsers))
+	router.POST("/api/admin/role_requests/:id/deny", authManager.Protected(r.DenyRoleRequest, auth.PermManageUsers))
+	router.GET("/api/admin/audit", authManager.Protected(r.GetAuditLog, auth.PermManageUsers))
 	router.GET("/api/ping", r.ping)
 
 	server := &http.Server{
-		Addr:    r.config.ListenAddress,
-		Handler: router,
+		Addr:    r.config.Get().ListenAddress,
+		Handler: compress(r.withRequestTimeout(router)),
 	}
 
-	logging.Info("router: Init(): Listen and serve on ", r.config.ListenAddress)
+	logging.Info("router: Init(): Listen and serve on ", r.config.Get().ListenAddress)
 	logging.Fatal(
 		server.ListenAndServe())
 }
 
+// withRequestTimeout returns a handler that cancels the context of requests to h after the configured
+// RequestTimeout, which cancels their queries. WebSocket connections are not limited.
+func (r *Router) withRequestTimeout(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The timeout was checked by Validate
+		timeout, _ := time.ParseDuration(r.config.Get().RequestTimeout)
+		if timeout <= 0 || strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			h.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
 func (r *Router) JobStart(
 	w http.ResponseWriter,
 	req *http.Request,
@@ -184,13 +235,13 @@ func (r *Router) JobStop(
 		if err != nil {
 			// Run aggregation tasks to calculate metadata metrics and (if enabled) prefetch job data
 			(*r.db).RunAggregation()
-			if r.config.Prefetch {
+			if r.config.Get().Prefetch {
 				go func() {
-					jobMetadata, err := r.store.GetJob(id)
+					jobMetadata, err := r.store.GetJob(context.Background(), id)
 					if err == nil {
-						dur, _ := time.ParseDuration(r.config.SampleInterval)
+						dur, _ := time.ParseDuration(r.config.Get().SampleInterval)
 						_, bestInterval := jobMetadata.CalculateSampleIntervals(dur)
-						r.jobCache.Get(&jobMetadata, bestInterval)
+						r.jobCache.Get(context.Background(), &jobMetadata, "", bestInterval, false)
 					}
 				}()
 			}
@@ -207,12 +258,10 @@ func (r *Router) GetJobs(
 	filter := r.parseGetJobParams(req.URL.Query())
 
 	// Check user authorization
-	if !utils.Contains(user.Roles, auth.ADMIN) {
-		filter.UserName = &user.Username
-	}
+	filter.Visibility = user.JobVisibility()
 
 	// Filter jobs
-	jobs, err := r.store.GetFilteredJobs(filter)
+	jobs, err := r.store.GetFilteredJobs(req.Context(), filter)
 	if err != nil {
 		logging.Error("Could not get jobs: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -221,11 +270,11 @@ func (r *Router) GetJobs(
 
 	// Get job tags
 	var tags []job.JobTag
-	if utils.Contains(user.Roles, auth.ADMIN) {
-		// Get all if user is admin
-		tags, err = r.store.GetJobTags("")
+	if user.HasPermission(auth.PermViewAllJobs) {
+		// Get all if user can view all jobs
+		tags, err = r.store.GetJobTags(req.Context(), "")
 	} else {
-		tags, err = r.store.GetJobTags(user.Username)
+		tags, err = r.store.GetJobTags(req.Context(), user.Username)
 	}
 	if err != nil {
 		logging.Error("Could not get job tags: ", err)
@@ -237,8 +286,8 @@ func (r *Router) GetJobs(
 	jobListData := job.JobListData{
 		Jobs: jobs,
 		Config: job.JobListConfig{
-			RadarChartMetrics: r.config.RadarChartMetrics,
-			Partitions:        r.config.Partitions,
+			RadarChartMetrics: r.config.Get().RadarChartMetrics,
+			Partitions:        r.config.Get().Partitions,
 			Tags:              tags,
 		}}
 	logging.Info("Router: GetJobs(): NumJobs = ", len(jobListData.Jobs))
@@ -265,6 +314,12 @@ func (r *Router) GetJob(
 	node := req.URL.Query().Get("node")
 	raw := req.URL.Query().Get("raw") == "true"
 	strId := params.ByName("id")
+	method, maxPoints, err := downsampling(req)
+	if err != nil {
+		logging.Error("router: GetJob(): Invalid downsampling parameters: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	// Read job ID
 	id, err := strconv.Atoi(strId)
@@ -275,7 +330,7 @@ func (r *Router) GetJob(
 	}
 
 	// Get job metadata from store
-	j, err := r.store.GetJob(id)
+	j, err := r.store.GetJob(req.Context(), id)
 	if err != nil {
 		logging.Error("router: GetJob(): Could not get job meta data (job ID = ", id, "): ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -283,7 +338,7 @@ func (r *Router) GetJob(
 	}
 
 	// Check user authorization
-	if !(utils.Contains(user.Roles, auth.ADMIN) || user.Username == j.UserName) {
+	if !user.CanViewJob(&j) {
 		logging.Error("router: GetJob(): User ", user.Username, " is not permitted to access job ", j.Id)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -305,7 +360,7 @@ func (r *Router) GetJob(
 	}
 
 	// Calculate best sample interval
-	dur, _ := time.ParseDuration(r.config.SampleInterval)
+	dur, _ := time.ParseDuration(r.config.Get().SampleInterval)
 	intervals, bestInterval := j.CalculateSampleIntervals(dur)
 
 	sampleInterval := bestInterval
@@ -322,16 +377,15 @@ func (r *Router) GetJob(
 	if j.IsRunning {
 		j.StartTime = int(time.Now().Unix()) - 3600
 	}
-	var jobData job.JobData
-	if node == "" && querySampleInterval == "" && !j.IsRunning && !raw {
-		jobData, err = r.jobCache.Get(&j, sampleInterval)
-	} else {
-		if j.IsRunning {
-			j.StopTime = int(time.Now().Unix())
-			node = ""
-		}
-		jobData, err = (*r.db).GetJobData(&j, node, sampleInterval, raw)
+	if j.IsRunning {
+		j.StopTime = int(time.Now().Unix())
+		node = ""
 	}
+	// Query more points than requested, so downsampling can select the relevant ones
+	if maxPoints > 0 && !raw && querySampleInterval == "" {
+		sampleInterval = analysis.DownsampleInterval(&j, dur, maxPoints)
+	}
+	jobData, err := r.jobCache.Get(req.Context(), &j, node, sampleInterval, raw)
 	if err != nil {
 		logging.Error("router: GetJob(): Could not get job metric data (job ID = ", id, "): ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -342,10 +396,27 @@ func (r *Router) GetJob(
 	jobData.SampleIntervals = intervals
 	j.StartTime = origStartTime
 
+	// Data of multiple nodes is separated by node, show how evenly the metrics are distributed
+	nodes := node
+	if nodes == "" {
+		nodes = j.NodeList
+	}
+	if !raw && strings.Contains(nodes, "|") {
+		jobData.Imbalance = analysis.Imbalance(&jobData)
+	}
+	// The cached data must not be modified
+	if maxPoints > 0 && !raw {
+		jobData = analysis.Downsample(jobData, method, maxPoints)
+	}
+
 	// Send data
-	jsonData, err := json.Marshal(&jobData)
+	var response any = &jobData
+	if columnar(req) {
+		response = jobData.Columnar()
+	}
+	data, contentType, err := encode(req, response)
 	if err != nil {
-		logging.Error("router: GetJob(): Could not marshal job to json (job ID = ", id, ")")
+		logging.Error("router: GetJob(): Could not marshal job (job ID = ", id, "): ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -353,7 +424,8 @@ func (r *Router) GetJob(
 	elapsed := time.Since(start)
 	logging.Info("Router: GetJob (job ID = ", j.Id, ") took ", elapsed)
 
-	w.Write(jsonData)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
 }
 
 // GetMetric writes the metric data to w, for the given request req, params and user.
@@ -366,6 +438,12 @@ func (r *Router) GetMetric(
 	strId := params.ByName("id")
 	metric := req.URL.Query().Get("metric")
 	aggFn := req.URL.Query().Get("aggFn")
+	method, maxPoints, err := downsampling(req)
+	if err != nil {
+		logging.Error("router: GetMetric(): Invalid downsampling parameters: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	if metric == "" {
 		logging.Error("router: GetMetric(): Metric was not provided")
@@ -387,7 +465,7 @@ func (r *Router) GetMetric(
 	}
 
 	// Get job metadata from store
-	j, err := r.store.GetJob(id)
+	j, err := r.store.GetJob(req.Context(), id)
 	if err != nil {
 		logging.Error("router: GetMetric(): Could not get job ", id, " meta data: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -395,15 +473,14 @@ func (r *Router) GetMetric(
 	}
 
 	// Check user authorization
-	if !(utils.Contains(user.Roles, auth.ADMIN) ||
-		user.Username == j.UserName) {
+	if !user.CanViewJob(&j) {
 		logging.Error("router: GetMetric(): User '", user.Username, "' is not permitted to access job ", j.Id)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	// Calculate best sample interval
-	dur, _ := time.ParseDuration(r.config.SampleInterval)
+	dur, _ := time.ParseDuration(r.config.Get().SampleInterval)
 	_, bestInterval := j.CalculateSampleIntervals(dur)
 
 	sampleInterval := bestInterval
@@ -422,8 +499,12 @@ func (r *Router) GetMetric(
 	if j.IsRunning {
 		j.StopTime = int(time.Now().Unix())
 	}
+	if maxPoints > 0 && querySampleInterval == "" {
+		sampleInterval = analysis.DownsampleInterval(&j, dur, maxPoints)
+	}
+	metrics := r.config.Get().Metrics
 	mc := slices.IndexFunc(
-		r.config.Metrics,
+		metrics,
 		func(c conf.MetricConfig) bool {
 			return c.GUID == metric
 		})
@@ -435,23 +516,49 @@ func (r *Router) GetMetric(
 
 	// Read performance metrics
 	logging.Info("router: GetMetric(): Reading metric with GUID", metric)
-	metricData, err := (*r.db).GetMetricDataWithAggFn(&j, r.config.Metrics[mc], aggFn, sampleInterval)
+	metricData, err := (*r.db).GetMetricDataWithAggFn(req.Context(), &j, metrics[mc], aggFn, sampleInterval)
 	if err != nil {
 		logging.Error("router: GetMetric(): Could not get metric data: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if maxPoints > 0 {
+		metricData = analysis.Downsample(job.JobData{MetricData: []job.MetricData{metricData}}, method, maxPoints).MetricData[0]
+	}
 
 	// Send data
-	jsonData, err := json.Marshal(&metricData)
+	var response any = &metricData
+	if columnar(req) {
+		response = metricData.Columnar()
+	}
+	data, contentType, err := encode(req, response)
 	if err != nil {
-		logging.Error("router: GetMetric(): Could not marshal metric data to json")
+		logging.Error("router: GetMetric(): Could not marshal metric data: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	logging.Info("router: GetMetric(): Sending metric with GUID", metric)
-	w.Write(jsonData)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// downsampling returns the downsampling method and the maximum number of points per series
+// from the query parameters downsample and maxPoints of req. maxPoints is 0 if not requested.
+func downsampling(req *http.Request) (method string, maxPoints int, err error) {
+	query := req.URL.Query()
+	if query.Get("maxPoints") == "" {
+		return "", 0, nil
+	}
+	maxPoints, err = strconv.Atoi(query.Get("maxPoints"))
+	if err != nil {
+		return "", 0, err
+	}
+	method = query.Get("downsample")
+	if method == "" {
+		method = analysis.DownsampleLTTB
+	}
+	return method, maxPoints, analysis.ValidateDownsampling(method, maxPoints)
 }
 
 // SearchUser uses http request parameter term as search term
@@ -464,7 +571,7 @@ func (r *Router) SearchUser(
 
 	searchTerm := params.ByName("term")
 
-	data, err := r.store.GetUserWithJob(searchTerm)
+	data, err := r.store.GetUserWithJob(req.Context(), searchTerm)
 	if err != nil {
 		errStr := fmt.Sprintln("router: SearchUser(): Could not read users")
 		logging.Error(errStr)
@@ -493,12 +600,7 @@ func (r *Router) SearchJob(
 
 	searchTerm := params.ByName("term")
 
-	username := ""
-	if !utils.Contains(user.Roles, auth.ADMIN) {
-		username = user.Username
-	}
-
-	data, err := r.store.GetJobByString(searchTerm, username)
+	data, err := r.store.GetJobByString(req.Context(), searchTerm, user.JobVisibility())
 	if err != nil {
 		errStr := fmt.Sprintln("router: SearchJob(): Could not read jobs")
 		logging.Error(errStr)
@@ -528,11 +630,11 @@ func (r *Router) SearchTag(
 	searchTerm := params.ByName("term")
 
 	username := user.Username
-	if utils.Contains(user.Roles, auth.ADMIN) {
+	if user.HasPermission(auth.PermViewAllJobs) {
 		username = ""
 	}
 
-	data, err := r.store.GetJobTagsByName(searchTerm, username)
+	data, err := r.store.GetJobTagsByName(req.Context(), searchTerm, username)
 	if err != nil {
 		errStr := fmt.Sprintln("router: SearchTag(): Could not read tags")
 		logging.Error(errStr)
@@ -573,7 +675,7 @@ func (r *Router) Login(
 		return
 	}
 
-	user, err := r.authManager.AuthLocalUser(dat.Username, dat.Password)
+	user, err := r.authManager.AuthLocalUser(req.Context(), dat.Username, dat.Password)
 	if err != nil {
 		logging.Error("Router: Login(): Could not authenticate user '", dat.Username, "': ", err)
 		w.WriteHeader(http.StatusUnauthorized)
@@ -692,7 +794,7 @@ func (r *Router) LoginOAuthCallback(
 	}
 
 	// Auto assign user role, if user self service is desired
-	if len(userRoles.Roles) == 0 && r.config.AutoAssignUserRole {
+	if len(userRoles.Roles) == 0 && r.config.Get().AutoAssignUserRole {
 		roles := []string{auth.USER}
 		userRoles.Roles = roles
 		r.store.SetUserRoles(userInfo.Username, roles)
@@ -701,6 +803,8 @@ func (r *Router) LoginOAuthCallback(
 	user := auth.UserInfo{
 		Username: userInfo.Username,
 		Roles:    userRoles.Roles,
+		Accounts: userRoles.Accounts,
+		Email:    userInfo.Email,
 	}
 	logging.Info("Router: LoginOAuthCallback(): User: ", user.Username, ", Roles: ", user.Roles)
 
@@ -711,40 +815,18 @@ func (r *Router) LoginOAuthCallback(
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, req, r.config.OAuth.AfterLoginRedirectUrl, http.StatusTemporaryRedirect)
-	logging.Info("Router: LoginOAuthCallback(): redirect to: ", r.config.OAuth.AfterLoginRedirectUrl)
+	http.Redirect(w, req, r.config.Get().OAuth.AfterLoginRedirectUrl, http.StatusTemporaryRedirect)
+	logging.Info("Router: LoginOAuthCallback(): redirect to: ", r.config.Get().OAuth.AfterLoginRedirectUrl)
 }
 
 func (r *Router) Logout(
 	w http.ResponseWriter,
 	req *http.Request,
 	params httprouter.Params,
-	_ auth.UserInfo) {
-
-	// Read body
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		logging.Error("Router: Logout(): Could not read http request body")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	var dat auth.AuthPayload
-	err = json.Unmarshal(body, &dat)
-	if err != nil {
-		logging.Error("Router: Logout(): Could not unmarshal http request body")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if dat.Username == "" {
-		logging.Error("Router: Logout(): No username given on logout")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	user auth.UserInfo) {
 
-	// Logout user in authManager
-	r.authManager.Logout(dat.Username)
+	// Only the user of the session is logged out, a username in the body is ignored
+	r.authManager.Logout(user.Username)
 
 	// Clear authorization cooky
 	http.SetCookie(w,
@@ -756,14 +838,14 @@ func (r *Router) Logout(
 		})
 	w.WriteHeader(http.StatusOK)
 
-	logging.Info("Router: Logout(): logged out user ", dat.Username)
+	logging.Info("Router: Logout(): logged out user ", user.Username)
 }
 
 func (r *Router) GenerateAPIKey(
 	w http.ResponseWriter,
 	req *http.Request,
 	params httprouter.Params,
-	_ auth.UserInfo) {
+	user auth.UserInfo) {
 	jwt, err :=
 		r.authManager.GenerateJWT(
 			auth.UserInfo{
@@ -776,6 +858,7 @@ func (r *Router) GenerateAPIKey(
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	r.audit(req, user, audit.APIKeyGenerate, "api", nil)
 	w.Write([]byte(jwt))
 	logging.Info("Router: GenerateAPIKey(): Generated API key")
 }
@@ -785,7 +868,7 @@ func (r *Router) AddTag(
 	req *http.Request,
 	params httprouter.Params,
 	user auth.UserInfo) {
-	job, tag, ok := r.parseTag(w, req)
+	job, tag, ok := r.parseTag(w, req, user)
 	if ok {
 		role := auth.USER
 		if utils.Contains(user.Roles, auth.ADMIN) {
@@ -795,6 +878,7 @@ func (r *Router) AddTag(
 		tag.Type = role
 		r.store.AddTag(job.Id, &tag)
 		r.jobCache.UpdateJob(job.Id)
+		r.audit(req, user, audit.TagAdd, strconv.Itoa(job.Id), audit.Diff(nil, tag))
 
 		jsonData, err := json.Marshal(&tag)
 		if err != nil {
@@ -813,13 +897,15 @@ func (r *Router) RemoveTag(
 	req *http.Request,
 	params httprouter.Params,
 	user auth.UserInfo) {
-	job, tag, ok := r.parseTag(w, req)
+	job, tag, ok := r.parseTag(w, req, user)
 	if ok {
 		err := r.store.RemoveTag(job.Id, &tag)
 		if err != nil {
 			logging.Error("Router: RemoveTag(): Failed to remove tag: ", err)
+			return
 		}
 		r.jobCache.UpdateJob(job.Id)
+		r.audit(req, user, audit.TagRemove, strconv.Itoa(job.Id), audit.Diff(tag, nil))
 	}
 }
 
@@ -843,12 +929,17 @@ func (r *Router) LiveMonitoring(
 		return
 	}
 
-	j, err := r.store.GetJob(id)
+	j, err := r.store.GetJob(req.Context(), id)
 	if err != nil {
 		logging.Error("Router: LiveMonitoring(): Could not get job ", id, " meta data: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !user.CanViewJob(&j) {
+		logging.Error("Router: LiveMonitoring(): User '", user.Username, "' is not permitted to access job ", j.Id)
+		c.Close()
+		return
+	}
 	monitor, done := (*r.db).CreateLiveMonitoringChannel(&j)
 
 	go func() {
@@ -873,9 +964,9 @@ func (r *Router) LiveMonitoring(
 							j.StartTime = wsLoadMetricsMsg.StartTime
 						}
 						j.StopTime = wsLoadMetricsMsg.StopTime
-						dur, _ := time.ParseDuration(r.config.SampleInterval)
+						dur, _ := time.ParseDuration(r.config.Get().SampleInterval)
 						_, bestInterval := j.CalculateSampleIntervals(dur)
-						data, err := (*r.db).GetJobData(&j, "", bestInterval, false)
+						data, err := (*r.db).GetJobData(context.Background(), &j, "", bestInterval, false)
 						j.StartTime = origStartTime
 						j.StopTime = origStopTime
 						if err == nil {
@@ -912,10 +1003,11 @@ func (r *Router) GetConfig(
 	user auth.UserInfo) {
 
 	// Restrict available configuration parameters for now
+	current := r.config.Get()
 	conf := conf.Configuration{}
-	conf.Metrics = r.config.Metrics
-	conf.Partitions = r.config.Partitions
-	conf.MetricCategories = r.config.MetricCategories
+	conf.Metrics = current.Metrics
+	conf.Partitions = current.Partitions
+	conf.MetricCategories = current.MetricCategories
 
 	data, err := json.Marshal(conf)
 	if err != nil {
@@ -932,9 +1024,45 @@ func (r *Router) UpdateConfig(
 	req *http.Request,
 	params httprouter.Params,
 	user auth.UserInfo) {
+
+	before, updated, ok := r.mergeConfigUpdate(w, req, "UpdateConfig")
+	if !ok {
+		return
+	}
+
+	// Reject invalid configurations with all problems found
+	if errs := append(updated.Validate(), analysis.ValidateConfig(&updated)...); len(errs) > 0 {
+		logging.Error("Router: UpdateConfig(): Rejected invalid configuration: ", errs)
+		data, _ := json.Marshal(ConfigValidationResult{Valid: false, Errors: errs})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(data)
+		return
+	}
+
+	// Swap in the new configuration; subscribers re-init affected units,
+	// e.g. the DB because of potential changes to metrics
+	r.config.Update(updated, user.Username)
+	r.config.Flush()
+	r.audit(req, user, audit.ConfigUpdate, "config", audit.Diff(before, updated))
+	data, err := json.Marshal(updated)
+	if err != nil {
+		logging.Error("Router: UpdateConfig(): Could not unmarshal updated config: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Write(data)
+}
+
+// mergeConfigUpdate applies the configuration update in the body of req to a copy of the
+// current configuration. The current configuration snapshot is returned as before.
+func (r *Router) mergeConfigUpdate(
+	w http.ResponseWriter,
+	req *http.Request,
+	caller string) (before *conf.Configuration, updated conf.Configuration, ok bool) {
+
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		logging.Error("Router: UpdateConfig(): Could not read update config request body: ", err)
+		logging.Error("Router: ", caller, "(): Could not read update config request body: ", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -943,7 +1071,7 @@ func (r *Router) UpdateConfig(
 	conf := conf.Configuration{}
 	err = json.Unmarshal(body, &conf)
 	if err != nil {
-		logging.Error("Router: UpdateConfig(): Could not unmarshal update config request body: ", err)
+		logging.Error("Router: ", caller, "(): Could not unmarshal update config request body: ", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -962,34 +1090,33 @@ func (r *Router) UpdateConfig(
 			return conf.Metrics[i].DisplayName < conf.Metrics[j].DisplayName
 		})
 
+	// Work on a copy, the current configuration snapshot must not be modified
+	before = r.config.Get()
+	updated = *before
+	updated.RadarChartMetrics = slices.Clone(before.RadarChartMetrics)
+
 	// Check if metric was removed and remove all references
-	deletedGuids := conf.GetDeletedMetrics(*r.config)
+	deletedGuids := conf.GetDeletedMetrics(*before)
 	if len(deletedGuids) > 0 {
 		for i, pc := range conf.Partitions {
 			pc.RemoveMissingMetrics(deletedGuids)
 			conf.Partitions[i] = pc
 		}
 		for _, v := range deletedGuids {
-			r.config.RadarChartMetrics = utils.Remove(r.config.RadarChartMetrics, v)
+			updated.RadarChartMetrics = utils.Remove(updated.RadarChartMetrics, v)
+		}
+		// The roofline analysis needs both of its metrics
+		if rl := updated.Roofline; rl != nil &&
+			(utils.Contains(deletedGuids, rl.FlopsMetric) || utils.Contains(deletedGuids, rl.MemBandwidthMetric)) {
+			updated.Roofline = nil
 		}
 	}
 
 	// Actually overwrite config
-	r.config.Metrics = conf.Metrics
-	r.config.Partitions = conf.Partitions
-	r.config.MetricCategories = conf.MetricCategories
-
-	// Re-init affected units
-	// DB because of potential changes to metrics
-	(*r.config).Flush()
-	(*r.db).Init(*r.config)
-	data, err := json.Marshal(r.config)
-	if err != nil {
-		logging.Error("Router: UpdateConfig(): Could not unmarshal updated config: ", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	w.Write(data)
+	updated.Metrics = conf.Metrics
+	updated.Partitions = conf.Partitions
+	updated.MetricCategories = conf.MetricCategories
+	return before, updated, true
 }
 
 func (r *Router) LiveLog(
@@ -1031,14 +1158,14 @@ func (r *Router) RefreshMetadata(
 	}
 
 	// Get job metadata from store
-	j, err := r.store.GetJob(id)
+	j, err := r.store.GetJob(req.Context(), id)
 	if err != nil {
 		logging.Error("Router: RefreshMetadata(): Could not get meta data for job ", id, ": ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	data, err := (*r.db).GetJobMetadataMetrics(&j)
+	data, err := (*r.db).GetJobMetadataMetrics(req.Context(), &j)
 	if err != nil {
 		logging.Error("Router: RefreshMetadata(): Could not get meta data metrics for job", id, ": ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -1052,6 +1179,8 @@ func (r *Router) RefreshMetadata(
 		return
 	}
 
+	r.audit(req, user, audit.MetadataRefresh, strId, nil)
+
 	jsonData, err := json.Marshal(&j)
 	if err != nil {
 		logging.Error("Router: RefreshMetadata(): Could not marhsal metadata for job ", id, ": ", err)
@@ -1062,6 +1191,23 @@ func (r *Router) RefreshMetadata(
 	w.Write(jsonData)
 }
 
+// GetCacheStats writes the statistics of the job data cache to w.
+func (r *Router) GetCacheStats(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+
+	data, err := json.Marshal(r.jobCache.Stats())
+	if err != nil {
+		logging.Error("Router: GetCacheStats(): Could not marshal cache statistics")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
 func (r *Router) GetUserConfig(
 	w http.ResponseWriter,
 	req *http.Request,
@@ -1096,7 +1242,7 @@ func (r *Router) SetUserConfig(
 	w http.ResponseWriter,
 	req *http.Request,
 	params httprouter.Params,
-	_ auth.UserInfo) {
+	caller auth.UserInfo) {
 	userStr := params.ByName("user")
 	if userStr == "" {
 		logging.Error("Router: SetUserConfig(): Could not get user string from request params")
@@ -1119,7 +1265,14 @@ func (r *Router) SetUserConfig(
 		return
 	}
 
+	before, _ := r.store.GetUserRoles(user.Username)
 	r.store.SetUserRoles(user.Username, user.Roles)
+	r.store.SetUserAccounts(user.Username, user.Accounts)
+	// Sessions carry the roles and accounts of the login
+	if !slices.Equal(before.Roles, user.Roles) || !slices.Equal(before.Accounts, user.Accounts) {
+		r.authManager.Logout(user.Username)
+	}
+	r.audit(req, caller, audit.UserConfigUpdate, user.Username, audit.Diff(before, user))
 	data, err := json.Marshal(user)
 	if err != nil {
 		logging.Error("Router: SetUserConfig(): Could not marshal user ", userStr)
@@ -1130,13 +1283,122 @@ func (r *Router) SetUserConfig(
 	w.Write(data)
 }
 
+// RolesResponse contains all role definitions and the permissions that can be granted.
+type RolesResponse struct {
+	Roles       []jobstore.Role
+	Permissions []string
+}
+
+// GetRoles writes all role definitions and the available permissions to w.
+func (r *Router) GetRoles(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	_ auth.UserInfo) {
+
+	data, err := json.Marshal(
+		RolesResponse{
+			Roles:       r.authManager.GetRoles(),
+			Permissions: auth.AllPermissions,
+		})
+	if err != nil {
+		logging.Error("Router: GetRoles(): Could not marshal roles")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
+// SetRole creates or updates the role given by the http request parameter role.
+func (r *Router) SetRole(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+	roleStr := params.ByName("role")
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logging.Error("Router: SetRole(): Could not read request body for role ", roleStr, ": ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	role := jobstore.Role{}
+	err = json.Unmarshal(body, &role)
+	if err != nil {
+		logging.Error("Router: SetRole(): Could not unmarshal role ", roleStr, ": ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	role.Name = roleStr
+	before := r.findRole(roleStr)
+
+	err = r.authManager.SetRole(role)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: SetRole(): Could not set role %s: %v", roleStr, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	r.audit(req, user, audit.RoleSet, roleStr, audit.Diff(before, role))
+
+	data, err := json.Marshal(role)
+	if err != nil {
+		logging.Error("Router: SetRole(): Could not marshal role ", roleStr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+	logging.Info("Router: SetRole(): Set permissions of role ", roleStr, " to ", role.Permissions)
+}
+
+// RemoveRole removes the role given by the http request parameter role.
+func (r *Router) RemoveRole(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+	user auth.UserInfo) {
+	roleStr := params.ByName("role")
+	before := r.findRole(roleStr)
+
+	err := r.authManager.RemoveRole(roleStr)
+	if err != nil {
+		errStr := fmt.Sprintf("Router: RemoveRole(): Could not remove role %s: %v", roleStr, err)
+		logging.Error(errStr)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	r.audit(req, user, audit.RoleRemove, roleStr, audit.Diff(before, nil))
+	w.WriteHeader(http.StatusOK)
+	logging.Info("Router: RemoveRole(): Removed role ", roleStr)
+}
+
+// findRole returns the definition of role 'name' or nil if it does not exist.
+func (r *Router) findRole(name string) *jobstore.Role {
+	for _, role := range r.authManager.GetRoles() {
+		if role.Name == name {
+			return &role
+		}
+	}
+	return nil
+}
+
 // parseTag reads
 // * job ID from http request parameter job
 // * a tag from the http body
 // * job metadata from the PostgreSQL database
+// and checks that user is permitted to access the job.
 func (r *Router) parseTag(
 	w http.ResponseWriter,
 	req *http.Request,
+	user auth.UserInfo,
 ) (
 	job job.JobMetadata,
 	tag job.JobTag,
@@ -1166,13 +1428,19 @@ func (r *Router) parseTag(
 		return
 	}
 
-	job, err = r.store.GetJob(jobId)
+	job, err = r.store.GetJob(req.Context(), jobId)
 	if err != nil {
 		logging.Error("Router: parseTag(): Could not get job ", jobId, " meta data")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	if !user.CanViewJob(&job) {
+		logging.Error("Router: parseTag(): User '", user.Username, "' is not permitted to access job ", job.Id)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	ok = true
 	return
 }
@@ -1250,52 +1518,6 @@ func (r *Router) parseGetJobParams(params url.Values) (filter job.JobFilter) {
 	return filter
 }
 
-// Sends a notification to the administrators
-func (r *Router) NotifyAdmin(
-	w http.ResponseWriter,
-	req *http.Request,
-	params httprouter.Params) {
-
-	logging.Info("Request Notification to admins")
-
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		logging.Error("Router: NotifyAdmin(): Could not read http request body")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	var dat auth.UserInfo
-	err = json.Unmarshal(body, &dat)
-	if err != nil {
-		logging.Error("Router: NotifyAdmin(): Could not unmarshal http request body")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if dat.Username == "" {
-		logging.Error("Router: NotifyAdmin(): Could not read data from http request body")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if utils.Contains(dat.Roles, "user") || utils.Contains(dat.Roles, "admin") {
-		logging.Error("Router: NotifyAdmin(): User already has roles")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	subject := "Role request by " + dat.Username
-	message := "The user " + dat.Username + " tried to access the jobmon-system. " +
-		"Currently this user has no role allwoing to access the webinterface." +
-		"\n\n" + dat.Username + " requests a Role to access the webinterface."
-
-	if (*r.notifier).Notify(subject, message) != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
 // Ping function sending back the current time
 func (r *Router) ping(w http.ResponseWriter,
 	req *http.Request,
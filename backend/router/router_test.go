@@ -0,0 +1,38 @@
+package router
+
+import (
+	"jobmon/auth"
+	"jobmon/config"
+	"jobmon/notify"
+	"jobmon/store"
+	"jobmon/test"
+	"testing"
+)
+
+// newTestRouter returns a Router with the handlers' dependencies backed by a MockStore.
+func newTestRouter(t *testing.T, c config.Configuration) (*Router, *test.MockStore) {
+	if c.JWTSecret == "" {
+		c.JWTSecret = "router-test-secret"
+	}
+
+	mockStore := &test.MockStore{}
+	r := &Router{store: mockStore, config: &config.Holder{}}
+	r.config.Init(c)
+	var n notify.Notifier = &test.MockEmailNotifier{}
+	r.notifier = &n
+	r.authManager = &auth.AuthManager{}
+	r.authManager.Init(c, &r.store, r.notifier)
+	r.auditor.Init(&r.store)
+	return r, mockStore
+}
+
+// auditEntry returns the last audit entry with action or fails the test.
+func auditEntry(t *testing.T, s *test.MockStore, action string) store.AuditEntry {
+	for i := len(s.AuditEntries) - 1; i >= 0; i-- {
+		if s.AuditEntries[i].Action == action {
+			return s.AuditEntries[i]
+		}
+	}
+	t.Fatalf("No audit entry for action %s in %+v", action, s.AuditEntries)
+	return store.AuditEntry{}
+}
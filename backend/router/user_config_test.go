@@ -0,0 +1,39 @@
+package router
+
+import (
+	"jobmon/auth"
+	"jobmon/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Tests if changing the accounts of an OAuth user revokes their session,
+// as sessions carry the accounts of the login
+func TestSetUserConfigRevokesSession(t *testing.T) {
+	r, mockStore := newTestRouter(t, config.Configuration{})
+	mockStore.SetUserRoles("bob", []string{auth.USER})
+	mockStore.SetUserAccounts("bob", []string{"proj1"})
+
+	set := func(body string) {
+		mockStore.SetUserSessionToken("bob", "token")
+		req := httptest.NewRequest(http.MethodPut, "/api/user_config/bob", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.SetUserConfig(w, req, httprouter.Params{{Key: "user", Value: "bob"}}, auth.UserInfo{Username: "admin"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	set(`{"Username":"bob","Roles":["user"],"Accounts":["proj1"]}`)
+	if _, ok := mockStore.JWT["bob"]; !ok {
+		t.Fatalf("Session was revoked without changes")
+	}
+	set(`{"Username":"bob","Roles":["user"],"Accounts":["proj1","proj2"]}`)
+	if _, ok := mockStore.JWT["bob"]; ok {
+		t.Fatalf("Session was not revoked on account change")
+	}
+}
@@ -86,6 +86,8 @@ func (s *PostgresStore) Init(c config.Configuration, influx *db.DB) {
 	if err != nil {
 		logging.Error("store: Init(): Failed to create table job_metadata: ", err)
 	}
+	s.addColumnIfNotExists((*job.JobMetadata)(nil), "phases", "jsonb")
+	s.addColumnIfNotExists((*job.JobMetadata)(nil), "analysis", "jsonb")
 
 	// Table user_sessions
 	_, err =
@@ -106,6 +108,58 @@ func (s *PostgresStore) Init(c config.Configuration, influx *db.DB) {
 	if err != nil {
 		logging.Error("store: Init(): Failed to create table user_roles: ", err)
 	}
+	s.addColumnIfNotExists((*UserRoles)(nil), "accounts", "jsonb")
+
+	// Table roles
+	_, err =
+		s.db.NewCreateTable().
+			Model((*Role)(nil)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: Init(): Failed to create table roles: ", err)
+	}
+
+	// Table role_requests
+	_, err =
+		s.db.NewCreateTable().
+			Model((*RoleRequest)(nil)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: Init(): Failed to create table role_requests: ", err)
+	}
+
+	// Table local_users
+	_, err =
+		s.db.NewCreateTable().
+			Model((*LocalUser)(nil)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: Init(): Failed to create table local_users: ", err)
+	}
+	s.addColumnIfNotExists((*LocalUser)(nil), "accounts", "jsonb")
+
+	// Table config_revisions
+	_, err =
+		s.db.NewCreateTable().
+			Model((*ConfigRevision)(nil)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: Init(): Failed to create table config_revisions: ", err)
+	}
+
+	// Table audit_entries
+	_, err =
+		s.db.NewCreateTable().
+			Model((*AuditEntry)(nil)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: Init(): Failed to create table audit_entries: ", err)
+	}
 
 	go s.finishOvertimeJobs()
 	go s.startCleanJobsTimer()
@@ -119,7 +173,7 @@ func (s *PostgresStore) Migrate(source *Store) {
 		logging.Error("store: Migration(): Err resetting: ", err)
 		return
 	}
-	jobs, _ := (*source).GetAllJobs()
+	jobs, _ := (*source).GetAllJobs(context.Background())
 	res, err :=
 		s.db.NewInsert().
 			Model(&jobs).
@@ -151,8 +205,10 @@ func (s *PostgresStore) PutJob(job job.JobMetadata) error {
 }
 
 // GetJob implements GetJob method of store interface.
-func (s *PostgresStore) GetJob(id int) (job job.JobMetadata, err error) {
+func (s *PostgresStore) GetJob(ctx context.Context, id int) (job job.JobMetadata, err error) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	job.Id = id
 	err =
@@ -160,7 +216,7 @@ func (s *PostgresStore) GetJob(id int) (job job.JobMetadata, err error) {
 			Model(&job).
 			WherePK().
 			Relation("Tags").
-			Scan(context.Background())
+			Scan(ctx)
 	if err != nil {
 		return
 	}
@@ -170,13 +226,15 @@ func (s *PostgresStore) GetJob(id int) (job job.JobMetadata, err error) {
 }
 
 // GetAllJobs implements GetAllJobs of store interface.
-func (s *PostgresStore) GetAllJobs() (jobs []job.JobMetadata, err error) {
+func (s *PostgresStore) GetAllJobs(ctx context.Context) (jobs []job.JobMetadata, err error) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	err =
 		s.db.NewSelect().
 			Model(&jobs).
-			Scan(context.Background())
+			Scan(ctx)
 	if err != nil {
 		jobs = []job.JobMetadata{}
 		return
@@ -188,12 +246,15 @@ func (s *PostgresStore) GetAllJobs() (jobs []job.JobMetadata, err error) {
 
 // GetFilteredJobs implements GetFilteredJobs of store interface.
 func (s *PostgresStore) GetFilteredJobs(
+	ctx context.Context,
 	filter job.JobFilter,
 ) (
 	jobs []job.JobMetadata,
 	err error,
 ) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	query := s.db.NewSelect().Model(&jobs).Relation("Tags")
 	query = appendTagFilter(query, filter.Tags, s.db)
@@ -207,7 +268,8 @@ func (s *PostgresStore) GetFilteredJobs(
 	query = appendRangeFilter(query, filter.NumTasks, "num_tasks")
 	query = appendRangeFilter(query, filter.NumGpus, "num_nodes * job_metadata.gp_us_per_node")
 	query = appendRangeFilter(query, filter.Time, "start_time")
-	err = query.Scan(context.Background())
+	query = appendVisibilityFilter(query, filter.Visibility)
+	err = query.Scan(ctx)
 	if err != nil {
 		jobs = []job.JobMetadata{}
 		return
@@ -219,12 +281,15 @@ func (s *PostgresStore) GetFilteredJobs(
 
 // GetJobTags implements GetJobTags of store interface.
 func (s *PostgresStore) GetJobTags(
+	ctx context.Context,
 	username string,
 ) (
 	tags []job.JobTag,
 	err error,
 ) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	query := s.db.NewSelect().
 		Table("job_tags").
@@ -234,7 +299,7 @@ func (s *PostgresStore) GetJobTags(
 	if username != "" {
 		query = query.Where("job_metadata.user_name=?", username)
 	}
-	err = query.Scan(context.Background(), &tags)
+	err = query.Scan(ctx, &tags)
 	if err != nil {
 		tags = []job.JobTag{}
 		return
@@ -246,6 +311,7 @@ func (s *PostgresStore) GetJobTags(
 
 // GetJobTagsByName implements GetJobTagsByName of store interface.
 func (s *PostgresStore) GetJobTagsByName(
+	ctx context.Context,
 	searchTerm string,
 	username string,
 ) (
@@ -253,6 +319,8 @@ func (s *PostgresStore) GetJobTagsByName(
 	err error,
 ) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	query := s.db.NewSelect().
 		Table("job_tags").
@@ -261,25 +329,27 @@ func (s *PostgresStore) GetJobTagsByName(
 	if username != "" {
 		query = query.Where("job_tags.created_by=?", username)
 	}
-	err = query.Scan(context.Background(), &tags)
+	err = query.Scan(ctx, &tags)
 
 	logging.Info("store: GetJobTagsByName took ", time.Since(start))
 	return
 }
 
 // GetUsersWithJob implements GetUsersWithJob of store interface
-func (s *PostgresStore) GetAllUsersWithJob() (
+func (s *PostgresStore) GetAllUsersWithJob(ctx context.Context) (
 	data []string,
 	err error,
 ) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	err = s.db.NewSelect().
 		Distinct().
 		Model(&data).
 		Table("job_metadata").
 		Column("user_name").
-		Scan(context.Background())
+		Scan(ctx)
 
 	logging.Info("store: GetUsersWithJob took ", time.Since(start))
 
@@ -288,12 +358,15 @@ func (s *PostgresStore) GetAllUsersWithJob() (
 
 // GetAllUsersWithJob implements GetAllUsersWithJob of store interface
 func (s *PostgresStore) GetUserWithJob(
+	ctx context.Context,
 	searchTerm string,
 ) (
 	data []string,
 	err error,
 ) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	err = s.db.NewSelect().
 		Distinct().
@@ -301,7 +374,7 @@ func (s *PostgresStore) GetUserWithJob(
 		Table("job_metadata").
 		Column("user_name").
 		Where("user_name LIKE '%" + searchTerm + "%'").
-		Scan(context.Background())
+		Scan(ctx)
 
 	logging.Info("store: GetUsersWithJob took ", time.Since(start))
 
@@ -318,7 +391,7 @@ func (s *PostgresStore) StopJob(
 	start := time.Now()
 
 	// Get job metadata from the database
-	job, err := s.GetJob(id)
+	job, err := s.GetJob(context.Background(), id)
 	if err != nil {
 		return
 	}
@@ -327,7 +400,7 @@ func (s *PostgresStore) StopJob(
 	job.IsRunning = false
 	job.StopTime = stopJob.StopTime
 	job.ExitCode = stopJob.ExitCode
-	data, err := (*s.influx).GetJobMetadataMetrics(&job)
+	data, err := (*s.influx).GetJobMetadataMetrics(context.Background(), &job)
 	if err != nil {
 		return
 	}
@@ -453,6 +526,7 @@ func (s *PostgresStore) SetUserRoles(
 		s.db.NewInsert().
 			Model(&user).
 			On("CONFLICT (username) DO UPDATE").
+			Set("roles = EXCLUDED.roles").
 			Exec(context.Background())
 	if err != nil {
 		logging.Error("store: SetUserRoles(): Failed to set roles, ", roles, " for user ", username, ": ", err)
@@ -462,25 +536,403 @@ func (s *PostgresStore) SetUserRoles(
 	logging.Info("store: SetUserRoles took ", time.Since(start))
 }
 
+// SetUserAccounts implements SetUserAccounts method of store interface.
+func (s *PostgresStore) SetUserAccounts(
+	username string,
+	accounts []string,
+) {
+	start := time.Now()
+
+	user :=
+		UserRoles{
+			Username: username,
+			Roles:    []string{},
+			Accounts: accounts,
+		}
+	_, err :=
+		s.db.NewInsert().
+			Model(&user).
+			On("CONFLICT (username) DO UPDATE").
+			Set("accounts = EXCLUDED.accounts").
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: SetUserAccounts(): Failed to set accounts, ", accounts, " for user ", username, ": ", err)
+		return
+	}
+
+	logging.Info("store: SetUserAccounts took ", time.Since(start))
+}
+
+// GetRoles implements GetRoles method of store interface.
+func (s *PostgresStore) GetRoles(ctx context.Context) (roles []Role, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	err =
+		s.db.NewSelect().
+			Model(&roles).
+			Scan(ctx)
+	if err != nil {
+		return
+	}
+
+	logging.Info("store: GetRoles took ", time.Since(start))
+	return
+}
+
+// SetRole implements SetRole method of store interface.
+func (s *PostgresStore) SetRole(role Role) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewInsert().
+			Model(&role).
+			On("CONFLICT (name) DO UPDATE").
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: SetRole took ", time.Since(start))
+	return nil
+}
+
+// RemoveRole implements RemoveRole method of store interface.
+func (s *PostgresStore) RemoveRole(name string) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewDelete().
+			Model(&Role{Name: name}).
+			WherePK().
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: RemoveRole took ", time.Since(start))
+	return nil
+}
+
+// AddRoleRequest implements AddRoleRequest method of store interface.
+func (s *PostgresStore) AddRoleRequest(request *RoleRequest) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewInsert().
+			Model(request).
+			Returning("id").
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: AddRoleRequest took ", time.Since(start))
+	return nil
+}
+
+// GetRoleRequest implements GetRoleRequest method of store interface.
+func (s *PostgresStore) GetRoleRequest(ctx context.Context, id int64) (request RoleRequest, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	request.Id = id
+	err =
+		s.db.NewSelect().
+			Model(&request).
+			WherePK().
+			Scan(ctx)
+	if err != nil {
+		return
+	}
+
+	logging.Info("store: GetRoleRequest took ", time.Since(start))
+	return
+}
+
+// GetRoleRequests implements GetRoleRequests method of store interface.
+func (s *PostgresStore) GetRoleRequests(ctx context.Context, status string) (requests []RoleRequest, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query :=
+		s.db.NewSelect().
+			Model(&requests).
+			Order("created_at DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err = query.Scan(ctx)
+	if err != nil {
+		requests = []RoleRequest{}
+		return
+	}
+
+	logging.Info("store: GetRoleRequests took ", time.Since(start))
+	return
+}
+
+// UpdateRoleRequest implements UpdateRoleRequest method of store interface.
+func (s *PostgresStore) UpdateRoleRequest(request RoleRequest) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewUpdate().
+			Model(&request).
+			WherePK().
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: UpdateRoleRequest took ", time.Since(start))
+	return nil
+}
+
+// CountRoleRequests implements CountRoleRequests method of store interface.
+func (s *PostgresStore) CountRoleRequests(ctx context.Context, username string, since time.Time) (int, error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	count, err :=
+		s.db.NewSelect().
+			Model((*RoleRequest)(nil)).
+			Where("username = ?", username).
+			Where("created_at >= ?", since).
+			Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	logging.Info("store: CountRoleRequests took ", time.Since(start))
+	return count, nil
+}
+
+// GetLocalUser implements GetLocalUser method of store interface.
+func (s *PostgresStore) GetLocalUser(ctx context.Context, username string) (user LocalUser, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	user.Username = username
+	err =
+		s.db.NewSelect().
+			Model(&user).
+			WherePK().
+			Scan(ctx)
+	if err != nil {
+		return
+	}
+
+	logging.Info("store: GetLocalUser took ", time.Since(start))
+	return
+}
+
+// GetLocalUsers implements GetLocalUsers method of store interface.
+func (s *PostgresStore) GetLocalUsers(ctx context.Context) (users []LocalUser, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	err =
+		s.db.NewSelect().
+			Model(&users).
+			Order("username").
+			Scan(ctx)
+	if err != nil {
+		users = []LocalUser{}
+		return
+	}
+
+	logging.Info("store: GetLocalUsers took ", time.Since(start))
+	return
+}
+
+// PutLocalUser implements PutLocalUser method of store interface.
+func (s *PostgresStore) PutLocalUser(user LocalUser) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewInsert().
+			Model(&user).
+			On("CONFLICT (username) DO UPDATE").
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: PutLocalUser took ", time.Since(start))
+	return nil
+}
+
+// RemoveLocalUser implements RemoveLocalUser method of store interface.
+func (s *PostgresStore) RemoveLocalUser(username string) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewDelete().
+			Model(&LocalUser{Username: username}).
+			WherePK().
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: RemoveLocalUser took ", time.Since(start))
+	return nil
+}
+
+// AddAuditEntry implements AddAuditEntry method of store interface.
+func (s *PostgresStore) AddAuditEntry(entry *AuditEntry) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewInsert().
+			Model(entry).
+			Returning("id").
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: AddAuditEntry took ", time.Since(start))
+	return nil
+}
+
+// GetAuditEntries implements GetAuditEntries method of store interface.
+func (s *PostgresStore) GetAuditEntries(ctx context.Context, filter AuditFilter) (entries []AuditEntry, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query :=
+		s.db.NewSelect().
+			Model(&entries).
+			Order("timestamp DESC")
+	query = appendValueFilter(query, filter.Username, "username")
+	query = appendValueFilter(query, filter.Action, "action")
+	query = appendValueFilter(query, filter.Target, "target")
+	if filter.Time != nil {
+		if filter.Time.From != nil {
+			query = query.Where("timestamp >= ?", time.Unix(int64(*filter.Time.From), 0))
+		}
+		if filter.Time.To != nil {
+			query = query.Where("timestamp <= ?", time.Unix(int64(*filter.Time.To), 0))
+		}
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err = query.Scan(ctx)
+	if err != nil {
+		entries = []AuditEntry{}
+		return
+	}
+
+	logging.Info("store: GetAuditEntries took ", time.Since(start))
+	return
+}
+
+// AddConfigRevision implements AddConfigRevision method of store interface.
+func (s *PostgresStore) AddConfigRevision(revision *ConfigRevision) error {
+	start := time.Now()
+
+	_, err :=
+		s.db.NewInsert().
+			Model(revision).
+			Returning("id").
+			Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	logging.Info("store: AddConfigRevision took ", time.Since(start))
+	return nil
+}
+
+// GetConfigRevision implements GetConfigRevision method of store interface.
+func (s *PostgresStore) GetConfigRevision(ctx context.Context, id int64) (revision ConfigRevision, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	revision.Id = id
+	err =
+		s.db.NewSelect().
+			Model(&revision).
+			WherePK().
+			Scan(ctx)
+	if err != nil {
+		return
+	}
+
+	logging.Info("store: GetConfigRevision took ", time.Since(start))
+	return
+}
+
+// GetConfigRevisions implements GetConfigRevisions method of store interface.
+func (s *PostgresStore) GetConfigRevisions(ctx context.Context) (revisions []ConfigRevision, err error) {
+	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	err =
+		s.db.NewSelect().
+			Model(&revisions).
+			ExcludeColumn("config").
+			Order("id DESC").
+			Scan(ctx)
+	if err != nil {
+		revisions = []ConfigRevision{}
+		return
+	}
+
+	logging.Info("store: GetConfigRevisions took ", time.Since(start))
+	return
+}
+
 // GetJobByString implements GetJobByString method of store interface
-func (s *PostgresStore) GetJobByString(searchTerm string, username string) (jobs []job.JobMetadata, err error) {
+func (s *PostgresStore) GetJobByString(ctx context.Context, searchTerm string, visibility *job.JobVisibility) (jobs []job.JobMetadata, err error) {
 	start := time.Now()
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
 
 	query := s.db.NewSelect().
 		Model(&jobs).
 		Where("CAST(job_metadata.id AS VARCHAR) LIKE '%" + searchTerm + "%' OR job_metadata.job_name LIKE '%" + searchTerm + "%' OR job_metadata.account LIKE '%" + searchTerm + "%'")
+	query = appendVisibilityFilter(query, visibility)
 
-	if username != "" {
-		query = query.Where("job_metadata.user_name=?", username)
-	}
-
-	err = query.Scan(context.Background())
+	err = query.Scan(ctx)
 
 	logging.Info("store: GetJobByString took ", time.Since(start))
 
 	return
 }
 
+// Reconfigure implements Reconfigure method of store interface.
+func (s *PostgresStore) Reconfigure(c config.Configuration) {
+	if c.JobStore != s.config.JobStore {
+		logging.Warning("store: Reconfigure(): Changes of the JobStore configuration require a restart")
+	}
+	jobStore := s.config.JobStore
+	s.config = c
+	s.config.JobStore = jobStore
+}
+
+// queryContext returns ctx limited by the configured query timeout.
+func (s *PostgresStore) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	// The timeout was checked by Validate
+	timeout, _ := time.ParseDuration(s.config.QueryTimeout)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // Flush implements Flush method of store interface.
 func (s *PostgresStore) Flush() {
 	err := s.db.Close()
@@ -620,6 +1072,40 @@ func appendRangeFilter(query *bun.SelectQuery, val *job.RangeFilter, key string)
 	return query
 }
 
+// appendVisibilityFilter restricts the query to jobs owned by visibility.UserName
+// or charged to one of visibility.Accounts.
+func appendVisibilityFilter(query *bun.SelectQuery, visibility *job.JobVisibility) *bun.SelectQuery {
+	if visibility == nil {
+		return query
+	}
+	if visibility.UserName == nil && len(visibility.Accounts) == 0 {
+		return query.Where("FALSE")
+	}
+	return query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+		if visibility.UserName != nil {
+			q = q.WhereOr("job_metadata.user_name = ?", *visibility.UserName)
+		}
+		if len(visibility.Accounts) > 0 {
+			q = q.WhereOr("job_metadata.account IN (?)", bun.In(visibility.Accounts))
+		}
+		return q
+	})
+}
+
+// addColumnIfNotExists adds column with SQL type columnType to the table of model.
+// Used to extend tables created by earlier versions.
+func (s *PostgresStore) addColumnIfNotExists(model interface{}, column string, columnType string) {
+	_, err :=
+		s.db.NewAddColumn().
+			Model(model).
+			ColumnExpr("? "+columnType, bun.Ident(column)).
+			IfNotExists().
+			Exec(context.Background())
+	if err != nil {
+		logging.Error("store: addColumnIfNotExists(): Failed to add column ", column, ": ", err)
+	}
+}
+
 // appendTagFilter appends a tag filter to the query.
 func appendTagFilter(query *bun.SelectQuery, tags *[]job.JobTag, db *bun.DB) *bun.SelectQuery {
 	if tags != nil {
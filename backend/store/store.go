@@ -1,13 +1,17 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
 	"jobmon/config"
 	"jobmon/db"
 	"jobmon/job"
+	"time"
 )
 
 // Store is the interface that wraps a list of methods used for setting up, closing and working
-// with a PostgreSQL database.
+// with a PostgreSQL database. Methods reading data for requests take the context ctx of the
+// request and are canceled when it is done; modifications are always completed.
 type Store interface {
 
 	// Init initializes the Postgres database based on the configuration
@@ -17,18 +21,22 @@ type Store interface {
 	// Shuts down the connection to the Postgres database.
 	Flush()
 
+	// Reconfigure applies the parts of configuration c that do not require a new
+	// database connection, e.g. the partition limits used to finish overtime jobs.
+	Reconfigure(c config.Configuration)
+
 	// PutJob adds job metadata to store
 	PutJob(job job.JobMetadata) error
 
 	// GetJob returns metadata for job with jobid id.
-	GetJob(id int) (job.JobMetadata, error)
+	GetJob(ctx context.Context, id int) (job.JobMetadata, error)
 
 	// GetAllJobs returns metadata information for all jobs.
-	GetAllJobs() ([]job.JobMetadata, error)
+	GetAllJobs(ctx context.Context) ([]job.JobMetadata, error)
 
 	// GetFilteredJobs returns metadata information for all jobs that
 	// satisfy the predicate filter.
-	GetFilteredJobs(filter job.JobFilter) ([]job.JobMetadata, error)
+	GetFilteredJobs(ctx context.Context, filter job.JobFilter) ([]job.JobMetadata, error)
 
 	// StopJob mark a job identified with id as stopped.
 	StopJob(id int, stopJob job.StopJob) error
@@ -37,16 +45,16 @@ type Store interface {
 	UpdateJob(job job.JobMetadata) error
 
 	// GetJobTags returns all job tags for the user 'username'
-	GetJobTags(username string) ([]job.JobTag, error)
+	GetJobTags(ctx context.Context, username string) ([]job.JobTag, error)
 
 	// GetJobTagsByName returns all job tags containing the given string in their name
-	GetJobTagsByName(searchTerm string, username string) ([]job.JobTag, error)
+	GetJobTagsByName(ctx context.Context, searchTerm string, username string) ([]job.JobTag, error)
 
 	// GetAllUsersWithJob returns all users with at least one job
-	GetAllUsersWithJob() ([]string, error)
+	GetAllUsersWithJob(ctx context.Context) ([]string, error)
 
 	// GetUserWithJob returns all users with at least one job with a username containing the search term.
-	GetUserWithJob(searchTerm string) ([]string, error)
+	GetUserWithJob(ctx context.Context, searchTerm string) ([]string, error)
 
 	// AddTag adds tag to the job identified with id.
 	AddTag(id int, tag *job.JobTag) error
@@ -69,8 +77,65 @@ type Store interface {
 	// SetUserRoles sets roles for user 'username'.
 	SetUserRoles(username string, roles []string)
 
+	// SetUserAccounts sets the accounts managed by user 'username'.
+	SetUserAccounts(username string, accounts []string)
+
+	// GetRoles returns all role definitions.
+	GetRoles(ctx context.Context) ([]Role, error)
+
+	// SetRole creates or updates a role definition.
+	SetRole(role Role) error
+
+	// RemoveRole removes the role definition with name 'name'.
+	RemoveRole(name string) error
+
+	// AddRoleRequest stores a new role request and sets its Id.
+	AddRoleRequest(request *RoleRequest) error
+
+	// GetRoleRequest returns the role request with id 'id'.
+	GetRoleRequest(ctx context.Context, id int64) (RoleRequest, error)
+
+	// GetRoleRequests returns all role requests with the given status,
+	// or all role requests if status is empty. Newest requests come first.
+	GetRoleRequests(ctx context.Context, status string) ([]RoleRequest, error)
+
+	// UpdateRoleRequest updates a stored role request.
+	UpdateRoleRequest(request RoleRequest) error
+
+	// CountRoleRequests returns the number of role requests made by user 'username' since 'since'.
+	CountRoleRequests(ctx context.Context, username string, since time.Time) (int, error)
+
+	// GetLocalUser returns the local user 'username'.
+	GetLocalUser(ctx context.Context, username string) (LocalUser, error)
+
+	// GetLocalUsers returns all local users stored in the store.
+	GetLocalUsers(ctx context.Context) ([]LocalUser, error)
+
+	// PutLocalUser creates or updates a local user.
+	PutLocalUser(user LocalUser) error
+
+	// RemoveLocalUser removes the local user 'username'.
+	RemoveLocalUser(username string) error
+
+	// AddAuditEntry stores a new audit log entry and sets its Id.
+	AddAuditEntry(entry *AuditEntry) error
+
+	// GetAuditEntries returns the audit log entries satisfying filter. Newest entries come first.
+	GetAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+
+	// AddConfigRevision stores a new configuration revision and sets its Id.
+	AddConfigRevision(revision *ConfigRevision) error
+
+	// GetConfigRevision returns the configuration revision with id 'id'.
+	GetConfigRevision(ctx context.Context, id int64) (ConfigRevision, error)
+
+	// GetConfigRevisions returns all configuration revisions without their configuration,
+	// newest revisions first.
+	GetConfigRevisions(ctx context.Context) ([]ConfigRevision, error)
+
 	// Returns jobs that contain the given search term in their id, job-name or account-name
-	GetJobByString(searchTerm string, username string) ([]job.JobMetadata, error)
+	// and are visible according to visibility.
+	GetJobByString(ctx context.Context, searchTerm string, visibility *job.JobVisibility) ([]job.JobMetadata, error)
 }
 
 // UserSession represents a User session consisting of a username and a token.
@@ -83,6 +148,102 @@ type UserSession struct {
 type UserRoles struct {
 	Username string `bun:",pk"`
 	Roles    []string
+	// Accounts managed by the user, e.g. as principal investigator
+	Accounts []string
+}
+
+// Role represents a role and the permissions granted by it.
+type Role struct {
+	Name        string `bun:",pk"`
+	Permissions []string
+}
+
+// LocalUser represents a user authenticated by username and password.
+type LocalUser struct {
+	Username string `bun:",pk"`
+	// bcrypt hash of the password; never sent to clients
+	BCryptHash string `json:"-"`
+	Roles      []string
+	// Accounts managed by the user, e.g. as principal investigator
+	Accounts []string
+	// Disabled users cannot log in
+	Disabled          bool
+	PasswordChangedAt time.Time
+}
+
+// Possible states of a role request
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+)
+
+// RoleRequest represents the request of a user to be granted a role.
+type RoleRequest struct {
+	Id int64 `bun:",pk,autoincrement"`
+	// User requesting the role, taken from the users session
+	Username string
+	// Requested role
+	Role string
+	// Reason given by the requester
+	Reason string
+	// Address used to notify the requester about the decision
+	Email string
+	// One of RoleRequestPending, RoleRequestApproved, RoleRequestDenied
+	Status    string
+	CreatedAt time.Time
+	// Admin that approved or denied the request
+	DecidedBy string
+	DecidedAt time.Time
+	// Comment of the admin on the decision
+	Comment string
+}
+
+// AuditEntry represents an administrative or tagging action in the audit log.
+type AuditEntry struct {
+	Id        int64 `bun:",pk,autoincrement"`
+	Timestamp time.Time
+	// User that performed the action
+	Username string
+	// Admin impersonating Username, if any
+	ImpersonatedBy string
+	// Address of the client the action was requested from
+	RemoteAddr string
+	// Name of the action, e.g. "config.update"
+	Action string
+	// Object the action was applied to, e.g. a username or job id
+	Target string
+	// Changed fields for actions that modify configuration or roles
+	Changes []AuditChange
+}
+
+// AuditChange represents the change of a single field recorded in the audit log.
+type AuditChange struct {
+	Field  string
+	Before any `json:",omitempty"`
+	After  any `json:",omitempty"`
+}
+
+// AuditFilter restricts the audit log entries returned by GetAuditEntries.
+// Nil fields do not restrict anything.
+type AuditFilter struct {
+	Username *string
+	Action   *string
+	Target   *string
+	// Unix timestamps in seconds
+	Time *job.RangeFilter
+	// Maximum number of entries, 0 means no limit
+	Limit int
+}
+
+// ConfigRevision represents a configuration that was applied at some point.
+type ConfigRevision struct {
+	Id int64 `bun:",pk,autoincrement"`
+	// User that applied the configuration, or config.FileAuthor if it was read from the config file
+	Author    string
+	CreatedAt time.Time
+	// Configuration in the format of the config file
+	Config json.RawMessage `json:",omitempty"`
 }
 
 // deprecated
@@ -1,7 +1,9 @@
 package test
 
 import (
+	"context"
 	"jobmon/config"
+	database "jobmon/db"
 	"jobmon/job"
 	"time"
 )
@@ -10,30 +12,36 @@ type MockDB struct {
 	Calls int
 }
 
-func (db *MockDB) Init(c config.Configuration) {
+func (db *MockDB) Init(c config.Configuration) error {
 	db.Calls += 1
+	return nil
 }
 
 func (db *MockDB) Close() {
 	db.Calls += 1
 }
 
-func (db *MockDB) GetJobData(j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
+func (db *MockDB) GetJobData(ctx context.Context, j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
 	db.Calls += 1
 	return job.JobData{Metadata: j}, nil
 }
 
-func (db *MockDB) GetAggregatedJobData(j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
+func (db *MockDB) GetAggregatedJobData(ctx context.Context, j *job.JobMetadata, nodes string, sampleInterval time.Duration, raw bool) (data job.JobData, err error) {
 	db.Calls += 1
 	return job.JobData{Metadata: j}, nil
 }
 
-func (db *MockDB) GetJobMetadataMetrics(job *job.JobMetadata) (data []job.JobMetadataData, err error) {
+func (db *MockDB) GetJobMetadataMetrics(ctx context.Context, job *job.JobMetadata) (data []job.JobMetadataData, err error) {
 	db.Calls += 1
 	return data, nil
 }
 
-func (db *MockDB) GetMetricDataWithAggFn(j *job.JobMetadata, m config.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
+func (db *MockDB) GetJobPhases(ctx context.Context, j *job.JobMetadata, c config.PhaseDetectionConfig) (phases job.JobPhases, err error) {
+	db.Calls += 1
+	return phases, nil
+}
+
+func (db *MockDB) GetMetricDataWithAggFn(ctx context.Context, j *job.JobMetadata, m config.MetricConfig, aggFn string, sampleInterval time.Duration) (data job.MetricData, err error) {
 	db.Calls += 1
 	return data, nil
 }
@@ -42,6 +50,16 @@ func (db *MockDB) RunAggregation() {
 	db.Calls += 1
 }
 
+func (db *MockDB) GetAggregationStatus(ctx context.Context) ([]database.AggregationStatus, error) {
+	db.Calls += 1
+	return []database.AggregationStatus{}, nil
+}
+
+func (db *MockDB) BackfillAggregations(start time.Time, stop time.Time, names []string) error {
+	db.Calls += 1
+	return nil
+}
+
 func (db *MockDB) GetDataRetentionTime() (int64, error) {
 	db.Calls += 1
 	return 128, nil
@@ -52,3 +70,13 @@ func (db *MockDB) CreateLiveMonitoringChannel(j *job.JobMetadata) (chan []job.Me
 	done := make(chan bool)
 	return monitor, done
 }
+
+func (db *MockDB) ValidateMetrics(ctx context.Context, metrics []config.MetricConfig) config.ValidationErrors {
+	db.Calls += 1
+	return config.ValidationErrors{}
+}
+
+func (db *MockDB) DiscoverMeasurements(ctx context.Context, lookback time.Duration) ([]database.MeasurementInfo, error) {
+	db.Calls += 1
+	return []database.MeasurementInfo{}, nil
+}
@@ -5,6 +5,7 @@ import (
 )
 
 type Message struct {
+	Address string
 	Subject string
 	Message string
 }
@@ -25,6 +26,13 @@ func (em *MockEmailNotifier) Notify(subject string, message string) error {
 	return nil
 }
 
+// Sends a notification with the given message to address
+func (em *MockEmailNotifier) NotifyUser(address string, subject string, message string) error {
+	var m Message = Message{Address: address, Subject: subject, Message: message}
+	em.Input = append(em.Input, m)
+	return nil
+}
+
 // Clears the stored notifications
 func (em *MockEmailNotifier) ClearMessages() {
 	em.Input = nil
@@ -1,15 +1,24 @@
 package test
 
 import (
+	"context"
+	"fmt"
 	"jobmon/config"
 	"jobmon/db"
 	"jobmon/job"
 	"jobmon/store"
+	"time"
 )
 
 type MockStore struct {
-	Calls int
-	JWT   map[string]string
+	Calls        int
+	JWT          map[string]string
+	Roles        map[string]store.Role
+	UserRoles    map[string]store.UserRoles
+	RoleRequests []store.RoleRequest
+	LocalUsers   map[string]store.LocalUser
+	AuditEntries []store.AuditEntry
+	Revisions    []store.ConfigRevision
 }
 
 func (s *MockStore) Init(c config.Configuration, database *db.DB) {
@@ -20,22 +29,26 @@ func (s *MockStore) Flush() {
 	s.Calls += 1
 }
 
+func (s *MockStore) Reconfigure(c config.Configuration) {
+	s.Calls += 1
+}
+
 func (s *MockStore) PutJob(job job.JobMetadata) error {
 	s.Calls += 1
 	return nil
 }
 
-func (s *MockStore) GetJob(id int) (job.JobMetadata, error) {
+func (s *MockStore) GetJob(ctx context.Context, id int) (job.JobMetadata, error) {
 	s.Calls += 1
 	return job.JobMetadata{}, nil
 }
 
-func (s *MockStore) GetAllJobs() ([]job.JobMetadata, error) {
+func (s *MockStore) GetAllJobs(ctx context.Context) ([]job.JobMetadata, error) {
 	s.Calls += 1
 	return make([]job.JobMetadata, 0), nil
 }
 
-func (s *MockStore) GetFilteredJobs(filter job.JobFilter) ([]job.JobMetadata, error) {
+func (s *MockStore) GetFilteredJobs(ctx context.Context, filter job.JobFilter) ([]job.JobMetadata, error) {
 	s.Calls += 1
 	return make([]job.JobMetadata, 0), nil
 }
@@ -50,22 +63,22 @@ func (s *MockStore) UpdateJob(job job.JobMetadata) error {
 	return nil
 }
 
-func (s *MockStore) GetJobTags(username string) ([]job.JobTag, error) {
+func (s *MockStore) GetJobTags(ctx context.Context, username string) ([]job.JobTag, error) {
 	s.Calls += 1
 	return make([]job.JobTag, 0), nil
 }
 
-func (s *MockStore) GetJobTagsByName(searchTerm string, username string) ([]job.JobTag, error) {
+func (s *MockStore) GetJobTagsByName(ctx context.Context, searchTerm string, username string) ([]job.JobTag, error) {
 	s.Calls += 1
 	return make([]job.JobTag, 0), nil
 }
 
-func (s *MockStore) GetAllUsersWithJob() ([]string, error) {
+func (s *MockStore) GetAllUsersWithJob(ctx context.Context) ([]string, error) {
 	s.Calls += 1
 	return make([]string, 0), nil
 }
 
-func (s *MockStore) GetUserWithJob(searchTerm string) ([]string, error) {
+func (s *MockStore) GetUserWithJob(ctx context.Context, searchTerm string) ([]string, error) {
 	s.Calls += 1
 	return make([]string, 0), nil
 }
@@ -106,14 +119,193 @@ func (s *MockStore) RemoveUserSession(username string) {
 
 func (s *MockStore) GetUserRoles(username string) (store.UserRoles, bool) {
 	s.Calls += 1
-	return store.UserRoles{}, true
+	if u, ok := s.UserRoles[username]; ok {
+		return u, true
+	}
+	return store.UserRoles{Username: username, Roles: []string{}}, false
 }
 
 func (s *MockStore) SetUserRoles(username string, roles []string) {
 	s.Calls += 1
+	if s.UserRoles == nil {
+		s.UserRoles = make(map[string]store.UserRoles)
+	}
+	u := s.UserRoles[username]
+	u.Username = username
+	u.Roles = roles
+	s.UserRoles[username] = u
+}
+
+func (s *MockStore) SetUserAccounts(username string, accounts []string) {
+	s.Calls += 1
+	if s.UserRoles == nil {
+		s.UserRoles = make(map[string]store.UserRoles)
+	}
+	u := s.UserRoles[username]
+	u.Username = username
+	u.Accounts = accounts
+	s.UserRoles[username] = u
+}
+
+func (s *MockStore) AddRoleRequest(request *store.RoleRequest) error {
+	s.Calls += 1
+	request.Id = int64(len(s.RoleRequests) + 1)
+	s.RoleRequests = append(s.RoleRequests, *request)
+	return nil
+}
+
+func (s *MockStore) GetRoleRequest(ctx context.Context, id int64) (store.RoleRequest, error) {
+	s.Calls += 1
+	for _, r := range s.RoleRequests {
+		if r.Id == id {
+			return r, nil
+		}
+	}
+	return store.RoleRequest{}, fmt.Errorf("role request %d not found", id)
+}
+
+func (s *MockStore) GetRoleRequests(ctx context.Context, status string) ([]store.RoleRequest, error) {
+	s.Calls += 1
+	requests := make([]store.RoleRequest, 0)
+	for _, r := range s.RoleRequests {
+		if status == "" || r.Status == status {
+			requests = append(requests, r)
+		}
+	}
+	return requests, nil
+}
+
+func (s *MockStore) UpdateRoleRequest(request store.RoleRequest) error {
+	s.Calls += 1
+	for i, r := range s.RoleRequests {
+		if r.Id == request.Id {
+			s.RoleRequests[i] = request
+			return nil
+		}
+	}
+	return fmt.Errorf("role request %d not found", request.Id)
+}
+
+func (s *MockStore) CountRoleRequests(ctx context.Context, username string, since time.Time) (int, error) {
+	s.Calls += 1
+	count := 0
+	for _, r := range s.RoleRequests {
+		if r.Username == username && !r.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MockStore) GetRoles(ctx context.Context) ([]store.Role, error) {
+	s.Calls += 1
+	roles := make([]store.Role, 0, len(s.Roles))
+	for _, r := range s.Roles {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func (s *MockStore) SetRole(role store.Role) error {
+	s.Calls += 1
+	if s.Roles == nil {
+		s.Roles = make(map[string]store.Role)
+	}
+	s.Roles[role.Name] = role
+	return nil
 }
 
-func (s *MockStore) GetJobByString(searchTerm string, username string) ([]job.JobMetadata, error) {
+func (s *MockStore) RemoveRole(name string) error {
+	s.Calls += 1
+	delete(s.Roles, name)
+	return nil
+}
+
+func (s *MockStore) GetLocalUser(ctx context.Context, username string) (store.LocalUser, error) {
+	s.Calls += 1
+	if u, ok := s.LocalUsers[username]; ok {
+		return u, nil
+	}
+	return store.LocalUser{}, fmt.Errorf("local user %s not found", username)
+}
+
+func (s *MockStore) GetLocalUsers(ctx context.Context) ([]store.LocalUser, error) {
+	s.Calls += 1
+	users := make([]store.LocalUser, 0, len(s.LocalUsers))
+	for _, u := range s.LocalUsers {
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (s *MockStore) PutLocalUser(user store.LocalUser) error {
+	s.Calls += 1
+	if s.LocalUsers == nil {
+		s.LocalUsers = make(map[string]store.LocalUser)
+	}
+	s.LocalUsers[user.Username] = user
+	return nil
+}
+
+func (s *MockStore) RemoveLocalUser(username string) error {
+	s.Calls += 1
+	delete(s.LocalUsers, username)
+	return nil
+}
+
+func (s *MockStore) GetJobByString(ctx context.Context, searchTerm string, visibility *job.JobVisibility) ([]job.JobMetadata, error) {
 	s.Calls += 1
 	return make([]job.JobMetadata, 0), nil
 }
+
+func (s *MockStore) AddAuditEntry(entry *store.AuditEntry) error {
+	s.Calls += 1
+	entry.Id = int64(len(s.AuditEntries) + 1)
+	s.AuditEntries = append(s.AuditEntries, *entry)
+	return nil
+}
+
+func (s *MockStore) GetAuditEntries(ctx context.Context, filter store.AuditFilter) ([]store.AuditEntry, error) {
+	s.Calls += 1
+	entries := make([]store.AuditEntry, 0)
+	for i := len(s.AuditEntries) - 1; i >= 0; i-- {
+		e := s.AuditEntries[i]
+		if (filter.Username == nil || e.Username == *filter.Username) &&
+			(filter.Action == nil || e.Action == *filter.Action) &&
+			(filter.Target == nil || e.Target == *filter.Target) {
+			entries = append(entries, e)
+		}
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (s *MockStore) AddConfigRevision(revision *store.ConfigRevision) error {
+	s.Calls += 1
+	revision.Id = int64(len(s.Revisions) + 1)
+	s.Revisions = append(s.Revisions, *revision)
+	return nil
+}
+
+func (s *MockStore) GetConfigRevision(ctx context.Context, id int64) (store.ConfigRevision, error) {
+	s.Calls += 1
+	for _, r := range s.Revisions {
+		if r.Id == id {
+			return r, nil
+		}
+	}
+	return store.ConfigRevision{}, fmt.Errorf("config revision %d not found", id)
+}
+
+func (s *MockStore) GetConfigRevisions(ctx context.Context) ([]store.ConfigRevision, error) {
+	s.Calls += 1
+	revisions := make([]store.ConfigRevision, 0, len(s.Revisions))
+	for i := len(s.Revisions) - 1; i >= 0; i-- {
+		r := s.Revisions[i]
+		r.Config = nil
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,17 @@
+package utils
+
+import "context"
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx that carries the user 'username' the request is made for,
+// e.g. to schedule the database queries of the request for that user.
+func WithUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, userKey{}, username)
+}
+
+// UserOf returns the user carried by ctx; empty for background tasks.
+func UserOf(ctx context.Context) string {
+	username, _ := ctx.Value(userKey{}).(string)
+	return username
+}
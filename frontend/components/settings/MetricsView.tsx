@@ -334,6 +334,7 @@ const MetricForm = ({ isNewMetric, metricConfig, setMetricConfig, ...categories}
         {TextField("Separation Key", "SeparationKey", errors.SeparationKey, true, undefined, TOOLTIP_SEPARATION_KEY)}
         {TextField("Filter Function", "FilterFunc", "", false, undefined, TOOLTIP_FILTER_FUNC)}
         {TextField("Post Query Operation", "PostQueryOp", "", false, undefined, TOOLTIP_POST_QUERY_OP)}
+        {TextField("Expression", "Expression", "", false, undefined, TOOLTIP_EXPRESSION)}
         <Flex mt={3} justify="space-between" gap={2}>
         {
           // Show the Reset and Submit buttons only if a metric configuration is being inserted.
@@ -444,6 +445,7 @@ const TOOLTIP_MAX_PER_TYPE = "Maximum value a unit as specified in the above \"T
 const TOOLTIP_SEPARATION_KEY = "Separation key used to differentiate between nodes in the InfluxDB query.";
 const TOOLTIP_FILTER_FUNC = "Optional filter function used in InfluxDB queries. Must be a valid Flux query.";
 const TOOLTIP_POST_QUERY_OP = "Optional post query function used in InfluxDB queries. Must be a valid Flux query.";
+const TOOLTIP_EXPRESSION = "Optional arithmetic expression over the measurements of other metrics, e.g. \"flops_any / mem_bw\". If set, the metric is computed per node from these metrics; Type must be \"node\" and Separation Key \"hostname\".";
                       
 const AggFnSelection = (displayName: string, name: string, availableAggFns: string[]) => {
   if ((availableAggFns?.length ?? 0) === 0) {
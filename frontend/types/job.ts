@@ -22,6 +22,32 @@ export interface JobMetadata {
   JobScript: string;
   Tags: JobTag[];
   Data: JobMetadataData[];
+  Phases: JobPhases | null;
+  Analysis: AnalyzerResult[] | null;
+}
+
+/**
+ * Finding is a result of a job analyzer.
+ */
+export interface Finding {
+  Analyzer: string;
+  Kind: string;
+  Severity: "info" | "warning";
+  Metric?: string;
+  Nodes?: string[];
+  Start?: string;
+  Stop?: string;
+  Value: number;
+  Message: string;
+}
+
+/**
+ * AnalyzerResult contains the findings of an analyzer for a job.
+ */
+export interface AnalyzerResult {
+  Analyzer: string;
+  Findings: Finding[];
+  Error?: string;
 }
 
 /**
@@ -83,6 +109,7 @@ export interface MetricConfig {
   PThreadAggFn: AggFn;
   FilterFunc: string;
   PostQueryOp: string;
+  Expression?: string;
 }
 
 /**
@@ -153,6 +180,121 @@ export interface JobData {
   QuantileData: QuantileData[];
   SampleInterval: number;
   SampleIntervals: number[];
+  Imbalance?: MetricImbalance[];
+}
+
+/**
+ * Series is the columnar representation of a time series, returned with
+ * format=columnar. Times are in Unix milliseconds, missing values are null.
+ */
+export interface Series {
+  Times: number[];
+  Values: (number | null)[];
+}
+
+/**
+ * ColumnarMetricData is MetricData with the series in columnar representation.
+ */
+export interface ColumnarMetricData {
+  Config: MetricConfig;
+  Data: DataMap<Series>;
+  RawData: string;
+}
+
+/**
+ * ColumnarQuantileData is QuantileData with the series in columnar representation.
+ */
+export interface ColumnarQuantileData {
+  Config: MetricConfig;
+  Quantiles: string[];
+  Data: DataMap<Series>;
+  RawData: string;
+}
+
+/**
+ * ColumnarJobData is JobData with the series in columnar representation.
+ */
+export interface ColumnarJobData {
+  Metadata: JobMetadata;
+  MetricData: ColumnarMetricData[];
+  QuantileData: ColumnarQuantileData[];
+  SampleInterval: number;
+  SampleIntervals: number[];
+  Imbalance?: MetricImbalance[];
+}
+
+/**
+ * PhaseStatistics contains the statistics of a metric during a segment or phase.
+ */
+export interface PhaseStatistics {
+  Mean: number;
+  Max: number;
+}
+
+/**
+ * PhaseSegment is the time span of a metric between two change points.
+ */
+export interface PhaseSegment extends PhaseStatistics {
+  Start: string;
+  Stop: string;
+}
+
+/**
+ * MetricSegments contains the change points of a metric and the segments between them.
+ */
+export interface MetricSegments {
+  GUID: string;
+  ChangePoints: string[];
+  Segments: PhaseSegment[];
+}
+
+/**
+ * JobPhase is a time span of a job between two change points of any metric.
+ */
+export interface JobPhase {
+  Start: string;
+  Stop: string;
+  Label: string;
+  Metrics: { [guid: string]: PhaseStatistics };
+}
+
+/**
+ * JobPhases is the segmentation of a job into phases.
+ */
+export interface JobPhases {
+  Method: string;
+  Penalty: number;
+  MinSegment: number;
+  Phases: JobPhase[];
+  Metrics: MetricSegments[];
+}
+
+/**
+ * ImbalancePoint describes how evenly a metric is distributed over the nodes of a job at one point in time.
+ */
+export interface ImbalancePoint {
+  Time: string;
+  CoefficientOfVariation: number;
+  MaxMeanRatio: number;
+}
+
+/**
+ * OutlierNode is a node whose values deviate strongly from the other nodes of a job.
+ */
+export interface OutlierNode {
+  Hostname: string;
+  Mean: number;
+  Score: number;
+}
+
+/**
+ * MetricImbalance describes how evenly a metric is distributed over the nodes of a job.
+ */
+export interface MetricImbalance {
+  GUID: string;
+  Points: ImbalancePoint[];
+  Score: number;
+  Outliers: OutlierNode[];
 }
 
 /**
@@ -163,6 +305,8 @@ export interface JobMetadataData {
   Mean: number;
   Max: number;
   ChangePoints: string[];
+  Imbalance: number;
+  OutlierNodes: string[] | null;
 }
 
 /**